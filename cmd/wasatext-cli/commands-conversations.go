@@ -0,0 +1,344 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/val7e/wasaText/pkg/client"
+)
+
+// timeLayout is the format of the timestamps in the text output
+const timeLayout = "2006-01-02 15:04"
+
+// cmdConversations implements `conversations`
+func cmdConversations(ctx context.Context, a *app, _ []string) error {
+	convs, err := a.client.GetMyConversations(ctx)
+	if err != nil {
+		return err
+	}
+	return a.print(convs, func(w io.Writer) {
+		for _, c := range convs {
+			last, when := "-", "-"
+			if c.LastMessage != nil {
+				last, when = oneLine(c.LastMessage.Preview), c.LastMessage.Timestamp.Local().Format(timeLayout)
+			}
+			_, _ = fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\n", c.Id, c.Type,
+				a.conversationName(c.Name, c.Participants), when, last)
+		}
+	})
+}
+
+// cmdStart implements `start <username>`
+func cmdStart(ctx context.Context, a *app, args []string) error {
+	conv, err := a.client.StartConversation(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	return a.print(conv, func(w io.Writer) {
+		_, _ = fmt.Fprintf(w, "%d\t%s\n", conv.Id, a.conversationName(conv.Name, conv.Participants))
+	})
+}
+
+// cmdRead implements `read <conversation>`: it prints the messages, the oldest first (as returned by the API)
+func cmdRead(ctx context.Context, a *app, args []string) error {
+	id, err := parseID("conversation", args[0])
+	if err != nil {
+		return err
+	}
+	conv, err := a.client.GetConversation(ctx, id)
+	if err != nil {
+		return err
+	}
+	return a.print(conv, func(w io.Writer) {
+		_, _ = fmt.Fprintf(w, "# %s (%s)\n", a.conversationName(conv.Name, conv.Participants), conv.Type)
+		for i := range conv.Messages {
+			printMessage(w, &conv.Messages[i])
+		}
+	})
+}
+
+// cmdSend implements `send <conversation> <text...|->`
+func cmdSend(ctx context.Context, a *app, args []string) error {
+	id, err := parseID("conversation", args[0])
+	if err != nil {
+		return err
+	}
+	text := strings.Join(args[1:], " ")
+	if text == "-" {
+		b, err := io.ReadAll(a.stdin)
+		if err != nil {
+			return fmt.Errorf("reading the message: %w", err)
+		}
+		text = strings.TrimRight(string(b), "\n")
+	}
+	if strings.TrimSpace(text) == "" {
+		return usageError{"the message is empty"}
+	}
+	msg, err := a.client.SendText(ctx, id, text)
+	if err != nil {
+		return err
+	}
+	return a.printMessage(msg)
+}
+
+// cmdSendPhoto implements `send-photo <conversation> <file>`
+func cmdSendPhoto(ctx context.Context, a *app, args []string) error {
+	id, err := parseID("conversation", args[0])
+	if err != nil {
+		return err
+	}
+	photo, err := readPhoto(args[1])
+	if err != nil {
+		return err
+	}
+	msg, err := a.client.SendPhoto(ctx, id, photo)
+	if err != nil {
+		return err
+	}
+	return a.printMessage(msg)
+}
+
+// cmdSendFile implements `send-file <conversation> <file>`
+func cmdSendFile(ctx context.Context, a *app, args []string) error {
+	id, err := parseID("conversation", args[0])
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(args[1])
+	if err != nil {
+		return fmt.Errorf("reading the file: %w", err)
+	}
+	defer func() { _ = f.Close() }()
+	msg, err := a.client.SendFile(ctx, id, filepath.Base(args[1]), f)
+	if err != nil {
+		return err
+	}
+	return a.printMessage(msg)
+}
+
+// cmdDownload implements `download <conversation> <message> <file|->`
+func cmdDownload(ctx context.Context, a *app, args []string) error {
+	ids, err := parseIDs(args, "conversation", "message")
+	if err != nil {
+		return err
+	}
+	return a.save(args[2], func(w io.Writer) error {
+		_, err := a.client.DownloadFile(ctx, ids[0], ids[1], w)
+		return err
+	})
+}
+
+// cmdSendVoice implements `send-voice <conversation> <file>`
+func cmdSendVoice(ctx context.Context, a *app, args []string) error {
+	id, err := parseID("conversation", args[0])
+	if err != nil {
+		return err
+	}
+	var mimeType string
+	switch strings.ToLower(filepath.Ext(args[1])) {
+	case ".ogg", ".opus":
+		mimeType = "audio/ogg"
+	case ".wav":
+		mimeType = "audio/wav"
+	default:
+		return usageError{"voice messages must be .ogg, .opus or .wav files"}
+	}
+	f, err := os.Open(args[1])
+	if err != nil {
+		return fmt.Errorf("reading the voice message: %w", err)
+	}
+	defer func() { _ = f.Close() }()
+	msg, err := a.client.SendAudio(ctx, id, mimeType, f)
+	if err != nil {
+		return err
+	}
+	return a.printMessage(msg)
+}
+
+// cmdDownloadVoice implements `download-voice <conversation> <message> <file|->`
+func cmdDownloadVoice(ctx context.Context, a *app, args []string) error {
+	ids, err := parseIDs(args, "conversation", "message")
+	if err != nil {
+		return err
+	}
+	return a.save(args[2], func(w io.Writer) error {
+		_, err := a.client.DownloadAudio(ctx, ids[0], ids[1], w)
+		return err
+	})
+}
+
+// save writes the output of download to the file at path (removed if the download fails), or to stdout for "-"
+func (a *app) save(path string, download func(io.Writer) error) error {
+	if path == "-" {
+		return download(a.out)
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("saving the file: %w", err)
+	}
+	if err := download(f); err != nil {
+		_ = f.Close()
+		_ = os.Remove(path)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("saving the file: %w", err)
+	}
+	return nil
+}
+
+// cmdForward implements `forward <conversation> <message> <username>`
+func cmdForward(ctx context.Context, a *app, args []string) error {
+	ids, err := parseIDs(args, "conversation", "message")
+	if err != nil {
+		return err
+	}
+	msg, err := a.client.ForwardMessage(ctx, ids[0], ids[1], args[2])
+	if err != nil {
+		return err
+	}
+	return a.printMessage(msg)
+}
+
+// cmdDelete implements `delete <conversation> <message>`
+func cmdDelete(ctx context.Context, a *app, args []string) error {
+	ids, err := parseIDs(args, "conversation", "message")
+	if err != nil {
+		return err
+	}
+	return a.client.DeleteMessage(ctx, ids[0], ids[1])
+}
+
+// cmdReact implements `react <conversation> <message> <emoji>`
+func cmdReact(ctx context.Context, a *app, args []string) error {
+	ids, err := parseIDs(args, "conversation", "message")
+	if err != nil {
+		return err
+	}
+	comment, err := a.client.CommentMessage(ctx, ids[0], ids[1], args[2])
+	if err != nil {
+		return err
+	}
+	return a.print(comment, func(w io.Writer) {
+		printComment(w, comment)
+	})
+}
+
+// cmdEditReaction implements `edit-reaction <conversation> <message> <comment> <text>`
+func cmdEditReaction(ctx context.Context, a *app, args []string) error {
+	ids, err := parseIDs(args, "conversation", "message", "comment")
+	if err != nil {
+		return err
+	}
+	comment, err := a.client.EditComment(ctx, ids[0], ids[1], ids[2], args[3])
+	if err != nil {
+		return err
+	}
+	return a.print(comment, func(w io.Writer) {
+		printComment(w, comment)
+	})
+}
+
+// cmdUnreact implements `unreact <conversation> <message> <comment>`
+func cmdUnreact(ctx context.Context, a *app, args []string) error {
+	ids, err := parseIDs(args, "conversation", "message", "comment")
+	if err != nil {
+		return err
+	}
+	return a.client.UncommentMessage(ctx, ids[0], ids[1], ids[2])
+}
+
+// cmdComments implements `comments <conversation> <message>`
+func cmdComments(ctx context.Context, a *app, args []string) error {
+	ids, err := parseIDs(args, "conversation", "message")
+	if err != nil {
+		return err
+	}
+	comments, err := a.client.GetComments(ctx, ids[0], ids[1])
+	if err != nil {
+		return err
+	}
+	return a.print(comments, func(w io.Writer) {
+		for i := range comments {
+			printComment(w, &comments[i])
+		}
+	})
+}
+
+// cmdMentions implements `mentions`: it prints the most recent mentions first
+func cmdMentions(ctx context.Context, a *app, _ []string) error {
+	mentions, err := a.client.GetMyMentions(ctx)
+	if err != nil {
+		return err
+	}
+	return a.print(mentions, func(w io.Writer) {
+		for _, m := range mentions {
+			_, _ = fmt.Fprintf(w, "%d\t%d\t[%s]\t%s\t%s:\t%s\n", m.ConversationId, m.MessageId,
+				m.Timestamp.Local().Format(timeLayout), m.GroupName, m.Sender, oneLine(m.Text))
+		}
+	})
+}
+
+// printComment writes a comment as "id  [time]  author  text  [edited]"
+func printComment(w io.Writer, c *client.Comment) {
+	_, _ = fmt.Fprintf(w, "%d\t[%s]\t%s\t%s", c.Id, c.Timestamp.Local().Format(timeLayout), c.Author, oneLine(c.Text))
+	if c.EditedAt != nil {
+		_, _ = fmt.Fprint(w, "\t[edited]")
+	}
+	_, _ = fmt.Fprintln(w)
+}
+
+func (a *app) printMessage(msg *client.Message) error {
+	return a.print(msg, func(w io.Writer) {
+		printMessage(w, msg)
+	})
+}
+
+// printMessage writes a message as "id  [time]  sender:  text  [comments: authors]  [replies: count]"
+func printMessage(w io.Writer, msg *client.Message) {
+	body := "[photo]"
+	if msg.Text != nil {
+		body = oneLine(*msg.Text)
+	} else if msg.File != nil {
+		body = fmt.Sprintf("[file: %s, %d bytes]", oneLine(msg.File.Name), msg.File.Size)
+	} else if msg.Audio != nil {
+		seconds := (msg.Audio.DurationMs + 999) / 1000
+		body = fmt.Sprintf("[voice: %d:%02d]", seconds/60, seconds%60)
+	}
+	_, _ = fmt.Fprintf(w, "%d\t[%s]\t%s:\t%s", msg.Id, msg.Timestamp.Local().Format(timeLayout), msg.Sender,
+		body)
+	if msg.CommentsCount > 0 {
+		_, _ = fmt.Fprintf(w, "\t[comments: %s]", strings.Join(msg.CommentsAuthors, ", "))
+	}
+	if msg.ThreadReplyCount > 0 {
+		_, _ = fmt.Fprintf(w, "\t[replies: %d]", msg.ThreadReplyCount)
+	}
+	if p := msg.LinkPreview; p != nil {
+		_, _ = fmt.Fprintf(w, "\t[link: %s]", oneLine(orDash(p.Title)))
+	}
+	_, _ = fmt.Fprintln(w)
+}
+
+// conversationName returns the name of a group, or the other participants of a direct conversation
+func (a *app) conversationName(name *string, participants []string) string {
+	if name != nil && *name != "" {
+		return *name
+	}
+	others := make([]string, 0, len(participants))
+	for _, p := range participants {
+		if p != a.session.Username {
+			others = append(others, p)
+		}
+	}
+	return orDash(strings.Join(others, ", "))
+}
+
+// oneLine replaces the line breaks of multi-line texts, which would break the columns
+func oneLine(s string) string {
+	return strings.ReplaceAll(strings.TrimSpace(s), "\n", " ⏎ ")
+}
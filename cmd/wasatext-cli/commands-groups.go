@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/val7e/wasaText/pkg/client"
+)
+
+// cmdGroupCreate implements `group create <name>`
+func cmdGroupCreate(ctx context.Context, a *app, args []string) error {
+	group, err := a.client.CreateGroup(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	return a.printGroup(group)
+}
+
+// cmdGroupShow implements `group show <group>`
+func cmdGroupShow(ctx context.Context, a *app, args []string) error {
+	id, err := parseID("group", args[0])
+	if err != nil {
+		return err
+	}
+	group, err := a.client.GetGroup(ctx, id)
+	if err != nil {
+		return err
+	}
+	return a.printGroup(group)
+}
+
+// cmdGroupRename implements `group rename <group> <name>`
+func cmdGroupRename(ctx context.Context, a *app, args []string) error {
+	id, err := parseID("group", args[0])
+	if err != nil {
+		return err
+	}
+	group, err := a.client.SetGroupName(ctx, id, args[1])
+	if err != nil {
+		return err
+	}
+	return a.printGroup(group)
+}
+
+// cmdGroupPhoto implements `group photo <group> <file>`
+func cmdGroupPhoto(ctx context.Context, a *app, args []string) error {
+	id, err := parseID("group", args[0])
+	if err != nil {
+		return err
+	}
+	photo, err := readPhoto(args[1])
+	if err != nil {
+		return err
+	}
+	group, err := a.client.SetGroupPhoto(ctx, id, photo)
+	if err != nil {
+		return err
+	}
+	return a.printGroup(group)
+}
+
+// cmdGroupAdd implements `group add <group> <username...>`
+func cmdGroupAdd(ctx context.Context, a *app, args []string) error {
+	id, err := parseID("group", args[0])
+	if err != nil {
+		return err
+	}
+	group, err := a.client.AddToGroup(ctx, id, args[1:]...)
+	if err != nil {
+		return err
+	}
+	return a.printGroup(group)
+}
+
+// cmdGroupLeave implements `group leave <group>`
+func cmdGroupLeave(ctx context.Context, a *app, args []string) error {
+	id, err := parseID("group", args[0])
+	if err != nil {
+		return err
+	}
+	return a.client.LeaveGroup(ctx, id)
+}
+
+func (a *app) printGroup(group *client.Group) error {
+	return a.print(group, func(w io.Writer) {
+		_, _ = fmt.Fprintf(w, "%d\t%s\t%s\n", group.Id, group.Name, strings.Join(group.Members, ", "))
+	})
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/val7e/wasaText/pkg/client"
+)
+
+// cmdThreads implements `threads`
+func cmdThreads(ctx context.Context, a *app, _ []string) error {
+	threads, err := a.client.GetMyThreads(ctx)
+	if err != nil {
+		return err
+	}
+	return a.print(threads, func(w io.Writer) {
+		for _, t := range threads {
+			last, when := "-", "-"
+			if t.LastReply != nil {
+				last, when = oneLine(t.LastReply.Preview), t.LastReply.Timestamp.Local().Format(timeLayout)
+			}
+			_, _ = fmt.Fprintf(w, "%d\t%d\t%s\t%d replies\t%d unread\t%s\t%s\n", t.ConversationId, t.MessageId,
+				oneLine(t.Message.Preview), t.ReplyCount, t.UnreadCount, when, last)
+		}
+	})
+}
+
+// cmdThread implements `thread <conversation> <message>`: it prints the replies, the oldest first
+func cmdThread(ctx context.Context, a *app, args []string) error {
+	ids, err := parseIDs(args, "conversation", "message")
+	if err != nil {
+		return err
+	}
+	replies, err := a.client.GetReplies(ctx, ids[0], ids[1])
+	if err != nil {
+		return err
+	}
+	return a.print(replies, func(w io.Writer) {
+		for i := range replies {
+			printReply(w, &replies[i])
+		}
+	})
+}
+
+// cmdReply implements `reply <conversation> <message> <text...|->`
+func cmdReply(ctx context.Context, a *app, args []string) error {
+	ids, err := parseIDs(args, "conversation", "message")
+	if err != nil {
+		return err
+	}
+	text := strings.Join(args[2:], " ")
+	if text == "-" {
+		b, err := io.ReadAll(a.stdin)
+		if err != nil {
+			return fmt.Errorf("reading the reply: %w", err)
+		}
+		text = strings.TrimRight(string(b), "\n")
+	}
+	if strings.TrimSpace(text) == "" {
+		return usageError{"the reply is empty"}
+	}
+	reply, err := a.client.ReplyText(ctx, ids[0], ids[1], text)
+	if err != nil {
+		return err
+	}
+	return a.print(reply, func(w io.Writer) {
+		printReply(w, reply)
+	})
+}
+
+// cmdReplyPhoto implements `reply-photo <conversation> <message> <file>`
+func cmdReplyPhoto(ctx context.Context, a *app, args []string) error {
+	ids, err := parseIDs(args, "conversation", "message")
+	if err != nil {
+		return err
+	}
+	photo, err := readPhoto(args[2])
+	if err != nil {
+		return err
+	}
+	reply, err := a.client.ReplyPhoto(ctx, ids[0], ids[1], photo)
+	if err != nil {
+		return err
+	}
+	return a.print(reply, func(w io.Writer) {
+		printReply(w, reply)
+	})
+}
+
+// cmdFollow implements `follow <conversation> <message>`
+func cmdFollow(ctx context.Context, a *app, args []string) error {
+	ids, err := parseIDs(args, "conversation", "message")
+	if err != nil {
+		return err
+	}
+	return a.client.SubscribeThread(ctx, ids[0], ids[1])
+}
+
+// cmdUnfollow implements `unfollow <conversation> <message>`
+func cmdUnfollow(ctx context.Context, a *app, args []string) error {
+	ids, err := parseIDs(args, "conversation", "message")
+	if err != nil {
+		return err
+	}
+	return a.client.UnsubscribeThread(ctx, ids[0], ids[1])
+}
+
+// cmdMarkRead implements `mark-read <conversation> <message> <reply>`
+func cmdMarkRead(ctx context.Context, a *app, args []string) error {
+	ids, err := parseIDs(args, "conversation", "message", "reply")
+	if err != nil {
+		return err
+	}
+	return a.client.MarkThreadRead(ctx, ids[0], ids[1], ids[2])
+}
+
+// printReply writes a reply as "id  [time]  sender:  text"
+func printReply(w io.Writer, r *client.Reply) {
+	body := "[photo]"
+	if r.Text != nil {
+		body = oneLine(*r.Text)
+	}
+	_, _ = fmt.Fprintf(w, "%d\t[%s]\t%s:\t%s\n", r.Id, r.Timestamp.Local().Format(timeLayout), r.Sender, body)
+}
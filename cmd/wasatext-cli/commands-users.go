@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/val7e/wasaText/pkg/client"
+)
+
+// cmdLogin implements `login <username>`: it logs in and saves the session
+func cmdLogin(ctx context.Context, a *app, args []string) error {
+	res, err := a.client.Login(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	a.session.Token, a.session.Username = res.Identifier, res.Username
+	if err := a.session.save(a.sessionPath); err != nil {
+		return err
+	}
+	return a.print(res, func(w io.Writer) {
+		if res.Created {
+			_, _ = fmt.Fprintf(w, "Registered and logged in as %s on %s\n", res.Username, a.session.Server)
+		} else {
+			_, _ = fmt.Fprintf(w, "Logged in as %s on %s\n", res.Username, a.session.Server)
+		}
+	})
+}
+
+// cmdLogout implements `logout`: it removes the identifier from the session file
+func cmdLogout(_ context.Context, a *app, _ []string) error {
+	a.session.Token, a.session.Username = "", ""
+	return a.session.save(a.sessionPath)
+}
+
+// cmdWhoami implements `whoami`
+func cmdWhoami(_ context.Context, a *app, _ []string) error {
+	return a.print(a.session, func(w io.Writer) {
+		_, _ = fmt.Fprintf(w, "%s on %s\n", a.session.Username, a.session.Server)
+	})
+}
+
+// cmdUsers implements `users <query>`
+func cmdUsers(ctx context.Context, a *app, args []string) error {
+	users, err := a.client.SearchUsers(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	return a.print(users, func(w io.Writer) {
+		for _, u := range users {
+			_, _ = fmt.Fprintf(w, "%d\t%s\n", u.Id, u.Username)
+		}
+	})
+}
+
+// cmdSetUsername implements `set-username <username>`, updating the saved session too
+func cmdSetUsername(ctx context.Context, a *app, args []string) error {
+	user, err := a.client.SetMyUserName(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	a.session.Username = user.Username
+	if err := a.session.save(a.sessionPath); err != nil {
+		return err
+	}
+	return a.printUser(user)
+}
+
+// cmdSetPhoto implements `set-photo <file>`
+func cmdSetPhoto(ctx context.Context, a *app, args []string) error {
+	photo, err := readPhoto(args[0])
+	if err != nil {
+		return err
+	}
+	user, err := a.client.SetMyPhoto(ctx, photo)
+	if err != nil {
+		return err
+	}
+	return a.printUser(user)
+}
+
+func (a *app) printUser(user *client.User) error {
+	return a.print(user, func(w io.Writer) {
+		_, _ = fmt.Fprintf(w, "%d\t%s\n", user.Id, user.Username)
+	})
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// command is a subcommand of wasatext-cli
+type command struct {
+	name string
+	args string
+	help string
+
+	// minArgs and maxArgs bound the number of arguments (maxArgs < 0: no limit)
+	minArgs, maxArgs int
+
+	// auth is true if the command needs a logged in user
+	auth bool
+
+	run func(ctx context.Context, a *app, args []string) error
+}
+
+// commands lists the subcommands, in the order of the usage message. Group commands have two words.
+var commands = []command{
+	{name: "help", help: "Show this help", maxArgs: -1},
+	{name: "login", args: "<username>", help: "Log in (registering the user if needed) and save the session",
+		minArgs: 1, maxArgs: 1, run: cmdLogin},
+	{name: "logout", help: "Forget the saved session", run: cmdLogout},
+	{name: "whoami", help: "Show the user of the saved session", auth: true, run: cmdWhoami},
+	{name: "users", args: "<query>", help: "Search users by username", minArgs: 1, maxArgs: 1, auth: true,
+		run: cmdUsers},
+	{name: "set-username", args: "<username>", help: "Change your username", minArgs: 1, maxArgs: 1, auth: true,
+		run: cmdSetUsername},
+	{name: "set-photo", args: "<file>", help: "Change your profile picture", minArgs: 1, maxArgs: 1, auth: true,
+		run: cmdSetPhoto},
+
+	{name: "conversations", help: "List your conversations, the most recent first", auth: true,
+		run: cmdConversations},
+	{name: "start", args: "<username>", help: "Start (or find) the conversation with a user", minArgs: 1,
+		maxArgs: 1, auth: true, run: cmdStart},
+	{name: "read", args: "<conversation>", help: "Show the messages of a conversation", minArgs: 1, maxArgs: 1,
+		auth: true, run: cmdRead},
+	{name: "send", args: "<conversation> <text...|->", help: "Send a text message (- reads it from stdin)",
+		minArgs: 2, maxArgs: -1, auth: true, run: cmdSend},
+	{name: "send-photo", args: "<conversation> <file>", help: "Send a photo", minArgs: 2, maxArgs: 2,
+		auth: true, run: cmdSendPhoto},
+	{name: "send-file", args: "<conversation> <file>", help: "Send a file (a document, an archive, audio or video)",
+		minArgs: 2, maxArgs: 2, auth: true, run: cmdSendFile},
+	{name: "download", args: "<conversation> <message> <file|->",
+		help: "Save the file of a message (- writes it to stdout)", minArgs: 3, maxArgs: 3, auth: true,
+		run: cmdDownload},
+	{name: "send-voice", args: "<conversation> <file>", help: "Send a voice message (.ogg, .opus or .wav)",
+		minArgs: 2, maxArgs: 2, auth: true, run: cmdSendVoice},
+	{name: "download-voice", args: "<conversation> <message> <file|->",
+		help: "Save the recording of a voice message (- writes it to stdout)", minArgs: 3, maxArgs: 3, auth: true,
+		run: cmdDownloadVoice},
+	{name: "forward", args: "<conversation> <message> <username>",
+		help: "Forward a message to the conversation with a user", minArgs: 3, maxArgs: 3, auth: true,
+		run: cmdForward},
+	{name: "delete", args: "<conversation> <message>", help: "Delete one of your messages", minArgs: 2,
+		maxArgs: 2, auth: true, run: cmdDelete},
+	{name: "react", args: "<conversation> <message> <emoji>", help: "Comment a message", minArgs: 3,
+		maxArgs: 3, auth: true, run: cmdReact},
+	{name: "unreact", args: "<conversation> <message> <comment>", help: "Remove one of your comments",
+		minArgs: 3, maxArgs: 3, auth: true, run: cmdUnreact},
+	{name: "edit-reaction", args: "<conversation> <message> <comment> <text>", help: "Edit one of your comments",
+		minArgs: 4, maxArgs: 4, auth: true, run: cmdEditReaction},
+	{name: "comments", args: "<conversation> <message>", help: "Show the comments of a message", minArgs: 2,
+		maxArgs: 2, auth: true, run: cmdComments},
+	{name: "mentions", help: "List the recent group messages mentioning you", auth: true, run: cmdMentions},
+
+	{name: "threads", help: "List the threads you follow, with their unread replies", auth: true,
+		run: cmdThreads},
+	{name: "thread", args: "<conversation> <message>", help: "Show the replies to a message", minArgs: 2,
+		maxArgs: 2, auth: true, run: cmdThread},
+	{name: "reply", args: "<conversation> <message> <text...|->",
+		help: "Reply to a message in its thread (- reads the reply from stdin)", minArgs: 3, maxArgs: -1,
+		auth: true, run: cmdReply},
+	{name: "reply-photo", args: "<conversation> <message> <file>", help: "Reply to a message with a photo",
+		minArgs: 3, maxArgs: 3, auth: true, run: cmdReplyPhoto},
+	{name: "follow", args: "<conversation> <message>", help: "Follow the thread of a message", minArgs: 2,
+		maxArgs: 2, auth: true, run: cmdFollow},
+	{name: "unfollow", args: "<conversation> <message>", help: "Stop following the thread of a message",
+		minArgs: 2, maxArgs: 2, auth: true, run: cmdUnfollow},
+	{name: "mark-read", args: "<conversation> <message> <reply>",
+		help: "Mark the replies of a followed thread as read, up to a reply", minArgs: 3, maxArgs: 3, auth: true,
+		run: cmdMarkRead},
+
+	{name: "group create", args: "<name>", help: "Create a group", minArgs: 1, maxArgs: 1, auth: true,
+		run: cmdGroupCreate},
+	{name: "group show", args: "<group>", help: "Show a group and its members", minArgs: 1, maxArgs: 1,
+		auth: true, run: cmdGroupShow},
+	{name: "group rename", args: "<group> <name>", help: "Rename a group", minArgs: 2, maxArgs: 2, auth: true,
+		run: cmdGroupRename},
+	{name: "group photo", args: "<group> <file>", help: "Change the photo of a group", minArgs: 2, maxArgs: 2,
+		auth: true, run: cmdGroupPhoto},
+	{name: "group add", args: "<group> <username...>", help: "Add users to a group", minArgs: 2, maxArgs: -1,
+		auth: true, run: cmdGroupAdd},
+	{name: "group leave", args: "<group>", help: "Leave a group", minArgs: 1, maxArgs: 1, auth: true,
+		run: cmdGroupLeave},
+}
+
+// findCommand returns the command named by the first (or the first two) arguments, and its arguments
+func findCommand(args []string) (*command, []string) {
+	for i := range commands {
+		words := strings.Fields(commands[i].name)
+		if len(args) >= len(words) && strings.Join(args[:len(words)], " ") == commands[i].name {
+			return &commands[i], args[len(words):]
+		}
+	}
+	return nil, nil
+}
+
+func printUsage(w io.Writer) {
+	_, _ = fmt.Fprint(w, `Usage: wasatext-cli [flags] <command> [arguments]
+
+Flags:
+  -server <url>    base URL of the API (default: the server of the saved session, or `+defaultServer+`)
+  -config <file>   session file (default: $WASATEXT_CONFIG, or wasatext/session.json in the user config directory)
+  -json            print the results as JSON
+
+Commands:
+`)
+	tw := tabwriter.NewWriter(w, 0, 0, 3, ' ', 0)
+	for _, c := range commands {
+		_, _ = fmt.Fprintf(tw, "  %s %s\t%s\n", c.name, c.args, c.help)
+	}
+	_ = tw.Flush()
+}
+
+// print writes v as JSON with -json, or calls text otherwise
+func (a *app) print(v interface{}, text func(w io.Writer)) error {
+	if a.json {
+		enc := json.NewEncoder(a.out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	}
+	tw := tabwriter.NewWriter(a.out, 0, 0, 2, ' ', 0)
+	text(tw)
+	return tw.Flush()
+}
+
+// parseID parses the numeric identifier given as argument `name`
+func parseID(name, arg string) (int64, error) {
+	id, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil || id <= 0 {
+		return 0, usageError{fmt.Sprintf("invalid %s identifier %q", name, arg)}
+	}
+	return id, nil
+}
+
+// parseIDs parses the identifiers of the first arguments, named by `names`
+func parseIDs(args []string, names ...string) ([]int64, error) {
+	ids := make([]int64, len(names))
+	for i, name := range names {
+		id, err := parseID(name, args[i])
+		if err != nil {
+			return nil, err
+		}
+		ids[i] = id
+	}
+	return ids, nil
+}
+
+// readPhoto returns the base64 encoding of the image in the file
+func readPhoto(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("reading the photo: %w", err)
+	}
+	return base64.StdEncoding.EncodeToString(data), nil
+}
+
+// orDash returns "-" for empty strings, to keep the columns aligned
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,163 @@
+/*
+Wasatext-cli is a command-line client for the WASAText API, built on `pkg/client`.
+
+Usage:
+
+	wasatext-cli [flags] <command> [arguments]
+
+The flags are:
+
+	-server <url>
+		Base URL of the API (default: the server of the saved session, or http://localhost:3000).
+	-config <file>
+		Session file (default: $WASATEXT_CONFIG, or wasatext/session.json in the user configuration directory).
+	-json
+		Print the results as JSON, for piping into other programs (like jq).
+
+Run `wasatext-cli help` for the list of commands. `login` stores the server URL and the user identifier in the session
+file (readable only by the user), the other commands use them until `logout`. Text messages are read from the standard
+input when the text is `-`, photos are read from files and sent base64-encoded. Other files are sent as they are
+with `send-file`, and saved with `download`; voice messages likewise with `send-voice` and `download-voice`.
+
+Return values (exit codes):
+
+	0
+		The command was successful
+
+	1
+		The command failed (connection error, or error reply of the server)
+
+	2
+		Invalid command line
+*/
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/val7e/wasaText/pkg/client"
+)
+
+// defaultServer is the API server used when no session is saved
+const defaultServer = "http://localhost:3000"
+
+// usageError is returned for an invalid command line
+type usageError struct {
+	msg string
+}
+
+func (e usageError) Error() string {
+	return e.msg
+}
+
+// main is the program entry point. The only purpose of this function is to call run() and set the exit code if there is
+// any error
+func main() {
+	err := run(os.Args[1:], os.Stdin, os.Stdout)
+	var uerr usageError
+	switch {
+	case err == nil:
+	case errors.Is(err, flag.ErrHelp):
+	case errors.As(err, &uerr):
+		_, _ = fmt.Fprintln(os.Stderr, "error: ", err)
+		_, _ = fmt.Fprintln(os.Stderr, "Run 'wasatext-cli help' for usage.")
+		os.Exit(2)
+	default:
+		_, _ = fmt.Fprintln(os.Stderr, "error: ", err)
+		os.Exit(1)
+	}
+}
+
+// app is the state shared by the commands
+type app struct {
+	session     *session
+	sessionPath string
+	client      *client.Client
+	json        bool
+	stdin       io.Reader
+	out         io.Writer
+}
+
+// run parses the global flags, loads the session and executes the command
+func run(args []string, stdin io.Reader, stdout io.Writer) error {
+	// The flag errors are reported by main, like the other errors of the command line
+	fs := flag.NewFlagSet("wasatext-cli", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	server := fs.String("server", "", "base URL of the API")
+	sessionPath := fs.String("config", "", "session file")
+	jsonOutput := fs.Bool("json", false, "print the results as JSON")
+	if err := fs.Parse(args); errors.Is(err, flag.ErrHelp) {
+		printUsage(stdout)
+		return err
+	} else if err != nil {
+		return usageError{err.Error()}
+	}
+	if fs.NArg() == 0 {
+		return usageError{"a command is required"}
+	}
+
+	cmd, cmdArgs := findCommand(fs.Args())
+	if cmd == nil {
+		return usageError{fmt.Sprintf("unknown command %q", strings.Join(fs.Args()[:min(2, fs.NArg())], " "))}
+	}
+	if cmd.name == "help" {
+		printUsage(stdout)
+		return nil
+	}
+	if len(cmdArgs) < cmd.minArgs || (cmd.maxArgs >= 0 && len(cmdArgs) > cmd.maxArgs) {
+		return usageError{fmt.Sprintf("usage: wasatext-cli %s %s", cmd.name, cmd.args)}
+	}
+
+	a := &app{
+		sessionPath: *sessionPath,
+		json:        *jsonOutput,
+		stdin:       stdin,
+		out:         stdout,
+	}
+	if a.sessionPath == "" {
+		p, err := defaultSessionPath()
+		if err != nil {
+			return err
+		}
+		a.sessionPath = p
+	}
+	s, err := loadSession(a.sessionPath)
+	if err != nil {
+		return err
+	}
+	a.session = s
+	if *server != "" && *server != s.Server {
+		// A different server doesn't know the saved identifier
+		s.Server, s.Token, s.Username = *server, "", ""
+	}
+	if s.Server == "" {
+		s.Server = defaultServer
+	}
+	if cmd.auth && s.Token == "" {
+		return errors.New("not logged in, run 'wasatext-cli login <username>' first")
+	}
+
+	a.client, err = client.New(s.Server, client.WithToken(s.Token))
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	return cmd.run(ctx, a, cmdArgs)
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"io"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/val7e/wasaText/doc"
+	"github.com/val7e/wasaText/service/api"
+	"github.com/val7e/wasaText/service/database/memdb"
+	"github.com/val7e/wasaText/service/openapi"
+)
+
+// newServer starts the API over an in-memory database, rejecting the requests that don't follow doc/api.yaml
+func newServer(t *testing.T) string {
+	t.Helper()
+	spec, err := openapi.Load(doc.APISpec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	router, err := api.New(api.Config{
+		Logger:     logger,
+		Database:   memdb.New(memdb.Config{}),
+		Validation: api.Validation{Spec: spec, Strict: true, Responses: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(router.Handler())
+	t.Cleanup(func() {
+		srv.Close()
+		_ = router.Close()
+	})
+	return srv.URL
+}
+
+// cli runs wasatext-cli with the arguments, reading stdin, and returns what it printed
+func cli(stdin string, args ...string) (string, error) {
+	var out bytes.Buffer
+	err := run(args, strings.NewReader(stdin), &out)
+	return out.String(), err
+}
+
+func TestCommandLine(t *testing.T) {
+	config := []string{"-config", filepath.Join(t.TempDir(), "session.json")}
+	tests := []struct {
+		name string
+		args []string
+		// usage is the usageError expected, err any other error
+		usage, err string
+	}{
+		{"no command", nil, "a command is required", ""},
+		{"only flags", []string{"-json"}, "a command is required", ""},
+		{"unknown command", []string{"nope", "x"}, `unknown command "nope x"`, ""},
+		{"group without subcommand", []string{"group"}, `unknown command "group"`, ""},
+		{"unknown group subcommand", []string{"group", "nope"}, `unknown command "group nope"`, ""},
+		{"missing argument", []string{"login"}, "usage: wasatext-cli login <username>", ""},
+		{"too many arguments", []string{"login", "alice", "bob"}, "usage: wasatext-cli login <username>", ""},
+		{"group arguments", []string{"group", "rename", "1"}, "usage: wasatext-cli group rename <group> <name>", ""},
+		{"unknown flag", []string{"-verbose", "whoami"}, "flag provided but not defined: -verbose", ""},
+		{"flag after the command", []string{"whoami", "-json"}, "usage: wasatext-cli whoami ", ""},
+		{"missing flag value", []string{"-server"}, "flag needs an argument: -server", ""},
+		{"invalid boolean", []string{"-json=maybe", "whoami"}, `invalid boolean value "maybe" for -json: parse error`, ""},
+		{"not logged in", []string{"conversations"}, "", "not logged in, run 'wasatext-cli login <username>' first"},
+	}
+	for _, tt := range tests {
+		out, err := cli("", append(config[:2:2], tt.args...)...)
+		var uerr usageError
+		switch {
+		case tt.usage != "" && (!errors.As(err, &uerr) || err.Error() != tt.usage):
+			t.Errorf("%s: got %v, want the usage error %q", tt.name, err, tt.usage)
+		case tt.err != "" && (err == nil || errors.As(err, &uerr) || err.Error() != tt.err):
+			t.Errorf("%s: got %v, want the error %q", tt.name, err, tt.err)
+		}
+		if out != "" {
+			t.Errorf("%s: printed %q", tt.name, out)
+		}
+	}
+
+	for _, args := range [][]string{{"help"}, {"-h"}, {"-json", "help", "me"}} {
+		out, err := cli("", append(config[:2:2], args...)...)
+		if err != nil && !errors.Is(err, flag.ErrHelp) {
+			t.Errorf("%v: %v", args, err)
+		}
+		for _, want := range []string{"Usage: wasatext-cli", "  -json ", "  login <username> ", "  group leave <group> "} {
+			if !strings.Contains(out, want) {
+				t.Errorf("%v: missing %q in the usage:\n%s", args, want, out)
+			}
+		}
+	}
+}
+
+// TestJSONOutput runs a session with -json against the API, checking the fields printed by the commands
+func TestJSONOutput(t *testing.T) {
+	url := newServer(t)
+	dir := t.TempDir()
+	alice := []string{"-server", url, "-config", filepath.Join(dir, "alice.json"), "-json"}
+	bob := []string{"-server", url, "-config", filepath.Join(dir, "bob.json"), "-json"}
+
+	// as runs the command with the flags of a user, decoding its output in v
+	as := func(flags []string, v interface{}, stdin string, args ...string) {
+		t.Helper()
+		out, err := cli(stdin, append(flags[:len(flags):len(flags)], args...)...)
+		if err != nil {
+			t.Fatalf("%v: %v", args, err)
+		}
+		if err := json.Unmarshal([]byte(out), v); err != nil {
+			t.Fatalf("%v: %v: %s", args, err, out)
+		}
+	}
+
+	var login map[string]interface{}
+	as(alice, &login, "", "login", "alice")
+	expectKeys(t, "login", login, "identifier", "username", "pic")
+	if login["username"] != "alice" || login["identifier"] == "" {
+		t.Errorf("login: got %v", login)
+	}
+	as(bob, &login, "", "login", "bob")
+
+	var whoami map[string]interface{}
+	as(alice, &whoami, "", "whoami")
+	expectKeys(t, "whoami", whoami, "server", "token", "username")
+	if whoami["server"] != url || whoami["username"] != "alice" {
+		t.Errorf("whoami: got %v", whoami)
+	}
+
+	var users []map[string]interface{}
+	as(alice, &users, "", "users", "bo")
+	if len(users) != 1 || users[0]["username"] != "bob" {
+		t.Fatalf("users: got %v", users)
+	}
+
+	var conv map[string]interface{}
+	as(alice, &conv, "", "start", "bob")
+	expectKeys(t, "start", conv, "id", "type", "participants", "messages")
+	if conv["type"] != "user" || !reflect.DeepEqual(conv["participants"], []interface{}{"alice", "bob"}) {
+		t.Errorf("start: got %v", conv)
+	}
+	id := strconv.FormatFloat(conv["id"].(float64), 'f', -1, 64)
+
+	var msg map[string]interface{}
+	as(alice, &msg, "first line\nsecond line\n", "send", id, "-")
+	expectKeys(t, "send", msg, "id", "timestamp", "sender", "type", "comments_count", "comments_authors",
+		"thread_reply_count", "text")
+	if msg["sender"] != "alice" || msg["type"] != "text" || msg["text"] != "first line\nsecond line" {
+		t.Errorf("send: got %v", msg)
+	}
+
+	var convs []map[string]interface{}
+	as(bob, &convs, "", "conversations")
+	if len(convs) != 1 {
+		t.Fatalf("conversations: got %v", convs)
+	}
+	expectKeys(t, "conversations", convs[0], "id", "type", "participants", "last_message")
+	if last, _ := convs[0]["last_message"].(map[string]interface{}); last["preview"] != "first line\nsecond line" {
+		t.Errorf("conversations: got the last message %v", convs[0]["last_message"])
+	}
+
+	// Without -json, the same commands print text
+	out, err := cli("", append(alice[:len(alice)-1:len(alice)-1], "conversations")...)
+	if err != nil || !strings.HasPrefix(out, id+"  user  bob  ") {
+		t.Errorf("conversations: got %v %q", err, out)
+	}
+	if _, err := cli("", append(alice[:len(alice)-1:len(alice)-1], "read", "first")...); err == nil ||
+		err.Error() != `invalid conversation identifier "first"` {
+		t.Errorf("read: got %v", err)
+	}
+}
+
+// expectKeys checks the fields of the JSON object v
+func expectKeys(t *testing.T, name string, v map[string]interface{}, keys ...string) {
+	t.Helper()
+	got := make([]string, 0, len(v))
+	for k := range v {
+		got = append(got, k)
+	}
+	sort.Strings(got)
+	sort.Strings(keys)
+	if !reflect.DeepEqual(got, keys) {
+		t.Errorf("%s: got the fields %v, want %v", name, got, keys)
+	}
+}
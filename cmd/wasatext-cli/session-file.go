@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// session is the content of the session file
+type session struct {
+	Server   string `json:"server"`
+	Token    string `json:"token,omitempty"`
+	Username string `json:"username,omitempty"`
+}
+
+// defaultSessionPath returns $WASATEXT_CONFIG, or wasatext/session.json in the user configuration directory
+func defaultSessionPath() (string, error) {
+	if p := os.Getenv("WASATEXT_CONFIG"); p != "" {
+		return p, nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("finding the configuration directory (use -config): %w", err)
+	}
+	return filepath.Join(dir, "wasatext", "session.json"), nil
+}
+
+// loadSession reads the session file. A missing file is an empty session.
+func loadSession(path string) (*session, error) {
+	var s session
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return &s, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("reading the session: %w", err)
+	}
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("reading the session %s: %w", path, err)
+	}
+	return &s, nil
+}
+
+// save writes the session file, readable only by the user as it contains the identifier
+func (s *session) save(path string) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("saving the session: %w", err)
+	}
+
+	// Write a temporary file and rename it, so that the session is never left half-written
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0o600); err != nil {
+		return fmt.Errorf("saving the session: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("saving the session: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/val7e/wasaText/service/database"
+	"github.com/val7e/wasaText/service/globaltime"
+)
+
+// runBackup implements `webapi backup [flags] [file]`: it takes a snapshot of the live database in `file`, or in a new
+// timestamped file of Backup.Dir if no file is given (pruning the old ones as scheduled backups do).
+func runBackup(cfg WebAPIConfiguration, logger *logrus.Logger) error {
+	if cfg.DB.Driver != "sqlite3" {
+		return fmt.Errorf("backups are supported only for sqlite3 databases, use the %s tools instead", cfg.DB.Driver)
+	}
+	if _, err := os.Stat(cfg.DB.Filename); err != nil {
+		return fmt.Errorf("opening database: %w", err)
+	}
+
+	dbconn, err := openDatabase(cfg)
+	if err != nil {
+		return fmt.Errorf("opening %s: %w", cfg.DB.Driver, err)
+	}
+	defer func() { _ = dbconn.Close() }()
+
+	if path := cfg.Args.Num(0); path != "" {
+		if err := database.BackupSQLite(context.Background(), dbconn, path); err != nil {
+			return fmt.Errorf("backup: %w", err)
+		}
+		logger.WithField("file", path).Info("backup completed")
+		return nil
+	}
+	return backupAndPrune(context.Background(), cfg, dbconn, logger)
+}
+
+// runRestore implements `webapi restore [flags] <file>`: it replaces the content of the database with the backup in
+// `file`, then applies the migrations needed to bring it to the current schema version. The backup is validated
+// (integrity and schema version) before the database is touched. The server must be stopped: the restore fails
+// while another process has the database open.
+func runRestore(cfg WebAPIConfiguration, logger *logrus.Logger) error {
+	if cfg.DB.Driver != "sqlite3" {
+		return fmt.Errorf("restore is supported only for sqlite3 databases, use the %s tools instead", cfg.DB.Driver)
+	}
+	path := cfg.Args.Num(0)
+	if path == "" {
+		return errors.New("restore: the backup file is required")
+	}
+
+	dbconn, err := openDatabase(cfg)
+	if err != nil {
+		return fmt.Errorf("opening %s: %w", cfg.DB.Driver, err)
+	}
+	defer func() { _ = dbconn.Close() }()
+
+	version, err := database.RestoreSQLite(context.Background(), dbconn, path)
+	if err != nil {
+		return fmt.Errorf("restore: %w", err)
+	}
+	logger.WithFields(logrus.Fields{"file": path, "version": version}).Info("backup restored")
+
+	if _, err := database.New(dbconn, database.Config{Driver: cfg.DB.Driver, QueryTimeout: cfg.DB.QueryTimeout}); err != nil {
+		return fmt.Errorf("migrating restored database: %w", err)
+	}
+	version, err = database.SchemaVersion(context.Background(), dbconn)
+	if err != nil {
+		return err
+	}
+	logger.WithField("version", version).Info("database schema up to date")
+	return nil
+}
+
+// scheduleBackups takes a backup of the database every Backup.Interval, until ctx is cancelled. Errors are logged and
+// do not stop the schedule.
+func scheduleBackups(ctx context.Context, cfg WebAPIConfiguration, dbconn *sql.DB, logger *logrus.Logger) {
+	ticker := time.NewTicker(cfg.Backup.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := backupAndPrune(ctx, cfg, dbconn, logger); err != nil {
+				logger.WithError(err).Error("scheduled backup failed")
+			}
+		}
+	}
+}
+
+// backupAndPrune writes a new timestamped backup in Backup.Dir, then deletes the oldest backups so that at most
+// Backup.Retention are kept (all of them if Backup.Retention is zero).
+func backupAndPrune(ctx context.Context, cfg WebAPIConfiguration, dbconn *sql.DB, logger *logrus.Logger) error {
+	if err := os.MkdirAll(cfg.Backup.Dir, 0o750); err != nil {
+		return fmt.Errorf("creating backup directory: %w", err)
+	}
+
+	prefix := strings.TrimSuffix(filepath.Base(cfg.DB.Filename), filepath.Ext(cfg.DB.Filename)) + "-"
+	path := filepath.Join(cfg.Backup.Dir, prefix+globaltime.Now().UTC().Format("20060102T150405Z")+".db")
+	if err := database.BackupSQLite(ctx, dbconn, path); err != nil {
+		return fmt.Errorf("backup: %w", err)
+	}
+	logger.WithField("file", path).Info("backup completed")
+
+	if cfg.Backup.Retention <= 0 {
+		return nil
+	}
+	// Timestamps sort lexicographically, so the oldest backups come first
+	backups, err := filepath.Glob(filepath.Join(cfg.Backup.Dir, prefix+"*.db"))
+	if err != nil {
+		return fmt.Errorf("listing backups: %w", err)
+	}
+	sort.Strings(backups)
+	for len(backups) > cfg.Backup.Retention {
+		if err := os.Remove(backups[0]); err != nil {
+			return fmt.Errorf("removing old backup: %w", err)
+		}
+		logger.WithField("file", backups[0]).Debug("old backup removed")
+		backups = backups[1:]
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/val7e/wasaText/service/database"
+	"github.com/val7e/wasaText/service/globaltime"
+)
+
+// TestBackupAndPrune takes more backups than Backup.Retention, an hour apart: the newest ones are kept, and a backup
+// of another database in the same directory is left alone.
+func TestBackupAndPrune(t *testing.T) {
+	const retention, backups = 3, 5
+
+	dir := t.TempDir()
+	backupDir := filepath.Join(dir, "backups")
+	cfg, err := loadConfiguration([]string{
+		"--config-path", filepath.Join(dir, "config.yml"),
+		"--db-filename", filepath.Join(dir, "wasatext.db"),
+		"--backup-dir", backupDir,
+		"--backup-retention", "3",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dbconn, err := openDatabase(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = dbconn.Close() })
+	if _, err := database.New(dbconn, database.Config{Driver: cfg.DB.Driver}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.MkdirAll(backupDir, 0o750); err != nil {
+		t.Fatal(err)
+	}
+	other := filepath.Join(backupDir, "other-20000101T000000Z.db")
+	if err := os.WriteFile(other, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	start := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	t.Cleanup(func() { globaltime.FixedTime = time.Time{} })
+	var want []string
+	for i := 0; i < backups; i++ {
+		// The timestamps are out of order, to check that the oldest one goes first rather than the first one taken
+		moment := start.Add(time.Duration((i*2)%backups) * time.Hour)
+		globaltime.FixedTime = moment
+		if err := backupAndPrune(context.Background(), cfg, dbconn, logger); err != nil {
+			t.Fatal(err)
+		}
+		want = append(want, "wasatext-"+moment.Format("20060102T150405Z")+".db")
+	}
+
+	entries, err := os.ReadDir(backupDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, e := range entries {
+		if e.Name() != filepath.Base(other) {
+			got = append(got, e.Name())
+		}
+	}
+	// Taken at +0h, +2h, +4h, +1h, +3h: the ones at +2h, +3h and +4h are kept
+	want = []string{want[1], want[4], want[2]}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("got the backups %v, want %v", got, want)
+	}
+	if len(got) != retention {
+		t.Errorf("got %d backups, want %d", len(got), retention)
+	}
+	if _, err := os.Stat(other); err != nil {
+		t.Errorf("the backup of the other database: %v", err)
+	}
+}
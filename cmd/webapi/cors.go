@@ -15,8 +15,10 @@ func applyCORSHandler(h http.Handler) http.Handler {
 			"x-example-header",
 			"Content-Type",
 			"Authorization",
+			"Last-Event-ID",
 		}),
 		handlers.AllowedMethods([]string{"GET", "POST", "OPTIONS", "DELETE", "PUT"}),
+		handlers.ExposedHeaders([]string{"X-Request-Id"}),
 		// Do not modify the CORS origin and max age, they are used in the evaluation.
 		handlers.AllowedOrigins([]string{"*"}),
 		handlers.MaxAge(1),
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"expvar"
+	"net/http"
+	"net/http/pprof"
+	"sync/atomic"
+
+	"github.com/val7e/wasaText/service/metrics"
+)
+
+var (
+	// requestsTotal counts the requests received by the API server
+	requestsTotal = expvar.NewInt("requests")
+
+	// requestsActive is the number of requests the API server is serving right now
+	requestsActive = expvar.NewInt("requests_active")
+
+	// statsDB holds the *sql.DB whose pool statistics are published as "db", set by debugHandler
+	statsDB atomic.Value
+)
+
+func init() {
+	expvar.Publish("db", expvar.Func(func() interface{} {
+		dbconn, ok := statsDB.Load().(*sql.DB)
+		if !ok {
+			return nil
+		}
+		return dbconn.Stats()
+	}))
+}
+
+// countRequests updates the request counters published in /debug/vars.
+func countRequests(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestsTotal.Add(1)
+		requestsActive.Add(1)
+		defer requestsActive.Add(-1)
+
+		h.ServeHTTP(w, r)
+	})
+}
+
+// debugHandler returns the handler of the debug server:
+//   - /debug/pprof/: the runtime profiler (see net/http/pprof)
+//   - /debug/vars: the expvar variables, as JSON (request counters, database pool statistics, memory statistics)
+//   - /metrics: the metrics in `reg` (HTTP routes and database calls), in the Prometheus text format
+//   - /readiness: replies with HTTP Status 200 if the API server is accepting requests, and with 503 while it is
+//     shutting down (`shuttingDown` is not zero) or when the database cannot be reached
+func debugHandler(dbconn *sql.DB, shuttingDown *int32, reg *metrics.Registry) http.Handler {
+	statsDB.Store(dbconn)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/vars", expvar.Handler())
+	mux.Handle("/metrics", reg.Handler())
+
+	mux.HandleFunc("/readiness", func(w http.ResponseWriter, r *http.Request) {
+		status, reason := http.StatusOK, ""
+		if atomic.LoadInt32(shuttingDown) != 0 {
+			status, reason = http.StatusServiceUnavailable, "shutting down"
+		} else if err := dbconn.PingContext(r.Context()); err != nil {
+			status, reason = http.StatusServiceUnavailable, "database not available"
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		if status != http.StatusOK {
+			_ = json.NewEncoder(w).Encode(map[string]string{
+				"status": "error",
+				"error":  reason,
+			})
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"status": "ok",
+		})
+	})
+
+	return mux
+}
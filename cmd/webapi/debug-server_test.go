@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/val7e/wasaText/service/metrics"
+)
+
+// TestDebugServer checks the readiness probe through the shutdown and a database failure, and the variables in
+// /debug/vars while an API request is being served
+func TestDebugServer(t *testing.T) {
+	dir := t.TempDir()
+	cfg, err := loadConfiguration([]string{
+		"--config-path", filepath.Join(dir, "config.yml"),
+		"--db-filename", filepath.Join(dir, "wasatext.db"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dbconn, err := openDatabase(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = dbconn.Close() })
+
+	var shuttingDown int32
+	debug := debugHandler(dbconn, &shuttingDown, metrics.NewRegistry())
+	readiness := func() (int, map[string]string) {
+		rec := httptest.NewRecorder()
+		debug.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readiness", nil))
+		var body map[string]string
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+			t.Fatalf("%v: %s", err, rec.Body.String())
+		}
+		return rec.Code, body
+	}
+
+	// An API request is in flight while the variables are read
+	before := requestsTotal.Value()
+	release, served := make(chan struct{}), make(chan struct{})
+	api := countRequests(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	go func() {
+		api.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/liveness", nil))
+		close(served)
+	}()
+	for requestsActive.Value() != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	rec := httptest.NewRecorder()
+	debug.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/debug/vars", nil))
+	close(release)
+	<-served
+
+	var vars struct {
+		Requests       *int64
+		RequestsActive *int64 `json:"requests_active"`
+		DB             *struct {
+			MaxOpenConnections *int
+			OpenConnections    *int
+			InUse              *int
+			Idle               *int
+		} `json:"db"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &vars); err != nil {
+		t.Fatalf("%v: %s", err, rec.Body.String())
+	}
+	if vars.Requests == nil || *vars.Requests != before+1 || vars.RequestsActive == nil || *vars.RequestsActive != 1 {
+		t.Errorf("got the counters %v and %v, want %d and 1", vars.Requests, vars.RequestsActive, before+1)
+	}
+	if vars.DB == nil || vars.DB.MaxOpenConnections == nil || vars.DB.OpenConnections == nil || vars.DB.InUse == nil ||
+		vars.DB.Idle == nil {
+		t.Errorf("the database statistics are missing: %s", rec.Body.String())
+	}
+	if requestsActive.Value() != 0 {
+		t.Errorf("got %d active requests after the request", requestsActive.Value())
+	}
+
+	if status, body := readiness(); status != http.StatusOK || body["status"] != "ok" {
+		t.Errorf("got %d %v, want ready", status, body)
+	}
+	atomic.StoreInt32(&shuttingDown, 1)
+	if status, body := readiness(); status != http.StatusServiceUnavailable || body["error"] != "shutting down" {
+		t.Errorf("shutting down: got %d %v", status, body)
+	}
+	atomic.StoreInt32(&shuttingDown, 0)
+	_ = dbconn.Close()
+	if status, body := readiness(); status != http.StatusServiceUnavailable || body["error"] != "database not available" {
+		t.Errorf("without database: got %d %v", status, body)
+	}
+}
@@ -0,0 +1,7 @@
+//go:build postgres
+
+package main
+
+// The PostgreSQL driver is linked only when building with the `postgres` tag, so that the default binary does not
+// carry it. Select it with DB.Driver "pgx" and a DB.DSN connection string.
+import _ "github.com/jackc/pgx/v5/stdlib"
@@ -18,15 +18,80 @@ type WebAPIConfiguration struct {
 	}
 	Web struct {
 		APIHost         string        `conf:"default:0.0.0.0:3000"`
-		DebugHost       string        `conf:"default:0.0.0.0:4000"`
+		DebugHost       string        `conf:"default:127.0.0.1:4000"`
 		ReadTimeout     time.Duration `conf:"default:5s"`
 		WriteTimeout    time.Duration `conf:"default:5s"`
 		ShutdownTimeout time.Duration `conf:"default:5s"`
 	}
 	Debug bool
-	DB    struct {
-		Filename string `conf:"default:/tmp/decaf.db"`
+	Log   struct {
+		JSON bool
 	}
+	DB struct {
+		Driver       string        `conf:"default:sqlite3"`
+		Filename     string        `conf:"default:/tmp/decaf.db"`
+		DSN          string        `conf:"mask"`
+		QueryTimeout time.Duration `conf:"default:3s"`
+
+		JournalMode string        `conf:"default:WAL"`
+		BusyTimeout time.Duration `conf:"default:5s"`
+		ForeignKeys bool          `conf:"default:true"`
+
+		MaxOpenConns    int `conf:"default:8"`
+		MaxIdleConns    int `conf:"default:8"`
+		ConnMaxLifetime time.Duration
+	}
+	RateLimit struct {
+		Login struct {
+			PerMinute int `conf:"default:10"`
+			Burst     int `conf:"default:5"`
+		}
+		Messaging struct {
+			PerMinute int `conf:"default:120"`
+			Burst     int `conf:"default:30"`
+		}
+		Search struct {
+			PerMinute int `conf:"default:30"`
+			Burst     int `conf:"default:10"`
+		}
+		Uploads struct {
+			PerMinute int `conf:"default:6"`
+			Burst     int `conf:"default:3"`
+		}
+	}
+	OpenAPI struct {
+		Validate bool
+		Strict   bool
+	}
+	Tracing struct {
+		Enabled     bool
+		Endpoint    string
+		ServiceName string `conf:"default:wasatext"`
+	}
+	LinkPreview struct {
+		Enabled              bool
+		Workers              int           `conf:"default:2"`
+		Timeout              time.Duration `conf:"default:5s"`
+		MaxBytes             int64         `conf:"default:524288"`
+		AllowPrivateNetworks bool
+	}
+	Files struct {
+		MaxSize       int64    `conf:"default:26214400"`
+		MaxConcurrent int      `conf:"default:4"`
+		AllowedTypes  []string `conf:"default:application/pdf;application/zip;application/x-gzip;application/ogg;text/plain;text/csv;image/*;audio/*;video/*"`
+	}
+	Audio struct {
+		MaxSize     int64         `conf:"default:10485760"`
+		MaxDuration time.Duration `conf:"default:5m"`
+	}
+	Backup struct {
+		Dir       string `conf:"default:/tmp/decaf-backups"`
+		Interval  time.Duration
+		Retention int `conf:"default:7"`
+	}
+
+	// Args are the arguments of the backup and restore commands
+	Args conf.Args
 }
 
 // loadConfiguration creates a WebAPIConfiguration starting from flags, environment variables and configuration file.
@@ -34,11 +99,11 @@ type WebAPIConfiguration struct {
 // configuration file (specified in WebAPIConfiguration.Config.Path).
 // So, CLI parameters will override the environment, and configuration file will override everything.
 // Note that the configuration file can be specified only via CLI or environment variable.
-func loadConfiguration() (WebAPIConfiguration, error) {
+func loadConfiguration(args []string) (WebAPIConfiguration, error) {
 	var cfg WebAPIConfiguration
 
 	// Try to load configuration from environment variables and command line switches
-	if err := conf.Parse(os.Args[1:], "CFG", &cfg); err != nil {
+	if err := conf.Parse(args, "CFG", &cfg); err != nil {
 		if errors.Is(err, conf.ErrHelpWanted) {
 			usage, err := conf.Usage("CFG", &cfg)
 			if err != nil {
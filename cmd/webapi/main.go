@@ -2,14 +2,50 @@
 Webapi is the executable for the main web server.
 It builds a web server around APIs from `service/api`.
 Webapi connects to external resources needed (database) and starts two web servers: the API web server, and the debug.
-Everything is served via the API web server, except debug variables (/debug/vars) and profiler infos (pprof).
+Everything is served via the API web server, except debug variables (/debug/vars), profiler infos (pprof), the
+Prometheus metrics (/metrics) and the readiness probe (/readiness), served by the debug server on Web.DebugHost (set it empty to disable the debug server).
+The debug server listens on the loopback interface by default, as the profiler must not be reachable by the API
+clients. If it cannot listen, the error is logged and the API server keeps running.
 
 Usage:
 
 	webapi [flags]
+	webapi backup [flags] [file]
+	webapi restore [flags] <file>
+
+The command can also be given after the flags.
 
 Flags and configurations are handled automatically by the code in `load-configuration.go`.
 
+The backup command takes a snapshot of the live SQLite database using the SQLite online backup API, in `file` or in a
+new timestamped file of the backup directory (keeping the newest Backup.Retention files). The server takes the same
+backups periodically when Backup.Interval is set. The restore command validates a backup (integrity and schema version)
+and copies it over the database, migrating it to the latest schema version. Stop the server first: the restore refuses
+a database open in another process.
+
+With OpenAPI.Validate, requests are checked against the OpenAPI document (doc/api.yaml, embedded in the executable)
+and the mismatches are logged; in debug mode, responses are checked too. OpenAPI.Strict rejects the mismatches, and
+stops the program at startup if an API route is missing from the document.
+
+With Tracing.Enabled, each API request and the database calls it makes are traced, continuing the trace of the caller
+when it sends a W3C `traceparent` header. Spans are sent to the OTLP/HTTP collector at Tracing.Endpoint, or printed on
+stdout as JSON lines when no endpoint is set.
+
+With LinkPreview.Enabled (off by default, as the server then fetches the pages linked by its users), the first link
+of each text message gets a preview (title, description and image of the page), fetched in the background by
+LinkPreview.Workers workers. Each fetch lasts at most LinkPreview.Timeout and reads at most LinkPreview.MaxBytes of
+the page. Pages in private networks are refused, unless LinkPreview.AllowPrivateNetworks is set. Once stored, the
+message with its preview is pushed to the participants connected to GET /users/me/events.
+
+The event streams of GET /users/me/events end after Web.WriteTimeout, like every response: the clients reconnect, and
+get the events they missed in between with the Last-Event-ID header.
+
+Files sent in the conversations are stored in the database. They are at most Files.MaxSize bytes, of one of the MIME
+types in Files.AllowedTypes ("audio/*" allows all the types of audio). The whole upload must arrive within
+Web.ReadTimeout: raise it to accept large files from slow clients. Uploads are held in memory until stored, so at most
+Files.MaxConcurrent of them are handled at once: the others wait, up to Web.WriteTimeout, then get 503. Voice messages
+(Ogg/Opus or WAV) are stored in the database too, and are at most Audio.MaxSize bytes and Audio.MaxDuration long.
+
 Return values (exit codes):
 
 	0
@@ -25,21 +61,28 @@ package main
 
 import (
 	"context"
-	"database/sql"
 	"errors"
 	"fmt"
 	"math/rand"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
+	"sync/atomic"
 	"syscall"
 
 	"github.com/ardanlabs/conf"
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/sirupsen/logrus"
+	"github.com/val7e/wasaText/doc"
 	"github.com/val7e/wasaText/service/api"
 	"github.com/val7e/wasaText/service/database"
 	"github.com/val7e/wasaText/service/globaltime"
+	"github.com/val7e/wasaText/service/linkpreview"
+	"github.com/val7e/wasaText/service/metrics"
+	"github.com/val7e/wasaText/service/openapi"
+	"github.com/val7e/wasaText/service/tracing"
 )
 
 // main is the program entry point. The only purpose of this function is to call run() and set the exit code if there is
@@ -61,8 +104,14 @@ func main() {
 // * closes the principal web server
 func run() error {
 	rand.Seed(globaltime.Now().UnixNano())
+	// The first argument selects the command, if it is not a flag
+	command, args := "", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
+
 	// Load Configuration and defaults
-	cfg, err := loadConfiguration()
+	cfg, err := loadConfiguration(args)
 	if err != nil {
 		if errors.Is(err, conf.ErrHelpWanted) {
 			return nil
@@ -73,31 +122,121 @@ func run() error {
 	// Init logging
 	logger := logrus.New()
 	logger.SetOutput(os.Stdout)
+	if cfg.Log.JSON {
+		logger.SetFormatter(&logrus.JSONFormatter{})
+	}
 	if cfg.Debug {
 		logger.SetLevel(logrus.DebugLevel)
 	} else {
 		logger.SetLevel(logrus.InfoLevel)
 	}
 
+	// The command may also follow the flags
+	if command == "" && len(cfg.Args) > 0 {
+		command, cfg.Args = cfg.Args[0], cfg.Args[1:]
+	}
+	switch command {
+	case "":
+	case "backup":
+		return runBackup(cfg, logger)
+	case "restore":
+		return runRestore(cfg, logger)
+	default:
+		return fmt.Errorf("unknown command %q", command)
+	}
+
 	logger.Infof("application initializing")
 
 	// Start Database
 	logger.Println("initializing database support")
-	dbconn, err := sql.Open("sqlite3", cfg.DB.Filename)
+	dbconn, err := openDatabase(cfg)
 	if err != nil {
-		logger.WithError(err).Error("error opening SQLite DB")
-		return fmt.Errorf("opening SQLite: %w", err)
+		logger.WithError(err).WithField("driver", cfg.DB.Driver).Error("error opening DB")
+		return fmt.Errorf("opening %s: %w", cfg.DB.Driver, err)
 	}
 	defer func() {
 		logger.Debug("database stopping")
 		_ = dbconn.Close()
 	}()
-	db, err := database.New(dbconn)
+	// Metrics are collected in a registry served by the debug server
+	reg := metrics.NewRegistry()
+
+	// Traces are sent to the OTLP collector at Tracing.Endpoint, or printed on stdout if no endpoint is set
+	var tracer *tracing.Tracer
+	if cfg.Tracing.Enabled {
+		var exporter tracing.Exporter = tracing.NewStdoutExporter(os.Stdout)
+		if cfg.Tracing.Endpoint != "" {
+			exporter = tracing.NewOTLPExporter(cfg.Tracing.Endpoint, cfg.Tracing.ServiceName)
+		}
+		tracer, err = tracing.NewTracer(tracing.Config{
+			ServiceName: cfg.Tracing.ServiceName,
+			Exporter:    exporter,
+			OnError: func(err error) {
+				logger.WithError(err).Warning("error exporting traces")
+			},
+		})
+		if err != nil {
+			return fmt.Errorf("creating tracer: %w", err)
+		}
+		defer func() {
+			ctx, cancel := context.WithTimeout(context.Background(), cfg.Web.ShutdownTimeout)
+			defer cancel()
+			if err := tracer.Shutdown(ctx); err != nil {
+				logger.WithError(err).Warning("error flushing traces")
+			}
+		}()
+	}
+
+	db, err := database.New(dbconn, database.Config{
+		Driver:       cfg.DB.Driver,
+		QueryTimeout: cfg.DB.QueryTimeout,
+	})
 	if err != nil {
 		logger.WithError(err).Error("error creating AppDatabase")
 		return fmt.Errorf("creating AppDatabase: %w", err)
 	}
 
+	// Every database call is measured, and traced when tracing is enabled
+	appdb := database.NewInstrumented(db, database.InstrumentConfig{Metrics: reg, Tracer: tracer})
+
+	// Link previews are fetched in the background, and stored with the messages
+	var previews *linkpreview.Service
+	if cfg.LinkPreview.Enabled {
+		previews, err = linkpreview.New(linkpreview.Config{
+			Fetcher: linkpreview.NewHTTPFetcher(linkpreview.HTTPConfig{
+				Timeout:              cfg.LinkPreview.Timeout,
+				MaxBytes:             cfg.LinkPreview.MaxBytes,
+				AllowPrivateNetworks: cfg.LinkPreview.AllowPrivateNetworks,
+			}),
+			Store:   appdb,
+			Logger:  logger,
+			Workers: cfg.LinkPreview.Workers,
+			Timeout: 2 * cfg.LinkPreview.Timeout,
+		})
+		if err != nil {
+			return fmt.Errorf("creating the link previews: %w", err)
+		}
+		defer func() {
+			ctx, cancel := context.WithTimeout(context.Background(), cfg.Web.ShutdownTimeout)
+			defer cancel()
+			if err := previews.Close(ctx); err != nil {
+				logger.WithError(err).Warning("link previews dropped at shutdown")
+			}
+		}()
+	}
+
+	// Start scheduled backups
+	if cfg.Backup.Interval > 0 {
+		if cfg.DB.Driver != "sqlite3" {
+			logger.Warnf("scheduled backups are supported only for sqlite3 databases, ignoring Backup.Interval")
+		} else {
+			backupCtx, stopBackups := context.WithCancel(context.Background())
+			defer stopBackups()
+			go scheduleBackups(backupCtx, cfg, dbconn, logger)
+			logger.WithField("interval", cfg.Backup.Interval).Info("scheduled backups enabled")
+		}
+	}
+
 	// Start (main) API server
 	logger.Info("initializing API server")
 
@@ -106,20 +245,53 @@ func run() error {
 	shutdown := make(chan os.Signal, 1)
 	signal.Notify(shutdown, os.Interrupt, syscall.SIGTERM)
 
-	// Make a channel to listen for errors coming from the listener. Use a
-	// buffered channel so the goroutine can exit if we don't collect this error.
-	serverErrors := make(chan error, 1)
+	// Make a channel to listen for errors coming from the listeners. Use a
+	// buffered channel so the goroutines can exit if we don't collect these errors.
+	serverErrors := make(chan error, 2)
+
+	// Requests (and, in debug mode, responses) are checked against the OpenAPI document when enabled. Strict mode
+	// rejects the mismatches, and refuses to start if an API route is missing from the document.
+	var validation api.Validation
+	if cfg.OpenAPI.Validate || cfg.OpenAPI.Strict {
+		spec, err := openapi.Load(doc.APISpec)
+		if err != nil {
+			return fmt.Errorf("loading the API document: %w", err)
+		}
+		validation = api.Validation{Spec: spec, Strict: cfg.OpenAPI.Strict, Responses: cfg.Debug}
+	}
 
 	// Create the API router
 	apirouter, err := api.New(api.Config{
-		Logger:   logger,
-		Database: db,
+		Logger:         logger,
+		Database:       appdb,
+		RequestTimeout: cfg.Web.WriteTimeout,
+		Metrics:        reg,
+		Tracer:         tracer,
+		RateLimits: api.RateLimits{
+			Login:     api.RateLimit(cfg.RateLimit.Login),
+			Messaging: api.RateLimit(cfg.RateLimit.Messaging),
+			Search:    api.RateLimit(cfg.RateLimit.Search),
+			Uploads:   api.RateLimit(cfg.RateLimit.Uploads),
+		},
+		Validation: validation,
+		Files: api.FileLimits{MaxSize: cfg.Files.MaxSize, AllowedTypes: cfg.Files.AllowedTypes,
+			MaxConcurrent: cfg.Files.MaxConcurrent},
+		Audio:        api.AudioLimits{MaxSize: cfg.Audio.MaxSize, MaxDuration: cfg.Audio.MaxDuration},
+		LinkPreviews: previews,
 	})
 	if err != nil {
 		logger.WithError(err).Error("error creating the API server instance")
 		return fmt.Errorf("creating the API server instance: %w", err)
 	}
 	router := apirouter.Handler()
+	if missing := apirouter.UndocumentedRoutes(); len(missing) > 0 {
+		if cfg.OpenAPI.Strict {
+			return fmt.Errorf("routes missing from the API document: %s", strings.Join(missing, ", "))
+		}
+		for _, route := range missing {
+			logger.WithField("route", route).Warning("route missing from the API document")
+		}
+	}
 
 	router, err = registerWebUI(router)
 	if err != nil {
@@ -130,6 +302,14 @@ func run() error {
 	// Apply CORS policy
 	router = applyCORSHandler(router)
 
+	// Count requests for the debug server
+	router = countRequests(router)
+
+	// Every request context derives from baseCtx, so cancelling it interrupts in-flight database work when the
+	// graceful shutdown deadline expires.
+	baseCtx, cancelBaseCtx := context.WithCancel(context.Background())
+	defer cancelBaseCtx()
+
 	// Create the API server
 	apiserver := http.Server{
 		Addr:              cfg.Web.APIHost,
@@ -137,6 +317,9 @@ func run() error {
 		ReadTimeout:       cfg.Web.ReadTimeout,
 		ReadHeaderTimeout: cfg.Web.ReadTimeout,
 		WriteTimeout:      cfg.Web.WriteTimeout,
+		BaseContext: func(net.Listener) context.Context {
+			return baseCtx
+		},
 	}
 
 	// Start the service listening for requests in a separate goroutine
@@ -146,6 +329,25 @@ func run() error {
 		logger.Infof("stopping API server")
 	}()
 
+	// Start the debug server, if enabled. It reports not ready as soon as the shutdown begins, so that load balancers
+	// stop sending requests while the API server drains. Its errors are not fatal: the API works without it.
+	var shuttingDown int32
+	var debugserver *http.Server
+	if cfg.Web.DebugHost != "" {
+		debugserver = &http.Server{
+			Addr:              cfg.Web.DebugHost,
+			Handler:           debugHandler(dbconn, &shuttingDown, reg),
+			ReadHeaderTimeout: cfg.Web.ReadTimeout,
+		}
+		go func() {
+			logger.Infof("debug server listening on %s", debugserver.Addr)
+			if err := debugserver.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				logger.WithError(err).Error("debug server error")
+			}
+			logger.Infof("stopping debug server")
+		}()
+	}
+
 	// Waiting for shutdown signal or POSIX signals
 	select {
 	case err := <-serverErrors:
@@ -154,6 +356,7 @@ func run() error {
 
 	case sig := <-shutdown:
 		logger.Infof("signal %v received, start shutdown", sig)
+		atomic.StoreInt32(&shuttingDown, 1)
 
 		// Asking API server to shut down and load shed.
 		err := apirouter.Close()
@@ -169,9 +372,18 @@ func run() error {
 		err = apiserver.Shutdown(ctx)
 		if err != nil {
 			logger.WithError(err).Warning("error during graceful shutdown of HTTP server")
+			cancelBaseCtx()
 			err = apiserver.Close()
 		}
 
+		// The debug server goes down last
+		if debugserver != nil {
+			if err := debugserver.Shutdown(ctx); err != nil {
+				logger.WithError(err).Warning("error during graceful shutdown of debug server")
+				_ = debugserver.Close()
+			}
+		}
+
 		// Log the status of this shutdown.
 		switch {
 		case sig == syscall.SIGSTOP:
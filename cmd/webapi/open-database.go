@@ -0,0 +1,48 @@
+package main
+
+import (
+	"database/sql"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// openDatabase opens the database/sql handle described by the DB section of the configuration and sizes its
+// connection pool.
+func openDatabase(cfg WebAPIConfiguration) (*sql.DB, error) {
+	dsn := cfg.DB.DSN
+	if cfg.DB.Driver == "sqlite3" {
+		dsn = sqliteDSN(cfg)
+	}
+
+	dbconn, err := sql.Open(cfg.DB.Driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	dbconn.SetMaxOpenConns(cfg.DB.MaxOpenConns)
+	dbconn.SetMaxIdleConns(cfg.DB.MaxIdleConns)
+	dbconn.SetConnMaxLifetime(cfg.DB.ConnMaxLifetime)
+	return dbconn, nil
+}
+
+// sqliteDSN builds the go-sqlite3 data source name for the configured file. PRAGMAs like foreign_keys and busy_timeout
+// are per connection, so they are passed in the DSN to be applied to every connection of the pool:
+//   - _journal_mode: WAL lets readers run concurrently with the (single) writer
+//   - _busy_timeout: how long a connection waits for a lock held by another one before failing with "database is locked"
+//   - _foreign_keys: enforces the FOREIGN KEY clauses of the schema (ON DELETE CASCADE included)
+//   - _txlock=immediate: transactions take the write lock at BEGIN. With the default (deferred) a transaction that reads
+//     and then writes fails right away with "database is locked" when another connection wrote in between, as SQLite
+//     cannot wait for the lock without breaking the isolation of what was read.
+func sqliteDSN(cfg WebAPIConfiguration) string {
+	params := url.Values{}
+	params.Set("_journal_mode", cfg.DB.JournalMode)
+	params.Set("_busy_timeout", strconv.FormatInt(cfg.DB.BusyTimeout.Milliseconds(), 10))
+	params.Set("_foreign_keys", strconv.FormatBool(cfg.DB.ForeignKeys))
+	params.Set("_txlock", "immediate")
+
+	sep := "?"
+	if strings.Contains(cfg.DB.Filename, "?") {
+		sep = "&"
+	}
+	return cfg.DB.Filename + sep + params.Encode()
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/val7e/wasaText/service/database"
+	"github.com/val7e/wasaText/service/models"
+)
+
+// TestConcurrentSenders sends messages to a group from many goroutines at once, on a SQLite file opened with the
+// default configuration: with deferred transactions some of them failed with "database is locked".
+func TestConcurrentSenders(t *testing.T) {
+	const senders, messages = 16, 50
+
+	dir := t.TempDir()
+	cfg, err := loadConfiguration([]string{
+		"--config-path", filepath.Join(dir, "config.yml"),
+		"--db-filename", filepath.Join(dir, "wasatext.db"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dbconn, err := openDatabase(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = dbconn.Close() })
+	db, err := database.New(dbconn, database.Config{Driver: cfg.DB.Driver, QueryTimeout: cfg.DB.QueryTimeout})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	ids := make([]int64, senders)
+	usernames := make([]string, senders)
+	for i := range ids {
+		usernames[i] = fmt.Sprintf("sender%d", i)
+		user, _, err := db.DoLogin(ctx, usernames[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids[i] = user.Id
+	}
+	group, err := db.CreateGroup(ctx, ids[0], "load")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.AddToGroup(ctx, group.Id, usernames[1:]); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, senders*messages)
+	for _, id := range ids {
+		wg.Add(1)
+		go func(id int64) {
+			defer wg.Done()
+			for n := 0; n < messages; n++ {
+				text := fmt.Sprintf("message %d of %d", n, id)
+				if _, err := db.SendMessage(ctx, group.Id, id, models.NewMessage{Type: "text", Text: &text}); err != nil {
+					errs <- err
+				}
+			}
+		}(id)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	conversation, err := db.GetConversation(ctx, group.Id, ids[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := len(conversation.Messages); got != senders*messages {
+		t.Errorf("got %d messages, want %d", got, senders*messages)
+	}
+}
@@ -0,0 +1,9 @@
+// Package doc contains the OpenAPI document of the API for embedding
+package doc
+
+import _ "embed"
+
+// APISpec is the OpenAPI document in api.yaml
+//
+//go:embed "api.yaml"
+var APISpec []byte
@@ -0,0 +1,42 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/val7e/wasaText/service/models"
+)
+
+// Audio is the recording of a voice message.
+type Audio = models.Audio
+
+// MessageAudio is the type of the voice messages.
+const MessageAudio = "audio"
+
+// SendAudio sends a voice message to a conversation. r is the recording, of type mimeType (audio/ogg for Ogg/Opus, or
+// audio/wav): other formats are refused with an *Error matching ErrUnsupportedType.
+func (c *Client) SendAudio(ctx context.Context, conversationID int64, mimeType string, r io.Reader) (*Message, error) {
+	var msg Message
+	_, err := c.send(ctx, http.MethodPost, "/conversations/"+pathID(conversationID)+"/audio", mimeType, r, &msg)
+	if err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
+
+// DownloadAudio writes the recording of a voice message to w, and returns the number of bytes written.
+func (c *Client) DownloadAudio(ctx context.Context, conversationID, messageID int64, w io.Writer) (int64, error) {
+	res, err := c.roundTrip(ctx, http.MethodGet, messagePath(conversationID, messageID)+"/audio", "audio/*", "", nil)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = res.Body.Close() }()
+
+	n, err := io.Copy(w, res.Body)
+	if err != nil {
+		return n, fmt.Errorf("downloading the recording: %w", err)
+	}
+	return n, nil
+}
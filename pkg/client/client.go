@@ -0,0 +1,201 @@
+/*
+Package client is a typed Go client for the WASAText API described in doc/api.yaml.
+
+A Client is created with the base URL of the API. Login registers (or logs in) a user and stores the identifier
+returned by the server, which is then sent as Bearer token by every other call:
+
+	c, err := client.New("http://localhost:3000")
+	if err != nil {
+		return err
+	}
+	me, err := c.Login(ctx, "alice")
+	if err != nil {
+		return err
+	}
+	conv, err := c.StartConversation(ctx, "bob")
+	if err != nil {
+		return err
+	}
+	_, err = c.SendText(ctx, conv.Id, "hi!")
+
+Error replies of the server are returned as *Error, which can be matched with errors.Is against ErrNotFound,
+ErrForbidden and the other sentinel errors. A Client is safe for concurrent use.
+*/
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/val7e/wasaText/service/tracing"
+)
+
+// Client calls the WASAText API.
+type Client struct {
+	baseURL    *url.URL
+	httpClient *http.Client
+
+	mu    sync.RWMutex
+	token string
+}
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithHTTPClient sets the http.Client used for the requests (default: a client with a 30 seconds timeout).
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = hc
+	}
+}
+
+// WithToken sets the Bearer token (the user identifier) of a user that already logged in.
+func WithToken(token string) Option {
+	return func(c *Client) {
+		c.token = token
+	}
+}
+
+// New returns a Client for the API at baseURL (like http://localhost:3000).
+func New(baseURL string, opts ...Option) (*Client, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("parsing the base URL: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported base URL scheme %q", u.Scheme)
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/")
+
+	c := &Client{
+		baseURL:    u,
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c, nil
+}
+
+// Token returns the Bearer token sent with the requests, empty before Login.
+func (c *Client) Token() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.token
+}
+
+// SetToken replaces the Bearer token sent with the requests.
+func (c *Client) SetToken(token string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.token = token
+}
+
+// do sends a request with the JSON encoding of `in` as body (if not nil), and decodes the JSON response into `out`
+// (if not nil). It returns the status code of successful responses, and an *Error otherwise.
+func (c *Client) do(ctx context.Context, method, path string, in, out interface{}) (int, error) {
+	var body io.Reader
+	var contentType string
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return 0, fmt.Errorf("encoding the request: %w", err)
+		}
+		body, contentType = bytes.NewReader(b), "application/json"
+	}
+	return c.send(ctx, method, path, contentType, body, out)
+}
+
+// send is do with a body already encoded as contentType.
+func (c *Client) send(ctx context.Context, method, path, contentType string, body io.Reader, out interface{}) (int, error) {
+	res, err := c.roundTrip(ctx, method, path, "application/json", contentType, body)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = res.Body.Close() }()
+
+	if out != nil && res.StatusCode != http.StatusNoContent {
+		if err := json.NewDecoder(res.Body).Decode(out); err != nil {
+			return res.StatusCode, fmt.Errorf("decoding the response of %s %s: %w", method, path, err)
+		}
+	}
+	return res.StatusCode, nil
+}
+
+// roundTrip sends a request, and returns the response if successful (the caller closes its body), or an *Error.
+func (c *Client) roundTrip(ctx context.Context, method, path, accept, contentType string, body io.Reader) (*http.Response, error) {
+	u := *c.baseURL
+	if i := strings.IndexByte(path, '?'); i >= 0 {
+		u.Path += path[:i]
+		u.RawQuery = path[i+1:]
+	} else {
+		u.Path += path
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, fmt.Errorf("creating the request: %w", err)
+	}
+	req.Header.Set("Accept", accept)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if token := c.Token(); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	tracing.Inject(ctx, req.Header)
+
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode >= 400 {
+		defer func() { _ = res.Body.Close() }()
+		return nil, newError(res)
+	}
+	return res, nil
+}
+
+// newError reads the error reply of the server.
+func newError(res *http.Response) error {
+	e := &Error{
+		StatusCode: res.StatusCode,
+		RequestID:  res.Header.Get("X-Request-Id"),
+	}
+	if s, err := strconv.Atoi(res.Header.Get("Retry-After")); err == nil {
+		e.RetryAfter = time.Duration(s) * time.Second
+	}
+
+	b, _ := io.ReadAll(io.LimitReader(res.Body, 64<<10))
+	var reply struct {
+		Error  string `json:"error"`
+		Field  string `json:"field"`
+		Reason string `json:"reason"`
+	}
+	if err := json.Unmarshal(b, &reply); err == nil && reply.Error != "" {
+		e.Message, e.Field, e.Reason = reply.Error, reply.Field, reply.Reason
+	} else {
+		e.Message = strings.TrimSpace(string(b))
+	}
+	if e.Message == "" {
+		e.Message = http.StatusText(res.StatusCode)
+	}
+	return e
+}
+
+// pathID formats an identifier for a path segment.
+func pathID(id int64) string {
+	return strconv.FormatInt(id, 10)
+}
+
+var errEmptyToken = errors.New("the server returned an empty identifier")
@@ -0,0 +1,363 @@
+package client_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/val7e/wasaText/doc"
+	"github.com/val7e/wasaText/pkg/client"
+	"github.com/val7e/wasaText/service/api"
+	"github.com/val7e/wasaText/service/database/memdb"
+	"github.com/val7e/wasaText/service/openapi"
+)
+
+var ctx = context.Background()
+
+// newServer starts the API over an in-memory database, validating the requests and the responses against
+// doc/api.yaml: a client call that does not follow the document fails with 400 or 500.
+func newServer(t *testing.T, limits api.RateLimits) string {
+	t.Helper()
+	spec, err := openapi.Load(doc.APISpec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	router, err := api.New(api.Config{
+		Logger:     logger,
+		Database:   memdb.New(memdb.Config{}),
+		RateLimits: limits,
+		Validation: api.Validation{Spec: spec, Strict: true, Responses: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(router.Handler())
+	t.Cleanup(func() {
+		srv.Close()
+		_ = router.Close()
+	})
+	return srv.URL
+}
+
+// login returns a client logged in as username
+func login(t *testing.T, url, username string) *client.Client {
+	t.Helper()
+	c, err := client.New(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Login(ctx, username); err != nil {
+		t.Fatalf("Login(%q): %v", username, err)
+	}
+	return c
+}
+
+func TestLogin(t *testing.T) {
+	url := newServer(t, api.RateLimits{})
+	c, err := client.New(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := c.Login(ctx, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Created || res.Username != "alice" || c.Token() != res.Identifier {
+		t.Errorf("first login: got %+v, token %q", res, c.Token())
+	}
+	again, err := c.Login(ctx, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.Created || again.Identifier != res.Identifier {
+		t.Errorf("second login: got %+v, want the identifier %q", again, res.Identifier)
+	}
+
+	me, err := c.SetMyUserName(ctx, "alice2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if me.Username != "alice2" {
+		t.Errorf("SetMyUserName: got %+v", me)
+	}
+	users, err := c.SearchUsers(ctx, "ice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 1 || users[0].Username != "alice2" {
+		t.Errorf("SearchUsers: got %+v", users)
+	}
+}
+
+func TestMessages(t *testing.T) {
+	url := newServer(t, api.RateLimits{})
+	alice := login(t, url, "alice")
+	bob := login(t, url, "bob")
+
+	conv, err := alice.StartConversation(ctx, "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := alice.SendText(ctx, conv.Id, "hi **bob**")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Sender != "alice" || msg.Text == nil || *msg.Text != "hi bob" {
+		t.Errorf("SendText: got %+v", msg)
+	}
+
+	summaries, err := bob.GetMyConversations(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(summaries) != 1 || summaries[0].Id != conv.Id || summaries[0].LastMessage == nil {
+		t.Errorf("GetMyConversations: got %+v", summaries)
+	}
+
+	comment, err := bob.CommentMessage(ctx, conv.Id, msg.Id, "👍")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bob.EditComment(ctx, conv.Id, msg.Id, comment.Id, "❤️"); err != nil {
+		t.Fatal(err)
+	}
+	comments, err := alice.GetComments(ctx, conv.Id, msg.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(comments) != 1 || comments[0].Text != "❤️" {
+		t.Errorf("GetComments: got %+v", comments)
+	}
+	if err := bob.UncommentMessage(ctx, conv.Id, msg.Id, comment.Id); err != nil {
+		t.Fatal(err)
+	}
+
+	reply, err := bob.ReplyText(ctx, conv.Id, msg.Id, "hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	replies, err := alice.GetReplies(ctx, conv.Id, msg.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(replies) != 1 || replies[0].Id != reply.Id {
+		t.Errorf("GetReplies: got %+v, want %+v", replies, reply)
+	}
+	if err := alice.MarkThreadRead(ctx, conv.Id, msg.Id, reply.Id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := alice.GetMyThreads(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := alice.DeleteMessage(ctx, conv.Id, msg.Id); err != nil {
+		t.Fatal(err)
+	}
+	conversation, err := bob.GetConversation(ctx, conv.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range conversation.Messages {
+		if m.Id == msg.Id {
+			t.Errorf("GetConversation: the deleted message %d is still there", msg.Id)
+		}
+	}
+}
+
+func TestCommentsPages(t *testing.T) {
+	url := newServer(t, api.RateLimits{})
+	alice := login(t, url, "alice")
+	login(t, url, "bob")
+	conv, err := alice.StartConversation(ctx, "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := alice.SendText(ctx, conv.Id, "pages")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// GetComments reads the second page too
+	for i := 0; i <= client.CommentsPageSize; i++ {
+		if _, err := alice.CommentMessage(ctx, conv.Id, msg.Id, strconv.Itoa(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	comments, err := alice.GetComments(ctx, conv.Id, msg.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(comments) != client.CommentsPageSize+1 {
+		t.Fatalf("GetComments: got %d comments, want %d", len(comments), client.CommentsPageSize+1)
+	}
+	for i, c := range comments {
+		if c.Text != strconv.Itoa(i) {
+			t.Fatalf("GetComments: comment %d is %q", i, c.Text)
+		}
+	}
+
+	page, err := alice.GetCommentsPage(ctx, conv.Id, msg.Id, comments[9].Id, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page) != 5 || page[0].Id != comments[10].Id {
+		t.Errorf("GetCommentsPage(after %d, 5): got %+v", comments[9].Id, page)
+	}
+}
+
+func TestGroups(t *testing.T) {
+	url := newServer(t, api.RateLimits{})
+	alice := login(t, url, "alice")
+	bob := login(t, url, "bob")
+	login(t, url, "carol")
+
+	group, err := alice.CreateGroup(ctx, "friends")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if group, err = alice.AddToGroup(ctx, group.Id, "bob", "carol"); err != nil {
+		t.Fatal(err)
+	}
+	if len(group.Members) != 3 {
+		t.Errorf("AddToGroup: got %+v", group)
+	}
+	if _, err := bob.SetGroupName(ctx, group.Id, "best friends"); err != nil {
+		t.Fatal(err)
+	}
+	if group, err = alice.GetGroup(ctx, group.Id); err != nil {
+		t.Fatal(err)
+	}
+	if group.Name != "best friends" {
+		t.Errorf("GetGroup: got %+v", group)
+	}
+
+	if _, err := alice.SendText(ctx, group.Id, "hi @bob"); err != nil {
+		t.Fatal(err)
+	}
+	mentions, err := bob.GetMyMentions(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mentions) != 1 {
+		t.Errorf("GetMyMentions: got %+v", mentions)
+	}
+
+	if err := bob.LeaveGroup(ctx, group.Id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bob.GetConversation(ctx, group.Id); !errors.Is(err, client.ErrForbidden) {
+		t.Errorf("GetConversation after LeaveGroup: got %v, want %v", err, client.ErrForbidden)
+	}
+}
+
+func TestFilesAndAudio(t *testing.T) {
+	url := newServer(t, api.RateLimits{})
+	alice := login(t, url, "alice")
+	bob := login(t, url, "bob")
+	conv, err := alice.StartConversation(ctx, "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content := "name,count\nalice,1\n"
+	msg, err := alice.SendFile(ctx, conv.Id, "counts.csv", strings.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var file bytes.Buffer
+	if _, err := bob.DownloadFile(ctx, conv.Id, msg.Id, &file); err != nil {
+		t.Fatal(err)
+	}
+	if file.String() != content {
+		t.Errorf("DownloadFile: got %q, want %q", file.String(), content)
+	}
+
+	wav := sineWAV(8000, 8000)
+	msg, err = alice.SendAudio(ctx, conv.Id, "audio/wav", bytes.NewReader(wav))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Audio == nil || msg.Audio.DurationMs != 1000 {
+		t.Errorf("SendAudio: got %+v", msg.Audio)
+	}
+	var audio bytes.Buffer
+	if _, err := bob.DownloadAudio(ctx, conv.Id, msg.Id, &audio); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(audio.Bytes(), wav) {
+		t.Errorf("DownloadAudio: got %d bytes, want the %d sent", audio.Len(), len(wav))
+	}
+
+	if _, err := alice.SendAudio(ctx, conv.Id, "audio/mpeg", strings.NewReader("ID3")); !errors.Is(err, client.ErrUnsupportedType) {
+		t.Errorf("SendAudio(audio/mpeg): got %v, want %v", err, client.ErrUnsupportedType)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	url := newServer(t, api.RateLimits{Messaging: api.RateLimit{PerMinute: 1, Burst: 1}})
+	anonymous, err := client.New(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := anonymous.GetMyConversations(ctx); !errors.Is(err, client.ErrUnauthorized) {
+		t.Errorf("without login: got %v, want %v", err, client.ErrUnauthorized)
+	}
+
+	alice := login(t, url, "alice")
+	if _, err := alice.GetConversation(ctx, 1234); !errors.Is(err, client.ErrForbidden) {
+		t.Errorf("GetConversation(1234): got %v, want %v", err, client.ErrForbidden)
+	}
+	if _, err := alice.StartConversation(ctx, "nobody"); !errors.Is(err, client.ErrNotFound) {
+		t.Errorf("StartConversation(nobody): got %v, want %v", err, client.ErrNotFound)
+	}
+
+	login(t, url, "bob")
+	conv, err := alice.StartConversation(ctx, "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = alice.SendFile(ctx, conv.Id, "empty.txt", strings.NewReader(""))
+	var e *client.Error
+	if !errors.As(err, &e) || !errors.Is(err, client.ErrBadRequest) || e.Field != "file" {
+		t.Errorf("SendFile with an empty file: got %#v", err)
+	}
+
+	if _, err := alice.SendText(ctx, conv.Id, "first"); err != nil {
+		t.Fatal(err)
+	}
+	_, err = alice.SendText(ctx, conv.Id, "second")
+	if !errors.As(err, &e) || !errors.Is(err, client.ErrTooManyRequests) || e.RetryAfter <= 0 {
+		t.Errorf("over the rate limit: got %#v", err)
+	}
+}
+
+// sineWAV returns a mono 16-bit PCM WAV file of n samples of a 440 Hz tone
+func sineWAV(rate, n int) []byte {
+	var b bytes.Buffer
+	le := binary.LittleEndian
+	b.WriteString("RIFF")
+	_ = binary.Write(&b, le, uint32(36+2*n))
+	b.WriteString("WAVEfmt ")
+	_ = binary.Write(&b, le, []uint32{16})
+	_ = binary.Write(&b, le, []uint16{1, 1})
+	_ = binary.Write(&b, le, []uint32{uint32(rate), uint32(2 * rate)})
+	_ = binary.Write(&b, le, []uint16{2, 16})
+	b.WriteString("data")
+	_ = binary.Write(&b, le, uint32(2*n))
+	for i := 0; i < n; i++ {
+		_ = binary.Write(&b, le, int16(8000*math.Sin(2*math.Pi*440*float64(i)/float64(rate))))
+	}
+	return b.Bytes()
+}
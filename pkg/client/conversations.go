@@ -0,0 +1,42 @@
+package client
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/val7e/wasaText/service/models"
+)
+
+// Types of the conversations.
+type (
+	Conversation        = models.Conversation
+	ConversationSummary = models.ConversationSummary
+	MessagePreview      = models.MessagePreview
+)
+
+// GetMyConversations returns the conversations of the current user, the most recent first.
+func (c *Client) GetMyConversations(ctx context.Context) ([]ConversationSummary, error) {
+	var conversations []ConversationSummary
+	_, err := c.do(ctx, http.MethodGet, "/conversations", nil, &conversations)
+	return conversations, err
+}
+
+// StartConversation returns the direct conversation with the recipient, creating it if it doesn't exist.
+func (c *Client) StartConversation(ctx context.Context, recipient string) (*Conversation, error) {
+	var conv Conversation
+	_, err := c.do(ctx, http.MethodPost, "/conversations", map[string]string{"recipient": recipient}, &conv)
+	if err != nil {
+		return nil, err
+	}
+	return &conv, nil
+}
+
+// GetConversation returns a conversation with its messages.
+func (c *Client) GetConversation(ctx context.Context, conversationID int64) (*Conversation, error) {
+	var conv Conversation
+	_, err := c.do(ctx, http.MethodGet, "/conversations/"+pathID(conversationID), nil, &conv)
+	if err != nil {
+		return nil, err
+	}
+	return &conv, nil
+}
@@ -0,0 +1,73 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Sentinel errors matched by *Error (with errors.Is) according to the status code of the reply.
+var (
+	ErrBadRequest      = errors.New("bad request")
+	ErrUnauthorized    = errors.New("unauthorized")
+	ErrForbidden       = errors.New("forbidden")
+	ErrNotFound        = errors.New("not found")
+	ErrConflict        = errors.New("conflict")
+	ErrTooLarge        = errors.New("request body too large")
+	ErrUnsupportedType = errors.New("unsupported media type")
+	ErrTooManyRequests = errors.New("too many requests")
+	ErrServer          = errors.New("server error")
+)
+
+// Error is an error reply of the server.
+type Error struct {
+	// StatusCode is the HTTP status code of the reply
+	StatusCode int
+
+	// Message is the "error" field of the reply (or the body, if it was not JSON)
+	Message string
+
+	// Field and Reason describe invalid request bodies (see the RequestError schema)
+	Field  string
+	Reason string
+
+	// RetryAfter is the time to wait before retrying, for 429 replies
+	RetryAfter time.Duration
+
+	// RequestID is the X-Request-Id of the reply, to match the request with the server logs
+	RequestID string
+}
+
+func (e *Error) Error() string {
+	msg := fmt.Sprintf("%d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+	if e.Field != "" {
+		msg += " (" + e.Field + ": " + e.Reason + ")"
+	} else if e.Reason != "" {
+		msg += " (" + e.Reason + ")"
+	}
+	return msg
+}
+
+// Is matches the sentinel error of the status code, so that errors.Is(err, ErrNotFound) works.
+func (e *Error) Is(target error) bool {
+	switch e.StatusCode {
+	case http.StatusBadRequest:
+		return target == ErrBadRequest
+	case http.StatusUnauthorized:
+		return target == ErrUnauthorized
+	case http.StatusForbidden:
+		return target == ErrForbidden
+	case http.StatusNotFound:
+		return target == ErrNotFound
+	case http.StatusConflict:
+		return target == ErrConflict
+	case http.StatusRequestEntityTooLarge:
+		return target == ErrTooLarge
+	case http.StatusUnsupportedMediaType:
+		return target == ErrUnsupportedType
+	case http.StatusTooManyRequests:
+		return target == ErrTooManyRequests
+	}
+	return e.StatusCode >= 500 && target == ErrServer
+}
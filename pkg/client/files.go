@@ -0,0 +1,58 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+
+	"github.com/val7e/wasaText/service/models"
+)
+
+// File is the file of a file message.
+type File = models.File
+
+// MessageFile is the type of the file messages.
+const MessageFile = "file"
+
+// SendFile sends a file to a conversation, with its name. The server detects the type of the file, and refuses the
+// types it does not allow with an *Error matching ErrUnsupportedType. The file is read in memory before being sent.
+func (c *Client) SendFile(ctx context.Context, conversationID int64, name string, r io.Reader) (*Message, error) {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	part, err := mw.CreateFormFile("file", name)
+	if err != nil {
+		return nil, fmt.Errorf("encoding the request: %w", err)
+	}
+	if _, err := io.Copy(part, r); err != nil {
+		return nil, fmt.Errorf("reading the file: %w", err)
+	}
+	if err := mw.Close(); err != nil {
+		return nil, fmt.Errorf("encoding the request: %w", err)
+	}
+
+	var msg Message
+	_, err = c.send(ctx, http.MethodPost, "/conversations/"+pathID(conversationID)+"/files", mw.FormDataContentType(),
+		&body, &msg)
+	if err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
+
+// DownloadFile writes the content of the file of a message to w, and returns the number of bytes written.
+func (c *Client) DownloadFile(ctx context.Context, conversationID, messageID int64, w io.Writer) (int64, error) {
+	res, err := c.roundTrip(ctx, http.MethodGet, messagePath(conversationID, messageID)+"/file", "*/*", "", nil)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = res.Body.Close() }()
+
+	n, err := io.Copy(w, res.Body)
+	if err != nil {
+		return n, fmt.Errorf("downloading the file: %w", err)
+	}
+	return n, nil
+}
@@ -0,0 +1,51 @@
+package client
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/val7e/wasaText/service/models"
+)
+
+// Group is a group conversation with its members.
+type Group = models.Group
+
+// CreateGroup creates a group with the current user as only member.
+func (c *Client) CreateGroup(ctx context.Context, name string) (*Group, error) {
+	return c.group(ctx, http.MethodPost, "/groups", map[string]string{"name": name})
+}
+
+// GetGroup returns a group.
+func (c *Client) GetGroup(ctx context.Context, groupID int64) (*Group, error) {
+	return c.group(ctx, http.MethodGet, "/groups/"+pathID(groupID), nil)
+}
+
+// SetGroupName renames a group.
+func (c *Client) SetGroupName(ctx context.Context, groupID int64, name string) (*Group, error) {
+	return c.group(ctx, http.MethodPut, "/groups/"+pathID(groupID)+"/name", map[string]string{"name": name})
+}
+
+// SetGroupPhoto changes the photo of a group. photo is the base64-encoded image.
+func (c *Client) SetGroupPhoto(ctx context.Context, groupID int64, photo string) (*Group, error) {
+	return c.group(ctx, http.MethodPut, "/groups/"+pathID(groupID)+"/photo", map[string]string{"photo": photo})
+}
+
+// AddToGroup adds users to a group.
+func (c *Client) AddToGroup(ctx context.Context, groupID int64, usernames ...string) (*Group, error) {
+	return c.group(ctx, http.MethodPost, "/groups/"+pathID(groupID)+"/members",
+		map[string][]string{"members": usernames})
+}
+
+// LeaveGroup removes the current user from a group.
+func (c *Client) LeaveGroup(ctx context.Context, groupID int64) error {
+	_, err := c.do(ctx, http.MethodDelete, "/groups/"+pathID(groupID)+"/members", nil, nil)
+	return err
+}
+
+func (c *Client) group(ctx context.Context, method, path string, in interface{}) (*Group, error) {
+	var group Group
+	if _, err := c.do(ctx, method, path, in, &group); err != nil {
+		return nil, err
+	}
+	return &group, nil
+}
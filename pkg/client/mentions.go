@@ -0,0 +1,55 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/val7e/wasaText/service/models"
+)
+
+// Types of the mentions.
+type (
+	MessageEntity = models.MessageEntity
+	Mention       = models.Mention
+)
+
+// Types of the entities of a message: a mention of a user, or the formatting of the text.
+const (
+	EntityMention  = "mention"
+	EntityBold     = "bold"
+	EntityItalic   = "italic"
+	EntityCode     = "code"
+	EntityPre      = "pre"
+	EntityTextLink = "text_link"
+	EntityURL      = "url"
+)
+
+// MentionsPageSize is the maximum number of mentions of a page.
+const MentionsPageSize = 100
+
+// GetMyMentions returns the most recent group messages mentioning the current user (a page of MentionsPageSize).
+func (c *Client) GetMyMentions(ctx context.Context) ([]Mention, error) {
+	return c.GetMyMentionsPage(ctx, 0, 0)
+}
+
+// GetMyMentionsPage returns up to limit mentions (all the page if limit is 0) preceding the message before (from the
+// most recent one if before is 0), the most recent first.
+func (c *Client) GetMyMentionsPage(ctx context.Context, before int64, limit int) ([]Mention, error) {
+	query := url.Values{}
+	if before != 0 {
+		query.Set("before", pathID(before))
+	}
+	if limit != 0 {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+	path := "/users/me/mentions"
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+
+	var mentions []Mention
+	_, err := c.do(ctx, http.MethodGet, path, nil, &mentions)
+	return mentions, err
+}
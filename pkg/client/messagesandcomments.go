@@ -0,0 +1,131 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/val7e/wasaText/service/models"
+)
+
+// Types of the messages and comments.
+type (
+	Message     = models.Message
+	LinkPreview = models.LinkPreview
+	Comment     = models.Comment
+)
+
+// Message types.
+const (
+	MessageText  = "text"
+	MessagePhoto = "photo"
+)
+
+// SendText sends a text message to a conversation.
+func (c *Client) SendText(ctx context.Context, conversationID int64, text string) (*Message, error) {
+	return c.sendMessage(ctx, conversationID, map[string]string{"type": MessageText, "text": text})
+}
+
+// SendPhoto sends a photo to a conversation. photo is the base64-encoded image.
+func (c *Client) SendPhoto(ctx context.Context, conversationID int64, photo string) (*Message, error) {
+	return c.sendMessage(ctx, conversationID, map[string]string{"type": MessagePhoto, "photo": photo})
+}
+
+func (c *Client) sendMessage(ctx context.Context, conversationID int64, in map[string]string) (*Message, error) {
+	var msg Message
+	_, err := c.do(ctx, http.MethodPost, "/conversations/"+pathID(conversationID)+"/messages", in, &msg)
+	if err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
+
+// ForwardMessage forwards a message to the direct conversation with the recipient, creating it if needed.
+func (c *Client) ForwardMessage(ctx context.Context, conversationID, messageID int64, recipient string) (*Message, error) {
+	var msg Message
+	_, err := c.do(ctx, http.MethodPost, messagePath(conversationID, messageID)+"/forward",
+		map[string]string{"recipient_username": recipient}, &msg)
+	if err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
+
+// DeleteMessage deletes a message sent by the current user.
+func (c *Client) DeleteMessage(ctx context.Context, conversationID, messageID int64) error {
+	_, err := c.do(ctx, http.MethodDelete, messagePath(conversationID, messageID), nil, nil)
+	return err
+}
+
+// CommentMessage adds a comment (usually an emoji reaction) to a message.
+func (c *Client) CommentMessage(ctx context.Context, conversationID, messageID int64, text string) (*Comment, error) {
+	var comment Comment
+	_, err := c.do(ctx, http.MethodPost, messagePath(conversationID, messageID)+"/comments",
+		map[string]string{"text": text}, &comment)
+	if err != nil {
+		return nil, err
+	}
+	return &comment, nil
+}
+
+// GetComments returns all the comments of a message, the oldest first, reading every page.
+func (c *Client) GetComments(ctx context.Context, conversationID, messageID int64) ([]Comment, error) {
+	comments := []Comment{}
+	var after int64
+	for {
+		page, err := c.GetCommentsPage(ctx, conversationID, messageID, after, CommentsPageSize)
+		if err != nil {
+			return nil, err
+		}
+		comments = append(comments, page...)
+		if len(page) < CommentsPageSize {
+			return comments, nil
+		}
+		after = page[len(page)-1].Id
+	}
+}
+
+// CommentsPageSize is the maximum number of comments of a page.
+const CommentsPageSize = 100
+
+// GetCommentsPage returns up to limit comments of a message (all the page if limit is 0) following the comment
+// after (from the first one if after is 0).
+func (c *Client) GetCommentsPage(ctx context.Context, conversationID, messageID, after int64, limit int) ([]Comment, error) {
+	query := url.Values{}
+	if after != 0 {
+		query.Set("after", pathID(after))
+	}
+	if limit != 0 {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+	path := messagePath(conversationID, messageID) + "/comments"
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+
+	var comments []Comment
+	_, err := c.do(ctx, http.MethodGet, path, nil, &comments)
+	return comments, err
+}
+
+// EditComment replaces the text of a comment of the current user.
+func (c *Client) EditComment(ctx context.Context, conversationID, messageID, commentID int64, text string) (*Comment, error) {
+	var comment Comment
+	_, err := c.do(ctx, http.MethodPut, messagePath(conversationID, messageID)+"/comments/"+pathID(commentID),
+		map[string]string{"text": text}, &comment)
+	if err != nil {
+		return nil, err
+	}
+	return &comment, nil
+}
+
+// UncommentMessage removes a comment of the current user.
+func (c *Client) UncommentMessage(ctx context.Context, conversationID, messageID, commentID int64) error {
+	_, err := c.do(ctx, http.MethodDelete, messagePath(conversationID, messageID)+"/comments/"+pathID(commentID), nil, nil)
+	return err
+}
+
+func messagePath(conversationID, messageID int64) string {
+	return "/conversations/" + pathID(conversationID) + "/messages/" + pathID(messageID)
+}
@@ -0,0 +1,102 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/val7e/wasaText/service/models"
+)
+
+// Types of the threads.
+type (
+	Reply         = models.Reply
+	ThreadSummary = models.ThreadSummary
+)
+
+// ReplyText replies with a text to the thread of a message.
+func (c *Client) ReplyText(ctx context.Context, conversationID, messageID int64, text string) (*Reply, error) {
+	return c.reply(ctx, conversationID, messageID, map[string]string{"type": MessageText, "text": text})
+}
+
+// ReplyPhoto replies with a photo to the thread of a message. photo is the base64-encoded image.
+func (c *Client) ReplyPhoto(ctx context.Context, conversationID, messageID int64, photo string) (*Reply, error) {
+	return c.reply(ctx, conversationID, messageID, map[string]string{"type": MessagePhoto, "photo": photo})
+}
+
+func (c *Client) reply(ctx context.Context, conversationID, messageID int64, in map[string]string) (*Reply, error) {
+	var reply Reply
+	_, err := c.do(ctx, http.MethodPost, messagePath(conversationID, messageID)+"/replies", in, &reply)
+	if err != nil {
+		return nil, err
+	}
+	return &reply, nil
+}
+
+// GetReplies returns the whole thread of a message, the oldest reply first, reading every page.
+func (c *Client) GetReplies(ctx context.Context, conversationID, messageID int64) ([]Reply, error) {
+	replies := []Reply{}
+	var after int64
+	for {
+		page, err := c.GetRepliesPage(ctx, conversationID, messageID, after, RepliesPageSize)
+		if err != nil {
+			return nil, err
+		}
+		replies = append(replies, page...)
+		if len(page) < RepliesPageSize {
+			return replies, nil
+		}
+		after = page[len(page)-1].Id
+	}
+}
+
+// RepliesPageSize is the maximum number of replies of a page.
+const RepliesPageSize = 100
+
+// GetRepliesPage returns up to limit replies of a thread (all the page if limit is 0) following the reply after
+// (from the first one if after is 0).
+func (c *Client) GetRepliesPage(ctx context.Context, conversationID, messageID, after int64, limit int) ([]Reply, error) {
+	query := url.Values{}
+	if after != 0 {
+		query.Set("after", pathID(after))
+	}
+	if limit != 0 {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+	path := messagePath(conversationID, messageID) + "/replies"
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+
+	var replies []Reply
+	_, err := c.do(ctx, http.MethodGet, path, nil, &replies)
+	return replies, err
+}
+
+// SubscribeThread follows the thread of a message; the replies sent before are considered read.
+func (c *Client) SubscribeThread(ctx context.Context, conversationID, messageID int64) error {
+	_, err := c.do(ctx, http.MethodPut, messagePath(conversationID, messageID)+"/thread/subscription", nil, nil)
+	return err
+}
+
+// UnsubscribeThread stops following the thread of a message.
+func (c *Client) UnsubscribeThread(ctx context.Context, conversationID, messageID int64) error {
+	_, err := c.do(ctx, http.MethodDelete, messagePath(conversationID, messageID)+"/thread/subscription", nil, nil)
+	return err
+}
+
+// MarkThreadRead marks the replies of a followed thread as read, up to the reply lastRead.
+func (c *Client) MarkThreadRead(ctx context.Context, conversationID, messageID, lastRead int64) error {
+	_, err := c.do(ctx, http.MethodPut, messagePath(conversationID, messageID)+"/thread/read",
+		map[string]int64{"last_read": lastRead}, nil)
+	return err
+}
+
+// GetMyThreads returns the threads followed by the current user with their unread replies, the most recently
+// active first.
+func (c *Client) GetMyThreads(ctx context.Context) ([]ThreadSummary, error) {
+	var threads []ThreadSummary
+	_, err := c.do(ctx, http.MethodGet, "/users/me/threads", nil, &threads)
+	return threads, err
+}
@@ -0,0 +1,66 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+
+	"github.com/val7e/wasaText/service/models"
+)
+
+// User is the profile of a user.
+type User = models.User
+
+// LoginResult is the reply of Login.
+type LoginResult struct {
+	// Identifier is the user identifier, used as Bearer token
+	Identifier string `json:"identifier"`
+	Username   string `json:"username"`
+	Pic        string `json:"pic"`
+
+	// Created is true if the user has just been registered
+	Created bool `json:"-"`
+}
+
+// Login registers the user, or logs in if it already exists, and uses the returned identifier as Bearer token for
+// the next calls.
+func (c *Client) Login(ctx context.Context, username string) (*LoginResult, error) {
+	var res LoginResult
+	status, err := c.do(ctx, http.MethodPost, "/session", map[string]string{"username": username}, &res)
+	if err != nil {
+		return nil, err
+	}
+	if res.Identifier == "" {
+		return nil, errEmptyToken
+	}
+	res.Created = status == http.StatusCreated
+	c.SetToken(res.Identifier)
+	return &res, nil
+}
+
+// SearchUsers returns the users whose username contains query.
+func (c *Client) SearchUsers(ctx context.Context, query string) ([]User, error) {
+	var users []User
+	_, err := c.do(ctx, http.MethodGet, "/users?"+url.Values{"searcheduser": {query}}.Encode(), nil, &users)
+	return users, err
+}
+
+// SetMyUserName changes the username of the current user.
+func (c *Client) SetMyUserName(ctx context.Context, username string) (*User, error) {
+	var user User
+	_, err := c.do(ctx, http.MethodPut, "/users/me/username", map[string]string{"username": username}, &user)
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// SetMyPhoto changes the profile picture of the current user. pic is the base64-encoded image.
+func (c *Client) SetMyPhoto(ctx context.Context, pic string) (*User, error) {
+	var user User
+	_, err := c.do(ctx, http.MethodPut, "/users/me/pic", map[string]string{"pic": pic}, &user)
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
@@ -0,0 +1,62 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/felixge/httpsnoop"
+	"github.com/gofrs/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// reqUUIDKey is the request context key of the request UUID assigned by accessLog.
+type reqUUIDKey struct{}
+
+// accessLog assigns a UUID to each request, returns it to the client in the X-Request-Id header, and logs one line
+// per request once it has been served. A request coming with a UUID in its own X-Request-Id header (from a proxy, or
+// another service) keeps it; otherwise a new one is generated. The same UUID is used as "reqid" by the request logger
+// created in wrap, so handler log entries can be matched with the access log line.
+func (rt *_router) accessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reqUUID, ok := requestID(r)
+		if !ok {
+			var err error
+			reqUUID, err = uuid.NewV4()
+			if err != nil {
+				rt.baseLogger.WithError(err).Error("can't generate a request UUID")
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+		}
+		w.Header().Set("X-Request-Id", reqUUID.String())
+		r = withRoute(r.WithContext(context.WithValue(r.Context(), reqUUIDKey{}, reqUUID)))
+
+		m := httpsnoop.CaptureMetrics(next, w, r)
+
+		fields := logrus.Fields{
+			"reqid":       reqUUID.String(),
+			"remote-ip":   r.RemoteAddr,
+			"method":      r.Method,
+			"route":       rt.routePattern(r),
+			"status":      m.Code,
+			"bytes":       m.Written,
+			"duration_ms": float64(m.Duration.Microseconds()) / 1000,
+		}
+		if userID, err := rt.getUserFromAuth(r); err == nil {
+			fields["user_id"] = userID
+		}
+		rt.baseLogger.WithFields(fields).Info("request served")
+	})
+}
+
+// requestID returns the UUID in the X-Request-Id header of r, if it is one in the canonical form. Any other value is
+// ignored, as it would end up in the logs.
+func requestID(r *http.Request) (uuid.UUID, bool) {
+	value := r.Header.Get("X-Request-Id")
+	id, err := uuid.FromString(value)
+	if err != nil || id == uuid.Nil || !strings.EqualFold(id.String(), value) {
+		return uuid.Nil, false
+	}
+	return id, true
+}
@@ -0,0 +1,106 @@
+package api_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gofrs/uuid"
+	"github.com/sirupsen/logrus"
+	"github.com/val7e/wasaText/service/api"
+	"github.com/val7e/wasaText/service/database/memdb"
+)
+
+func TestAccessLog(t *testing.T) {
+	var out bytes.Buffer
+	logger := logrus.New()
+	logger.SetOutput(&out)
+	logger.SetFormatter(&logrus.JSONFormatter{})
+	db := memdb.New(memdb.Config{})
+	router, err := api.New(api.Config{Logger: logger, Database: db})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = router.Close() })
+	handler := router.Handler()
+	alice, _, err := db.DoLogin(context.Background(), "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	const incoming = "0b9e2f6c-5f7e-4d3a-9c1b-2a4e6f8d0c13"
+
+	tests := []struct {
+		name      string
+		path      string
+		requestID string
+		// want is the request ID returned, or "" for a new one
+		want   string
+		status int
+		route  string
+		user   bool
+	}{
+		{"incoming ID", "/conversations", incoming, incoming, http.StatusOK, "/conversations", true},
+		{"uppercase incoming ID", "/conversations", strings.ToUpper(incoming), incoming, http.StatusOK, "/conversations", true},
+		{"no ID", "/liveness", "", "", http.StatusOK, "/liveness", false},
+		{"not a UUID", "/liveness", "abc\nfake log line", "", http.StatusOK, "/liveness", false},
+		{"nil UUID", "/liveness", uuid.Nil.String(), "", http.StatusOK, "/liveness", false},
+		{"UUID in braces", "/liveness", "{" + incoming + "}", "", http.StatusOK, "/liveness", false},
+		{"not found", "/nowhere/42", incoming, incoming, http.StatusNotFound, "unmatched", false},
+	}
+	for _, tt := range tests {
+		out.Reset()
+		req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		if tt.requestID != "" {
+			req.Header.Set("X-Request-Id", tt.requestID)
+		}
+		if tt.user {
+			req.Header.Set("Authorization", "Bearer "+strconv.FormatInt(alice.Id, 10))
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		got := rec.Header().Get("X-Request-Id")
+		if tt.want != "" && got != tt.want {
+			t.Errorf("%s: got the request ID %q, want %q", tt.name, got, tt.want)
+		}
+		if id, err := uuid.FromString(got); tt.want == "" && (err != nil || id == uuid.Nil || got == tt.requestID) {
+			t.Errorf("%s: got the request ID %q, want a new UUID", tt.name, got)
+		}
+
+		// The handlers can log too, with the same request ID: the access log is one line per request
+		var entry map[string]interface{}
+		served := 0
+		for _, line := range strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n") {
+			var e map[string]interface{}
+			if err := json.Unmarshal([]byte(line), &e); err != nil {
+				t.Fatalf("%s: %v: %s", tt.name, err, line)
+			}
+			if e["reqid"] != got {
+				t.Errorf("%s: got a log line of another request: %s", tt.name, line)
+			}
+			if e["msg"] == "request served" {
+				entry = e
+				served++
+			}
+		}
+		if served != 1 {
+			t.Errorf("%s: got %d access log lines, want 1:\n%s", tt.name, served, out.String())
+			continue
+		}
+		if entry["method"] != http.MethodGet || entry["route"] != tt.route || entry["status"] != float64(tt.status) ||
+			entry["bytes"] != float64(rec.Body.Len()) {
+			t.Errorf("%s: got %v", tt.name, entry)
+		}
+		if d, ok := entry["duration_ms"].(float64); !ok || d < 0 {
+			t.Errorf("%s: got the duration %v", tt.name, entry["duration_ms"])
+		}
+		if _, ok := entry["user_id"]; ok != tt.user {
+			t.Errorf("%s: got the user ID %v", tt.name, entry["user_id"])
+		}
+	}
+}
@@ -1,26 +1,36 @@
 package api
 
 import (
+	"context"
+	"errors"
 	"net/http"
 
+	"github.com/felixge/httpsnoop"
 	"github.com/gofrs/uuid"
 	"github.com/julienschmidt/httprouter"
 	"github.com/sirupsen/logrus"
 	"github.com/val7e/wasaText/service/api/reqcontext"
+	"github.com/val7e/wasaText/service/tracing"
 )
 
 // httpRouterHandler is the signature for functions that accepts a reqcontext.RequestContext in addition to those
 // required by the httprouter package.
 type httpRouterHandler func(http.ResponseWriter, *http.Request, httprouter.Params, reqcontext.RequestContext)
 
-// wrap parses the request and adds a reqcontext.RequestContext instance related to the request.
+// wrap parses the request and adds a reqcontext.RequestContext instance related to the request. The request is
+// validated against the OpenAPI document first (see validateOpenAPI).
 func (rt *_router) wrap(fn httpRouterHandler) func(http.ResponseWriter, *http.Request, httprouter.Params) {
-	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-		reqUUID, err := uuid.NewV4()
-		if err != nil {
-			rt.baseLogger.WithError(err).Error("can't generate a request UUID")
-			w.WriteHeader(http.StatusInternalServerError)
-			return
+	return rt.validateOpenAPI(func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		// The request UUID is assigned by accessLog, this generates one only if the handler is not behind it
+		reqUUID, ok := r.Context().Value(reqUUIDKey{}).(uuid.UUID)
+		if !ok {
+			var err error
+			reqUUID, err = uuid.NewV4()
+			if err != nil {
+				rt.baseLogger.WithError(err).Error("can't generate a request UUID")
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
 		}
 		var ctx = reqcontext.RequestContext{
 			ReqUUID: reqUUID,
@@ -32,7 +42,39 @@ func (rt *_router) wrap(fn httpRouterHandler) func(http.ResponseWriter, *http.Re
 			"remote-ip": r.RemoteAddr,
 		})
 
+		// Bound the request context: it is already cancelled when the client goes away or the server shuts down, and
+		// the deadline stops database work that could not be written back anyway after the server WriteTimeout.
+		if rt.requestTimeout > 0 {
+			reqCtx, cancel := context.WithTimeout(r.Context(), rt.requestTimeout)
+			defer cancel()
+			r = r.WithContext(reqCtx)
+		}
+
+		// Start the span of the request, continuing the trace of the caller if it sent a traceparent header. The
+		// AppDatabase calls made with the request context become its children.
+		route := rt.routePattern(r)
+		spanCtx, span := rt.tracer.Start(tracing.Extract(r.Context(), r.Header), r.Method+" "+route, tracing.SpanKindServer)
+		defer span.End()
+		if span != nil {
+			span.SetAttribute("http.method", r.Method)
+			span.SetAttribute("http.route", route)
+			span.SetAttribute("reqid", ctx.ReqUUID.String())
+			ctx.Logger = ctx.Logger.WithField("trace_id", span.SpanContext().TraceID.String())
+			r = r.WithContext(spanCtx)
+		}
+
 		// Call the next handler in chain (usually, the handler function for the path)
-		fn(w, r, ps, ctx)
-	}
+		if span == nil {
+			fn(w, r, ps, ctx)
+			return
+		}
+		m := httpsnoop.CaptureMetricsFn(w, func(w http.ResponseWriter) {
+			fn(w, r, ps, ctx)
+		})
+		span.SetAttribute("http.status_code", m.Code)
+		span.SetAttribute("http.response_size", m.Written)
+		if m.Code >= http.StatusInternalServerError {
+			span.SetError(errors.New(http.StatusText(m.Code)))
+		}
+	})
 }
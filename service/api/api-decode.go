@@ -0,0 +1,153 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"unicode/utf8"
+)
+
+// Maximum sizes of the request bodies. Photos are sent base64-encoded inside the JSON body (at most 500000 characters,
+// see the Pic schema in doc/api.yaml), so the routes receiving them have a larger limit.
+const (
+	maxBodySize      = 16 << 10
+	maxPhotoBodySize = 1 << 20
+)
+
+// maxTextLength is the maximum length of the text of a message or reply, in characters (see the NewMessage schema in
+// doc/api.yaml).
+const maxTextLength = 1000
+
+// maxCommentLength is the maximum length of the text of a comment, in characters (see the NewComment schema in
+// doc/api.yaml).
+const maxCommentLength = 300
+
+// checkText returns a *bodyError if the text of a message is longer than maxTextLength. It is checked before the
+// markup of the text is parsed, so that the work done for a message stays bounded.
+func checkText(text string) error {
+	return checkLength(text, maxTextLength)
+}
+
+// checkComment returns a *bodyError if the text of a comment is longer than maxCommentLength.
+func checkComment(text string) error {
+	return checkLength(text, maxCommentLength)
+}
+
+func checkLength(text string, max int) error {
+	if n := utf8.RuneCountInString(text); n > max {
+		return &bodyError{status: http.StatusBadRequest, Message: "Invalid request body", Field: "text",
+			Reason: fmt.Sprintf("must be at most %d characters, got %d", max, n)}
+	}
+	return nil
+}
+
+// bodyError is a request body that could not be decoded. It is sent to the client as JSON:
+//
+//	{"error": "Invalid request body", "field": "text", "reason": "expected string, got number"}
+//
+// Field is omitted when the problem is not related to a single field (e.g., a syntax error).
+type bodyError struct {
+	status int
+
+	Message string `json:"error"`
+	Field   string `json:"field,omitempty"`
+	Reason  string `json:"reason"`
+}
+
+func (e *bodyError) Error() string {
+	if e.Field != "" {
+		return fmt.Sprintf("%s: %s: %s", e.Message, e.Field, e.Reason)
+	}
+	return fmt.Sprintf("%s: %s", e.Message, e.Reason)
+}
+
+// decodeJSONBody decodes the request body, a single JSON value of at most maxSize bytes, into dst. Fields not in dst
+// and data after the JSON value are rejected. The returned error, if any, is a *bodyError: write it with
+// writeBodyError.
+func decodeJSONBody(w http.ResponseWriter, r *http.Request, dst interface{}, maxSize int64) error {
+	r.Body = http.MaxBytesReader(w, r.Body, maxSize)
+
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(dst); err != nil {
+		return decodeError(err, maxSize)
+	}
+	if err := dec.Decode(&struct{}{}); !errors.Is(err, io.EOF) {
+		if bodyTooLarge(err) {
+			return decodeError(err, maxSize)
+		}
+		return &bodyError{status: http.StatusBadRequest, Message: "Invalid request body",
+			Reason: "unexpected data after the JSON value"}
+	}
+	return nil
+}
+
+// bodyTooLarge reports whether err comes from a body that exceeded the limit of http.MaxBytesReader.
+func bodyTooLarge(err error) bool {
+	return errors.As(err, new(*http.MaxBytesError))
+}
+
+// decodeError converts the errors of json.Decoder.Decode into a *bodyError.
+func decodeError(err error, maxSize int64) *bodyError {
+	e := &bodyError{status: http.StatusBadRequest, Message: "Invalid request body"}
+
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.Is(err, io.EOF):
+		e.Reason = "the body is empty"
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		e.Reason = "the body is truncated"
+	case errors.As(err, &syntaxErr):
+		e.Reason = fmt.Sprintf("malformed JSON at offset %d", syntaxErr.Offset)
+	case errors.As(err, &typeErr):
+		e.Field = typeErr.Field
+		e.Reason = fmt.Sprintf("expected %s, got %s", jsonType(typeErr.Type), typeErr.Value)
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		e.Field = strings.Trim(strings.TrimPrefix(err.Error(), "json: unknown field "), `"`)
+		e.Reason = "unknown field"
+	case bodyTooLarge(err):
+		e.status = http.StatusRequestEntityTooLarge
+		e.Message = "Request body too large"
+		e.Reason = fmt.Sprintf("the body must be at most %d bytes", maxSize)
+	default:
+		e.Reason = err.Error()
+	}
+	return e
+}
+
+// jsonType returns the name of the JSON type decoded into values of type t.
+func jsonType(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Ptr:
+		return jsonType(t.Elem())
+	case reflect.Slice, reflect.Array:
+		return "array"
+	case reflect.Struct, reflect.Map:
+		return "object"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.String:
+		return "string"
+	case reflect.Interface:
+		return "value"
+	default:
+		return "number"
+	}
+}
+
+// writeBodyError replies to a request whose body was rejected by decodeJSONBody, with HTTP Status 400 (or 413 if the
+// body is too large) and the error as JSON.
+func writeBodyError(w http.ResponseWriter, err error) {
+	var e *bodyError
+	if !errors.As(err, &e) {
+		e = &bodyError{status: http.StatusBadRequest, Message: "Invalid request body", Reason: err.Error()}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(e.status)
+	_ = json.NewEncoder(w).Encode(e)
+}
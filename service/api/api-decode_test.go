@@ -0,0 +1,47 @@
+package api_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/val7e/wasaText/service/api"
+	"github.com/val7e/wasaText/service/database/memdb"
+)
+
+// TestBodyTooLarge checks that JSON bodies over the limit are rejected with 413, whether the excess is inside the JSON
+// value or after it
+func TestBodyTooLarge(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	router, err := api.New(api.Config{
+		Logger:   logger,
+		Database: memdb.New(memdb.Config{}),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = router.Close() })
+	handler := router.Handler()
+
+	for name, body := range map[string]string{
+		"in the value":    `{"username": "` + strings.Repeat("a", 20<<10) + `"}`,
+		"after the value": `{"username": "alice"}` + strings.Repeat(" ", 20<<10),
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/session", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		var got struct{ Error string }
+		_ = json.Unmarshal(rec.Body.Bytes(), &got)
+		if rec.Code != http.StatusRequestEntityTooLarge || got.Error != "Request body too large" {
+			t.Errorf("%s: got %d %s", name, rec.Code, rec.Body)
+		}
+	}
+}
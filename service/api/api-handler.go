@@ -6,37 +6,53 @@ import (
 
 // Handler returns an instance of httprouter.Router that handle APIs registered here
 func (rt *_router) Handler() http.Handler {
-	// Register routes
-	rt.router.GET("/", rt.getHelloWorld)
-	rt.router.GET("/context", rt.wrap(rt.getContextReply))
-
-	rt.router.POST("/session", rt.wrap(rt.doLogin))
-
-	rt.router.GET("/users", rt.wrap(rt.searchUser))
-	rt.router.PUT("/users/me/username", rt.wrap(rt.setMyUserName))
-	rt.router.PUT("/users/me/pic", rt.wrap(rt.setMyPhoto))
-
-	rt.router.GET("/conversations", rt.wrap(rt.getMyConversations))
-	rt.router.POST("/conversations", rt.wrap(rt.startConversation))
-	rt.router.GET("/conversations/:conversation_id", rt.wrap(rt.getConversation))
-
-	rt.router.POST("/groups", rt.wrap(rt.createGroup))
-    rt.router.GET("/groups/:group_id", rt.wrap(rt.getGroup))
-	rt.router.PUT("/groups/:group_id/name", rt.wrap(rt.setGroupName))
-	rt.router.PUT("/groups/:group_id/photo", rt.wrap(rt.setGroupPhoto))
-	rt.router.POST("/groups/:group_id/members", rt.wrap(rt.addToGroup))
-	rt.router.DELETE("/groups/:group_id/members", rt.wrap(rt.leaveGroup))
-
-	rt.router.POST("/conversations/:conversation_id/messages/:message_id/forward", rt.wrap(rt.forwardMessage))
-	rt.router.POST("/conversations/:conversation_id/messages", rt.wrap(rt.sendMessage))
-	rt.router.DELETE("/conversations/:conversation_id/messages/:message_id", rt.wrap(rt.deleteMessage))
-
-	rt.router.POST("/conversations/:conversation_id/messages/:message_id/comments", rt.wrap(rt.commentMessage))
-	rt.router.DELETE("/conversations/:conversation_id/messages/:message_id/comments/:comment_id", rt.wrap(rt.uncommentMessage))
-	rt.router.GET("/conversations/:conversation_id/messages/:message_id/comments", rt.wrap(rt.getComments))
+	// Register routes. The API routes, registered with rt.handle, are described in doc/api.yaml.
+	rt.register(http.MethodGet, "/", rt.getHelloWorld)
+	rt.register(http.MethodGet, "/context", rt.wrap(rt.getContextReply))
+
+	rt.handle(http.MethodPost, "/session", rt.rateLimit(rt.limiters.login, rt.wrap(rt.doLogin)))
+
+	rt.handle(http.MethodGet, "/users", rt.rateLimit(rt.limiters.search, rt.wrap(rt.searchUser)))
+	rt.handle(http.MethodPut, "/users/me/username", rt.wrap(rt.setMyUserName))
+	rt.handle(http.MethodPut, "/users/me/pic", rt.rateLimit(rt.limiters.uploads, rt.wrap(rt.setMyPhoto)))
+
+	rt.handle(http.MethodGet, "/conversations", rt.wrap(rt.getMyConversations))
+	rt.handle(http.MethodPost, "/conversations", rt.wrap(rt.startConversation))
+	rt.handle(http.MethodGet, "/conversations/:conversation_id", rt.wrap(rt.getConversation))
+
+	rt.handle(http.MethodPost, "/groups", rt.wrap(rt.createGroup))
+	rt.handle(http.MethodGet, "/groups/:group_id", rt.wrap(rt.getGroup))
+	rt.handle(http.MethodPut, "/groups/:group_id/name", rt.wrap(rt.setGroupName))
+	rt.handle(http.MethodPut, "/groups/:group_id/photo", rt.rateLimit(rt.limiters.uploads, rt.wrap(rt.setGroupPhoto)))
+	rt.handle(http.MethodPost, "/groups/:group_id/members", rt.wrap(rt.addToGroup))
+	rt.handle(http.MethodDelete, "/groups/:group_id/members", rt.wrap(rt.leaveGroup))
+
+	rt.handle(http.MethodPost, "/conversations/:conversation_id/messages/:message_id/forward", rt.rateLimit(rt.limiters.messaging, rt.wrap(rt.forwardMessage)))
+	rt.handle(http.MethodPost, "/conversations/:conversation_id/messages", rt.rateLimit(rt.limiters.messaging, rt.wrap(rt.sendMessage)))
+	rt.handle(http.MethodDelete, "/conversations/:conversation_id/messages/:message_id", rt.wrap(rt.deleteMessage))
+
+	rt.handle(http.MethodPost, "/conversations/:conversation_id/messages/:message_id/comments", rt.rateLimit(rt.limiters.messaging, rt.wrap(rt.commentMessage)))
+	rt.handle(http.MethodPut, "/conversations/:conversation_id/messages/:message_id/comments/:comment_id", rt.rateLimit(rt.limiters.messaging, rt.wrap(rt.editComment)))
+	rt.handle(http.MethodDelete, "/conversations/:conversation_id/messages/:message_id/comments/:comment_id", rt.wrap(rt.uncommentMessage))
+	rt.handle(http.MethodGet, "/conversations/:conversation_id/messages/:message_id/comments", rt.wrap(rt.getComments))
+
+	rt.handle(http.MethodGet, "/users/me/threads", rt.wrap(rt.getMyThreads))
+	rt.handle(http.MethodPost, "/conversations/:conversation_id/messages/:message_id/replies", rt.rateLimit(rt.limiters.messaging, rt.wrap(rt.replyToMessage)))
+	rt.handle(http.MethodGet, "/conversations/:conversation_id/messages/:message_id/replies", rt.wrap(rt.getReplies))
+	rt.handle(http.MethodPut, "/conversations/:conversation_id/messages/:message_id/thread/subscription", rt.wrap(rt.subscribeThread))
+	rt.handle(http.MethodDelete, "/conversations/:conversation_id/messages/:message_id/thread/subscription", rt.wrap(rt.unsubscribeThread))
+	rt.handle(http.MethodPut, "/conversations/:conversation_id/messages/:message_id/thread/read", rt.wrap(rt.markThreadRead))
+
+	rt.handle(http.MethodGet, "/users/me/mentions", rt.wrap(rt.getMyMentions))
+	rt.handle(http.MethodGet, "/users/me/events", rt.wrap(rt.getEvents))
+
+	rt.handle(http.MethodPost, "/conversations/:conversation_id/files", rt.rateLimit(rt.limiters.uploads, rt.wrap(rt.sendFile)))
+	rt.handle(http.MethodGet, "/conversations/:conversation_id/messages/:message_id/file", rt.wrap(rt.getFile))
+	rt.handle(http.MethodPost, "/conversations/:conversation_id/audio", rt.rateLimit(rt.limiters.uploads, rt.wrap(rt.sendAudio)))
+	rt.handle(http.MethodGet, "/conversations/:conversation_id/messages/:message_id/audio", rt.wrap(rt.getAudio))
 
 	// Special routes
-	rt.router.GET("/liveness", rt.liveness)
+	rt.register(http.MethodGet, "/liveness", rt.liveness)
 
-	return rt.router
+	return rt.instrument(rt.accessLog(rt.router))
 }
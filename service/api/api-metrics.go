@@ -0,0 +1,86 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"github.com/felixge/httpsnoop"
+	"github.com/julienschmidt/httprouter"
+	"github.com/val7e/wasaText/service/metrics"
+)
+
+// httpMetrics are the collectors of the requests served by the router. Both are labeled by route pattern (e.g.,
+// "/conversations/:conversation_id"), HTTP method and status code.
+type httpMetrics struct {
+	requests *metrics.CounterVec
+	duration *metrics.HistogramVec
+}
+
+func newHTTPMetrics(reg *metrics.Registry) *httpMetrics {
+	return &httpMetrics{
+		requests: reg.NewCounterVec("http_requests_total", "HTTP requests served.", "route", "method", "status"),
+		duration: reg.NewHistogramVec("http_request_duration_seconds", "Duration of the HTTP requests.", nil, "route", "method", "status"),
+	}
+}
+
+// instrument records the HTTP metrics of the requests served by `next`. It is a no-op if no metrics registry was
+// configured.
+func (rt *_router) instrument(next http.Handler) http.Handler {
+	if rt.metrics == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r = withRoute(r)
+		m := httpsnoop.CaptureMetrics(next, w, r)
+
+		route, method, status := rt.routePattern(r), methodLabel(r.Method), strconv.Itoa(m.Code)
+		rt.metrics.requests.Inc(route, method, status)
+		rt.metrics.duration.Observe(m.Duration.Seconds(), route, method, status)
+	})
+}
+
+// routeKey is the request context key of the pattern of the route serving the request, a *string set by register. The
+// middlewares running before the router add it with withRoute, to read it once the request has been served.
+type routeKey struct{}
+
+// withRoute returns r with an empty route pattern in its context, if it has none yet.
+func withRoute(r *http.Request) *http.Request {
+	if _, ok := r.Context().Value(routeKey{}).(*string); ok {
+		return r
+	}
+	return r.WithContext(context.WithValue(r.Context(), routeKey{}, new(string)))
+}
+
+// register registers a route in the router, recording its pattern in the context of the requests it serves.
+func (rt *_router) register(method, path string, handle httprouter.Handle) {
+	rt.router.Handle(method, path, func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		if pattern, ok := r.Context().Value(routeKey{}).(*string); ok {
+			*pattern = path
+		} else {
+			r = r.WithContext(context.WithValue(r.Context(), routeKey{}, &path))
+		}
+		handle(w, r, ps)
+	})
+}
+
+// routePattern returns the pattern of the route that served the request (e.g., "/conversations/:conversation_id"), or
+// "unmatched" if there is none. Paths are not used directly as label values, as each conversation, message and group
+// would get its own time series.
+func (rt *_router) routePattern(r *http.Request) string {
+	if pattern, ok := r.Context().Value(routeKey{}).(*string); ok && *pattern != "" {
+		return *pattern
+	}
+	return "unmatched"
+}
+
+// methodLabel returns the HTTP method as a label value. Methods outside the standard ones are "OTHER", so that clients
+// can't create new time series at will.
+func methodLabel(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete,
+		http.MethodConnect, http.MethodOptions, http.MethodTrace:
+		return method
+	}
+	return "OTHER"
+}
@@ -0,0 +1,58 @@
+package api_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/val7e/wasaText/service/api"
+	"github.com/val7e/wasaText/service/database/memdb"
+	"github.com/val7e/wasaText/service/metrics"
+)
+
+// TestMetricsLabels checks that the requests are counted by route pattern, whatever the parameter values, and that
+// the non-standard methods share a single label value
+func TestMetricsLabels(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	reg := metrics.NewRegistry()
+	router, err := api.New(api.Config{Logger: logger, Database: memdb.New(memdb.Config{}), Metrics: reg})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = router.Close() })
+	handler := router.Handler()
+
+	for _, req := range []struct{ method, path string }{
+		// The same value in two parameters, and a parameter value equal to a literal segment
+		{http.MethodGet, "/conversations/7/messages/7/comments"},
+		{http.MethodGet, "/conversations/comments/messages/1/comments"},
+		{http.MethodGet, "/liveness"},
+		{http.MethodGet, "/nowhere/42"},
+		{"PROPFIND", "/conversations"},
+		{"X-MADE-UP-1", "/liveness"},
+	} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(req.method, req.path, nil))
+	}
+
+	var text strings.Builder
+	reg.WriteText(&text)
+	for _, want := range []string{
+		`http_requests_total{route="/conversations/:conversation_id/messages/:message_id/comments",method="GET",status="401"} 2`,
+		`http_requests_total{route="/liveness",method="GET",status="200"} 1`,
+		`http_requests_total{route="unmatched",method="GET",status="404"} 1`,
+		`http_requests_total{route="unmatched",method="OTHER",status="405"} 2`,
+	} {
+		if !strings.Contains(text.String(), want+"\n") {
+			t.Errorf("missing %s", want)
+		}
+	}
+	for _, unwanted := range []string{"PROPFIND", "X-MADE-UP-1", "/7", "/nowhere"} {
+		if strings.Contains(text.String(), unwanted) {
+			t.Errorf("%s is a label value:\n%s", unwanted, text.String())
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package api
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/gofrs/uuid"
+	"github.com/julienschmidt/httprouter"
+	"github.com/val7e/wasaText/service/openapi"
+)
+
+// Validation configures the validation of requests and responses against the OpenAPI document (doc/api.yaml).
+type Validation struct {
+	// Spec is the OpenAPI document. Nothing is validated if it is nil.
+	Spec *openapi.Spec
+
+	// Strict rejects the requests not matching the document with HTTP Status 400, and replaces the responses not
+	// matching it with 500. Otherwise, mismatches are only logged.
+	Strict bool
+
+	// Responses enables the validation of the responses too. Responses are buffered to be validated, so this is meant
+	// for debug builds and tests.
+	Responses bool
+}
+
+// route is a route registered by Handler, with the path in the httprouter syntax (/groups/:group_id).
+type route struct {
+	method string
+	path   string
+}
+
+// handle registers an API route, which must be described in the OpenAPI document (see UndocumentedRoutes).
+func (rt *_router) handle(method, path string, handle httprouter.Handle) {
+	rt.register(method, path, handle)
+	rt.routes = append(rt.routes, route{method: method, path: path})
+}
+
+// UndocumentedRoutes returns the API routes registered by Handler that are missing from the OpenAPI document of
+// Config.Validation, as "METHOD /path/{param}". Call it after Handler.
+func (rt *_router) UndocumentedRoutes() []string {
+	if rt.validation.Spec == nil {
+		return nil
+	}
+	var missing []string
+	for _, r := range rt.routes {
+		segments := strings.Split(r.path, "/")
+		for i, seg := range segments {
+			if strings.HasPrefix(seg, ":") || strings.HasPrefix(seg, "*") {
+				segments[i] = "{" + seg[1:] + "}"
+			}
+		}
+		path := strings.Join(segments, "/")
+		if rt.validation.Spec.Operation(r.method, path) == nil {
+			missing = append(missing, r.method+" "+path)
+		}
+	}
+	return missing
+}
+
+// validateOpenAPI checks the requests for the operations of the OpenAPI document (and their responses, if enabled)
+// against it. Requests for paths not in the document (like /context) are not checked. It is applied by wrap, so it
+// runs after the rate limiter: the body of a rejected request is never read.
+func (rt *_router) validateOpenAPI(next httprouter.Handle) httprouter.Handle {
+	spec := rt.validation.Spec
+	if spec == nil {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		op, pathParams := spec.FindOperation(r.Method, r.URL.Path)
+		if op == nil {
+			next(w, r, ps)
+			return
+		}
+
+		logger := rt.baseLogger.WithField("operation", op.ID)
+		if reqUUID, ok := r.Context().Value(reqUUIDKey{}).(uuid.UUID); ok {
+			logger = logger.WithField("reqid", reqUUID.String())
+		}
+
+		// Bodies over the largest limit are rejected by the handlers anyway, with 413
+		body, complete, err := peekBody(r, maxPhotoBodySize)
+		if err == nil && complete {
+			if err := op.ValidateRequest(r, pathParams, body); err != nil {
+				logger.WithError(err).Warning("request does not match the API document")
+				if rt.validation.Strict {
+					writeBodyError(w, &bodyError{
+						status:  http.StatusBadRequest,
+						Message: "Request does not match the API document",
+						Reason:  err.Error(),
+					})
+					return
+				}
+			}
+		}
+
+		// Event streams don't end: they can't be buffered
+		if !rt.validation.Responses || op.EventStream() {
+			next(w, r, ps)
+			return
+		}
+		rec := &responseRecorder{header: http.Header{}, status: http.StatusOK}
+		next(rec, r, ps)
+		if err := op.ValidateResponse(rec.status, rec.header, rec.body.Bytes()); err != nil {
+			logger.WithError(err).WithField("status", rec.status).Error("response does not match the API document")
+			if rt.validation.Strict {
+				writeBodyError(w, &bodyError{
+					status:  http.StatusInternalServerError,
+					Message: "Response does not match the API document",
+					Reason:  err.Error(),
+				})
+				return
+			}
+		}
+		rec.copyTo(w)
+	}
+}
+
+// peekBody reads up to limit bytes of the request body, and replaces r.Body so that the handler can read it again.
+// complete is false if the body is longer than limit.
+func peekBody(r *http.Request, limit int64) (body []byte, complete bool, err error) {
+	body, err = io.ReadAll(io.LimitReader(r.Body, limit+1))
+	r.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+	return body, int64(len(body)) <= limit, err
+}
+
+// responseRecorder buffers a response, to validate it before sending it.
+type responseRecorder struct {
+	header      http.Header
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (rec *responseRecorder) Header() http.Header {
+	return rec.header
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	if !rec.wroteHeader {
+		rec.status, rec.wroteHeader = status, true
+	}
+}
+
+func (rec *responseRecorder) Write(p []byte) (int, error) {
+	rec.wroteHeader = true
+	return rec.body.Write(p)
+}
+
+func (rec *responseRecorder) copyTo(w http.ResponseWriter) {
+	for k, v := range rec.header {
+		w.Header()[k] = v
+	}
+	w.WriteHeader(rec.status)
+	_, _ = w.Write(rec.body.Bytes())
+}
@@ -0,0 +1,87 @@
+package api_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/val7e/wasaText/doc"
+	"github.com/val7e/wasaText/service/api"
+	"github.com/val7e/wasaText/service/database/memdb"
+	"github.com/val7e/wasaText/service/openapi"
+)
+
+// TestRoutesDocumented checks that every route of the API is described in the OpenAPI document
+func TestRoutesDocumented(t *testing.T) {
+	spec, err := openapi.Load(doc.APISpec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	router, err := api.New(api.Config{
+		Logger:     logger,
+		Database:   memdb.New(memdb.Config{}),
+		Validation: api.Validation{Spec: spec},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = router.Close() })
+
+	router.Handler()
+	for _, route := range router.UndocumentedRoutes() {
+		t.Errorf("%s is not in doc/api.yaml", route)
+	}
+}
+
+// countingReader counts the calls to Read
+type countingReader struct {
+	io.Reader
+	reads int
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	r.reads++
+	return r.Reader.Read(p)
+}
+
+// TestValidationAfterRateLimit checks that the body of a request rejected by the rate limiter is not read to be
+// validated
+func TestValidationAfterRateLimit(t *testing.T) {
+	spec, err := openapi.Load(doc.APISpec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	router, err := api.New(api.Config{
+		Logger:     logger,
+		Database:   memdb.New(memdb.Config{}),
+		Validation: api.Validation{Spec: spec, Strict: true},
+		RateLimits: api.RateLimits{Login: api.RateLimit{PerMinute: 1, Burst: 1}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = router.Close() })
+	handler := router.Handler()
+
+	for i, want := range []int{http.StatusCreated, http.StatusTooManyRequests} {
+		body := &countingReader{Reader: strings.NewReader(`{"username": "alice"}`)}
+		req := httptest.NewRequest(http.MethodPost, "/session", body)
+		req.Header.Set("Content-Type", "application/json")
+		res := httptest.NewRecorder()
+		handler.ServeHTTP(res, req)
+		if res.Code != want {
+			t.Fatalf("request %d: got %d, want %d", i+1, res.Code, want)
+		}
+		if want == http.StatusTooManyRequests && body.reads != 0 {
+			t.Errorf("the body of the rejected request was read %d times", body.reads)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package api
+
+import (
+	"encoding/json"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/val7e/wasaText/service/globaltime"
+	"github.com/val7e/wasaText/service/metrics"
+)
+
+// RateLimit is the maximum request rate of a single client (an authenticated user, or a remote IP for anonymous
+// requests) to a group of routes: PerMinute requests per minute on average, with bursts of up to Burst requests.
+// A zero PerMinute disables the limit.
+type RateLimit struct {
+	PerMinute int
+	Burst     int
+}
+
+// RateLimits are the limits of each group of routes.
+type RateLimits struct {
+	// Login limits POST /session, always by remote IP
+	Login RateLimit
+
+	// Messaging limits sending, forwarding and commenting messages
+	Messaging RateLimit
+
+	// Search limits the user search (GET /users)
+	Search RateLimit
+
+	// Uploads limits the routes receiving photos, files and voice messages
+	Uploads RateLimit
+}
+
+// rateLimiters holds one limiter per group of routes. A nil limiter lets every request through.
+type rateLimiters struct {
+	login     *rateLimiter
+	messaging *rateLimiter
+	search    *rateLimiter
+	uploads   *rateLimiter
+}
+
+func newRateLimiters(limits RateLimits, clock globaltime.Clock, reg *metrics.Registry) rateLimiters {
+	var rejected *metrics.CounterVec
+	if reg != nil {
+		rejected = reg.NewCounterVec("http_rate_limited_total", "Requests rejected by the rate limiter.", "group")
+	}
+	return rateLimiters{
+		login:     newRateLimiter("login", limits.Login, true, clock, rejected),
+		messaging: newRateLimiter("messaging", limits.Messaging, false, clock, rejected),
+		search:    newRateLimiter("search", limits.Search, false, clock, rejected),
+		uploads:   newRateLimiter("uploads", limits.Uploads, false, clock, rejected),
+	}
+}
+
+// rateLimiter is a token bucket per client. Each bucket holds up to `burst` tokens and gains `rate` tokens per second;
+// a request takes one token, and is rejected if there is none.
+type rateLimiter struct {
+	group    string
+	rate     float64
+	burst    float64
+	byIP     bool
+	clock    globaltime.Clock
+	rejected *metrics.CounterVec
+
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// sweepInterval is how often the buckets that refilled completely (the clients that stopped sending requests) are
+// removed, to keep the memory bounded by the number of recently active clients.
+const sweepInterval = time.Minute
+
+// newRateLimiter returns the limiter of a group of routes, or nil if the limit is disabled. If byIP is true, requests
+// are always counted per remote IP, even when authenticated.
+func newRateLimiter(group string, limit RateLimit, byIP bool, clock globaltime.Clock, rejected *metrics.CounterVec) *rateLimiter {
+	if limit.PerMinute <= 0 {
+		return nil
+	}
+	burst := limit.Burst
+	if burst < 1 {
+		burst = 1
+	}
+	return &rateLimiter{
+		group:     group,
+		rate:      float64(limit.PerMinute) / 60,
+		burst:     float64(burst),
+		byIP:      byIP,
+		clock:     clock,
+		rejected:  rejected,
+		buckets:   map[string]*tokenBucket{},
+		lastSweep: clock.Now(),
+	}
+}
+
+// allow takes a token from the bucket of `key`. When `key` has no bucket yet and `ip` is not empty (`key` is a user,
+// connecting from `ip`), the new bucket first costs a token of the bucket of `ip`. If a bucket is empty, it returns
+// false and the time after which the next token is available.
+func (l *rateLimiter) allow(key, ip string) (bool, time.Duration) {
+	now := l.clock.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastSweep) >= sweepInterval {
+		for k, b := range l.buckets {
+			if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+				delete(l.buckets, k)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	if _, ok := l.buckets[key]; !ok && ip != "" {
+		if ok, retryAfter := l.take(ip, now); !ok {
+			return false, retryAfter
+		}
+	}
+	return l.take(key, now)
+}
+
+// take takes a token from the bucket of `key`, creating it full if needed. The caller holds l.mu.
+func (l *rateLimiter) take(key string, now time.Time) (bool, time.Duration) {
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens = math.Min(l.burst, b.tokens+elapsed.Seconds()*l.rate)
+		b.last = now
+	}
+
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// rateLimit rejects the requests exceeding the limit `l` with HTTP Status 429 and a Retry-After header (in seconds).
+// Requests are counted per authenticated user, or per remote IP when the Authorization header is missing or invalid
+// (and always for the login, where users are not authenticated yet).
+func (rt *_router) rateLimit(l *rateLimiter, next httprouter.Handle) httprouter.Handle {
+	if l == nil {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		ok, retryAfter := l.allow(rt.rateLimitKey(r, l.byIP))
+		if ok {
+			next(w, r, ps)
+			return
+		}
+		if l.rejected != nil {
+			l.rejected.Inc(l.group)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		w.WriteHeader(http.StatusTooManyRequests)
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": "Too many requests"})
+	}
+}
+
+// rateLimitKey returns the client the request is counted for: "user:<id>" or "ip:<address>", and the remote IP of the
+// users. The identifier of the Authorization header is not looked up in the database, as that would cost a query per
+// request: a client making up a new identifier for each request gets a new bucket each time, but pays for it with a
+// token of its IP (see allow), so it gets no more requests through, and adds no more buckets, than its IP allows.
+func (rt *_router) rateLimitKey(r *http.Request, byIP bool) (key, ip string) {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if !byIP {
+		if userID, err := rt.getUserFromAuth(r); err == nil {
+			return "user:" + strconv.FormatInt(userID, 10), "ip:" + host
+		}
+	}
+	return "ip:" + host, ""
+}
@@ -39,10 +39,15 @@ package api
 import (
 	"errors"
 	"net/http"
+	"time"
 
 	"github.com/julienschmidt/httprouter"
 	"github.com/sirupsen/logrus"
 	"github.com/val7e/wasaText/service/database"
+	"github.com/val7e/wasaText/service/globaltime"
+	"github.com/val7e/wasaText/service/linkpreview"
+	"github.com/val7e/wasaText/service/metrics"
+	"github.com/val7e/wasaText/service/tracing"
 )
 
 // Config is used to provide dependencies and configuration to the New function.
@@ -52,6 +57,35 @@ type Config struct {
 
 	// Database is the instance of database.AppDatabase where data are saved
 	Database database.AppDatabase
+
+	// RequestTimeout bounds the context passed to the database for each request (usually the server WriteTimeout).
+	// Zero means that only client disconnection and server shutdown cancel the request context.
+	RequestTimeout time.Duration
+
+	// Metrics is the registry where the HTTP metrics of the router are recorded (optional)
+	Metrics *metrics.Registry
+
+	// Tracer records a span for each request served by a wrapped handler (optional)
+	Tracer *tracing.Tracer
+
+	// RateLimits are the request rate limits per client. The zero value disables rate limiting.
+	RateLimits RateLimits
+
+	// Validation configures the validation against the OpenAPI document. The zero value disables it.
+	Validation Validation
+
+	// Files are the limits of the files sent in the conversations (the zero value uses the defaults)
+	Files FileLimits
+
+	// Audio are the limits of the voice messages (the zero value uses the defaults)
+	Audio AudioLimits
+
+	// LinkPreviews adds previews to the messages with links, in the background (optional)
+	LinkPreviews *linkpreview.Service
+
+	// Clock is the time seen by the rate limiters (default: globaltime.System). The database has its own, in its
+	// configuration.
+	Clock globaltime.Clock
 }
 
 // Router is the package API interface representing an API handler builder
@@ -61,6 +95,10 @@ type Router interface {
 
 	// Close terminates any resource used in the package
 	Close() error
+
+	// UndocumentedRoutes returns the API routes registered by Handler that are missing from the OpenAPI document
+	// given in Config.Validation
+	UndocumentedRoutes() []string
 }
 
 // New returns a new Router instance
@@ -79,11 +117,25 @@ func New(cfg Config) (Router, error) {
 	router.RedirectTrailingSlash = false
 	router.RedirectFixedPath = false
 
-	return &_router{
+	rt := &_router{
 		router:     router,
 		baseLogger: cfg.Logger,
 		db:         cfg.Database,
-	}, nil
+
+		requestTimeout: cfg.RequestTimeout,
+		tracer:         cfg.Tracer,
+		limiters:       newRateLimiters(cfg.RateLimits, globaltime.OrSystem(cfg.Clock), cfg.Metrics),
+		validation:     cfg.Validation,
+		linkPreviews:   cfg.LinkPreviews,
+		events:         newEventHub(),
+		files:          cfg.Files.withDefaults(),
+		fileUploads:    make(chan struct{}, cfg.Files.withDefaults().MaxConcurrent),
+		audio:          cfg.Audio.withDefaults(),
+	}
+	if cfg.Metrics != nil {
+		rt.metrics = newHTTPMetrics(cfg.Metrics)
+	}
+	return rt, nil
 }
 
 type _router struct {
@@ -94,4 +146,31 @@ type _router struct {
 	baseLogger logrus.FieldLogger
 
 	db database.AppDatabase
+
+	requestTimeout time.Duration
+
+	// metrics is nil if no metrics registry was configured
+	metrics *httpMetrics
+
+	// tracer is nil if tracing is disabled
+	tracer *tracing.Tracer
+
+	limiters rateLimiters
+
+	validation Validation
+
+	// linkPreviews is nil if link previews are disabled
+	linkPreviews *linkpreview.Service
+
+	// events are pushed to the clients connected to GET /users/me/events
+	events *eventHub
+
+	files FileLimits
+	audio AudioLimits
+
+	// fileUploads holds a token per upload being handled by sendFile
+	fileUploads chan struct{}
+
+	// routes are the API routes registered by Handler
+	routes []route
 }
@@ -0,0 +1,266 @@
+/*
+Package apitest is the end-to-end test harness of the API. NewServer boots the router of `service/api` inside an
+httptest.Server, over a new SQLite database, with a fake clock (globaltime.Fake) shared by the router and the database;
+Run plays the scenario files against it.
+
+A scenario is a YAML file with a list of HTTP requests and the expected replies (see Step and Expect). Each scenario
+runs on its own server, so identifiers start from 1 and timestamps from Epoch, and the expected bodies can rely on
+them:
+
+	name: direct chat
+	steps:
+	  - request: POST /session
+	    body: {username: alice}
+	    expect:
+	      status: 201
+	      body: {identifier: "1", username: alice}
+	    save: {alice: identifier}
+	  - request: POST /session
+	    body: {username: bob}
+	    expect: {status: 201}
+	  - request: POST /conversations
+	    as: alice
+	    body: {recipient: bob}
+	    expect:
+	      status: 201
+	      body: {id: 1, type: user, participants: [alice, bob], messages: []}
+	    save: {conv: id}
+	  - advance: 90s
+	  - request: POST /conversations/${conv}/messages
+	    as: alice
+	    body: {type: text, text: hi}
+	    expect:
+	      status: 201
+	      body: {id: 1, sender: alice, timestamp: "2025-01-01T12:01:30Z"}
+
+The link previews are fetched from the pages listed in the scenario (see Scenario.Pages), never from the web, one at
+a time in the order of the messages, and a `flush: true` step waits for them before the next request. The streams of
+server-sent events are read up to the events expected by the step (see Expect.Events).
+
+The requests and the responses are validated against doc/api.yaml too: a step fails on a mismatch, unless it has
+`invalid_request: true` and the mismatch is in its request. Run fails when a route (an operation of the document, or
+one of the special routes) is not exercised by any scenario. The scenarios of the project are embedded in Scenarios:
+
+	func TestScenarios(t *testing.T) {
+		apitest.Run(t, apitest.Scenarios, apitest.OpenSQLite)
+	}
+*/
+package apitest
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3" // SQLite driver for the test databases
+	"github.com/sirupsen/logrus"
+	"github.com/val7e/wasaText/doc"
+	"github.com/val7e/wasaText/service/api"
+	"github.com/val7e/wasaText/service/database"
+	"github.com/val7e/wasaText/service/database/databasetest"
+	"github.com/val7e/wasaText/service/globaltime"
+	"github.com/val7e/wasaText/service/linkpreview"
+	"github.com/val7e/wasaText/service/openapi"
+)
+
+//go:embed scenarios/*.yaml
+var scenarioFiles embed.FS
+
+// Scenarios are the scenario files of the project (service/api/apitest/scenarios).
+var Scenarios fs.FS
+
+func init() {
+	var err error
+	if Scenarios, err = fs.Sub(scenarioFiles, "scenarios"); err != nil {
+		panic(err)
+	}
+}
+
+// Epoch is the initial time of the clock of a Server.
+var Epoch = databasetest.Epoch
+
+// specialRoutes are the routes served outside the API document
+var specialRoutes = []string{"GET /", "GET /context", "GET /liveness"}
+
+// Options configures a Server.
+type Options struct {
+	// Open opens the database of the server (default: a new SQLite database in a temporary directory)
+	Open databasetest.Opener
+
+	// RateLimits are the rate limits of the server (default: no limits)
+	RateLimits api.RateLimits
+
+	// Pages are the web pages seen by the link previews, keyed by URL (default: none, so no link has a preview)
+	Pages linkpreview.Stub
+}
+
+// Server is the API server under test.
+type Server struct {
+	// URL is the base URL of the server, like http://127.0.0.1:50000
+	URL string
+
+	// Client sends requests to the server
+	Client *http.Client
+
+	// Clock is the clock of the router (rate limits) and of the database (timestamps), set to Epoch
+	Clock *globaltime.Fake
+
+	spec *openapi.Spec
+
+	previews *linkpreview.Service
+
+	mu              sync.Mutex
+	hits            map[string]bool
+	problems        []string
+	requestProblems []string
+}
+
+// NewServer starts a server, stopped at the end of the test.
+func NewServer(t *testing.T, opts Options) *Server {
+	t.Helper()
+
+	spec, err := openapi.Load(doc.APISpec)
+	if err != nil {
+		t.Fatalf("loading the API document: %v", err)
+	}
+	open := opts.Open
+	if open == nil {
+		open = OpenSQLite
+	}
+	s := &Server{spec: spec, Clock: globaltime.NewFake(Epoch), hits: make(map[string]bool)}
+	db := open(t, s.Clock)
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	logger.AddHook(s)
+
+	s.previews, err = linkpreview.New(linkpreview.Config{Fetcher: opts.Pages, Store: db, Logger: logger, Workers: 1})
+	if err != nil {
+		t.Fatalf("creating the link previews: %v", err)
+	}
+	t.Cleanup(func() { _ = s.previews.Close(context.Background()) })
+
+	router, err := api.New(api.Config{
+		Logger:       logger,
+		Database:     db,
+		RateLimits:   opts.RateLimits,
+		Validation:   api.Validation{Spec: spec, Responses: true},
+		LinkPreviews: s.previews,
+		Clock:        s.Clock,
+	})
+	if err != nil {
+		t.Fatalf("creating the API router: %v", err)
+	}
+
+	srv := httptest.NewServer(s.record(router.Handler()))
+	t.Cleanup(srv.Close)
+	s.URL, s.Client = srv.URL, srv.Client()
+	return s
+}
+
+// Flush waits for the link previews of the messages sent so far.
+func (s *Server) Flush(ctx context.Context) error {
+	return s.previews.Flush(ctx)
+}
+
+// OpenSQLite opens a new SQLite database in a temporary directory, with the options used by the webapi executable. It
+// is a databasetest.Opener.
+func OpenSQLite(t *testing.T, clock globaltime.Clock) database.AppDatabase {
+	t.Helper()
+	dsn := "file:" + filepath.Join(t.TempDir(), "wasatext.db") +
+		"?_foreign_keys=true&_journal_mode=WAL&_busy_timeout=5000&_txlock=immediate"
+	conn, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		t.Fatalf("opening SQLite: %v", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+
+	db, err := database.New(conn, database.Config{Clock: clock})
+	if err != nil {
+		t.Fatalf("creating the database: %v", err)
+	}
+	return db
+}
+
+// record marks the route of each request as covered
+func (s *Server) record(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := r.Method + " " + r.URL.Path
+		if op, _ := s.spec.FindOperation(r.Method, r.URL.Path); op != nil {
+			route = op.Method + " " + op.Path
+		}
+		s.mu.Lock()
+		s.hits[route] = true
+		s.mu.Unlock()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Routes returns every route of the server: the operations of the API document and the special routes.
+func (s *Server) Routes() []string {
+	routes := append([]string(nil), specialRoutes...)
+	for _, op := range s.spec.Operations() {
+		routes = append(routes, op.Method+" "+op.Path)
+	}
+	sort.Strings(routes)
+	return routes
+}
+
+// Covered returns the routes that received at least one request.
+func (s *Server) Covered() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var covered []string
+	for _, route := range s.Routes() {
+		if s.hits[route] {
+			covered = append(covered, route)
+		}
+	}
+	return covered
+}
+
+// Levels implements logrus.Hook: the server reports the requests that don't match the API document as warnings, and
+// the responses as errors.
+func (s *Server) Levels() []logrus.Level {
+	return []logrus.Level{logrus.WarnLevel, logrus.ErrorLevel}
+}
+
+// Fire implements logrus.Hook.
+func (s *Server) Fire(entry *logrus.Entry) error {
+	if entry.Message != "request does not match the API document" &&
+		entry.Message != "response does not match the API document" {
+		return nil
+	}
+	problem := entry.Message
+	if err, ok := entry.Data[logrus.ErrorKey].(error); ok {
+		problem += ": " + err.Error()
+	}
+	s.mu.Lock()
+	if entry.Level == logrus.WarnLevel {
+		s.requestProblems = append(s.requestProblems, problem)
+	} else {
+		s.problems = append(s.problems, problem)
+	}
+	s.mu.Unlock()
+	return nil
+}
+
+// takeProblems returns (and forgets) the mismatches of the requests and of the responses with the API document found
+// since the last call
+func (s *Server) takeProblems() (requests, responses []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	requests, responses = s.requestProblems, s.problems
+	s.requestProblems, s.problems = nil, nil
+	return requests, responses
+}
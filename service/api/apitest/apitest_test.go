@@ -0,0 +1,20 @@
+package apitest_test
+
+import (
+	"testing"
+
+	"github.com/val7e/wasaText/service/api/apitest"
+	"github.com/val7e/wasaText/service/database"
+	"github.com/val7e/wasaText/service/database/memdb"
+	"github.com/val7e/wasaText/service/globaltime"
+)
+
+func TestScenarios(t *testing.T) {
+	apitest.Run(t, apitest.Scenarios, apitest.OpenSQLite)
+}
+
+func TestScenariosMemDB(t *testing.T) {
+	apitest.Run(t, apitest.Scenarios, func(t *testing.T, clock globaltime.Clock) database.AppDatabase {
+		return memdb.New(memdb.Config{Clock: clock})
+	})
+}
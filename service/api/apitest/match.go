@@ -0,0 +1,132 @@
+package apitest
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// Special values of the expectations
+const (
+	anyValue = "$any"
+	absent   = "$absent"
+)
+
+// match compares the expected value (from a scenario file) with the JSON reply, returning the differences
+func match(path string, want, got interface{}) []string {
+	if s, ok := want.(string); ok && s == anyValue {
+		return nil
+	}
+
+	switch w := want.(type) {
+	case nil:
+		if got != nil {
+			return []string{fmt.Sprintf("%s: got %s, want null", path, describe(got))}
+		}
+		return nil
+
+	case map[string]interface{}:
+		g, ok := got.(map[string]interface{})
+		if !ok {
+			return []string{fmt.Sprintf("%s: got %s, want an object", path, describe(got))}
+		}
+		keys := make([]string, 0, len(w))
+		for k := range w {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		var problems []string
+		for _, k := range keys {
+			item, present := g[k]
+			switch {
+			case w[k] == absent && present:
+				problems = append(problems, fmt.Sprintf("%s.%s: got %s, want no key", path, k, describe(item)))
+			case w[k] == absent:
+			case !present:
+				problems = append(problems, fmt.Sprintf("%s.%s is missing", path, k))
+			default:
+				problems = append(problems, match(path+"."+k, w[k], item)...)
+			}
+		}
+		return problems
+
+	case []interface{}:
+		g, ok := got.([]interface{})
+		if !ok {
+			return []string{fmt.Sprintf("%s: got %s, want an array", path, describe(got))}
+		}
+		if len(g) != len(w) {
+			return []string{fmt.Sprintf("%s: got %d items, want %d", path, len(g), len(w))}
+		}
+		var problems []string
+		for i := range w {
+			problems = append(problems, match(path+"."+strconv.Itoa(i), w[i], g[i])...)
+		}
+		return problems
+
+	case int, float64, json.Number:
+		g, ok := got.(json.Number)
+		if !ok || !sameNumber(w, g) {
+			return []string{fmt.Sprintf("%s: got %s, want %v", path, describe(got), w)}
+		}
+		return nil
+	}
+
+	if want != got {
+		return []string{fmt.Sprintf("%s: got %s, want %s", path, describe(got), describe(want))}
+	}
+	return nil
+}
+
+func sameNumber(want interface{}, got json.Number) bool {
+	g, err := got.Float64()
+	if err != nil {
+		return false
+	}
+	switch w := want.(type) {
+	case int:
+		return g == float64(w)
+	case float64:
+		return g == w
+	case json.Number:
+		f, err := w.Float64()
+		return err == nil && f == g
+	}
+	return false
+}
+
+// describe formats a JSON value for the error messages
+func describe(v interface{}) string {
+	if v == nil {
+		return "null"
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	if len(b) > 200 {
+		return string(b[:200]) + "..."
+	}
+	return string(b)
+}
+
+// normalize converts the maps decoded by yaml.v2 (with interface{} keys) to JSON objects
+func normalize(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			out[fmt.Sprint(k)] = normalize(item)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, item := range v {
+			out[i] = normalize(item)
+		}
+		return out
+	}
+	return v
+}
@@ -0,0 +1,562 @@
+package apitest
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/val7e/wasaText/service/api"
+	"github.com/val7e/wasaText/service/database/databasetest"
+	"github.com/val7e/wasaText/service/linkpreview"
+	"github.com/val7e/wasaText/service/models"
+	"gopkg.in/yaml.v2"
+)
+
+// Scenario is a scenario file.
+type Scenario struct {
+	Name string `yaml:"name"`
+
+	// RateLimits enables the rate limits of the server (disabled by default). The clock only moves with the advance
+	// steps, so buckets don't refill in between: a burst of N lets exactly N requests through.
+	RateLimits struct {
+		Login     RateLimit `yaml:"login"`
+		Messaging RateLimit `yaml:"messaging"`
+		Search    RateLimit `yaml:"search"`
+		Uploads   RateLimit `yaml:"uploads"`
+	} `yaml:"rate_limits"`
+
+	// Pages are the web pages seen by the link previews, keyed by URL
+	Pages map[string]Page `yaml:"pages"`
+
+	Steps []Step `yaml:"steps"`
+
+	file string
+}
+
+// RateLimit is api.RateLimit in scenario files.
+type RateLimit struct {
+	PerMinute int `yaml:"per_minute"`
+	Burst     int `yaml:"burst"`
+}
+
+// Page is the preview of a web page in scenario files.
+type Page struct {
+	Title       string `yaml:"title"`
+	Description string `yaml:"description"`
+	Image       string `yaml:"image"`
+}
+
+// Step is a request of a scenario, and its expected reply; or, if Advance is set, a move of the clock of the server;
+// or, if Flush is set, a wait for the background work of the server.
+//
+// Strings in the request and in the expectations can refer to the variables saved by the previous steps as ${name}:
+// a string made only of a reference is replaced by the value (keeping its JSON type), otherwise the value is
+// formatted in the string. ${pic} is predefined, and holds a small base64-encoded PNG.
+type Step struct {
+	Name string `yaml:"name"`
+
+	// Advance moves the clock forward, like "90s" or "1h"
+	Advance string `yaml:"advance"`
+
+	// Flush waits for the link previews of the messages sent by the previous steps
+	Flush bool `yaml:"flush"`
+
+	// Request is the method and the path of the request, like "GET /conversations/${conv}"
+	Request string `yaml:"request"`
+
+	// As is the variable holding the Bearer token of the request (usually saved from the identifier of POST /session)
+	As string `yaml:"as"`
+
+	Headers map[string]string `yaml:"headers"`
+
+	// Body is sent as JSON, RawBody as is (for malformed bodies)
+	Body    interface{} `yaml:"body"`
+	RawBody *string     `yaml:"raw_body"`
+
+	// InvalidRequest marks the requests that don't match the API document on purpose, to check how the handlers
+	// reject them. The step fails if the request does not match the document without it, or matches it with it.
+	InvalidRequest bool `yaml:"invalid_request"`
+
+	Expect Expect `yaml:"expect"`
+
+	// Save maps variable names to the values of the JSON reply, selected by a dotted path like "messages.0.id" (an
+	// empty path saves the whole reply)
+	Save map[string]string `yaml:"save"`
+}
+
+// Expect is the expected reply of a step.
+//
+// Body is compared with the JSON reply: objects must contain (at least) the expected keys, arrays must have the same
+// length, and "$any" matches any value. A key with the value "$absent" must not be in the reply. Headers are
+// compared the same way.
+type Expect struct {
+	Status  int               `yaml:"status"`
+	Headers map[string]string `yaml:"headers"`
+	Body    interface{}       `yaml:"body"`
+
+	// Text is the expected body of replies that are not JSON
+	Text *string `yaml:"text"`
+
+	// Events are the first events expected on a stream of server-sent events (text/event-stream), which is closed once
+	// they are read. Their data is compared like Body.
+	Events []Event `yaml:"events"`
+}
+
+// Event is a server-sent event.
+type Event struct {
+	Event string      `yaml:"event"`
+	Data  interface{} `yaml:"data"`
+}
+
+// streamTimeout bounds the wait for the events expected on a stream
+const streamTimeout = 10 * time.Second
+
+// pic is the predefined ${pic} variable: a 5x5 PNG
+const pic = "iVBORw0KGgoAAAANSUhEUgAAAAUAAAAFCAYAAACNbyblAAAAHElEQVQI12P4//8/w38GIAXDIBKE0DHxgljNBAAO9TXL0Y4OHwAAAABJRU5ErkJggg=="
+
+// LoadScenarios reads the scenario files (*.yaml) in the root of fsys, sorted by file name.
+func LoadScenarios(fsys fs.FS) ([]*Scenario, error) {
+	files, err := fs.Glob(fsys, "*.yaml")
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+
+	var scenarios []*Scenario
+	for _, file := range files {
+		data, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return nil, err
+		}
+		sc := &Scenario{file: file}
+		if err := yaml.UnmarshalStrict(data, sc); err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		if sc.Name == "" {
+			sc.Name = strings.TrimSuffix(file, ".yaml")
+		}
+		for i, step := range sc.Steps {
+			if step.Advance != "" {
+				if _, err := time.ParseDuration(step.Advance); err != nil || step.Request != "" {
+					return nil, fmt.Errorf("%s, step %d: advance must be a duration, alone in its step", file, i+1)
+				}
+				continue
+			}
+			if step.Flush {
+				if step.Request != "" {
+					return nil, fmt.Errorf("%s, step %d: flush must be alone in its step", file, i+1)
+				}
+				continue
+			}
+			if _, _, ok := splitRequest(step.Request); !ok {
+				return nil, fmt.Errorf("%s, step %d: the request must be \"METHOD /path\", got %q", file, i+1, step.Request)
+			}
+			if step.Expect.Status == 0 {
+				return nil, fmt.Errorf("%s, step %d: expect.status is required", file, i+1)
+			}
+		}
+		scenarios = append(scenarios, sc)
+	}
+	return scenarios, nil
+}
+
+// Run plays every scenario of fsys as a subtest, each on a new Server over a database opened by open (OpenSQLite if
+// nil), then fails if a route of the server was not exercised by any scenario.
+func Run(t *testing.T, fsys fs.FS, open databasetest.Opener) {
+	scenarios, err := LoadScenarios(fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(scenarios) == 0 {
+		t.Fatal("no scenarios found")
+	}
+
+	var routes []string
+	covered := make(map[string]bool)
+	for _, sc := range scenarios {
+		sc := sc
+		t.Run(sc.Name, func(t *testing.T) {
+			srv := NewServer(t, Options{Open: open, RateLimits: sc.rateLimits(), Pages: sc.pages()})
+			defer func() {
+				// Deferred, to count the steps played before a failure too
+				routes = srv.Routes()
+				for _, route := range srv.Covered() {
+					covered[route] = true
+				}
+			}()
+			sc.Play(t, srv)
+		})
+	}
+
+	var missing []string
+	for _, route := range routes {
+		if !covered[route] {
+			missing = append(missing, route)
+		}
+	}
+	if len(missing) > 0 {
+		t.Errorf("routes not covered by any scenario:\n\t%s", strings.Join(missing, "\n\t"))
+	}
+}
+
+func (sc *Scenario) rateLimits() api.RateLimits {
+	return api.RateLimits{
+		Login:     api.RateLimit(sc.RateLimits.Login),
+		Messaging: api.RateLimit(sc.RateLimits.Messaging),
+		Search:    api.RateLimit(sc.RateLimits.Search),
+		Uploads:   api.RateLimit(sc.RateLimits.Uploads),
+	}
+}
+
+func (sc *Scenario) pages() linkpreview.Stub {
+	pages := make(linkpreview.Stub)
+	for link, p := range sc.Pages {
+		pages[link] = models.LinkPreview{Title: p.Title, Description: p.Description, Image: p.Image}
+	}
+	return pages
+}
+
+// Play runs the steps of the scenario against srv, stopping at the first step that fails.
+func (sc *Scenario) Play(t *testing.T, srv *Server) {
+	t.Helper()
+	vars := map[string]interface{}{"pic": pic}
+	for i := range sc.Steps {
+		step := &sc.Steps[i]
+		if err := step.play(srv, vars); err != nil {
+			name := step.Name
+			if name == "" {
+				name = step.Request
+			}
+			t.Fatalf("%s, step %d (%s): %v", sc.file, i+1, name, err)
+		}
+	}
+}
+
+func (step *Step) play(srv *Server, vars map[string]interface{}) error {
+	if step.Advance != "" {
+		d, _ := time.ParseDuration(step.Advance)
+		srv.Clock.Advance(d)
+		return nil
+	}
+	if step.Flush {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		return srv.Flush(ctx)
+	}
+
+	method, path, _ := splitRequest(step.Request)
+	path, err := expandString(path, vars)
+	if err != nil {
+		return err
+	}
+
+	var body io.Reader
+	switch {
+	case step.RawBody != nil:
+		raw, err := expandString(*step.RawBody, vars)
+		if err != nil {
+			return err
+		}
+		body = strings.NewReader(raw)
+	case step.Body != nil:
+		v, err := expand(normalize(step.Body), vars)
+		if err != nil {
+			return err
+		}
+		b, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Errorf("encoding the body: %w", err)
+		}
+		body = bytes.NewReader(b)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), streamTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, method, srv.URL+path, body)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if step.As != "" {
+		token, ok := vars[step.As]
+		if !ok {
+			return fmt.Errorf("unknown variable %q in as", step.As)
+		}
+		req.Header.Set("Authorization", "Bearer "+fmt.Sprint(token))
+	}
+	for k, v := range step.Headers {
+		if v, err = expandString(v, vars); err != nil {
+			return err
+		}
+		req.Header.Set(k, v)
+	}
+
+	res, err := srv.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = res.Body.Close() }()
+	if step.Expect.Events != nil && res.StatusCode == http.StatusOK {
+		events, err := readEvents(res.Body, len(step.Expect.Events))
+		if err != nil {
+			return err
+		}
+		if err := step.checkProblems(srv); err != nil {
+			return err
+		}
+		return step.checkEvents(res.Header, events, vars)
+	}
+	raw, err := io.ReadAll(res.Body)
+	if err != nil {
+		return fmt.Errorf("reading the reply: %w", err)
+	}
+
+	if res.StatusCode != step.Expect.Status {
+		return fmt.Errorf("got status %d, want %d; body: %s", res.StatusCode, step.Expect.Status, bytes.TrimSpace(raw))
+	}
+	if err := step.checkProblems(srv); err != nil {
+		return err
+	}
+	return step.check(res.Header, raw, vars)
+}
+
+// checkProblems returns an error if the server found unexpected mismatches with the API document while serving the
+// step
+func (step *Step) checkProblems(srv *Server) error {
+	requests, responses := srv.takeProblems()
+	switch {
+	case len(responses) > 0:
+		return fmt.Errorf("%s", strings.Join(responses, "; "))
+	case len(requests) > 0 && !step.InvalidRequest:
+		return fmt.Errorf("%s (use invalid_request if it is on purpose)", strings.Join(requests, "; "))
+	case len(requests) == 0 && step.InvalidRequest:
+		return errors.New("the request matches the API document, but the step has invalid_request")
+	}
+	return nil
+}
+
+// check compares the reply with the expectations, then saves the variables
+func (step *Step) check(header http.Header, raw []byte, vars map[string]interface{}) error {
+	for k, want := range step.Expect.Headers {
+		want, err := expandString(want, vars)
+		if err != nil {
+			return err
+		}
+		got, present := header.Get(k), len(header.Values(k)) > 0
+		switch {
+		case want == absent && present:
+			return fmt.Errorf("header %s: got %q, want no header", k, got)
+		case want == absent:
+		case !present:
+			return fmt.Errorf("header %s is missing", k)
+		case want != anyValue && got != want:
+			return fmt.Errorf("header %s: got %q, want %q", k, got, want)
+		}
+	}
+
+	if step.Expect.Text != nil {
+		if got := string(raw); got != *step.Expect.Text {
+			return fmt.Errorf("got body %q, want %q", got, *step.Expect.Text)
+		}
+	}
+
+	if step.Expect.Body == nil && len(step.Save) == 0 {
+		return nil
+	}
+	var got interface{}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	if err := dec.Decode(&got); err != nil {
+		return fmt.Errorf("the reply is not JSON (%v): %s", err, bytes.TrimSpace(raw))
+	}
+
+	if step.Expect.Body != nil {
+		want, err := expand(normalize(step.Expect.Body), vars)
+		if err != nil {
+			return err
+		}
+		if problems := match("body", want, got); len(problems) > 0 {
+			return fmt.Errorf("%s\nreply: %s", strings.Join(problems, "\n"), bytes.TrimSpace(raw))
+		}
+	}
+
+	for name, path := range step.Save {
+		v, err := lookup(got, path)
+		if err != nil {
+			return fmt.Errorf("saving %s: %w", name, err)
+		}
+		vars[name] = v
+	}
+	return nil
+}
+
+// checkEvents compares the events read from a stream with the expectations
+func (step *Step) checkEvents(header http.Header, events []receivedEvent, vars map[string]interface{}) error {
+	if step.Expect.Status != http.StatusOK {
+		return fmt.Errorf("got status 200, want %d", step.Expect.Status)
+	}
+	if err := step.check(header, nil, vars); err != nil {
+		return err
+	}
+	for i, want := range step.Expect.Events {
+		got := events[i]
+		if got.name != want.Event {
+			return fmt.Errorf("event %d: got %q, want %q", i+1, got.name, want.Event)
+		}
+		var data interface{}
+		dec := json.NewDecoder(strings.NewReader(got.data))
+		dec.UseNumber()
+		if err := dec.Decode(&data); err != nil {
+			return fmt.Errorf("event %d: the data is not JSON (%v): %s", i+1, err, got.data)
+		}
+		wantData, err := expand(normalize(want.Data), vars)
+		if err != nil {
+			return err
+		}
+		if problems := match(fmt.Sprintf("event %d", i+1), wantData, data); len(problems) > 0 {
+			return fmt.Errorf("%s\ndata: %s", strings.Join(problems, "\n"), got.data)
+		}
+	}
+	return nil
+}
+
+// receivedEvent is an event read from a stream
+type receivedEvent struct {
+	name, data string
+}
+
+// readEvents reads the first n events of a text/event-stream body. Blocks without data (like "retry: 2000") and
+// comments are skipped.
+func readEvents(body io.Reader, n int) ([]receivedEvent, error) {
+	var events []receivedEvent
+	var e receivedEvent
+	var data []string
+	scanner := bufio.NewScanner(body)
+	for len(events) < n && scanner.Scan() {
+		line := scanner.Text()
+		field, value := line, ""
+		if i := strings.IndexByte(line, ':'); i >= 0 {
+			field, value = line[:i], strings.TrimPrefix(line[i+1:], " ")
+		}
+		switch field {
+		case "":
+			if line == "" && data != nil {
+				e.data = strings.Join(data, "\n")
+				events = append(events, e)
+			}
+			if line == "" {
+				e, data = receivedEvent{}, nil
+			}
+		case "event":
+			e.name = value
+		case "data":
+			data = append(data, value)
+		}
+	}
+	if len(events) < n {
+		return nil, fmt.Errorf("got %d events, want %d (%v)", len(events), n, scanner.Err())
+	}
+	return events, nil
+}
+
+// splitRequest splits "METHOD /path"
+func splitRequest(request string) (method, path string, ok bool) {
+	fields := strings.Fields(request)
+	if len(fields) != 2 || !strings.HasPrefix(fields[1], "/") {
+		return "", "", false
+	}
+	return strings.ToUpper(fields[0]), fields[1], true
+}
+
+var varRef = regexp.MustCompile(`\$\{(\w+)\}`)
+
+// expand replaces the variable references in the strings of v
+func expand(v interface{}, vars map[string]interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case string:
+		if m := varRef.FindStringSubmatch(v); m != nil && m[0] == v {
+			value, ok := vars[m[1]]
+			if !ok {
+				return nil, fmt.Errorf("unknown variable %q", m[1])
+			}
+			return value, nil
+		}
+		return expandString(v, vars)
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			expanded, err := expand(item, vars)
+			if err != nil {
+				return nil, err
+			}
+			out[k] = expanded
+		}
+		return out, nil
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, item := range v {
+			expanded, err := expand(item, vars)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = expanded
+		}
+		return out, nil
+	}
+	return v, nil
+}
+
+// expandString formats the variables referenced in s
+func expandString(s string, vars map[string]interface{}) (string, error) {
+	var err error
+	out := varRef.ReplaceAllStringFunc(s, func(ref string) string {
+		name := ref[2 : len(ref)-1]
+		value, ok := vars[name]
+		if !ok {
+			err = fmt.Errorf("unknown variable %q", name)
+			return ref
+		}
+		return fmt.Sprint(value)
+	})
+	return out, err
+}
+
+// lookup returns the value at the dotted path of a JSON value
+func lookup(v interface{}, path string) (interface{}, error) {
+	if path == "" {
+		return v, nil
+	}
+	for _, key := range strings.Split(path, ".") {
+		switch node := v.(type) {
+		case map[string]interface{}:
+			item, ok := node[key]
+			if !ok {
+				return nil, fmt.Errorf("no %q in the reply", path)
+			}
+			v = item
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(node) {
+				return nil, fmt.Errorf("no %q in the reply", path)
+			}
+			v = node[i]
+		default:
+			return nil, fmt.Errorf("no %q in the reply", path)
+		}
+	}
+	return v, nil
+}
@@ -0,0 +1,174 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/val7e/wasaText/service/api/reqcontext"
+	"github.com/val7e/wasaText/service/audio"
+	"github.com/val7e/wasaText/service/models"
+)
+
+// AudioLimits are the limits of the voice messages.
+type AudioLimits struct {
+	// MaxSize is the maximum size of a recording, in bytes (default DefaultMaxAudioSize)
+	MaxSize int64
+
+	// MaxDuration is the maximum length of a recording (default DefaultMaxAudioDuration)
+	MaxDuration time.Duration
+}
+
+// Defaults of AudioLimits
+const (
+	DefaultMaxAudioSize     int64 = 10 << 20
+	DefaultMaxAudioDuration       = 5 * time.Minute
+)
+
+func (l AudioLimits) withDefaults() AudioLimits {
+	if l.MaxSize <= 0 {
+		l.MaxSize = DefaultMaxAudioSize
+	}
+	if l.MaxDuration <= 0 {
+		l.MaxDuration = DefaultMaxAudioDuration
+	}
+	return l
+}
+
+// sendAudio sends a voice message in a conversation. The body is the recording, Ogg/Opus or WAV: the duration and the
+// waveform are read from it.
+func (rt *_router) sendAudio(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	w.Header().Set("Content-Type", "application/json")
+
+	// Get user ID from Authorization header
+	userID, err := rt.getUserFromAuth(r)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("Authorization failed")
+		w.WriteHeader(http.StatusUnauthorized)
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+
+	conversationID, err := strconv.ParseInt(ps.ByName("conversation_id"), 10, 64)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("Invalid conversation ID")
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": "Invalid conversation ID"})
+		return
+	}
+
+	data, err := readAudioBody(w, r, rt.audio.MaxSize)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("Invalid voice message")
+		writeBodyError(w, err)
+		return
+	}
+	info, err := audio.Analyze(data)
+	if errors.Is(err, audio.ErrUnsupported) {
+		ctx.Logger.WithError(err).Error("Voice message format not supported")
+		w.WriteHeader(http.StatusUnsupportedMediaType)
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": "Voice messages must be Ogg/Opus or WAV"})
+		return
+	}
+	if err == nil && info.Duration <= 0 {
+		err = errors.New("the recording is empty")
+	}
+	if err == nil && info.Duration > rt.audio.MaxDuration {
+		err = fmt.Errorf("the recording must be at most %s long", rt.audio.MaxDuration)
+	}
+	if err != nil {
+		ctx.Logger.WithError(err).Error("Invalid voice message")
+		writeBodyError(w, &bodyError{status: http.StatusBadRequest, Message: "Invalid request body", Reason: err.Error()})
+		return
+	}
+
+	ctx.Logger.WithField("conversation_id", conversationID).WithField("size", len(data)).
+		WithField("duration", info.Duration).Info("Sending voice message")
+
+	message, err := rt.db.SendAudio(r.Context(), conversationID, userID, models.NewAudio{
+		MimeType: info.MimeType,
+		Duration: info.Duration,
+		Waveform: info.Waveform,
+		Data:     data,
+	})
+	if err != nil {
+		if err.Error() == "user not participant in conversation" {
+			ctx.Logger.WithError(err).Error("User not participant in conversation")
+			w.WriteHeader(http.StatusForbidden)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": "You are not a participant in this conversation"})
+			return
+		}
+
+		ctx.Logger.WithError(err).Error("Error sending voice message")
+		w.WriteHeader(http.StatusInternalServerError)
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": "Failed to send voice message"})
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(message)
+}
+
+// getAudio returns the recording of a voice message, for the participants of the conversation
+func (rt *_router) getAudio(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	w.Header().Set("Content-Type", "application/json")
+
+	userID, conversationID, messageID, ok := rt.threadRequest(w, r, ps, ctx)
+	if !ok {
+		return
+	}
+
+	info, data, err := rt.db.GetAudio(r.Context(), messageID, conversationID, userID)
+	if err != nil {
+		status, msg := http.StatusInternalServerError, "Failed to retrieve voice message"
+		switch err.Error() {
+		case "user not participant in conversation":
+			status, msg = http.StatusForbidden, "You are not a participant in this conversation"
+		case "message not found":
+			status, msg = http.StatusNotFound, "Message not found"
+		case "message has no audio":
+			status, msg = http.StatusNotFound, "The message is not a voice message"
+		}
+		ctx.Logger.WithError(err).Error(msg)
+		w.WriteHeader(status)
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": msg})
+		return
+	}
+
+	ext := ".ogg"
+	if info.MimeType == audio.MimeWAV {
+		ext = ".wav"
+	}
+	w.Header().Set("Content-Type", info.MimeType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("inline",
+		map[string]string{"filename": "voice-" + strconv.FormatInt(messageID, 10) + ext}))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Security-Policy", "sandbox")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(data)
+}
+
+// readAudioBody reads the recording in the body, of at most maxSize bytes. The returned error, if any, is a
+// *bodyError.
+func readAudioBody(w http.ResponseWriter, r *http.Request, maxSize int64) ([]byte, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxSize)
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		if bodyTooLarge(err) {
+			return nil, &bodyError{status: http.StatusRequestEntityTooLarge, Message: "Voice message too large",
+				Reason: fmt.Sprintf("the recording must be at most %d bytes", maxSize)}
+		}
+		return nil, &bodyError{status: http.StatusBadRequest, Message: "Invalid request body", Reason: err.Error()}
+	}
+	if len(data) == 0 {
+		return nil, &bodyError{status: http.StatusBadRequest, Message: "Invalid request body", Reason: "the body is empty"}
+	}
+	return data, nil
+}
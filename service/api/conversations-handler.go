@@ -24,7 +24,7 @@ func (rt *_router) getMyConversations(w http.ResponseWriter, r *http.Request, ps
 
 	ctx.Logger.WithField("user_id", userID).Info("Fetching conversations")
 
-	conversations, err := rt.db.GetMyConversations(userID)
+	conversations, err := rt.db.GetMyConversations(r.Context(), userID)
 	if err != nil {
 		ctx.Logger.WithError(err).Error("Error fetching conversations")
 		w.WriteHeader(http.StatusInternalServerError)
@@ -60,7 +60,7 @@ func (rt *_router) getConversation(w http.ResponseWriter, r *http.Request, ps ht
 	ctx.Logger.WithField("conversation_id", conversationID).WithField("user_id", userID).Info("Fetching conversation")
 
 	// Pass userID to check if user is participant
-	conversation, err := rt.db.GetConversation(conversationID, userID)
+	conversation, err := rt.db.GetConversation(r.Context(), conversationID, userID)
 	if err != nil {
 		if err.Error() == "conversation not found" {
 			ctx.Logger.WithError(err).Error("Conversation not found")
@@ -104,10 +104,9 @@ func (rt *_router) startConversation(w http.ResponseWriter, r *http.Request, ps
 		Recipient string `json:"recipient"`
 	}
 
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+	if err := decodeJSONBody(w, r, &req, maxBodySize); err != nil {
 		ctx.Logger.WithError(err).Error("Invalid request body")
-		w.WriteHeader(http.StatusBadRequest)
-		_ = json.NewEncoder(w).Encode(map[string]string{"error": "Invalid request body"})
+		writeBodyError(w, err)
 		return
 	}
 
@@ -120,7 +119,7 @@ func (rt *_router) startConversation(w http.ResponseWriter, r *http.Request, ps
 
 	ctx.Logger.WithField("user_id", userID).WithField("recipient", req.Recipient).Info("Starting conversation")
 
-	conversation, err := rt.db.StartConversation(userID, req.Recipient)
+	conversation, err := rt.db.StartConversation(r.Context(), userID, req.Recipient)
 	if err != nil {
 		if err.Error() == "recipient user not found" {
 			ctx.Logger.WithError(err).Error("Recipient user not found")
@@ -0,0 +1,124 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/val7e/wasaText/service/api/reqcontext"
+	"github.com/val7e/wasaText/service/models"
+)
+
+const (
+	// eventsKeepAlive is the interval of the comments written to idle streams, so that proxies keep them open
+	eventsKeepAlive = 15 * time.Second
+
+	// eventsRetry is the delay before reconnecting advised to the clients
+	eventsRetry = 2 * time.Second
+)
+
+// Names of the events
+const (
+	// eventMessageUpdated carries a messageEvent: a message changed after it was sent
+	eventMessageUpdated = "message_updated"
+)
+
+// messageEvent is the data of the events about a message
+type messageEvent struct {
+	ConversationID int64          `json:"conversation_id"`
+	Message        models.Message `json:"message"`
+}
+
+// getEvents streams the events of the user as server-sent events, until the client goes away, the request timeout
+// expires or the server shuts down. Clients reconnect with the Last-Event-ID header to get the events they missed in
+// between, if they are still in the history of the server.
+func (rt *_router) getEvents(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	// Get user ID from Authorization header
+	userID, err := rt.getUserFromAuth(r)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("Authorization failed")
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnauthorized)
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		ctx.Logger.Error("The response writer can't stream")
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": "Streaming not supported"})
+		return
+	}
+
+	// Without Last-Event-ID, the stream starts from the next event
+	after := int64(-1)
+	if id, err := strconv.ParseInt(r.Header.Get("Last-Event-ID"), 10, 64); err == nil && id >= 0 {
+		after = id
+	}
+	events, missed := rt.events.subscribe(userID, after)
+	if events == nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": "The server is shutting down"})
+		return
+	}
+	defer rt.events.unsubscribe(userID, events)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	_, _ = fmt.Fprintf(w, "retry: %d\n\n", eventsRetry.Milliseconds())
+	for _, e := range missed {
+		writeEvent(w, e)
+	}
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(eventsKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				// Fell behind: the client reconnects and gets the rest from the history
+				ctx.Logger.Warning("event stream too slow, closing it")
+				return
+			}
+			writeEvent(w, e)
+		case <-keepAlive.C:
+			_, _ = io.WriteString(w, ": keep-alive\n\n")
+		case <-r.Context().Done():
+			return
+		case <-rt.events.done:
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+// writeEvent writes an event in the text/event-stream format. Its data is JSON, on a single line.
+func writeEvent(w io.Writer, e event) {
+	_, _ = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.id, e.name, e.data)
+}
+
+// pushMessageUpdate sends the updated message to the participants of its conversation connected to the event stream.
+func (rt *_router) pushMessageUpdate(ctx context.Context, conversationID int64, message models.Message) {
+	logger := rt.baseLogger.WithField("message_id", message.Id)
+	users, err := rt.db.GetParticipantIDs(ctx, conversationID)
+	if err != nil {
+		logger.WithError(err).Warning("error finding the participants to push the message to")
+		return
+	}
+	data, err := json.Marshal(messageEvent{ConversationID: conversationID, Message: message})
+	if err != nil {
+		logger.WithError(err).Error("error encoding the message event")
+		return
+	}
+	rt.events.publish(eventMessageUpdated, data, users)
+}
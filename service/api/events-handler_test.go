@@ -0,0 +1,141 @@
+package api_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/val7e/wasaText/service/api"
+	"github.com/val7e/wasaText/service/database/memdb"
+	"github.com/val7e/wasaText/service/linkpreview"
+	"github.com/val7e/wasaText/service/models"
+)
+
+// openEvents opens the event stream of a user, and reads the first block (the retry delay): the user is subscribed
+// when it returns
+func openEvents(t *testing.T, url string, userID int64) *bufio.Reader {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodGet, url+"/users/me/events", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+strconv.FormatInt(userID, 10))
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = res.Body.Close() })
+	if res.StatusCode != http.StatusOK || res.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("got %s %s", res.Status, res.Header.Get("Content-Type"))
+	}
+	stream := bufio.NewReader(res.Body)
+	if block := readBlock(t, stream); !strings.HasPrefix(block, "retry: ") {
+		t.Fatalf("got the first block %q, want the retry delay", block)
+	}
+	return stream
+}
+
+// readBlock reads the lines of a stream up to the next empty one. It returns an empty string at the end of the stream.
+func readBlock(t *testing.T, stream *bufio.Reader) string {
+	t.Helper()
+	var block strings.Builder
+	for {
+		line, err := stream.ReadString('\n')
+		if err == io.EOF {
+			return block.String()
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if line == "\n" {
+			return block.String()
+		}
+		block.WriteString(line)
+	}
+}
+
+// TestEventsPushLinkPreview checks that the message with its link preview is pushed to the participants connected to
+// the event stream, and only to them
+func TestEventsPushLinkPreview(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	db := memdb.New(memdb.Config{})
+	previews, err := linkpreview.New(linkpreview.Config{
+		Fetcher: linkpreview.Stub{"https://example.com/": {Title: "Example"}},
+		Store:   db,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = previews.Close(context.Background()) }()
+	router, err := api.New(api.Config{Logger: logger, Database: db, LinkPreviews: previews})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(router.Handler())
+	defer srv.Close()
+
+	var users []*models.User
+	for _, name := range []string{"alice", "bob", "carol"} {
+		u, _, err := db.DoLogin(context.Background(), name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		users = append(users, u)
+	}
+	conv, err := db.StartConversation(context.Background(), users[0].Id, "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob := openEvents(t, srv.URL, users[1].Id)
+	carol := openEvents(t, srv.URL, users[2].Id)
+
+	req, err := http.NewRequest(http.MethodPost, srv.URL+"/conversations/"+strconv.FormatInt(conv.Id, 10)+"/messages",
+		strings.NewReader(`{"type": "text", "text": "look at https://example.com/"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+strconv.FormatInt(users[0].Id, 10))
+	req.Header.Set("Content-Type", "application/json")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = res.Body.Close()
+	if res.StatusCode != http.StatusCreated {
+		t.Fatalf("sending the message: got %s", res.Status)
+	}
+
+	block := readBlock(t, bob)
+	lines := strings.Split(strings.TrimSuffix(block, "\n"), "\n")
+	if len(lines) != 3 || lines[0] != "id: 1" || lines[1] != "event: message_updated" ||
+		!strings.HasPrefix(lines[2], "data: ") {
+		t.Fatalf("got the event %q", block)
+	}
+	var data struct {
+		ConversationID int64          `json:"conversation_id"`
+		Message        models.Message `json:"message"`
+	}
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(lines[2], "data: ")), &data); err != nil {
+		t.Fatal(err)
+	}
+	if data.ConversationID != conv.Id || data.Message.Sender != "alice" || data.Message.LinkPreview == nil ||
+		data.Message.LinkPreview.Title != "Example" {
+		t.Errorf("got the data %+v", data)
+	}
+
+	// Closing the router ends the streams: carol got nothing
+	if err := router.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if block := readBlock(t, carol); block != "" {
+		t.Errorf("carol got %q", block)
+	}
+}
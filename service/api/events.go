@@ -0,0 +1,128 @@
+package api
+
+import (
+	"sync"
+)
+
+const (
+	// eventHistory is the number of recent events kept for the clients that reconnect with Last-Event-ID
+	eventHistory = 256
+
+	// eventBuffer is the number of events waiting to be written to a stream, above which the stream is closed
+	eventBuffer = 64
+)
+
+// event is a server-sent event for some users. Identifiers grow from 1 in the life of the process.
+type event struct {
+	id    int64
+	name  string
+	data  []byte
+	users []int64
+}
+
+// eventHub delivers the events to the streams of GET /users/me/events, and keeps the last eventHistory of them. A
+// stream that falls behind is closed rather than skipping events: its client reconnects, and gets the events it
+// missed from the history.
+type eventHub struct {
+	mu      sync.Mutex
+	lastID  int64
+	history []event // oldest first
+	streams map[int64]map[chan event]struct{}
+
+	// done is closed by close, to end the streams
+	done   chan struct{}
+	closed bool
+}
+
+func newEventHub() *eventHub {
+	return &eventHub{streams: make(map[int64]map[chan event]struct{}), done: make(chan struct{})}
+}
+
+// subscribe opens a stream of the events of userID. If after is not negative, missed are the events of the history
+// for userID with an identifier greater than after. The channel is nil if the hub is closed.
+func (h *eventHub) subscribe(userID, after int64) (events chan event, missed []event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return nil, nil
+	}
+
+	if after >= 0 {
+		for _, e := range h.history {
+			if e.id > after && e.addressedTo(userID) {
+				missed = append(missed, e)
+			}
+		}
+	}
+	events = make(chan event, eventBuffer)
+	if h.streams[userID] == nil {
+		h.streams[userID] = make(map[chan event]struct{})
+	}
+	h.streams[userID][events] = struct{}{}
+	return events, missed
+}
+
+// unsubscribe removes a stream returned by subscribe.
+func (h *eventHub) unsubscribe(userID int64, events chan event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.streams[userID][events]; ok {
+		h.remove(userID, events)
+	}
+}
+
+// publish sends an event to the streams of the users, and adds it to the history.
+func (h *eventHub) publish(name string, data []byte, users []int64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return
+	}
+
+	h.lastID++
+	e := event{id: h.lastID, name: name, data: data, users: users}
+	if len(h.history) == eventHistory {
+		copy(h.history, h.history[1:])
+		h.history = h.history[:eventHistory-1]
+	}
+	h.history = append(h.history, e)
+
+	for _, userID := range users {
+		for events := range h.streams[userID] {
+			select {
+			case events <- e:
+			default:
+				h.remove(userID, events)
+			}
+		}
+	}
+}
+
+// remove closes a stream and forgets it. The caller holds h.mu.
+func (h *eventHub) remove(userID int64, events chan event) {
+	close(events)
+	delete(h.streams[userID], events)
+	if len(h.streams[userID]) == 0 {
+		delete(h.streams, userID)
+	}
+}
+
+// close ends the streams, and stops accepting new ones.
+func (h *eventHub) close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.closed {
+		h.closed = true
+		close(h.done)
+	}
+}
+
+// addressedTo returns true if the event is for userID
+func (e event) addressedTo(userID int64) bool {
+	for _, id := range e.users {
+		if id == userID {
+			return true
+		}
+	}
+	return false
+}
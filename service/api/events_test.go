@@ -0,0 +1,106 @@
+package api
+
+import (
+	"testing"
+)
+
+// receive returns the events waiting on a stream, and whether it is still open
+func receive(events chan event) (got []int64, open bool) {
+	for {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				return got, false
+			}
+			got = append(got, e.id)
+		default:
+			return got, true
+		}
+	}
+}
+
+func expectEvents(t *testing.T, name string, got []int64, want ...int64) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Errorf("%s: got the events %v, want %v", name, got, want)
+		return
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			t.Errorf("%s: got the events %v, want %v", name, got, want)
+			return
+		}
+	}
+}
+
+func TestEventHub(t *testing.T) {
+	h := newEventHub()
+	alice, _ := h.subscribe(1, -1)
+	bob, _ := h.subscribe(2, -1)
+	bob2, _ := h.subscribe(2, -1)
+
+	h.publish("e", nil, []int64{1, 2})
+	h.publish("e", nil, []int64{2})
+	h.publish("e", nil, []int64{3})
+
+	got, _ := receive(alice)
+	expectEvents(t, "alice", got, 1)
+	for _, stream := range []chan event{bob, bob2} {
+		got, _ = receive(stream)
+		expectEvents(t, "bob", got, 1, 2)
+	}
+
+	// Reconnections get the events after Last-Event-ID, and only theirs
+	h.unsubscribe(2, bob2)
+	if _, open := receive(bob2); open {
+		t.Error("the stream is open after unsubscribe")
+	}
+	_, missed := h.subscribe(2, 1)
+	expectEvents(t, "missed by bob", ids(missed), 2)
+	_, missed = h.subscribe(3, 0)
+	expectEvents(t, "missed by carol", ids(missed), 3)
+	_, missed = h.subscribe(3, -1)
+	expectEvents(t, "without Last-Event-ID", ids(missed))
+
+	h.close()
+	select {
+	case <-h.done:
+	default:
+		t.Error("done is open after close")
+	}
+	if events, _ := h.subscribe(1, -1); events != nil {
+		t.Error("subscribe succeeded after close")
+	}
+}
+
+func TestEventHubSlowStream(t *testing.T) {
+	h := newEventHub()
+	slow, _ := h.subscribe(1, -1)
+	for i := 0; i < eventBuffer+1; i++ {
+		h.publish("e", nil, []int64{1})
+	}
+
+	// The stream is closed rather than losing an event in the middle
+	got, open := receive(slow)
+	if open || len(got) != eventBuffer {
+		t.Errorf("got %d events and open=%v, want %d events and the stream closed", len(got), open, eventBuffer)
+	}
+	h.unsubscribe(1, slow) // no double close
+
+	// The history keeps the last eventHistory events
+	for i := 0; i < eventHistory; i++ {
+		h.publish("e", nil, []int64{1})
+	}
+	_, missed := h.subscribe(1, 0)
+	if len(missed) != eventHistory || missed[0].id != eventBuffer+2 {
+		t.Errorf("got %d events from %d, want %d from %d", len(missed), missed[0].id, eventHistory, eventBuffer+2)
+	}
+}
+
+func ids(events []event) []int64 {
+	var ids []int64
+	for _, e := range events {
+		ids = append(ids, e.id)
+	}
+	return ids
+}
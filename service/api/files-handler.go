@@ -0,0 +1,269 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/val7e/wasaText/service/api/reqcontext"
+	"github.com/val7e/wasaText/service/models"
+)
+
+// FileLimits are the limits of the files sent in the conversations.
+type FileLimits struct {
+	// MaxSize is the maximum size of a file, in bytes (default DefaultMaxFileSize)
+	MaxSize int64
+
+	// AllowedTypes are the MIME types accepted, like "application/pdf", or "audio/*" for all the types of audio
+	// (default DefaultFileTypes). The type of a file is detected from its content, not taken from the client.
+	AllowedTypes []string
+
+	// MaxConcurrent is the maximum number of uploads handled at the same time (default DefaultMaxConcurrentFiles).
+	// Each one is held in memory, so the files take at most MaxSize × MaxConcurrent bytes; the other uploads wait
+	// for their turn, up to the request timeout.
+	MaxConcurrent int
+}
+
+// Defaults of FileLimits
+var (
+	DefaultMaxFileSize        int64 = 25 << 20
+	DefaultMaxConcurrentFiles       = 4
+	DefaultFileTypes                = []string{
+		"application/pdf", "application/zip", "application/x-gzip", "application/ogg", "text/plain", "text/csv",
+		"image/*", "audio/*", "video/*",
+	}
+)
+
+// maxFileNameLength is the maximum length of the name of a file, in bytes
+const maxFileNameLength = 255
+
+// multipartOverhead is the room left in the body of an upload for the multipart headers and boundaries
+const multipartOverhead = 16 << 10
+
+func (l FileLimits) withDefaults() FileLimits {
+	if l.MaxSize <= 0 {
+		l.MaxSize = DefaultMaxFileSize
+	}
+	if len(l.AllowedTypes) == 0 {
+		l.AllowedTypes = DefaultFileTypes
+	}
+	if l.MaxConcurrent <= 0 {
+		l.MaxConcurrent = DefaultMaxConcurrentFiles
+	}
+	return l
+}
+
+// allowed returns true if the MIME type is in the allowlist
+func (l FileLimits) allowed(mimeType string) bool {
+	for _, t := range l.AllowedTypes {
+		t = strings.ToLower(strings.TrimSpace(t))
+		if t == mimeType || (strings.HasSuffix(t, "/*") && strings.HasPrefix(mimeType, strings.TrimSuffix(t, "*"))) {
+			return true
+		}
+	}
+	return false
+}
+
+// sendFile sends a file message in a conversation. The file is the "file" part of a multipart/form-data body. It is
+// read in memory, up to the maximum size, as it is stored in the database as a whole: FileLimits.MaxConcurrent bounds
+// the uploads held at the same time.
+func (rt *_router) sendFile(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	w.Header().Set("Content-Type", "application/json")
+
+	// Get user ID from Authorization header
+	userID, err := rt.getUserFromAuth(r)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("Authorization failed")
+		w.WriteHeader(http.StatusUnauthorized)
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+
+	conversationID, err := strconv.ParseInt(ps.ByName("conversation_id"), 10, 64)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("Invalid conversation ID")
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": "Invalid conversation ID"})
+		return
+	}
+
+	select {
+	case rt.fileUploads <- struct{}{}:
+		defer func() { <-rt.fileUploads }()
+	case <-r.Context().Done():
+		ctx.Logger.WithError(r.Context().Err()).Warning("No room for the upload")
+		w.Header().Set("Retry-After", "1")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": "Too many uploads in progress"})
+		return
+	}
+
+	file, err := readFilePart(w, r, rt.files.MaxSize)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("Invalid file upload")
+		writeBodyError(w, err)
+		return
+	}
+	if !rt.files.allowed(file.MimeType) {
+		ctx.Logger.WithField("mime_type", file.MimeType).Error("File type not allowed")
+		w.WriteHeader(http.StatusUnsupportedMediaType)
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": "File type not allowed: " + file.MimeType})
+		return
+	}
+
+	ctx.Logger.WithField("conversation_id", conversationID).WithField("size", len(file.Data)).
+		WithField("mime_type", file.MimeType).Info("Sending file")
+
+	message, err := rt.db.SendFile(r.Context(), conversationID, userID, file)
+	if err != nil {
+		if err.Error() == "user not participant in conversation" {
+			ctx.Logger.WithError(err).Error("User not participant in conversation")
+			w.WriteHeader(http.StatusForbidden)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": "You are not a participant in this conversation"})
+			return
+		}
+
+		ctx.Logger.WithError(err).Error("Error sending file")
+		w.WriteHeader(http.StatusInternalServerError)
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": "Failed to send file"})
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(message)
+}
+
+// getFile downloads the file of a message, for the participants of the conversation
+func (rt *_router) getFile(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	w.Header().Set("Content-Type", "application/json")
+
+	userID, conversationID, messageID, ok := rt.threadRequest(w, r, ps, ctx)
+	if !ok {
+		return
+	}
+
+	file, data, err := rt.db.GetFile(r.Context(), messageID, conversationID, userID)
+	if err != nil {
+		status, msg := http.StatusInternalServerError, "Failed to retrieve file"
+		switch err.Error() {
+		case "user not participant in conversation":
+			status, msg = http.StatusForbidden, "You are not a participant in this conversation"
+		case "message not found":
+			status, msg = http.StatusNotFound, "Message not found"
+		case "message has no file":
+			status, msg = http.StatusNotFound, "The message has no file"
+		}
+		ctx.Logger.WithError(err).Error(msg)
+		w.WriteHeader(status)
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": msg})
+		return
+	}
+
+	// Always downloaded, never rendered by the browser: the file comes from another user
+	w.Header().Set("Content-Type", file.MimeType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": file.Name}))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Security-Policy", "sandbox")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(data)
+}
+
+// readFilePart reads the "file" part of a multipart/form-data body in memory, up to maxSize bytes. The type of the file
+// is sniffed from its first 512 bytes. The returned error, if any, is a *bodyError.
+func readFilePart(w http.ResponseWriter, r *http.Request, maxSize int64) (models.NewFile, error) {
+	invalid := func(reason string) error {
+		return &bodyError{status: http.StatusBadRequest, Message: "Invalid request body", Reason: reason}
+	}
+	tooLarge := &bodyError{status: http.StatusRequestEntityTooLarge, Message: "File too large",
+		Reason: fmt.Sprintf("the file must be at most %d bytes", maxSize)}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxSize+multipartOverhead)
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return models.NewFile{}, invalid("the body must be multipart/form-data")
+	}
+
+	for {
+		part, err := mr.NextPart()
+		if errors.Is(err, io.EOF) {
+			return models.NewFile{}, &bodyError{status: http.StatusBadRequest, Message: "Invalid request body",
+				Field: "file", Reason: "the file is required"}
+		}
+		if err != nil {
+			if bodyTooLarge(err) {
+				return models.NewFile{}, tooLarge
+			}
+			return models.NewFile{}, invalid("malformed multipart body")
+		}
+		if part.FormName() != "file" {
+			return models.NewFile{}, &bodyError{status: http.StatusBadRequest, Message: "Invalid request body",
+				Field: part.FormName(), Reason: "unknown field"}
+		}
+
+		name := part.FileName()
+		if !validFileName(name) {
+			return models.NewFile{}, &bodyError{status: http.StatusBadRequest, Message: "Invalid request body",
+				Field: "file", Reason: fmt.Sprintf("the file needs a name of at most %d bytes, without control characters",
+					maxFileNameLength)}
+		}
+		data, err := io.ReadAll(io.LimitReader(part, maxSize+1))
+		if err != nil {
+			if bodyTooLarge(err) {
+				return models.NewFile{}, tooLarge
+			}
+			return models.NewFile{}, invalid("malformed multipart body")
+		}
+		if int64(len(data)) > maxSize {
+			return models.NewFile{}, tooLarge
+		}
+		if len(data) == 0 {
+			return models.NewFile{}, &bodyError{status: http.StatusBadRequest, Message: "Invalid request body",
+				Field: "file", Reason: "the file is empty"}
+		}
+		head := data
+		if len(head) > 512 {
+			head = head[:512]
+		}
+		return models.NewFile{Name: name, MimeType: detectFileType(name, head), Data: data}, nil
+	}
+}
+
+func validFileName(name string) bool {
+	if name == "" || len(name) > maxFileNameLength || !utf8.ValidString(name) || name == "." || name == ".." {
+		return false
+	}
+	for _, r := range name {
+		if unicode.IsControl(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// detectFileType returns the MIME type of a file, sniffed from the start of its content; the extension of the name refines it
+// only when the content says no more than "binary data" or "text"
+func detectFileType(name string, data []byte) string {
+	sniffed, _, _ := mime.ParseMediaType(http.DetectContentType(data))
+	if sniffed != "application/octet-stream" && sniffed != "text/plain" {
+		return sniffed
+	}
+	byName, _, err := mime.ParseMediaType(mime.TypeByExtension(strings.ToLower(filepath.Ext(name))))
+	if err != nil || byName == "" {
+		return sniffed
+	}
+	// Text can't become binary, and the other way around
+	if (sniffed == "text/plain") != strings.HasPrefix(byName, "text/") {
+		return sniffed
+	}
+	return byName
+}
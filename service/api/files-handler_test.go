@@ -0,0 +1,111 @@
+package api_test
+
+import (
+	"context"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/val7e/wasaText/service/api"
+	"github.com/val7e/wasaText/service/database/memdb"
+)
+
+// TestSendFileConcurrency holds an upload open: with Files.MaxConcurrent 1 the next one waits for it until the request
+// timeout, then gets 503; once it is over, the uploads go through again
+func TestSendFileConcurrency(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	db := memdb.New(memdb.Config{})
+	router, err := api.New(api.Config{
+		Logger:         logger,
+		Database:       db,
+		RequestTimeout: 200 * time.Millisecond,
+		Files:          api.FileLimits{MaxConcurrent: 1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = router.Close() })
+	srv := httptest.NewServer(router.Handler())
+	defer srv.Close()
+
+	alice, _, err := db.DoLogin(context.Background(), "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := db.DoLogin(context.Background(), "bob"); err != nil {
+		t.Fatal(err)
+	}
+	conv, err := db.StartConversation(context.Background(), alice.Id, "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	url := srv.URL + "/conversations/" + strconv.FormatInt(conv.Id, 10) + "/files"
+
+	// upload sends notes.txt, writing the body with write. It returns a zero response if the request failed.
+	upload := func(write func(mw *multipart.Writer, part io.Writer)) http.Response {
+		body, pw := io.Pipe()
+		mw := multipart.NewWriter(pw)
+		go func() {
+			part, _ := mw.CreateFormFile("file", "notes.txt")
+			write(mw, part)
+			_ = pw.Close()
+		}()
+		req, err := http.NewRequest(http.MethodPost, url, body)
+		if err != nil {
+			t.Error(err)
+			return http.Response{}
+		}
+		req.Header.Set("Authorization", "Bearer "+strconv.FormatInt(alice.Id, 10))
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Error(err)
+			return http.Response{}
+		}
+		_, _ = io.Copy(io.Discard, res.Body)
+		_ = res.Body.Close()
+		return *res
+	}
+	complete := func(mw *multipart.Writer, part io.Writer) {
+		_, _ = io.WriteString(part, "hello")
+		_ = mw.Close()
+	}
+
+	// The first upload stops in the middle of the file, until release is closed
+	started, release := make(chan struct{}), make(chan struct{})
+	first := make(chan struct{})
+	go func() {
+		upload(func(mw *multipart.Writer, part io.Writer) {
+			_, _ = io.WriteString(part, "hel")
+			close(started)
+			<-release
+			_, _ = io.WriteString(part, "lo")
+			_ = mw.Close()
+		})
+		close(first)
+	}()
+	<-started
+	time.Sleep(50 * time.Millisecond) // the server reads the start of the body
+
+	start := time.Now()
+	res := upload(complete)
+	if res.StatusCode != http.StatusServiceUnavailable || res.Header.Get("Retry-After") != "1" {
+		t.Errorf("the second upload: got %s, Retry-After %q, want 503", res.Status, res.Header.Get("Retry-After"))
+	}
+	if waited := time.Since(start); waited < 150*time.Millisecond {
+		t.Errorf("the second upload waited %v, want about the request timeout", waited)
+	}
+
+	// The first upload outlived its request timeout too, so it fails storing the file: it frees the slot anyway
+	close(release)
+	<-first
+	if res := upload(complete); res.StatusCode != http.StatusCreated {
+		t.Errorf("the upload after the first one: got %s", res.Status)
+	}
+}
@@ -27,10 +27,9 @@ func (rt *_router) createGroup(w http.ResponseWriter, r *http.Request, ps httpro
 		Name string `json:"name"`
 	}
 
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+	if err := decodeJSONBody(w, r, &req, maxBodySize); err != nil {
 		ctx.Logger.WithError(err).Error("Invalid request body")
-		w.WriteHeader(http.StatusBadRequest)
-		_ = json.NewEncoder(w).Encode(map[string]string{"error": "Invalid request body"})
+		writeBodyError(w, err)
 		return
 	}
 
@@ -43,7 +42,7 @@ func (rt *_router) createGroup(w http.ResponseWriter, r *http.Request, ps httpro
 
 	ctx.Logger.WithField("user_id", userID).WithField("group_name", req.Name).Info("Creating group")
 
-	group, err := rt.db.CreateGroup(userID, req.Name)
+	group, err := rt.db.CreateGroup(r.Context(), userID, req.Name)
 	if err != nil {
 		ctx.Logger.WithError(err).Error("Error creating group")
 		w.WriteHeader(http.StatusInternalServerError)
@@ -78,7 +77,7 @@ func (rt *_router) getGroup(w http.ResponseWriter, r *http.Request, ps httproute
 
 	ctx.Logger.WithField("user_id", userID).WithField("group_id", groupID).Info("Getting group")
 
-	group, err := rt.db.GetGroup(groupID)
+	group, err := rt.db.GetGroup(r.Context(), groupID)
 	if err != nil {
 		if err.Error() == database.ErrGroupNotFound {
 			ctx.Logger.WithError(err).Error("Group not found")
@@ -122,10 +121,9 @@ func (rt *_router) setGroupName(w http.ResponseWriter, r *http.Request, ps httpr
 		Name string `json:"name"`
 	}
 
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+	if err := decodeJSONBody(w, r, &req, maxBodySize); err != nil {
 		ctx.Logger.WithError(err).Error("Invalid request body")
-		w.WriteHeader(http.StatusBadRequest)
-		_ = json.NewEncoder(w).Encode(map[string]string{"error": "Invalid request body"})
+		writeBodyError(w, err)
 		return
 	}
 
@@ -138,7 +136,7 @@ func (rt *_router) setGroupName(w http.ResponseWriter, r *http.Request, ps httpr
 
 	ctx.Logger.WithField("user_id", userID).WithField("group_id", groupID).WithField("new_name", req.Name).Info("Updating group name")
 
-    group, err := rt.db.SetGroupName(groupID, req.Name)
+    group, err := rt.db.SetGroupName(r.Context(), groupID, req.Name)
     if err != nil {
 		if err.Error() == database.ErrGroupNotFound {
 			ctx.Logger.WithError(err).Error("Group not found")
@@ -185,10 +183,9 @@ func (rt *_router) setGroupPhoto(w http.ResponseWriter, r *http.Request, ps http
 		Photo string `json:"photo"`
 	}
 
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+	if err := decodeJSONBody(w, r, &req, maxPhotoBodySize); err != nil {
 		ctx.Logger.WithError(err).Error("Invalid request body")
-		w.WriteHeader(http.StatusBadRequest)
-		_ = json.NewEncoder(w).Encode(map[string]string{"error": "Invalid request body"})
+		writeBodyError(w, err)
 		return
 	}
 
@@ -200,7 +197,7 @@ func (rt *_router) setGroupPhoto(w http.ResponseWriter, r *http.Request, ps http
 	}
 
 	ctx.Logger.WithField("user_id", userID).WithField("group_id", groupID).Info("Updating group photo")
-	group, err := rt.db.SetGroupPhoto(groupID, req.Photo)
+	group, err := rt.db.SetGroupPhoto(r.Context(), groupID, req.Photo)
 	if err != nil {
 		if err.Error() == database.ErrGroupNotFound {
 			ctx.Logger.WithError(err).Error("Group not found")
@@ -251,10 +248,9 @@ func (rt *_router) addToGroup(w http.ResponseWriter, r *http.Request, ps httprou
 		Members []string `json:"members"`
 	}
 
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+	if err := decodeJSONBody(w, r, &req, maxBodySize); err != nil {
 		ctx.Logger.WithError(err).Error("Invalid request body")
-		w.WriteHeader(http.StatusBadRequest)
-		_ = json.NewEncoder(w).Encode(map[string]string{"error": "Invalid request body"})
+		writeBodyError(w, err)
 		return
 	}
 
@@ -267,7 +263,7 @@ func (rt *_router) addToGroup(w http.ResponseWriter, r *http.Request, ps httprou
 
 	ctx.Logger.WithField("user_id", userID).WithField("group_id", groupID).WithField("members", req.Members).Info("Adding members to group")
 
-	group, err := rt.db.AddToGroup(groupID, req.Members)
+	group, err := rt.db.AddToGroup(r.Context(), groupID, req.Members)
 	if err != nil {
 		if err.Error() == database.ErrGroupNotFound {
 			ctx.Logger.WithError(err).Error("Group not found")
@@ -309,7 +305,7 @@ func (rt *_router) leaveGroup(w http.ResponseWriter, r *http.Request, ps httprou
 
 	ctx.Logger.WithField("user_id", userID).WithField("group_id", groupID).Info("User leaving group")
 
-	err = rt.db.LeaveGroup(groupID, userID)
+	err = rt.db.LeaveGroup(r.Context(), groupID, userID)
 	if err != nil {
 		if err.Error() == database.ErrGroupNotFound {
 			ctx.Logger.WithError(err).Error("Group not found")
@@ -0,0 +1,23 @@
+package api
+
+import (
+	"context"
+
+	"github.com/val7e/wasaText/service/linkpreview"
+	"github.com/val7e/wasaText/service/models"
+)
+
+// enqueueLinkPreview schedules the preview of the first link of a new message of the conversation. The preview is not
+// in the reply: once stored, the message with its preview is pushed to the participants.
+func (rt *_router) enqueueLinkPreview(conversationID int64, msg *models.Message) {
+	if rt.linkPreviews == nil || msg.Text == nil || msg.LinkPreview != nil {
+		return
+	}
+	if link := linkpreview.Link(*msg.Text, msg.Entities); link != "" {
+		updated := *msg
+		rt.linkPreviews.Enqueue(msg.Id, link, func(ctx context.Context, preview models.LinkPreview) {
+			updated.LinkPreview = &preview
+			rt.pushMessageUpdate(ctx, conversationID, updated)
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/val7e/wasaText/service/api/reqcontext"
+	"github.com/val7e/wasaText/service/database"
+)
+
+// getMyMentions lists the group messages mentioning the user, the most recent first: `limit` messages (at most
+// database.MentionsPageSize) preceding the message `before`
+func (rt *_router) getMyMentions(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	w.Header().Set("Content-Type", "application/json")
+
+	// Get user ID from Authorization header
+	userID, err := rt.getUserFromAuth(r)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("Authorization failed")
+		w.WriteHeader(http.StatusUnauthorized)
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+
+	// Parse the page, both optional
+	var before int64
+	if s := r.URL.Query().Get("before"); s != "" {
+		before, err = strconv.ParseInt(s, 10, 64)
+		if err != nil || before < 1 {
+			ctx.Logger.WithField("before", s).Error("Invalid before")
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": "Invalid before: must be a message ID"})
+			return
+		}
+	}
+
+	limit := database.MentionsPageSize
+	if s := r.URL.Query().Get("limit"); s != "" {
+		limit, err = strconv.Atoi(s)
+		if err != nil || limit < 1 || limit > database.MentionsPageSize {
+			ctx.Logger.WithField("limit", s).Error("Invalid limit")
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(map[string]string{
+				"error": fmt.Sprintf("Invalid limit: must be between 1 and %d", database.MentionsPageSize),
+			})
+			return
+		}
+	}
+
+	mentions, err := rt.db.GetMyMentions(r.Context(), userID, before, limit)
+	if err != nil {
+		if err.Error() == "mention not found" {
+			ctx.Logger.WithError(err).Error("Mention not found")
+			w.WriteHeader(http.StatusNotFound)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": "Mention not found"})
+			return
+		}
+
+		ctx.Logger.WithError(err).Error("Error fetching mentions")
+		w.WriteHeader(http.StatusInternalServerError)
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": "Failed to retrieve mentions"})
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(mentions)
+}
@@ -2,11 +2,14 @@ package api
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/julienschmidt/httprouter"
 	"github.com/val7e/wasaText/service/api/reqcontext"
+	"github.com/val7e/wasaText/service/database"
 	"github.com/val7e/wasaText/service/models"
 )
 
@@ -38,10 +41,9 @@ func (rt *_router) sendMessage(w http.ResponseWriter, r *http.Request, ps httpro
 		Photo *string `json:"photo,omitempty"`
 	}
 
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+	if err := decodeJSONBody(w, r, &req, maxPhotoBodySize); err != nil {
 		ctx.Logger.WithError(err).Error("Invalid request body")
-		w.WriteHeader(http.StatusBadRequest)
-		_ = json.NewEncoder(w).Encode(map[string]string{"error": "Invalid request body"})
+		writeBodyError(w, err)
 		return
 	}
 
@@ -59,6 +61,14 @@ func (rt *_router) sendMessage(w http.ResponseWriter, r *http.Request, ps httpro
 		return
 	}
 
+	if req.Type == "text" {
+		if err := checkText(*req.Text); err != nil {
+			ctx.Logger.WithError(err).Error("Text too long")
+			writeBodyError(w, err)
+			return
+		}
+	}
+
 	if req.Type == "photo" && (req.Photo == nil || *req.Photo == "") {
 		ctx.Logger.Error("Photo message requires photo content")
 		w.WriteHeader(http.StatusBadRequest)
@@ -74,7 +84,7 @@ func (rt *_router) sendMessage(w http.ResponseWriter, r *http.Request, ps httpro
 		Photo: req.Photo,
 	}
 
-	message, err := rt.db.SendMessage(conversationID, userID, newMsg)
+	message, err := rt.db.SendMessage(r.Context(), conversationID, userID, newMsg)
 	if err != nil {
 		if err.Error() == "user not participant in conversation" {
 			ctx.Logger.WithError(err).Error("User not participant in conversation")
@@ -90,12 +100,23 @@ func (rt *_router) sendMessage(w http.ResponseWriter, r *http.Request, ps httpro
 			return
 		}
 
+		if strings.HasPrefix(err.Error(), "invalid markup: ") {
+			ctx.Logger.WithError(err).Error("Invalid formatting")
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(map[string]string{
+				"error": "Invalid formatting: " + strings.TrimPrefix(err.Error(), "invalid markup: "),
+			})
+			return
+		}
+
 		ctx.Logger.WithError(err).Error("Error sending message")
 		w.WriteHeader(http.StatusInternalServerError)
 		_ = json.NewEncoder(w).Encode(map[string]string{"error": "Failed to send message"})
 		return
 	}
 
+	rt.enqueueLinkPreview(conversationID, message)
+
 	w.WriteHeader(http.StatusCreated)
 	_ = json.NewEncoder(w).Encode(message)
 }
@@ -126,10 +147,9 @@ func (rt *_router) forwardMessage(w http.ResponseWriter, r *http.Request, ps htt
 		RecipientUsername string `json:"recipient_username"`
 	}
 
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+	if err := decodeJSONBody(w, r, &req, maxBodySize); err != nil {
 		ctx.Logger.WithError(err).Error("Invalid request body")
-		w.WriteHeader(http.StatusBadRequest)
-		_ = json.NewEncoder(w).Encode(map[string]string{"error": "Invalid request body"})
+		writeBodyError(w, err)
 		return
 	}
 
@@ -141,7 +161,7 @@ func (rt *_router) forwardMessage(w http.ResponseWriter, r *http.Request, ps htt
 	}
 
 	// Use StartConversation to get or create the conversation with the recipient
-	conversation, err := rt.db.StartConversation(userID, req.RecipientUsername)
+	conversation, err := rt.db.StartConversation(r.Context(), userID, req.RecipientUsername)
 	if err != nil {
 		if err.Error() == "recipient user not found" {
 			ctx.Logger.WithError(err).Error("Recipient user not found")
@@ -157,7 +177,7 @@ func (rt *_router) forwardMessage(w http.ResponseWriter, r *http.Request, ps htt
 
 	ctx.Logger.WithField("message_id", messageID).WithField("recipient_username", req.RecipientUsername).WithField("user_id", userID).Info("Forwarding message")
 
-	forwardedMessage, err := rt.db.ForwardMessage(messageID, conversation.Id, userID)
+	forwardedMessage, err := rt.db.ForwardMessage(r.Context(), messageID, conversation.Id, userID)
 	if err != nil {
 		if err.Error() == "original message not found" {
 			ctx.Logger.WithError(err).Error("Original message not found")
@@ -172,6 +192,8 @@ func (rt *_router) forwardMessage(w http.ResponseWriter, r *http.Request, ps htt
 		return
 	}
 
+	rt.enqueueLinkPreview(conversation.Id, forwardedMessage)
+
 	w.WriteHeader(http.StatusCreated)
 	_ = json.NewEncoder(w).Encode(forwardedMessage)
 }
@@ -207,7 +229,7 @@ func (rt *_router) deleteMessage(w http.ResponseWriter, r *http.Request, ps http
 
 	ctx.Logger.WithField("conversation_id", conversationID).WithField("message_id", messageID).WithField("user_id", userID).Info("Deleting message")
 
-	err = rt.db.DeleteMessage(messageID, conversationID, userID)
+	err = rt.db.DeleteMessage(r.Context(), messageID, conversationID, userID)
 	if err != nil {
 		if err.Error() == "message not found" {
 			ctx.Logger.WithError(err).Error("Message not found")
@@ -273,10 +295,9 @@ func (rt *_router) commentMessage(w http.ResponseWriter, r *http.Request, ps htt
 		Text string `json:"text"`
 	}
 
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+	if err := decodeJSONBody(w, r, &req, maxBodySize); err != nil {
 		ctx.Logger.WithError(err).Error("Invalid request body")
-		w.WriteHeader(http.StatusBadRequest)
-		_ = json.NewEncoder(w).Encode(map[string]string{"error": "Invalid request body"})
+		writeBodyError(w, err)
 		return
 	}
 
@@ -287,13 +308,19 @@ func (rt *_router) commentMessage(w http.ResponseWriter, r *http.Request, ps htt
 		return
 	}
 
+	if err := checkComment(req.Text); err != nil {
+		ctx.Logger.WithError(err).Error("Comment too long")
+		writeBodyError(w, err)
+		return
+	}
+
 	ctx.Logger.WithField("message_id", messageID).WithField("user_id", userID).Info("Adding comment to message")
 
 	newComment := models.NewComment{
 		Text: req.Text,
 	}
 
-	comment, err := rt.db.CommentMessage(messageID, conversationID, userID, newComment)
+	comment, err := rt.db.CommentMessage(r.Context(), messageID, conversationID, userID, newComment)
 	if err != nil {
 		if err.Error() == "message not found" {
 			ctx.Logger.WithError(err).Error("Message not found")
@@ -357,7 +384,7 @@ func (rt *_router) uncommentMessage(w http.ResponseWriter, r *http.Request, ps h
 
 	ctx.Logger.WithField("message_id", messageID).WithField("user_id", userID).Info("Removing comment from message")
 
-	err = rt.db.UncommentMessage(messageID, conversationID, userID)
+	err = rt.db.UncommentMessage(r.Context(), messageID, conversationID, userID)
 	if err != nil {
 		if err.Error() == "message not found" {
 			ctx.Logger.WithError(err).Error("Message not found")
@@ -389,10 +416,27 @@ func (rt *_router) uncommentMessage(w http.ResponseWriter, r *http.Request, ps h
 	w.WriteHeader(http.StatusNoContent)
 }
 
-// getComments retrieves all comments for a specific message
-func (rt *_router) getComments(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+// editComment replaces the text of one of the user's comments
+func (rt *_router) editComment(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
 	w.Header().Set("Content-Type", "application/json")
 
+	// Get user ID from Authorization header
+	userID, err := rt.getUserFromAuth(r)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("Authorization failed")
+		w.WriteHeader(http.StatusUnauthorized)
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+
+	conversationID, err := strconv.ParseInt(ps.ByName("conversation_id"), 10, 64)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("Invalid conversation ID")
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": "Invalid conversation ID"})
+		return
+	}
+
 	messageID, err := strconv.ParseInt(ps.ByName("message_id"), 10, 64)
 	if err != nil {
 		ctx.Logger.WithError(err).Error("Invalid message ID")
@@ -401,13 +445,135 @@ func (rt *_router) getComments(w http.ResponseWriter, r *http.Request, ps httpro
 		return
 	}
 
-	ctx.Logger.WithField("message_id", messageID).Info("Fetching comments")
+	commentID, err := strconv.ParseInt(ps.ByName("comment_id"), 10, 64)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("Invalid comment ID")
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": "Invalid comment ID"})
+		return
+	}
+
+	// Parse request body
+	var req struct {
+		Text string `json:"text"`
+	}
+
+	if err := decodeJSONBody(w, r, &req, maxBodySize); err != nil {
+		ctx.Logger.WithError(err).Error("Invalid request body")
+		writeBodyError(w, err)
+		return
+	}
+
+	if req.Text == "" {
+		ctx.Logger.Error("Comment text is required")
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": "Comment text is required"})
+		return
+	}
+
+	if err := checkComment(req.Text); err != nil {
+		ctx.Logger.WithError(err).Error("Comment too long")
+		writeBodyError(w, err)
+		return
+	}
+
+	ctx.Logger.WithField("comment_id", commentID).WithField("user_id", userID).Info("Editing comment")
 
-	comments, err := rt.db.GetComments(messageID)
+	comment, err := rt.db.EditComment(r.Context(), commentID, messageID, conversationID, userID, models.NewComment{Text: req.Text})
 	if err != nil {
-		ctx.Logger.WithError(err).Error("Error fetching comments")
+		if err.Error() == "message not found" {
+			ctx.Logger.WithError(err).Error("Message not found")
+			w.WriteHeader(http.StatusNotFound)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": "Message not found"})
+			return
+		}
+
+		if err.Error() == "comment not found" {
+			ctx.Logger.WithError(err).Error("Comment not found")
+			w.WriteHeader(http.StatusNotFound)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": "Comment not found"})
+			return
+		}
+
+		if err.Error() == "unauthorized: user is not the author" {
+			ctx.Logger.WithError(err).Error("User is not the author of the comment")
+			w.WriteHeader(http.StatusForbidden)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": "You can only edit your own comments"})
+			return
+		}
+
+		if err.Error() == "user not participant in conversation" {
+			ctx.Logger.WithError(err).Error("User not participant in conversation")
+			w.WriteHeader(http.StatusForbidden)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": "You are not a participant in this conversation"})
+			return
+		}
+
+		if err.Error() == "message does not belong to specified conversation" {
+			ctx.Logger.WithError(err).Error("Message doesn't belong to conversation")
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": "Message does not belong to this conversation"})
+			return
+		}
+
+		ctx.Logger.WithError(err).Error("Error editing comment")
 		w.WriteHeader(http.StatusInternalServerError)
-		_ = json.NewEncoder(w).Encode(map[string]string{"error": "Failed to retrieve comments"})
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": "Failed to edit comment"})
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(comment)
+}
+
+// getComments retrieves a page of the comments of a message: `limit` comments (at most database.CommentsPageSize)
+// following the comment `after`, the oldest first
+func (rt *_router) getComments(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	w.Header().Set("Content-Type", "application/json")
+
+	userID, conversationID, messageID, ok := rt.threadRequest(w, r, ps, ctx)
+	if !ok {
+		return
+	}
+
+	// Parse the page, both optional
+	var after int64
+	var err error
+	if s := r.URL.Query().Get("after"); s != "" {
+		after, err = strconv.ParseInt(s, 10, 64)
+		if err != nil || after < 1 {
+			ctx.Logger.WithField("after", s).Error("Invalid after")
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": "Invalid after: must be a comment ID"})
+			return
+		}
+	}
+
+	limit := database.CommentsPageSize
+	if s := r.URL.Query().Get("limit"); s != "" {
+		limit, err = strconv.Atoi(s)
+		if err != nil || limit < 1 || limit > database.CommentsPageSize {
+			ctx.Logger.WithField("limit", s).Error("Invalid limit")
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(map[string]string{
+				"error": fmt.Sprintf("Invalid limit: must be between 1 and %d", database.CommentsPageSize),
+			})
+			return
+		}
+	}
+
+	ctx.Logger.WithField("message_id", messageID).Info("Fetching comments")
+
+	comments, err := rt.db.GetComments(r.Context(), messageID, conversationID, userID, after, limit)
+	if err != nil {
+		if err.Error() == "comment not found" {
+			ctx.Logger.WithError(err).Error("Comment not found")
+			w.WriteHeader(http.StatusNotFound)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": "Comment not found"})
+			return
+		}
+
+		writeThreadError(w, ctx, err, "Failed to retrieve comments")
 		return
 	}
 
@@ -21,10 +21,9 @@ func (rt *_router) doLogin(w http.ResponseWriter, r *http.Request, ps httprouter
 		Username string `json:"username"`
 	}
 
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+	if err := decodeJSONBody(w, r, &req, maxBodySize); err != nil {
 		ctx.Logger.WithError(err).Error("Invalid request body")
-		w.WriteHeader(http.StatusBadRequest)
-		_ = json.NewEncoder(w).Encode(map[string]string{"error": "Invalid request body"})
+		writeBodyError(w, err)
 		return
 	}
 
@@ -37,7 +36,7 @@ func (rt *_router) doLogin(w http.ResponseWriter, r *http.Request, ps httprouter
 
 	ctx.Logger.WithField("username", req.Username).Info("Login/Registration attempt")
 
-	user, isNewUser, err := rt.db.DoLogin(req.Username)
+	user, isNewUser, err := rt.db.DoLogin(r.Context(), req.Username)
 	if err != nil {
 		if err.Error() == "username must be between 3 and 25 characters" ||
 			err.Error() == "username can only contain letters, numbers, underscores, and hyphens" {
@@ -2,5 +2,7 @@ package api
 
 // Close should close everything opened in the lifecycle of the `_router`; for example, background goroutines.
 func (rt *_router) Close() error {
+	// End the event streams, which would keep the server from shutting down until their request timeout
+	rt.events.close()
 	return nil
 }
@@ -0,0 +1,288 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/val7e/wasaText/service/api/reqcontext"
+	"github.com/val7e/wasaText/service/database"
+	"github.com/val7e/wasaText/service/models"
+)
+
+// replyToMessage adds a reply (text or photo) to the thread of a message
+func (rt *_router) replyToMessage(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	w.Header().Set("Content-Type", "application/json")
+
+	userID, conversationID, messageID, ok := rt.threadRequest(w, r, ps, ctx)
+	if !ok {
+		return
+	}
+
+	// Parse request body
+	var req struct {
+		Type  string  `json:"type"`
+		Text  *string `json:"text,omitempty"`
+		Photo *string `json:"photo,omitempty"`
+	}
+
+	if err := decodeJSONBody(w, r, &req, maxPhotoBodySize); err != nil {
+		ctx.Logger.WithError(err).Error("Invalid request body")
+		writeBodyError(w, err)
+		return
+	}
+
+	if req.Type != "text" && req.Type != "photo" {
+		ctx.Logger.Error("Invalid reply type")
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": "Reply type must be 'text' or 'photo'"})
+		return
+	}
+
+	if req.Type == "text" && (req.Text == nil || *req.Text == "") {
+		ctx.Logger.Error("Text reply requires text content")
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": "Text reply requires text content"})
+		return
+	}
+
+	if req.Type == "text" {
+		if err := checkText(*req.Text); err != nil {
+			ctx.Logger.WithError(err).Error("Text too long")
+			writeBodyError(w, err)
+			return
+		}
+	}
+
+	if req.Type == "photo" && (req.Photo == nil || *req.Photo == "") {
+		ctx.Logger.Error("Photo reply requires photo content")
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": "Photo reply requires photo content (base64 encoded)"})
+		return
+	}
+
+	ctx.Logger.WithField("message_id", messageID).WithField("user_id", userID).WithField("type", req.Type).Info("Replying to message")
+
+	reply, err := rt.db.ReplyToMessage(r.Context(), messageID, conversationID, userID, models.NewMessage{
+		Type:  req.Type,
+		Text:  req.Text,
+		Photo: req.Photo,
+	})
+	if err != nil {
+		if strings.HasPrefix(err.Error(), "invalid base64 photo data") {
+			ctx.Logger.WithError(err).Error("Invalid photo format")
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": "Invalid photo format. Photo must be base64 encoded"})
+			return
+		}
+
+		writeThreadError(w, ctx, err, "Failed to send reply")
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(reply)
+}
+
+// getReplies retrieves a page of the thread of a message: `limit` replies (at most database.RepliesPageSize) following
+// the reply `after`, the oldest first
+func (rt *_router) getReplies(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	w.Header().Set("Content-Type", "application/json")
+
+	userID, conversationID, messageID, ok := rt.threadRequest(w, r, ps, ctx)
+	if !ok {
+		return
+	}
+
+	// Parse the page, both optional
+	var after int64
+	var err error
+	if s := r.URL.Query().Get("after"); s != "" {
+		after, err = strconv.ParseInt(s, 10, 64)
+		if err != nil || after < 1 {
+			ctx.Logger.WithField("after", s).Error("Invalid after")
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": "Invalid after: must be a reply ID"})
+			return
+		}
+	}
+
+	limit := database.RepliesPageSize
+	if s := r.URL.Query().Get("limit"); s != "" {
+		limit, err = strconv.Atoi(s)
+		if err != nil || limit < 1 || limit > database.RepliesPageSize {
+			ctx.Logger.WithField("limit", s).Error("Invalid limit")
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(map[string]string{
+				"error": fmt.Sprintf("Invalid limit: must be between 1 and %d", database.RepliesPageSize),
+			})
+			return
+		}
+	}
+
+	ctx.Logger.WithField("message_id", messageID).Info("Fetching replies")
+
+	replies, err := rt.db.GetReplies(r.Context(), messageID, conversationID, userID, after, limit)
+	if err != nil {
+		writeThreadError(w, ctx, err, "Failed to retrieve replies")
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(replies)
+}
+
+// subscribeThread makes the user follow the thread of a message
+func (rt *_router) subscribeThread(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	w.Header().Set("Content-Type", "application/json")
+
+	userID, conversationID, messageID, ok := rt.threadRequest(w, r, ps, ctx)
+	if !ok {
+		return
+	}
+
+	ctx.Logger.WithField("message_id", messageID).WithField("user_id", userID).Info("Subscribing to thread")
+
+	if err := rt.db.SubscribeThread(r.Context(), messageID, conversationID, userID); err != nil {
+		writeThreadError(w, ctx, err, "Failed to subscribe to thread")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// unsubscribeThread stops following the thread of a message
+func (rt *_router) unsubscribeThread(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	w.Header().Set("Content-Type", "application/json")
+
+	userID, conversationID, messageID, ok := rt.threadRequest(w, r, ps, ctx)
+	if !ok {
+		return
+	}
+
+	ctx.Logger.WithField("message_id", messageID).WithField("user_id", userID).Info("Unsubscribing from thread")
+
+	if err := rt.db.UnsubscribeThread(r.Context(), messageID, conversationID, userID); err != nil {
+		writeThreadError(w, ctx, err, "Failed to unsubscribe from thread")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// markThreadRead marks the replies of a followed thread as read, up to the given reply
+func (rt *_router) markThreadRead(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	w.Header().Set("Content-Type", "application/json")
+
+	userID, conversationID, messageID, ok := rt.threadRequest(w, r, ps, ctx)
+	if !ok {
+		return
+	}
+
+	// Parse request body
+	var req struct {
+		LastRead int64 `json:"last_read"`
+	}
+
+	if err := decodeJSONBody(w, r, &req, maxBodySize); err != nil {
+		ctx.Logger.WithError(err).Error("Invalid request body")
+		writeBodyError(w, err)
+		return
+	}
+
+	if req.LastRead < 1 {
+		ctx.Logger.Error("The last read reply is required")
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": "last_read must be the ID of a reply"})
+		return
+	}
+
+	ctx.Logger.WithField("message_id", messageID).WithField("user_id", userID).Info("Marking thread read")
+
+	if err := rt.db.MarkThreadRead(r.Context(), messageID, conversationID, userID, req.LastRead); err != nil {
+		writeThreadError(w, ctx, err, "Failed to mark thread read")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// getMyThreads lists the threads followed by the user, with their unread replies
+func (rt *_router) getMyThreads(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	w.Header().Set("Content-Type", "application/json")
+
+	// Get user ID from Authorization header
+	userID, err := rt.getUserFromAuth(r)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("Authorization failed")
+		w.WriteHeader(http.StatusUnauthorized)
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+
+	threads, err := rt.db.GetMyThreads(r.Context(), userID)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("Error fetching threads")
+		w.WriteHeader(http.StatusInternalServerError)
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": "Failed to retrieve threads"})
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(threads)
+}
+
+// threadRequest authenticates the user and parses the conversation and message of a thread route. If ok is false, the
+// error reply has been written.
+func (rt *_router) threadRequest(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) (userID, conversationID, messageID int64, ok bool) {
+	// Get user ID from Authorization header
+	userID, err := rt.getUserFromAuth(r)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("Authorization failed")
+		w.WriteHeader(http.StatusUnauthorized)
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return 0, 0, 0, false
+	}
+
+	conversationID, err = strconv.ParseInt(ps.ByName("conversation_id"), 10, 64)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("Invalid conversation ID")
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": "Invalid conversation ID"})
+		return 0, 0, 0, false
+	}
+
+	messageID, err = strconv.ParseInt(ps.ByName("message_id"), 10, 64)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("Invalid message ID")
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": "Invalid message ID"})
+		return 0, 0, 0, false
+	}
+
+	return userID, conversationID, messageID, true
+}
+
+// writeThreadError replies to a failed thread operation; unexpected errors are reported with the message `failure`
+func writeThreadError(w http.ResponseWriter, ctx reqcontext.RequestContext, err error, failure string) {
+	status, msg := http.StatusInternalServerError, failure
+	switch err.Error() {
+	case "message not found":
+		status, msg = http.StatusNotFound, "Message not found"
+	case "reply not found":
+		status, msg = http.StatusNotFound, "Reply not found"
+	case "user not subscribed to thread":
+		status, msg = http.StatusNotFound, "You are not subscribed to this thread"
+	case "user not participant in conversation":
+		status, msg = http.StatusForbidden, "You are not a participant in this conversation"
+	case "message does not belong to specified conversation":
+		status, msg = http.StatusBadRequest, "Message does not belong to this conversation"
+	}
+
+	ctx.Logger.WithError(err).Error(failure)
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]string{"error": msg})
+}
@@ -22,7 +22,7 @@ func (rt *_router) searchUser(w http.ResponseWriter, r *http.Request, ps httprou
 
 	ctx.Logger.WithField("search_query", searchQuery).Info("Searching users")
 
-	users, err := rt.db.SearchUser(searchQuery)
+	users, err := rt.db.SearchUser(r.Context(), searchQuery)
 	if err != nil {
 		ctx.Logger.WithError(err).Error("Error searching users")
 		w.WriteHeader(http.StatusInternalServerError)
@@ -52,10 +52,9 @@ func (rt *_router) setMyUserName(w http.ResponseWriter, r *http.Request, ps http
 		Username string `json:"username"`
 	}
 
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+	if err := decodeJSONBody(w, r, &req, maxBodySize); err != nil {
 		ctx.Logger.WithError(err).Error("Invalid request body")
-		w.WriteHeader(http.StatusBadRequest)
-		_ = json.NewEncoder(w).Encode(map[string]string{"error": "Invalid request body"})
+		writeBodyError(w, err)
 		return
 	}
 
@@ -68,7 +67,7 @@ func (rt *_router) setMyUserName(w http.ResponseWriter, r *http.Request, ps http
 
 	ctx.Logger.WithField("user_id", userID).WithField("new_username", req.Username).Info("Updating username")
 
-	user, err := rt.db.SetMyUserName(userID, req.Username)
+	user, err := rt.db.SetMyUserName(r.Context(), userID, req.Username)
 	if err != nil {
 		if err.Error() == "username already taken" {
 			ctx.Logger.WithError(err).Error("Username already taken")
@@ -113,10 +112,9 @@ func (rt *_router) setMyPhoto(w http.ResponseWriter, r *http.Request, ps httprou
 		Pic string `json:"pic"`
 	}
 
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+	if err := decodeJSONBody(w, r, &req, maxPhotoBodySize); err != nil {
 		ctx.Logger.WithError(err).Error("Invalid request body")
-		w.WriteHeader(http.StatusBadRequest)
-		_ = json.NewEncoder(w).Encode(map[string]string{"error": "Invalid request body"})
+		writeBodyError(w, err)
 		return
 	}
 
@@ -129,7 +127,7 @@ func (rt *_router) setMyPhoto(w http.ResponseWriter, r *http.Request, ps httprou
 
 	ctx.Logger.WithField("user_id", userID).Info("Updating profile picture")
 
-	user, err := rt.db.SetMyPhoto(userID, req.Pic)
+	user, err := rt.db.SetMyPhoto(r.Context(), userID, req.Pic)
 	if err != nil {
 		if err.Error() == "invalid base64 photo data" {
 			ctx.Logger.WithError(err).Error("Invalid photo format")
@@ -0,0 +1,77 @@
+/*
+Package audio reads the voice messages sent by the users: it finds their duration and a waveform summary, drawn by the
+clients in the player, without decoding the whole recording.
+
+Analyze accepts Ogg/Opus files (what browsers record with MediaRecorder) and WAV files. The waveform of WAV files is
+the peak amplitude of the samples; Opus can't be decoded with the standard library, so the waveform of Ogg/Opus files
+follows the size of the packets instead, which grows with the loudness (silence is coded in a few bytes).
+*/
+package audio
+
+import (
+	"bytes"
+	"errors"
+	"time"
+)
+
+// Bars is the number of values of the waveforms.
+const Bars = 64
+
+// MaxLevel is the value of the loudest bar of a waveform, the other bars are relative to it.
+const MaxLevel = 100
+
+// MIME types of the supported formats
+const (
+	MimeOgg = "audio/ogg"
+	MimeWAV = "audio/wav"
+)
+
+// ErrUnsupported is returned for data that is not an audio file of a supported format.
+var ErrUnsupported = errors.New("unsupported audio format")
+
+// Info describes an audio file.
+type Info struct {
+	// MimeType is MimeOgg or MimeWAV
+	MimeType string
+
+	// Duration is the length of the recording
+	Duration time.Duration
+
+	// Waveform has Bars values between 0 and MaxLevel, the level of each part of the recording
+	Waveform []int
+}
+
+// Analyze returns the format, the duration and the waveform of an audio file. Files of other formats are rejected
+// with ErrUnsupported, files of a supported format that can't be read with a descriptive error.
+func Analyze(data []byte) (*Info, error) {
+	switch {
+	case len(data) >= 12 && bytes.Equal(data[:4], []byte("RIFF")) && bytes.Equal(data[8:12], []byte("WAVE")):
+		return analyzeWAV(data)
+	case len(data) >= 4 && bytes.Equal(data[:4], []byte("OggS")):
+		return analyzeOgg(data)
+	}
+	return nil, ErrUnsupported
+}
+
+// duration returns the length of n samples at `rate` samples per second, without overflowing for large n
+func duration(n, rate int64) time.Duration {
+	return time.Duration(n/rate)*time.Second + time.Duration(n%rate)*time.Second/time.Duration(rate)
+}
+
+// waveform scales the levels of the Bars parts of a recording so that the loudest is MaxLevel
+func waveform(levels []float64) []int {
+	var max float64
+	for _, l := range levels {
+		if l > max {
+			max = l
+		}
+	}
+	bars := make([]int, len(levels))
+	if max == 0 {
+		return bars
+	}
+	for i, l := range levels {
+		bars[i] = int(l/max*MaxLevel + 0.5)
+	}
+	return bars
+}
@@ -0,0 +1,177 @@
+package audio_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/val7e/wasaText/service/audio"
+)
+
+// wav returns a mono WAV file of `seconds` of a tone at `rate` Hz, in PCM samples of `bits` bits, silent in the first
+// half and loud in the second
+func wav(rate, seconds, bits int) []byte {
+	n := rate * seconds
+	var samples bytes.Buffer
+	for i := 0; i < n; i++ {
+		amp := 0.0
+		if i >= n/2 {
+			amp = 0.8 * math.Sin(float64(i)*0.7)
+		}
+		switch bits {
+		case 8:
+			samples.WriteByte(byte(128 + amp*127))
+		case 16:
+			_ = binary.Write(&samples, binary.LittleEndian, int16(amp*(1<<15-1)))
+		case 24:
+			v := int32(amp * (1<<23 - 1))
+			samples.Write([]byte{byte(v), byte(v >> 8), byte(v >> 16)})
+		}
+	}
+
+	var b bytes.Buffer
+	b.WriteString("RIFF")
+	_ = binary.Write(&b, binary.LittleEndian, uint32(36+samples.Len()))
+	b.WriteString("WAVEfmt ")
+	for _, v := range []interface{}{
+		uint32(16), uint16(1), uint16(1), uint32(rate), uint32(rate * bits / 8), uint16(bits / 8), uint16(bits),
+	} {
+		_ = binary.Write(&b, binary.LittleEndian, v)
+	}
+	b.WriteString("data")
+	_ = binary.Write(&b, binary.LittleEndian, uint32(samples.Len()))
+	b.Write(samples.Bytes())
+	return b.Bytes()
+}
+
+// page appends an Ogg page of the stream 7 with the packets, ending at the granule position
+func page(b *bytes.Buffer, granule int64, packets ...[]byte) {
+	var lacing, body []byte
+	for _, p := range packets {
+		n := len(p)
+		for ; n >= 255; n -= 255 {
+			lacing = append(lacing, 255)
+		}
+		lacing = append(lacing, byte(n))
+		body = append(body, p...)
+	}
+	b.WriteString("OggS")
+	b.Write([]byte{0, 0})
+	_ = binary.Write(b, binary.LittleEndian, granule)
+	_ = binary.Write(b, binary.LittleEndian, uint32(7))
+	b.Write(make([]byte, 8)) // sequence number and checksum
+	b.WriteByte(byte(len(lacing)))
+	b.Write(lacing)
+	b.Write(body)
+}
+
+// opusHead is the identification header of an Opus stream, with a pre-skip of 312 samples
+var opusHead = []byte("OpusHead\x01\x01\x38\x01\x80\xbb\x00\x00\x00\x00\x00")
+
+// ogg returns an Ogg/Opus file of n packets of 20 ms (CELT, code 0) ending at the granule position
+func ogg(n int, granule int64) []byte {
+	var b bytes.Buffer
+	page(&b, 0, opusHead)
+	page(&b, 0, []byte("OpusTags\x00\x00\x00\x00\x00\x00\x00\x00"))
+	packets := make([][]byte, n)
+	for i := range packets {
+		// TOC byte 0xF8: CELT fullband, 20 ms, one frame; the size follows the loudness
+		packets[i] = append([]byte{0xF8}, bytes.Repeat([]byte{1}, 1+i*10/n)...)
+	}
+	page(&b, granule, packets...)
+	return b.Bytes()
+}
+
+func TestWAV(t *testing.T) {
+	for _, bits := range []int{8, 16, 24} {
+		info, err := audio.Analyze(wav(8000, 3, bits))
+		if err != nil {
+			t.Fatalf("%d bits: %v", bits, err)
+		}
+		if info.MimeType != audio.MimeWAV || info.Duration != 3*time.Second || len(info.Waveform) != audio.Bars {
+			t.Errorf("%d bits: got %+v", bits, info)
+			continue
+		}
+		if info.Waveform[0] != 0 || info.Waveform[audio.Bars-1] != audio.MaxLevel {
+			t.Errorf("%d bits: got the waveform %v, want silence then the loudest level", bits, info.Waveform)
+		}
+	}
+}
+
+func TestOgg(t *testing.T) {
+	// 100 packets of 20 ms: 96000 samples after the pre-skip
+	info, err := audio.Analyze(ogg(100, 312+96000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.MimeType != audio.MimeOgg || info.Duration != 2*time.Second || len(info.Waveform) != audio.Bars {
+		t.Fatalf("got %+v", info)
+	}
+	if info.Waveform[0] >= info.Waveform[audio.Bars-1] || info.Waveform[audio.Bars-1] != audio.MaxLevel {
+		t.Errorf("got the waveform %v, want it to grow with the packets", info.Waveform)
+	}
+
+	// Samples trimmed at the end shorten the recording
+	if info, err = audio.Analyze(ogg(100, 312+95000)); err != nil {
+		t.Fatal(err)
+	}
+	if want := 95000 * time.Second / 48000; info.Duration != want {
+		t.Errorf("with trimmed samples: got %v, want %v", info.Duration, want)
+	}
+}
+
+func TestAnalyzeErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		err  string
+	}{
+		{"unknown format", []byte("ID3 not an mp3"), audio.ErrUnsupported.Error()},
+		{"no fmt chunk", []byte("RIFF\x04\x00\x00\x00WAVEdata\x00\x00\x00\x00"), "invalid WAV file: missing fmt or data chunk"},
+		{"no samples", wav(8000, 0, 16), "invalid WAV file: no samples"},
+		{"truncated Ogg page", []byte("OggS\x00"), "invalid Ogg file: truncated page at offset 0"},
+		{"not Opus", func() []byte {
+			var b bytes.Buffer
+			page(&b, 0, []byte("\x01vorbis"))
+			page(&b, 0, []byte("\x03vorbis"))
+			return b.Bytes()
+		}(), audio.ErrUnsupported.Error()},
+		{"no audio", ogg(0, 0), "invalid Ogg/Opus file: no audio"},
+		{"granule before the pre-skip", ogg(100, 300), "invalid Ogg/Opus file: no audio"},
+
+		// 2^55+480000 samples would overflow the duration in nanoseconds, giving 10s
+		{"forged granule", ogg(100, 1<<55+480000), "invalid Ogg/Opus file: granule position"},
+	}
+	for _, tt := range tests {
+		info, err := audio.Analyze(tt.data)
+		if err == nil || !strings.HasPrefix(err.Error(), tt.err) {
+			t.Errorf("%s: got %+v, %v, want the error %q", tt.name, info, err, tt.err)
+		}
+	}
+}
+
+// FuzzAnalyze checks that any file is rejected or gets a duration and a waveform of Bars levels. The duration of a few
+// samples at a high rate rounds to zero: the API rejects those recordings.
+func FuzzAnalyze(f *testing.F) {
+	f.Add(wav(8000, 1, 16))
+	f.Add(wav(100, 2, 8))
+	f.Add(ogg(10, 312+9600))
+	f.Add(ogg(3, 1<<62))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		info, err := audio.Analyze(data)
+		if err != nil {
+			return
+		}
+		if info.Duration < 0 || len(info.Waveform) != audio.Bars {
+			t.Fatalf("got %+v", info)
+		}
+		for _, l := range info.Waveform {
+			if l < 0 || l > audio.MaxLevel {
+				t.Fatalf("got the waveform %v", info.Waveform)
+			}
+		}
+	})
+}
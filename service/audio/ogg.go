@@ -0,0 +1,174 @@
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// opusRate is the rate of the granule positions of Ogg/Opus streams, whatever the rate of the recording
+const opusRate = 48000
+
+// maxOpusPacket is the longest duration of an Opus packet, 120 ms at 48 kHz (RFC 6716, section 3.2.5)
+const maxOpusPacket = 5760
+
+// oggPacket is a packet of the first logical stream of an Ogg file, with the granule position of the page where it
+// ends (-1 if none)
+type oggPacket struct {
+	data    []byte
+	granule int64
+}
+
+// analyzeOgg reads the first logical stream of an Ogg file, which must be Opus
+func analyzeOgg(data []byte) (*Info, error) {
+	packets, err := oggPackets(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(packets) < 2 || len(packets[0].data) < 19 || !bytes.HasPrefix(packets[0].data, []byte("OpusHead")) {
+		return nil, ErrUnsupported
+	}
+	preSkip := int64(binary.LittleEndian.Uint16(packets[0].data[10:12]))
+
+	// packets[1] has the comments (OpusTags), the audio follows. The duration is the granule position of the last
+	// page (the samples at 48 kHz since the start), less the samples that decoders discard at the start.
+	audio := packets[2:]
+	var last int64 = -1
+	for _, p := range audio {
+		if p.granule >= 0 {
+			last = p.granule
+		}
+	}
+	if len(audio) == 0 || last <= preSkip {
+		return nil, errors.New("invalid Ogg/Opus file: no audio")
+	}
+
+	// The level of each bar is the bitrate of its packets: a packet is placed in time by the samples of the previous
+	// ones, and adds its size over its duration to the bars it covers
+	durations := make([]int64, len(audio))
+	var total int64
+	for i, p := range audio {
+		durations[i] = opusSamples(p.data)
+		total += durations[i]
+	}
+	if total == 0 {
+		return nil, errors.New("invalid Ogg/Opus file: no audio")
+	}
+	// The granule position counts the samples of the packets, less the ones trimmed at the end: a larger one is
+	// forged, and would give any duration
+	if last > total+maxOpusPacket {
+		return nil, fmt.Errorf("invalid Ogg/Opus file: granule position %d past the %d samples of the packets", last, total)
+	}
+	levels := make([]float64, Bars)
+	weights := make([]float64, Bars)
+	var start int64
+	for i, p := range audio {
+		if durations[i] == 0 {
+			continue
+		}
+		rate := float64(len(p.data)) / float64(durations[i])
+		first, lastBar := int(start*Bars/total), int((start+durations[i]-1)*Bars/total)
+		for b := first; b <= lastBar; b++ {
+			levels[b] += rate * float64(durations[i])
+			weights[b] += float64(durations[i])
+		}
+		start += durations[i]
+	}
+	for b := range levels {
+		if weights[b] > 0 {
+			levels[b] /= weights[b]
+		}
+	}
+
+	return &Info{
+		MimeType: MimeOgg,
+		Duration: duration(last-preSkip, opusRate),
+		Waveform: waveform(levels),
+	}, nil
+}
+
+// oggPackets splits the pages of an Ogg file into the packets of its first logical stream. The checksums of the pages
+// are not verified.
+func oggPackets(data []byte) ([]oggPacket, error) {
+	var packets []oggPacket
+	var serial uint32
+	var partial []byte
+	for pos, page := 0, 0; pos < len(data); page++ {
+		// Header: "OggS", version, flags, granule position, serial number, sequence number, checksum, segment count,
+		// then the segment table (the lacing values) and the segments
+		if len(data)-pos < 27 {
+			return nil, fmt.Errorf("invalid Ogg file: truncated page at offset %d", pos)
+		}
+		if !bytes.Equal(data[pos:pos+4], []byte("OggS")) {
+			return nil, fmt.Errorf("invalid Ogg file: bad page at offset %d", pos)
+		}
+		granule := int64(binary.LittleEndian.Uint64(data[pos+6 : pos+14]))
+		pageSerial := binary.LittleEndian.Uint32(data[pos+14 : pos+18])
+		segments := int(data[pos+26])
+		if len(data)-pos < 27+segments {
+			return nil, fmt.Errorf("invalid Ogg file: truncated page at offset %d", pos)
+		}
+		lacing := data[pos+27 : pos+27+segments]
+		body := pos + 27 + segments
+		size := 0
+		for _, l := range lacing {
+			size += int(l)
+		}
+		if len(data)-body < size {
+			return nil, fmt.Errorf("invalid Ogg file: truncated page at offset %d", pos)
+		}
+		if page == 0 {
+			serial = pageSerial
+		}
+
+		if pageSerial == serial {
+			// A lacing value below 255 ends a packet; packets ending with 255 continue on the next page
+			seg := body
+			for _, l := range lacing {
+				partial = append(partial, data[seg:seg+int(l)]...)
+				seg += int(l)
+				if l < 255 {
+					packets = append(packets, oggPacket{data: partial, granule: -1})
+					partial = nil
+				}
+			}
+			// The granule position is the one of the last packet ending in the page
+			if len(packets) > 0 && granule != -1 {
+				packets[len(packets)-1].granule = granule
+			}
+		}
+		pos = body + size
+	}
+	return packets, nil
+}
+
+// opusSamples returns the duration of an Opus packet in samples at 48 kHz, from its TOC byte (RFC 6716, section 3.1)
+func opusSamples(packet []byte) int64 {
+	if len(packet) == 0 {
+		return 0
+	}
+	toc := packet[0]
+	config := int(toc >> 3)
+	var frame int64
+	switch {
+	case config < 12: // SILK: 10, 20, 40 or 60 ms
+		frame = []int64{480, 960, 1920, 2880}[config%4]
+	case config < 16: // Hybrid: 10 or 20 ms
+		frame = []int64{480, 960}[config%2]
+	default: // CELT: 2.5, 5, 10 or 20 ms
+		frame = []int64{120, 240, 480, 960}[config%4]
+	}
+	switch toc & 3 {
+	case 0:
+		return frame
+	case 1, 2:
+		return 2 * frame
+	default:
+		// The frame count of code 3 packets is in the second byte; packets longer than 120 ms are invalid
+		if len(packet) < 2 || int64(packet[1]&0x3F)*frame > maxOpusPacket {
+			return 0
+		}
+		return int64(packet[1]&0x3F) * frame
+	}
+}
@@ -0,0 +1,117 @@
+package audio
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// WAV format codes, in the fmt chunk
+const (
+	wavPCM        = 1
+	wavFloat      = 3
+	wavExtensible = 0xFFFE
+)
+
+// analyzeWAV reads a RIFF/WAVE file of integer (8 to 32 bits) or float (32 bits) samples
+func analyzeWAV(data []byte) (*Info, error) {
+	var format, channels, bits int
+	var sampleRate int
+	var samples []byte
+	haveFmt := false
+
+	// The chunks follow "RIFF", the size and "WAVE"; each is an ID, a size and the content, padded to an even size
+	for pos := 12; pos+8 <= len(data); {
+		id := string(data[pos : pos+4])
+		size := int64(binary.LittleEndian.Uint32(data[pos+4 : pos+8]))
+		pos += 8
+		// Recorders that can't seek write a placeholder size for the data: it is the rest of the file
+		if remaining := int64(len(data) - pos); size > remaining {
+			size = remaining
+		}
+		chunk := data[pos : pos+int(size)]
+
+		switch id {
+		case "fmt ":
+			if len(chunk) < 16 {
+				return nil, errors.New("invalid WAV file: short fmt chunk")
+			}
+			format = int(binary.LittleEndian.Uint16(chunk[0:2]))
+			channels = int(binary.LittleEndian.Uint16(chunk[2:4]))
+			sampleRate = int(binary.LittleEndian.Uint32(chunk[4:8]))
+			bits = int(binary.LittleEndian.Uint16(chunk[14:16]))
+			if format == wavExtensible && len(chunk) >= 26 {
+				format = int(binary.LittleEndian.Uint16(chunk[24:26]))
+			}
+			haveFmt = true
+		case "data":
+			samples = chunk
+		}
+		if samples != nil {
+			break
+		}
+		pos += int(size) + int(size&1)
+	}
+
+	if !haveFmt || samples == nil {
+		return nil, errors.New("invalid WAV file: missing fmt or data chunk")
+	}
+	if channels <= 0 || sampleRate <= 0 {
+		return nil, fmt.Errorf("invalid WAV file: %d channels at %d Hz", channels, sampleRate)
+	}
+	sample, ok := wavSampleReader(format, bits)
+	if !ok {
+		return nil, fmt.Errorf("unsupported WAV encoding: format %d, %d bits", format, bits)
+	}
+
+	frameSize := channels * bits / 8
+	frames := len(samples) / frameSize
+	if frames == 0 {
+		return nil, errors.New("invalid WAV file: no samples")
+	}
+
+	// The level of each bar is the peak of its samples
+	levels := make([]float64, Bars)
+	for f := 0; f < frames; f++ {
+		bar := f * Bars / frames
+		for c := 0; c < channels; c++ {
+			off := f*frameSize + c*bits/8
+			if v := math.Abs(sample(samples[off:])); v > levels[bar] {
+				levels[bar] = v
+			}
+		}
+	}
+
+	return &Info{
+		MimeType: MimeWAV,
+		Duration: duration(int64(frames), int64(sampleRate)),
+		Waveform: waveform(levels),
+	}, nil
+}
+
+// wavSampleReader returns the function reading a sample of the encoding, between -1 and 1
+func wavSampleReader(format, bits int) (func([]byte) float64, bool) {
+	switch {
+	case format == wavPCM && bits == 8:
+		// 8 bits samples are unsigned
+		return func(b []byte) float64 { return (float64(b[0]) - 128) / 128 }, true
+	case format == wavPCM && bits == 16:
+		return func(b []byte) float64 { return float64(int16(binary.LittleEndian.Uint16(b))) / (1 << 15) }, true
+	case format == wavPCM && bits == 24:
+		return func(b []byte) float64 {
+			return float64(int32(uint32(b[0])<<8|uint32(b[1])<<16|uint32(b[2])<<24)>>8) / (1 << 23)
+		}, true
+	case format == wavPCM && bits == 32:
+		return func(b []byte) float64 { return float64(int32(binary.LittleEndian.Uint32(b))) / (1 << 31) }, true
+	case format == wavFloat && bits == 32:
+		return func(b []byte) float64 {
+			v := float64(math.Float32frombits(binary.LittleEndian.Uint32(b)))
+			if math.IsNaN(v) || math.IsInf(v, 0) {
+				return 0
+			}
+			return v
+		}, true
+	}
+	return nil, false
+}
@@ -0,0 +1,155 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/val7e/wasaText/service/models"
+)
+
+// audioJoin adds the columns of audioColumns to the messages `m` of a query
+const audioJoin = `LEFT JOIN audio a ON a.message_id = m.id`
+
+// audioColumns are scanned in the fields of an audioScan, in order
+const audioColumns = `a.mime_type, a.size, a.duration_ms, a.waveform`
+
+// audioPreview is the SQL expression of the preview of the voice message `pa` (the alias of the audio table), like
+// "Voice message (0:12)": the seconds are rounded up, so that short messages don't show 0:00. SQLite gives || a
+// higher precedence than the arithmetic operators, hence the parentheses.
+const audioPreview = `'Voice message (' || (((pa.duration_ms + 999) / 1000) / 60) || ':' ||
+	CASE WHEN ((pa.duration_ms + 999) / 1000) % 60 < 10 THEN '0' ELSE '' END ||
+	(((pa.duration_ms + 999) / 1000) % 60) || ')'`
+
+// audioScan holds the audio columns of a message, NULL if it is not a voice message
+type audioScan struct {
+	mimeType         sql.NullString
+	size, durationMs sql.NullInt64
+	waveform         []byte
+}
+
+func (s *audioScan) audio() *models.Audio {
+	if !s.mimeType.Valid {
+		return nil
+	}
+	return &models.Audio{
+		MimeType:   s.mimeType.String,
+		Size:       s.size.Int64,
+		DurationMs: s.durationMs.Int64,
+		Waveform:   decodeWaveform(s.waveform),
+	}
+}
+
+// encodeWaveform stores the waveform bars (0 to 100) as a byte each
+func encodeWaveform(bars []int) []byte {
+	b := make([]byte, len(bars))
+	for i, v := range bars {
+		switch {
+		case v < 0:
+			v = 0
+		case v > 255:
+			v = 255
+		}
+		b[i] = byte(v)
+	}
+	return b
+}
+
+func decodeWaveform(b []byte) []int {
+	bars := make([]int, len(b))
+	for i, v := range b {
+		bars[i] = int(v)
+	}
+	return bars
+}
+
+// SendAudio sends a voice message in a conversation. The metadata of the recording are stored as given.
+func (db *appdbimpl) SendAudio(ctx context.Context, conversationID, senderID int64, audio models.NewAudio) (*models.Message, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	var messageID int64
+	err := db.withTx(ctx, func(tx queryer) error {
+		var participantCount int
+		err := tx.QueryRowContext(ctx, `
+			SELECT COUNT(*) FROM conversation_participants
+			WHERE conversation_id = ? AND user_id = ?
+		`, conversationID, senderID).Scan(&participantCount)
+		if err != nil || participantCount == 0 {
+			return fmt.Errorf("user not participant in conversation")
+		}
+
+		err = tx.QueryRowContext(ctx, `
+			INSERT INTO messages (conversation_id, sender_id, type, timestamp)
+			VALUES (?, ?, 'audio', ?)
+			RETURNING id
+		`, conversationID, senderID, db.now()).Scan(&messageID)
+		if err != nil {
+			return fmt.Errorf("error sending message: %w", err)
+		}
+
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO audio (message_id, mime_type, size, duration_ms, waveform, data) VALUES (?, ?, ?, ?, ?, ?)
+		`, messageID, audio.MimeType, len(audio.Data), audio.Duration.Milliseconds(), encodeWaveform(audio.Waveform),
+			audio.Data)
+		if err != nil {
+			return fmt.Errorf("error storing audio: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return db.getMessageByID(ctx, messageID)
+}
+
+// GetAudio returns the recording of the voice message messageID of a conversation of the user, and its content.
+func (db *appdbimpl) GetAudio(ctx context.Context, messageID, conversationID, userID int64) (*models.Audio, []byte, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	var participantCount int
+	err := db.c.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM conversation_participants
+		WHERE conversation_id = ? AND user_id = ?
+	`, conversationID, userID).Scan(&participantCount)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error checking participant: %w", err)
+	}
+	if participantCount == 0 {
+		return nil, nil, fmt.Errorf("user not participant in conversation")
+	}
+
+	var audio audioScan
+	var data []byte
+	err = db.c.QueryRowContext(ctx, `
+		SELECT `+audioColumns+`, a.data
+		FROM messages m
+		`+audioJoin+`
+		WHERE m.id = ? AND m.conversation_id = ?
+	`, messageID, conversationID).Scan(&audio.mimeType, &audio.size, &audio.durationMs, &audio.waveform, &data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil, fmt.Errorf("message not found")
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("error getting audio: %w", err)
+	}
+	if !audio.mimeType.Valid {
+		return nil, nil, fmt.Errorf("message has no audio")
+	}
+	return audio.audio(), data, nil
+}
+
+// copyAudio gives the recording of a message to its forwarded copy
+func copyAudio(ctx context.Context, tx queryer, messageID, copyID int64) error {
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO audio (message_id, mime_type, size, duration_ms, waveform, data)
+		SELECT ?, mime_type, size, duration_ms, waveform, data FROM audio WHERE message_id = ?
+	`, copyID, messageID)
+	if err != nil {
+		return fmt.Errorf("error copying audio: %w", err)
+	}
+	return nil
+}
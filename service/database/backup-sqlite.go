@@ -0,0 +1,188 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// BackupSQLite writes a consistent snapshot of the SQLite database `src` to the file `path`, using the SQLite online
+// backup API: the database stays usable by other connections while the copy runs. The snapshot is written to a
+// temporary file and renamed to `path` only when complete, so `path` never contains a partial copy.
+func BackupSQLite(ctx context.Context, src *sql.DB, path string) error {
+	tmp := path + ".tmp"
+	_ = os.Remove(tmp)
+
+	dst, err := sql.Open("sqlite3", tmp)
+	if err != nil {
+		return fmt.Errorf("error opening backup file: %w", err)
+	}
+	defer func() { _ = dst.Close() }()
+
+	dstConn, err := dst.Conn(ctx)
+	if err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("error connecting to the backup file: %w", err)
+	}
+	err = copySQLite(ctx, dstConn, src)
+	_ = dstConn.Close()
+	if err != nil {
+		_ = dst.Close()
+		_ = os.Remove(tmp)
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("error closing backup file: %w", err)
+	}
+	return os.Rename(tmp, path)
+}
+
+// RestoreSQLite replaces the content of the SQLite database `dst` with the backup in the file `path`, and returns the
+// schema version of the backup. The backup is checked first: it must pass the SQLite integrity check and carry a
+// schema version between 1 and the latest one known by this build, so that New can bring it up to date. `dst` is
+// not migrated here.
+//
+// The backup is copied into the pages of `dst`, under any other connection to the same file: the server must be
+// stopped. To enforce it, RestoreSQLite takes the exclusive lock of `dst` before the copy, and fails with
+// ErrDatabaseInUse if it can't. In WAL mode (the default of the server), SQLite refuses that lock while another
+// connection has the database open, idle or not; in the other journal modes, only while it is in a transaction.
+func RestoreSQLite(ctx context.Context, dst *sql.DB, path string) (int, error) {
+	if _, err := os.Stat(path); err != nil {
+		return 0, fmt.Errorf("error opening backup: %w", err)
+	}
+	src, err := sql.Open("sqlite3", "file:"+path+"?mode=ro")
+	if err != nil {
+		return 0, fmt.Errorf("error opening backup: %w", err)
+	}
+	defer func() { _ = src.Close() }()
+
+	var integrity string
+	if err := src.QueryRowContext(ctx, "PRAGMA integrity_check").Scan(&integrity); err != nil {
+		return 0, fmt.Errorf("error checking backup integrity: %w", err)
+	}
+	if integrity != "ok" {
+		return 0, fmt.Errorf("backup integrity check failed: %s", integrity)
+	}
+
+	version, err := SchemaVersion(ctx, src)
+	if err != nil {
+		return 0, fmt.Errorf("backup has no schema version, it is not a WASAText database: %w", err)
+	}
+	latest := len(sqliteMigrations)
+	switch {
+	case version < 1:
+		return 0, errors.New("backup has no schema version, it is not a WASAText database")
+	case version > latest:
+		return 0, fmt.Errorf("backup schema version %d is newer than the latest known version %d", version, latest)
+	}
+
+	dstConn, err := dst.Conn(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("error connecting to the database: %w", err)
+	}
+	defer func() { _ = dstConn.Close() }()
+	unlock, err := lockSQLite(ctx, dstConn)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	if err := copySQLite(ctx, dstConn, src); err != nil {
+		return 0, err
+	}
+	return version, nil
+}
+
+// SchemaVersion returns the latest migration applied to the database `db`, as recorded in the schema_migrations table.
+func SchemaVersion(ctx context.Context, db *sql.DB) (int, error) {
+	var version int
+	err := db.QueryRowContext(ctx, "SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&version)
+	if err != nil {
+		return 0, fmt.Errorf("error reading schema version: %w", err)
+	}
+	return version, nil
+}
+
+// ErrDatabaseInUse is returned by RestoreSQLite when another connection uses the database
+var ErrDatabaseInUse = errors.New("the database is in use, stop the server before restoring")
+
+// lockSQLite takes the exclusive lock of the database of conn, and keeps it until unlock is called. Other connections
+// can neither read nor write in between.
+func lockSQLite(ctx context.Context, conn *sql.Conn) (unlock func(), err error) {
+	if _, err := conn.ExecContext(ctx, "PRAGMA locking_mode = EXCLUSIVE"); err != nil {
+		return nil, fmt.Errorf("error locking the database: %w", err)
+	}
+	// In exclusive locking mode the lock taken by a write transaction is kept after the commit
+	unlock = func() {
+		_, _ = conn.ExecContext(context.Background(), "PRAGMA locking_mode = NORMAL")
+		// The lock is released by the next access to the database
+		_, _ = conn.ExecContext(context.Background(), "SELECT 1 FROM sqlite_master LIMIT 1")
+	}
+	if _, err := conn.ExecContext(ctx, "BEGIN EXCLUSIVE; COMMIT"); err != nil {
+		unlock()
+		var sqliteErr sqlite3.Error
+		if errors.As(err, &sqliteErr) && (sqliteErr.Code == sqlite3.ErrBusy || sqliteErr.Code == sqlite3.ErrLocked) {
+			return nil, ErrDatabaseInUse
+		}
+		return nil, fmt.Errorf("error locking the database: %w", err)
+	}
+	return unlock, nil
+}
+
+// copySQLite copies the main database of `src` over the main database of `dstConn` with the online backup API.
+func copySQLite(ctx context.Context, dstConn *sql.Conn, src *sql.DB) error {
+	srcConn, err := src.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("error connecting to the source database: %w", err)
+	}
+	defer func() { _ = srcConn.Close() }()
+
+	return dstConn.Raw(func(dstDriverConn interface{}) error {
+		return srcConn.Raw(func(srcDriverConn interface{}) error {
+			d, ok := dstDriverConn.(*sqlite3.SQLiteConn)
+			if !ok {
+				return errors.New("destination is not a SQLite database")
+			}
+			s, ok := srcDriverConn.(*sqlite3.SQLiteConn)
+			if !ok {
+				return errors.New("source is not a SQLite database")
+			}
+
+			b, err := d.Backup("main", s, "main")
+			if err != nil {
+				return fmt.Errorf("error starting backup: %w", err)
+			}
+			// All pages are copied in one step, within a single read transaction on the source. An incremental copy
+			// restarts whenever another connection writes between two steps, and might never finish under load; in WAL
+			// mode the read transaction does not block writers anyway.
+			for {
+				done, err := b.Step(-1)
+				if err != nil {
+					_ = b.Finish()
+					return fmt.Errorf("error copying database: %w", err)
+				}
+				if done {
+					break
+				}
+
+				// The source or destination is locked, retry
+				select {
+				case <-ctx.Done():
+					_ = b.Finish()
+					return ctx.Err()
+				case <-time.After(10 * time.Millisecond):
+				}
+			}
+			if err := b.Finish(); err != nil {
+				return fmt.Errorf("error finishing backup: %w", err)
+			}
+			return nil
+		})
+	})
+}
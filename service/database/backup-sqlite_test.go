@@ -0,0 +1,270 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// openSQLite opens the SQLite file `path` like the server does, in WAL mode
+func openSQLite(t *testing.T, path string) *sql.DB {
+	t.Helper()
+	dbconn, err := sql.Open("sqlite3", path+"?_journal_mode=WAL&_busy_timeout=1000&_foreign_keys=true&_txlock=immediate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = dbconn.Close() })
+	return dbconn
+}
+
+// newSQLite creates a WASAText database in `path`, with the users in it
+func newSQLite(t *testing.T, path string, users ...string) (*sql.DB, AppDatabase) {
+	t.Helper()
+	dbconn := openSQLite(t, path)
+	db, err := New(dbconn, Config{Driver: "sqlite3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range users {
+		if _, _, err := db.DoLogin(context.Background(), name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dbconn, db
+}
+
+func countUsers(t *testing.T, dbconn *sql.DB) int {
+	t.Helper()
+	var n int
+	if err := dbconn.QueryRow("SELECT COUNT(*) FROM users").Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+// TestBackupSQLiteWhileWriting takes backups of a database while another connection writes to it: every backup is a
+// consistent snapshot, that opens and passes the integrity check.
+func TestBackupSQLiteWhileWriting(t *testing.T) {
+	dir := t.TempDir()
+	dbconn, db := newSQLite(t, filepath.Join(dir, "live.db"))
+
+	ctx := context.Background()
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	var writeErr error
+	go func() {
+		defer wg.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			if _, _, err := db.DoLogin(ctx, fmt.Sprintf("user%d", i)); err != nil {
+				writeErr = err
+				return
+			}
+		}
+	}()
+
+	var backups []string
+	for i := 0; i < 5; i++ {
+		path := filepath.Join(dir, fmt.Sprintf("backup%d.db", i))
+		if err := BackupSQLite(ctx, dbconn, path); err != nil {
+			close(stop)
+			wg.Wait()
+			t.Fatal(err)
+		}
+		backups = append(backups, path)
+	}
+	close(stop)
+	wg.Wait()
+	if writeErr != nil {
+		t.Fatalf("writing during the backups: %v", writeErr)
+	}
+
+	previous := 0
+	for _, path := range backups {
+		snapshot := openSQLite(t, path)
+		var integrity string
+		if err := snapshot.QueryRow("PRAGMA integrity_check").Scan(&integrity); err != nil || integrity != "ok" {
+			t.Fatalf("%s: integrity check: %q, %v", path, integrity, err)
+		}
+		if _, err := New(snapshot, Config{Driver: "sqlite3"}); err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		users := countUsers(t, snapshot)
+		if users < previous {
+			t.Errorf("%s has %d users, the previous backup %d", path, users, previous)
+		}
+		previous = users
+	}
+	if total := countUsers(t, dbconn); previous > total {
+		t.Errorf("the last backup has %d users, the database %d", previous, total)
+	}
+	if _, err := os.Stat(backups[0] + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("the temporary file is left: %v", err)
+	}
+}
+
+// TestRestoreSQLiteRejects checks that the invalid backups are refused before the target database is touched
+func TestRestoreSQLiteRejects(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+	target, _ := newSQLite(t, filepath.Join(dir, "target.db"), "alice")
+
+	// A valid backup with another user, to derive the invalid ones from
+	valid := filepath.Join(dir, "valid.db")
+	source, _ := newSQLite(t, filepath.Join(dir, "source.db"), "bob", "carol")
+	if err := BackupSQLite(ctx, source, valid); err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(valid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	backups := map[string]func(path string) error{
+		"not a database": func(path string) error {
+			return os.WriteFile(path, []byte(strings.Repeat("not a database\n", 512)), 0o600)
+		},
+		"not a WASAText database": func(path string) error {
+			other := openSQLite(t, path)
+			_, err := other.Exec("CREATE TABLE notes (id INTEGER PRIMARY KEY, text TEXT); INSERT INTO notes (text) VALUES ('hi')")
+			if err == nil {
+				err = other.Close()
+			}
+			return err
+		},
+		"corrupt": func(path string) error {
+			// Overwrite the pages after the first one: the header is valid, the b-trees are not
+			corrupt := append([]byte(nil), content...)
+			page := int(corrupt[16])<<8 | int(corrupt[17])
+			for i := page; i < len(corrupt); i++ {
+				corrupt[i] = 0xA5
+			}
+			return os.WriteFile(path, corrupt, 0o600)
+		},
+		"newer": func(path string) error {
+			if err := os.WriteFile(path, content, 0o600); err != nil {
+				return err
+			}
+			newer := openSQLite(t, path)
+			_, err := newer.Exec("INSERT INTO schema_migrations (version) VALUES (?)", len(sqliteMigrations)+1)
+			if err == nil {
+				err = newer.Close()
+			}
+			return err
+		},
+	}
+	for name, write := range backups {
+		path := filepath.Join(dir, strings.ReplaceAll(name, " ", "-")+".db")
+		if err := write(path); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if version, err := RestoreSQLite(ctx, target, path); err == nil {
+			t.Errorf("%s: restored, with version %d", name, version)
+		}
+		var username string
+		if err := target.QueryRow("SELECT username FROM users").Scan(&username); err != nil || username != "alice" {
+			t.Errorf("%s: the target has changed: %q, %v", name, username, err)
+		}
+	}
+
+	version, err := RestoreSQLite(ctx, target, valid)
+	if err != nil || version != len(sqliteMigrations) {
+		t.Fatalf("got version %d, %v", version, err)
+	}
+	if users := countUsers(t, target); users != 2 {
+		t.Errorf("got %d users after the restore, want 2", users)
+	}
+}
+
+// TestRestoreSQLiteMigrates restores a backup with an old schema version, that New brings up to date
+func TestRestoreSQLiteMigrates(t *testing.T) {
+	const oldVersion = 3
+	dir := t.TempDir()
+	ctx := context.Background()
+
+	old := openSQLite(t, filepath.Join(dir, "old.db"))
+	if _, err := old.Exec("CREATE TABLE schema_migrations (version INTEGER PRIMARY KEY, applied_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP)"); err != nil {
+		t.Fatal(err)
+	}
+	for version := 1; version <= oldVersion; version++ {
+		for _, query := range sqliteMigrations[version-1] {
+			if _, err := old.Exec(query); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if _, err := old.Exec("INSERT INTO schema_migrations (version) VALUES (?)", version); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := old.Exec("INSERT INTO users (username, pic) VALUES ('dave', x'')"); err != nil {
+		t.Fatal(err)
+	}
+	backup := filepath.Join(dir, "backup.db")
+	if err := BackupSQLite(ctx, old, backup); err != nil {
+		t.Fatal(err)
+	}
+
+	target, _ := newSQLite(t, filepath.Join(dir, "target.db"), "alice")
+	version, err := RestoreSQLite(ctx, target, backup)
+	if err != nil || version != oldVersion {
+		t.Fatalf("got version %d, %v, want %d", version, err, oldVersion)
+	}
+	db, err := New(target, Config{Driver: "sqlite3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version, err := SchemaVersion(ctx, target); err != nil || version != len(sqliteMigrations) {
+		t.Errorf("got version %d, %v after New, want %d", version, err, len(sqliteMigrations))
+	}
+	user, created, err := db.DoLogin(ctx, "dave")
+	if err != nil || created || user.Username != "dave" {
+		t.Errorf("logging in the restored user: %+v, %v", user, err)
+	}
+}
+
+// TestRestoreSQLiteInUse checks that a database opened by another connection, like the one of a running server, is
+// not restored
+func TestRestoreSQLiteInUse(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+	path := filepath.Join(dir, "target.db")
+	target, _ := newSQLite(t, path, "alice")
+	source, _ := newSQLite(t, filepath.Join(dir, "source.db"), "bob")
+	backup := filepath.Join(dir, "backup.db")
+	if err := BackupSQLite(ctx, source, backup); err != nil {
+		t.Fatal(err)
+	}
+
+	// The server is idle, but has the database open
+	server := openSQLite(t, path)
+	if countUsers(t, server) != 1 {
+		t.Fatal("the server does not see the database")
+	}
+	if _, err := RestoreSQLite(ctx, target, backup); !errors.Is(err, ErrDatabaseInUse) {
+		t.Fatalf("got %v, want %v", err, ErrDatabaseInUse)
+	}
+	if err := server.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Once it is stopped, the restore goes through, and releases the lock
+	if _, err := RestoreSQLite(ctx, target, backup); err != nil {
+		t.Fatal(err)
+	}
+	restarted := openSQLite(t, path)
+	var username string
+	if err := restarted.QueryRow("SELECT username FROM users").Scan(&username); err != nil || username != "bob" {
+		t.Errorf("after the restore: %q, %v", username, err)
+	}
+}
@@ -0,0 +1,48 @@
+package database_test
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/val7e/wasaText/service/database"
+	"github.com/val7e/wasaText/service/database/databasetest"
+	"github.com/val7e/wasaText/service/database/memdb"
+	"github.com/val7e/wasaText/service/globaltime"
+)
+
+// sqliteDSN are the connection parameters of the webapi executable (see cmd/webapi/open-database.go)
+const sqliteDSN = "?_foreign_keys=true&_journal_mode=WAL&_txlock=immediate&_busy_timeout=5000"
+
+// openSQLite returns a new database on a SQLite file in the temporary directory of the test
+func openSQLite(t testing.TB, clock globaltime.Clock) database.AppDatabase {
+	conn, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "wasatext.db")+sqliteDSN)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	db, err := database.New(conn, database.Config{Clock: clock})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestSQLite(t *testing.T) {
+	databasetest.Run(t, func(t *testing.T, clock globaltime.Clock) database.AppDatabase {
+		return openSQLite(t, clock)
+	})
+}
+
+func TestMemDB(t *testing.T) {
+	databasetest.Run(t, func(t *testing.T, clock globaltime.Clock) database.AppDatabase {
+		return memdb.New(memdb.Config{Clock: clock})
+	})
+}
+
+func TestInstrumented(t *testing.T) {
+	databasetest.Run(t, func(t *testing.T, clock globaltime.Clock) database.AppDatabase {
+		return database.NewInstrumented(memdb.New(memdb.Config{Clock: clock}), database.InstrumentConfig{})
+	})
+}
@@ -1,6 +1,7 @@
 package database
 
 import (
+	"context"
 	"database/sql"
 	"encoding/base64"
 	"errors"
@@ -11,39 +12,43 @@ import (
 )
 
 // GetMyConversations retrieves all conversations for a specific user
-func (db *appdbimpl) GetMyConversations(userID int64) ([]models.ConversationSummary, error) {
+func (db *appdbimpl) GetMyConversations(ctx context.Context, userID int64) ([]models.ConversationSummary, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	// Single statement for the whole list: the last message of each conversation is found through the
+	// (conversation_id, timestamp) index and joined by ID
 	query := `
-		SELECT DISTINCT 
+		SELECT
 			c.id,
 			c.type,
 			c.name,
 			c.convo_pic,
-			(SELECT m.timestamp 
-			 FROM messages m 
-			 WHERE m.conversation_id = c.id 
-			 ORDER BY m.timestamp DESC 
-			 LIMIT 1) as last_message_timestamp,
-			(SELECT COALESCE(m.text, 'Photo')
-			 FROM messages m 
-			 WHERE m.conversation_id = c.id 
-			 ORDER BY m.timestamp DESC 
-			 LIMIT 1) as last_message_preview
-		FROM conversations c
-		INNER JOIN conversation_participants cp ON c.id = cp.conversation_id
+			lm.timestamp AS last_message_timestamp,
+			` + previewOf("lm") + ` AS last_message_preview
+		FROM conversation_participants cp
+		INNER JOIN conversations c ON c.id = cp.conversation_id
+		LEFT JOIN messages lm ON lm.id = (
+			SELECT m.id
+			FROM messages m
+			WHERE m.conversation_id = c.id
+			ORDER BY m.timestamp DESC, m.id DESC
+			LIMIT 1
+		)
 		WHERE cp.user_id = ?
-		ORDER BY last_message_timestamp DESC NULLS LAST
+		ORDER BY last_message_timestamp DESC NULLS LAST, lm.id DESC, c.id
 		LIMIT 1000
 	`
-	rows, err := db.c.Query(query, userID)
+	rows, err := db.c.QueryContext(ctx, query, userID)
 	if err != nil {
 		return nil, fmt.Errorf("error fetching conversations: %w", err)
 	}
 	defer func() { _ = rows.Close() }()
 
-	var conversations []models.ConversationSummary
+	var conversations = []models.ConversationSummary{}
 	for rows.Next() {
 		var conv models.ConversationSummary
-		var lastMsgTimestamp sql.NullString
+		var lastMsgTimestamp sql.NullTime
 		var lastMsgPreview sql.NullString
 
 		err := rows.Scan(
@@ -58,18 +63,10 @@ func (db *appdbimpl) GetMyConversations(userID int64) ([]models.ConversationSumm
 			return nil, fmt.Errorf("error scanning conversation: %w", err)
 		}
 
-		// Get participants
-		participants, err := db.getConversationParticipants(conv.Id)
-		if err != nil {
-			return nil, fmt.Errorf("error getting participants: %w", err)
-		}
-		conv.Participants = participants
-
 		// Set last message if exists
 		if lastMsgTimestamp.Valid && lastMsgPreview.Valid {
-			timestamp, _ := time.Parse(time.RFC3339, lastMsgTimestamp.String)
 			conv.LastMessage = &models.MessagePreview{
-				Timestamp: timestamp,
+				Timestamp: lastMsgTimestamp.Time.UTC(),
 				Preview:   lastMsgPreview.String,
 			}
 		}
@@ -80,16 +77,29 @@ func (db *appdbimpl) GetMyConversations(userID int64) ([]models.ConversationSumm
 	if err = rows.Err(); err != nil {
 		return nil, fmt.Errorf("error iterating conversations: %w", err)
 	}
+	_ = rows.Close()
+
+	// Get participants of all the conversations at once
+	participants, err := db.getUserConversationsParticipants(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("error getting participants: %w", err)
+	}
+	for i := range conversations {
+		conversations[i].Participants = participants[conversations[i].Id]
+	}
 
 	return conversations, nil
 }
 
 // GetConversation retrieves a specific conversation with messages
-func (db *appdbimpl) GetConversation(conversationID, userID int64) (*models.Conversation, error) {
+func (db *appdbimpl) GetConversation(ctx context.Context, conversationID, userID int64) (*models.Conversation, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
 	// Only check participation if userID is provided (not 0)
 	if userID != 0 {
 		var participantCount int
-		err := db.c.QueryRow("SELECT COUNT(*) FROM conversation_participants WHERE conversation_id = ? AND user_id = ?", conversationID, userID).Scan(&participantCount)
+		err := db.c.QueryRowContext(ctx, "SELECT COUNT(*) FROM conversation_participants WHERE conversation_id = ? AND user_id = ?", conversationID, userID).Scan(&participantCount)
 		if err != nil {
 			return nil, fmt.Errorf("error checking participation: %w", err)
 		}
@@ -101,7 +111,7 @@ func (db *appdbimpl) GetConversation(conversationID, userID int64) (*models.Conv
 
 	// Get conversation details
 	var conv models.Conversation
-	err := db.c.QueryRow("SELECT id, name, type, convo_pic FROM conversations WHERE id = ?", conversationID).Scan(&conv.Id, &conv.Name, &conv.Type, &conv.ConvoPic)
+	err := db.c.QueryRowContext(ctx, "SELECT id, name, type, convo_pic FROM conversations WHERE id = ?", conversationID).Scan(&conv.Id, &conv.Name, &conv.Type, &conv.ConvoPic)
 	if errors.Is(err, sql.ErrNoRows) {
 		return nil, fmt.Errorf("conversation not found")
 	}
@@ -110,14 +120,14 @@ func (db *appdbimpl) GetConversation(conversationID, userID int64) (*models.Conv
 	}
 
 	// Get participants
-	participants, err := db.getConversationParticipants(conversationID)
+	participants, err := db.getConversationParticipants(ctx, conversationID)
 	if err != nil {
 		return nil, fmt.Errorf("error getting participants: %w", err)
 	}
 	conv.Participants = participants
 
 	// Get messages
-	messages, err := db.getConversationMessages(conversationID)
+	messages, err := db.getConversationMessages(ctx, conversationID)
 	if err != nil {
 		return nil, fmt.Errorf("error getting messages: %w", err)
 	}
@@ -126,11 +136,55 @@ func (db *appdbimpl) GetConversation(conversationID, userID int64) (*models.Conv
 	return &conv, nil
 }
 
+// GetParticipantIDs returns the identifiers of the participants of a conversation, sorted.
+func (db *appdbimpl) GetParticipantIDs(ctx context.Context, conversationID int64) ([]int64, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := db.c.QueryContext(ctx,
+		"SELECT user_id FROM conversation_participants WHERE conversation_id = ? ORDER BY user_id", conversationID)
+	if err != nil {
+		return nil, fmt.Errorf("error getting participants: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	var ids = []int64{}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("error getting participants: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error getting participants: %w", err)
+	}
+
+	// A group can be left by everyone: only an empty result needs a check of the conversation
+	if len(ids) == 0 {
+		var count int
+		if err := db.c.QueryRowContext(ctx, "SELECT COUNT(*) FROM conversations WHERE id = ?", conversationID).Scan(&count); err != nil {
+			return nil, fmt.Errorf("error getting conversation: %w", err)
+		}
+		if count == 0 {
+			return nil, fmt.Errorf("conversation not found")
+		}
+	}
+	return ids, nil
+}
+
+// errDirectConversationRace is returned inside the StartConversation transaction when another request registered the
+// same direct chat first.
+var errDirectConversationRace = errors.New("direct conversation created concurrently")
+
 // StartConversation creates a new direct conversation
-func (db *appdbimpl) StartConversation(senderID int64, recipientUsername string) (*models.Conversation, error) {
+func (db *appdbimpl) StartConversation(ctx context.Context, senderID int64, recipientUsername string) (*models.Conversation, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
 	// Get recipient user ID
 	var recipientID int64
-	err := db.c.QueryRow("SELECT id FROM users WHERE username = ?", recipientUsername).Scan(&recipientID)
+	err := db.c.QueryRowContext(ctx, "SELECT id FROM users WHERE username = ?", recipientUsername).Scan(&recipientID)
 	if errors.Is(err, sql.ErrNoRows) {
 		return nil, fmt.Errorf("recipient user not found")
 	}
@@ -138,52 +192,80 @@ func (db *appdbimpl) StartConversation(senderID int64, recipientUsername string)
 		return nil, fmt.Errorf("error finding recipient: %w", err)
 	}
 
-	// Check if conversation already exists
-	query := `
-		SELECT c.id
-		FROM conversations c
-		INNER JOIN conversation_participants cp1 ON c.id = cp1.conversation_id
-		INNER JOIN conversation_participants cp2 ON c.id = cp2.conversation_id
-		WHERE c.type = 'user'
-		AND cp1.user_id = ?
-		AND cp2.user_id = ?
-	`
-
-	var existingConvID int64
-	err = db.c.QueryRow(query, senderID, recipientID).Scan(&existingConvID)
-	if err == nil {
-		// Conversation exists, return it
-		return db.GetConversation(existingConvID, senderID)
+	// A direct chat is identified by the ordered pair of its participants
+	userLow, userHigh := senderID, recipientID
+	if userLow > userHigh {
+		userLow, userHigh = userHigh, userLow
 	}
 
-	// Create new conversation
-	result, err := db.c.Exec(
-		"INSERT INTO conversations (type, created_at, updated_at) VALUES ('user', CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)",
-	)
-	if err != nil {
-		return nil, fmt.Errorf("error creating conversation: %w", err)
-	}
+	var convID int64
+	err = db.withTx(ctx, func(tx queryer) error {
+		// Check if conversation already exists
+		err := tx.QueryRowContext(ctx,
+			"SELECT conversation_id FROM direct_conversations WHERE user_low = ? AND user_high = ?",
+			userLow, userHigh,
+		).Scan(&convID)
+		if err == nil {
+			return nil
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("error checking existing conversation: %w", err)
+		}
 
-	convID, err := result.LastInsertId()
-	if err != nil {
-		return nil, fmt.Errorf("error getting conversation ID: %w", err)
-	}
+		// Create new conversation
+		now := db.now()
+		err = tx.QueryRowContext(ctx,
+			"INSERT INTO conversations (type, created_at, updated_at) VALUES ('user', ?, ?) RETURNING id",
+			now, now,
+		).Scan(&convID)
+		if err != nil {
+			return fmt.Errorf("error creating conversation: %w", err)
+		}
 
-	// Add participants
-	_, err = db.c.Exec(
-		"INSERT INTO conversation_participants (conversation_id, user_id) VALUES (?, ?), (?, ?)",
-		convID, senderID, convID, recipientID,
-	)
-	if err != nil {
-		return nil, fmt.Errorf("error adding participants: %w", err)
+		// Claim the pair: the UNIQUE constraint lets only one of two concurrent requests succeed
+		result, err := tx.ExecContext(ctx,
+			"INSERT INTO direct_conversations (conversation_id, user_low, user_high) VALUES (?, ?, ?) ON CONFLICT DO NOTHING",
+			convID, userLow, userHigh,
+		)
+		if err != nil {
+			return fmt.Errorf("error registering direct conversation: %w", err)
+		}
+		if claimed, err := result.RowsAffected(); err != nil {
+			return fmt.Errorf("error registering direct conversation: %w", err)
+		} else if claimed == 0 {
+			return errDirectConversationRace
+		}
+
+		// Add participants
+		_, err = tx.ExecContext(ctx,
+			"INSERT INTO conversation_participants (conversation_id, user_id, joined_at) VALUES (?, ?, ?), (?, ?, ?)",
+			convID, senderID, now, convID, recipientID, now,
+		)
+		if err != nil {
+			return fmt.Errorf("error adding participants: %w", err)
+		}
+		return nil
+	})
+
+	if errors.Is(err, errDirectConversationRace) {
+		// Lost the race: the transaction was rolled back, return the conversation created by the other request
+		err = db.c.QueryRowContext(ctx,
+			"SELECT conversation_id FROM direct_conversations WHERE user_low = ? AND user_high = ?",
+			userLow, userHigh,
+		).Scan(&convID)
+		if err != nil {
+			return nil, fmt.Errorf("error getting existing conversation: %w", err)
+		}
+	} else if err != nil {
+		return nil, err
 	}
 
-	return db.GetConversation(convID, senderID)
+	return db.GetConversation(ctx, convID, senderID)
 }
 
 // Helper function to get conversation participants
-func (db *appdbimpl) getConversationParticipants(conversationID int64) ([]string, error) {
-	rows, err := db.c.Query(`
+func (db *appdbimpl) getConversationParticipants(ctx context.Context, conversationID int64) ([]string, error) {
+	rows, err := db.c.QueryContext(ctx, `
 		SELECT u.username
 		FROM users u
 		INNER JOIN conversation_participants cp ON u.id = cp.user_id
@@ -210,9 +292,51 @@ func (db *appdbimpl) getConversationParticipants(conversationID int64) ([]string
 	return participants, nil
 }
 
+// Helper function to get the participants of every conversation of a user, keyed by conversation ID
+func (db *appdbimpl) getUserConversationsParticipants(ctx context.Context, userID int64) (map[int64][]string, error) {
+	rows, err := db.c.QueryContext(ctx, `
+		SELECT cp.conversation_id, u.username
+		FROM conversation_participants me
+		INNER JOIN conversation_participants cp ON cp.conversation_id = me.conversation_id
+		INNER JOIN users u ON u.id = cp.user_id
+		WHERE me.user_id = ?
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	participants := make(map[int64][]string)
+	for rows.Next() {
+		var conversationID int64
+		var username string
+		if err := rows.Scan(&conversationID, &username); err != nil {
+			return nil, err
+		}
+		participants[conversationID] = append(participants[conversationID], username)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return participants, nil
+}
+
 // Helper function to get conversation messages
-func (db *appdbimpl) getConversationMessages(conversationID int64) ([]models.Message, error) {
-	rows, err := db.c.Query(`
+func (db *appdbimpl) getConversationMessages(ctx context.Context, conversationID int64) ([]models.Message, error) {
+	// Get comment authors of all the messages at once. This runs before the messages query: a nested query issued while
+	// rows are open needs a second connection, and can wait forever once the pool is exhausted.
+	commentAuthors, err := db.getConversationCommentAuthors(ctx, conversationID)
+	if err != nil {
+		return nil, err
+	}
+	entities, err := db.getEntities(ctx, "m.conversation_id = ?", conversationID)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.c.QueryContext(ctx, `
 		SELECT 
 			m.id, 
 			u.username as sender_username,
@@ -220,23 +344,38 @@ func (db *appdbimpl) getConversationMessages(conversationID int64) ([]models.Mes
 			m.text, 
 			m.photo,
 			m.timestamp,
-			(SELECT COUNT(*) FROM comments c WHERE c.message_id = m.id) as comments_count
+			(SELECT COUNT(*) FROM comments c WHERE c.message_id = m.id) as comments_count,
+			(SELECT COUNT(*) FROM replies r WHERE r.message_id = m.id) as thread_reply_count,
+			lr.timestamp,
+			COALESCE(lr.text, 'Photo'),
+			`+linkPreviewColumns+`,
+			`+fileColumns+`,
+			`+audioColumns+`
 		FROM messages m
 		INNER JOIN users u ON m.sender_id = u.id
+		`+lastReplyJoin+`
+		`+linkPreviewJoin+`
+		`+fileJoin+`
+		`+audioJoin+`
 		WHERE m.conversation_id = ?
-		ORDER BY m.timestamp
+		ORDER BY m.timestamp, m.id
 	`, conversationID)
 	if err != nil {
 		return nil, err
 	}
 	defer func() { _ = rows.Close() }()
 
-	var messages []models.Message
+	var messages = []models.Message{}
 	for rows.Next() {
 		var msg models.Message
 		var text sql.NullString
 		var photoBytes []byte
 		var timestamp time.Time
+		var lastReplyTimestamp sql.NullTime
+		var lastReplyPreview string
+		var linkPreview linkPreviewScan
+		var file fileScan
+		var audio audioScan
 
 		err := rows.Scan(
 			&msg.Id,
@@ -246,12 +385,22 @@ func (db *appdbimpl) getConversationMessages(conversationID int64) ([]models.Mes
 			&photoBytes,
 			&timestamp,
 			&msg.CommentsCount,
+			&msg.ThreadReplyCount,
+			&lastReplyTimestamp,
+			&lastReplyPreview,
+			&linkPreview.url, &linkPreview.title, &linkPreview.description, &linkPreview.image,
+			&file.name, &file.mimeType, &file.size,
+			&audio.mimeType, &audio.size, &audio.durationMs, &audio.waveform,
 		)
 		if err != nil {
 			return nil, err
 		}
 
 		msg.Timestamp = timestamp
+		msg.LastReply = lastReply(lastReplyTimestamp, lastReplyPreview)
+		msg.LinkPreview = linkPreview.preview()
+		msg.File = file.file()
+		msg.Audio = audio.audio()
 
 		// Handle text
 		if text.Valid {
@@ -264,11 +413,12 @@ func (db *appdbimpl) getConversationMessages(conversationID int64) ([]models.Mes
 			msg.Photo = &photoBase64
 		}
 
-		// Get comment authors
-		commentAuthors, err := db.getMessageCommentAuthors(msg.Id)
-		if err == nil {
-			msg.CommentsAuthors = commentAuthors
+		// Set comment authors
+		msg.CommentsAuthors = commentAuthors[msg.Id]
+		if msg.CommentsAuthors == nil {
+			msg.CommentsAuthors = []string{}
 		}
+		msg.Entities = entities[msg.Id]
 
 		messages = append(messages, msg)
 	}
@@ -280,27 +430,30 @@ func (db *appdbimpl) getConversationMessages(conversationID int64) ([]models.Mes
 	return messages, nil
 }
 
-// Helper to get comment authors for a message
-func (db *appdbimpl) getMessageCommentAuthors(messageID int64) ([]string, error) {
-	rows, err := db.c.Query(`
-		SELECT DISTINCT u.username
+// Helper to get the comment authors of every message in a conversation, keyed by message ID
+func (db *appdbimpl) getConversationCommentAuthors(ctx context.Context, conversationID int64) (map[int64][]string, error) {
+	rows, err := db.c.QueryContext(ctx, `
+		SELECT c.message_id, u.username
 		FROM comments c
+		INNER JOIN messages m ON c.message_id = m.id
 		INNER JOIN users u ON c.user_id = u.id
-		WHERE c.message_id = ?
-		LIMIT 1000
-	`, messageID)
+		WHERE m.conversation_id = ?
+		GROUP BY c.message_id, u.username
+		ORDER BY c.message_id, MIN(c.id)
+	`, conversationID)
 	if err != nil {
 		return nil, err
 	}
 	defer func() { _ = rows.Close() }()
 
-	var authors = []string{}
+	authors := make(map[int64][]string)
 	for rows.Next() {
+		var messageID int64
 		var username string
-		if err := rows.Scan(&username); err != nil {
+		if err := rows.Scan(&messageID, &username); err != nil {
 			return nil, err
 		}
-		authors = append(authors, username)
+		authors[messageID] = append(authors[messageID], username)
 	}
 
 	return authors, rows.Err()
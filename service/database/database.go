@@ -26,172 +26,186 @@ This is an example on how to migrate the DB and connect to it:
 		_ = db.Close()
 	}()
 
-Then you can initialize the AppDatabase and pass it to the api package.
+Then you can initialize the AppDatabase and pass it to the api package. New applies the migrations of the SQL dialect
+matching the driver: SQLite ("sqlite3") and PostgreSQL ("pgx" or "postgres") are supported.
+
+	appdb, err := database.New(db, database.Config{Driver: "sqlite3"})
 */
 package database
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
+	"time"
 
+	"github.com/val7e/wasaText/service/globaltime"
 	"github.com/val7e/wasaText/service/models"
 )
 
-// AppDatabase is the high level interface for the DB
+// AppDatabase is the high level interface for the DB. Every method accepts the context of the caller (usually the
+// HTTP request context): when it is cancelled, or when the per-call timeout configured in Config expires, the
+// running queries are interrupted and the method returns the context error.
 type AppDatabase interface {
-	GetName() (string, error)
-	SetName(name string) error
-	Ping() error
+	GetName(ctx context.Context) (string, error)
+	SetName(ctx context.Context, name string) error
+	Ping(ctx context.Context) error
 
 	// User operations defined in users.go
-	DoLogin(username string) (*models.User, bool, error)
-	SearchUser(query string) ([]models.User, error)
-	SetMyUserName(userID int64, newUsername string) (*models.User, error)
-	SetMyPhoto(userID int64, newPic string) (*models.User, error)
-	GetUserByID(userID int64) (*models.User, error)
-	GetUserByUsername(username string) (*models.User, error)
+	DoLogin(ctx context.Context, username string) (*models.User, bool, error)
+	SearchUser(ctx context.Context, query string) ([]models.User, error)
+	SetMyUserName(ctx context.Context, userID int64, newUsername string) (*models.User, error)
+	SetMyPhoto(ctx context.Context, userID int64, newPic string) (*models.User, error)
+	GetUserByID(ctx context.Context, userID int64) (*models.User, error)
+	GetUserByUsername(ctx context.Context, username string) (*models.User, error)
 
 	// Conversation operations defined in conversations.go
-	GetMyConversations(userID int64) ([]models.ConversationSummary, error)
-	GetConversation(conversationID int64, userID int64) (*models.Conversation, error)
-	StartConversation(senderID int64, recipientUsername string) (*models.Conversation, error)
+	GetMyConversations(ctx context.Context, userID int64) ([]models.ConversationSummary, error)
+	GetConversation(ctx context.Context, conversationID int64, userID int64) (*models.Conversation, error)
+	StartConversation(ctx context.Context, senderID int64, recipientUsername string) (*models.Conversation, error)
+	GetParticipantIDs(ctx context.Context, conversationID int64) ([]int64, error)
 
 	// Group operations defined in groups.go
-	CreateGroup(creatorID int64, name string) (*models.Group, error)
-	GetGroup(groupID int64) (*models.Group, error)
-	SetGroupName(groupID int64, name string) (*models.Group, error)
-	SetGroupPhoto(groupID int64, photo string) (*models.Group, error)
-	AddToGroup(groupID int64, memberUsernames []string) (*models.Group, error)
-	LeaveGroup(groupID int64, userID int64) error
+	CreateGroup(ctx context.Context, creatorID int64, name string) (*models.Group, error)
+	GetGroup(ctx context.Context, groupID int64) (*models.Group, error)
+	SetGroupName(ctx context.Context, groupID int64, name string) (*models.Group, error)
+	SetGroupPhoto(ctx context.Context, groupID int64, photo string) (*models.Group, error)
+	AddToGroup(ctx context.Context, groupID int64, memberUsernames []string) (*models.Group, error)
+	LeaveGroup(ctx context.Context, groupID int64, userID int64) error
 
 	// Message operations defined in messages.go
-	SendMessage(conversationID int64, senderID int64, message models.NewMessage) (*models.Message, error)
-	ForwardMessage(messageID, recipientConversationID int64, authorID int64) (*models.Message, error)
-	DeleteMessage(messageID, conversationID int64, userID int64) error
+	SendMessage(ctx context.Context, conversationID int64, senderID int64, message models.NewMessage) (*models.Message, error)
+	ForwardMessage(ctx context.Context, messageID, recipientConversationID int64, authorID int64) (*models.Message, error)
+	DeleteMessage(ctx context.Context, messageID, conversationID int64, userID int64) error
 
 	// Comment operations defined in messages.go
-	CommentMessage(messageID, conversationID int64, authorID int64, comment models.NewComment) (*models.Comment, error)
-	UncommentMessage(messageID, conversationID int64, userID int64) error
-	GetComments(messageID int64) ([]models.Comment, error)
+	CommentMessage(ctx context.Context, messageID, conversationID int64, authorID int64, comment models.NewComment) (*models.Comment, error)
+	EditComment(ctx context.Context, commentID, messageID, conversationID int64, userID int64, comment models.NewComment) (*models.Comment, error)
+	UncommentMessage(ctx context.Context, messageID, conversationID int64, userID int64) error
+	GetComments(ctx context.Context, messageID, conversationID int64, userID int64, after int64, limit int) ([]models.Comment, error)
+
+	// Thread operations defined in threads.go
+	ReplyToMessage(ctx context.Context, messageID, conversationID int64, senderID int64, reply models.NewMessage) (*models.Reply, error)
+	GetReplies(ctx context.Context, messageID, conversationID int64, userID int64, after int64, limit int) ([]models.Reply, error)
+	SubscribeThread(ctx context.Context, messageID, conversationID int64, userID int64) error
+	UnsubscribeThread(ctx context.Context, messageID, conversationID int64, userID int64) error
+	MarkThreadRead(ctx context.Context, messageID, conversationID int64, userID int64, replyID int64) error
+	GetMyThreads(ctx context.Context, userID int64) ([]models.ThreadSummary, error)
+
+	// Mention operations defined in mentions.go
+	GetMyMentions(ctx context.Context, userID int64, before int64, limit int) ([]models.Mention, error)
+
+	// Link preview operations defined in link-previews.go
+	SetLinkPreview(ctx context.Context, messageID int64, preview models.LinkPreview) error
+
+	// File operations defined in files.go
+	SendFile(ctx context.Context, conversationID, senderID int64, file models.NewFile) (*models.Message, error)
+	GetFile(ctx context.Context, messageID, conversationID, userID int64) (*models.File, []byte, error)
+
+	// Voice message operations defined in audio.go
+	SendAudio(ctx context.Context, conversationID, senderID int64, audio models.NewAudio) (*models.Message, error)
+	GetAudio(ctx context.Context, messageID, conversationID, userID int64) (*models.Audio, []byte, error)
+}
+
+// Config is used to provide options to the New function.
+type Config struct {
+	// Driver is the name of the database/sql driver used to open the connection: "sqlite3" (the default if empty)
+	// for SQLite, "pgx" or "postgres" for PostgreSQL. It selects the SQL dialect and the migration set.
+	Driver string
+
+	// QueryTimeout is the maximum duration of a single AppDatabase call. Zero means no limit other than the one of the
+	// context passed by the caller.
+	QueryTimeout time.Duration
+
+	// Clock gives the time of the timestamps written by the application: messages, comments, users and conversations
+	// (default: globaltime.System)
+	Clock globaltime.Clock
 }
 
 type appdbimpl struct {
-	c *sql.DB
+	// c runs queries outside transactions, rebinding them for the dialect
+	c rebound
+
+	raw     *sql.DB
+	dialect dialect
+
+	queryTimeout time.Duration
+	clock        globaltime.Clock
 }
 
-// New returns a new instance of AppDatabase based on the SQLite connection `db`.
-// `db` is required - an error will be returned if `db` is `nil`.
-func New(db *sql.DB) (AppDatabase, error) {
+// New returns a new instance of AppDatabase based on the database connection `db`, opened with the driver named in
+// `cfg.Driver`. `db` is required - an error will be returned if `db` is `nil`.
+func New(db *sql.DB, cfg Config) (AppDatabase, error) {
 	if db == nil {
 		return nil, errors.New("database is required when building a AppDatabase")
 	}
 
-	// Create all necessary tables
-	if err := createTables(db); err != nil {
+	d, err := dialectFor(cfg.Driver)
+	if err != nil {
+		return nil, err
+	}
+
+	appdb := &appdbimpl{
+		c:            rebound{q: db, d: d},
+		raw:          db,
+		dialect:      d,
+		queryTimeout: cfg.QueryTimeout,
+		clock:        globaltime.OrSystem(cfg.Clock),
+	}
+
+	// Create or update the database structure
+	if err := appdb.migrate(context.Background()); err != nil {
 		return nil, fmt.Errorf("error creating database structure: %w", err)
 	}
+	if err := appdb.fillThumbnails(context.Background()); err != nil {
+		return nil, fmt.Errorf("error making thumbnails: %w", err)
+	}
 
-	return &appdbimpl{
-		c: db,
-	}, nil
+	return appdb, nil
 }
 
-// Database tables
-func createTables(db *sql.DB) error {
-	tables := []string{
-		// users table
-		`CREATE TABLE IF NOT EXISTS users (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			username TEXT NOT NULL UNIQUE,
-			pic BLOB NOT NULL,
-			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
-		);`,
-
-		// conversations table
-		`CREATE TABLE IF NOT EXISTS conversations (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			name TEXT,
-			type TEXT NOT NULL CHECK (type IN ('user', 'group')),
-			convo_pic BLOB,
-			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
-		);`,
-
-		// conversation_participants table
-		`CREATE TABLE IF NOT EXISTS conversation_participants (
-			conversation_id INTEGER,
-			user_id INTEGER,
-			joined_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-			PRIMARY KEY (conversation_id, user_id),
-			FOREIGN KEY (conversation_id) REFERENCES conversations(id) ON DELETE CASCADE,
-			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
-		);`,
-
-		// groups table
-		`CREATE TABLE IF NOT EXISTS groups (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			name TEXT NOT NULL,
-			conversation_id INTEGER NOT NULL,
-			group_photo BLOB,
-			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-			FOREIGN KEY (conversation_id) REFERENCES conversations(id) ON DELETE CASCADE
-		);`,
-
-		// group_members table
-		`CREATE TABLE IF NOT EXISTS group_members (
-			group_id INTEGER,
-			user_id INTEGER,
-			joined_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-			PRIMARY KEY (group_id, user_id),
-			FOREIGN KEY (group_id) REFERENCES groups(id) ON DELETE CASCADE,
-			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
-		);`,
-
-		// messages table
-		`CREATE TABLE IF NOT EXISTS messages (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			conversation_id INTEGER NOT NULL,
-			sender_id INTEGER NOT NULL,
-			type TEXT NOT NULL CHECK (type IN ('text', 'photo')),
-			text TEXT,
-			photo BLOB,
-			timestamp DATETIME DEFAULT CURRENT_TIMESTAMP,
-			FOREIGN KEY (conversation_id) REFERENCES conversations(id) ON DELETE CASCADE,
-			FOREIGN KEY (sender_id) REFERENCES users(id) ON DELETE CASCADE,
-			CHECK ((type = 'text' AND text IS NOT NULL) OR (type = 'photo' AND photo IS NOT NULL))
-		);`,
-
-		// comments table
-		`CREATE TABLE IF NOT EXISTS comments (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			message_id INTEGER NOT NULL,
-			user_id INTEGER NOT NULL,
-			text TEXT NOT NULL,
-			timestamp DATETIME DEFAULT CURRENT_TIMESTAMP,
-			FOREIGN KEY (message_id) REFERENCES messages(id) ON DELETE CASCADE,
-			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
-		);`,
-
-		// Index for faster comment lookups
-		`CREATE INDEX IF NOT EXISTS idx_comments_message ON comments(message_id);`,
-
-		// Index for faster message lookups
-		`CREATE INDEX IF NOT EXISTS idx_messages_conversation ON messages(conversation_id, timestamp DESC);`,
+func (db *appdbimpl) Ping(ctx context.Context) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	return db.raw.PingContext(ctx)
+}
+
+// now returns the current time of the clock, as stored in the database.
+func (db *appdbimpl) now() interface{} {
+	return db.dialect.timestamp(db.clock.Now())
+}
+
+// withTimeout derives the context for a single AppDatabase call, applying the configured query timeout (if any).
+func (db *appdbimpl) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if db.queryTimeout <= 0 {
+		return context.WithCancel(ctx)
 	}
+	return context.WithTimeout(ctx, db.queryTimeout)
+}
 
-	// Execute creation queries
-	for i, query := range tables {
-		if _, err := db.Exec(query); err != nil {
-			return fmt.Errorf("error creating table/index %d: %w", i+1, err)
+// withTx runs fn inside a transaction. The transaction is committed if fn returns nil, and rolled back otherwise; the
+// error returned by fn is passed to the caller unchanged.
+func (db *appdbimpl) withTx(ctx context.Context, fn func(tx queryer) error) error {
+	tx, err := db.raw.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			_ = tx.Rollback()
+			panic(p)
 		}
+	}()
+
+	if err := fn(rebound{q: tx, d: db.dialect}); err != nil {
+		_ = tx.Rollback()
+		return err
 	}
 
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %w", err)
+	}
 	return nil
 }
-
-func (db *appdbimpl) Ping() error {
-	return db.c.Ping()
-}
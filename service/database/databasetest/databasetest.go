@@ -0,0 +1,1568 @@
+/*
+Package databasetest is the contract of database.AppDatabase, shared by its implementations: Run checks the behaviour
+that the API handlers rely on (results, error messages, ordering and identifiers) against a fresh database for each
+case.
+
+	func TestMemDB(t *testing.T) {
+		databasetest.Run(t, func(t *testing.T, clock globaltime.Clock) database.AppDatabase {
+			return memdb.New(memdb.Config{Clock: clock})
+		})
+	}
+
+The SQL implementation runs it on a new SQLite file for each case, opened as the webapi executable does (foreign keys
+enabled). The database must take its timestamps from the clock given to the Opener: a globaltime.Fake set to Epoch.
+*/
+package databasetest
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"image/png"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/val7e/wasaText/service/database"
+	"github.com/val7e/wasaText/service/globaltime"
+	"github.com/val7e/wasaText/service/models"
+)
+
+// Opener returns a new, empty AppDatabase for the test, with the given clock.
+type Opener func(t *testing.T, clock globaltime.Clock) database.AppDatabase
+
+// Epoch is the initial time of the clock given to the Opener.
+var Epoch = time.Date(2025, time.January, 1, 12, 0, 0, 0, time.UTC)
+
+// pic is a valid base64 image, different from the default picture of new users
+const pic = "R0lGODlhAQABAIAAAAAAAP///yH5BAEAAAAALAAAAAABAAEAAAIBRAA7"
+
+// Run runs the contract tests as subtests of t, each on a database returned by open.
+func Run(t *testing.T, open Opener) {
+	cases := []struct {
+		name string
+		fn   func(t *testing.T, db database.AppDatabase)
+	}{
+		{"Login", testLogin},
+		{"Users", testUsers},
+		{"SearchUser", testSearchUser},
+		{"StartConversation", testStartConversation},
+		{"ConcurrentStart", testConcurrentStart},
+		{"GetMyConversations", testGetMyConversations},
+		{"Groups", testGroups},
+		{"Messages", testMessages},
+		{"Comments", testComments},
+		{"RichText", testRichText},
+		{"LinkPreviews", testLinkPreviews},
+		{"Files", testFiles},
+		{"Audio", testAudio},
+		{"Cancelled", testCancelled},
+	}
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			c.fn(t, open(t, globaltime.NewFake(Epoch)))
+		})
+	}
+	t.Run("Timestamps", func(t *testing.T) {
+		clock := globaltime.NewFake(Epoch)
+		testTimestamps(t, open(t, clock), clock)
+	})
+	t.Run("CommentEdits", func(t *testing.T) {
+		clock := globaltime.NewFake(Epoch)
+		testCommentEdits(t, open(t, clock), clock)
+	})
+	t.Run("Threads", func(t *testing.T) {
+		clock := globaltime.NewFake(Epoch)
+		testThreads(t, open(t, clock), clock)
+	})
+	t.Run("Mentions", func(t *testing.T) {
+		clock := globaltime.NewFake(Epoch)
+		testMentions(t, open(t, clock), clock)
+	})
+}
+
+var ctx = context.Background()
+
+func testLogin(t *testing.T, db database.AppDatabase) {
+	alice, created, err := db.DoLogin(ctx, "alice")
+	if err != nil || !created || alice.Id != 1 || alice.Username != "alice" || alice.Pic == "" {
+		t.Fatalf("registering: got %+v, %v, %v", alice, created, err)
+	}
+	again, created, err := db.DoLogin(ctx, "alice")
+	if err != nil || created || *again != *alice {
+		t.Fatalf("logging in again: got %+v, %v, %v", again, created, err)
+	}
+	if bob := login(t, db, "bob_1"); bob.Id != 2 {
+		t.Errorf("second user: got ID %d, want 2", bob.Id)
+	}
+	if _, _, err := db.DoLogin(ctx, "Alice"); err != nil {
+		t.Errorf("usernames are case-sensitive, got %v", err)
+	}
+
+	expectError(t, "short username", "username must be between 3 and 25 characters", func() error {
+		_, _, err := db.DoLogin(ctx, "al")
+		return err
+	})
+	expectError(t, "long username", "username must be between 3 and 25 characters", func() error {
+		_, _, err := db.DoLogin(ctx, strings.Repeat("a", 26))
+		return err
+	})
+	expectError(t, "invalid characters", "username can only contain letters, numbers, _, and -", func() error {
+		_, _, err := db.DoLogin(ctx, "al ice")
+		return err
+	})
+}
+
+func testUsers(t *testing.T, db database.AppDatabase) {
+	alice := login(t, db, "alice")
+	login(t, db, "bob")
+
+	if u, err := db.GetUserByID(ctx, alice.Id); err != nil || *u != *alice {
+		t.Errorf("GetUserByID: got %+v, %v", u, err)
+	}
+	if u, err := db.GetUserByUsername(ctx, "alice"); err != nil || *u != *alice {
+		t.Errorf("GetUserByUsername: got %+v, %v", u, err)
+	}
+	expectError(t, "GetUserByID", "user not found", func() error {
+		_, err := db.GetUserByID(ctx, 99)
+		return err
+	})
+	expectError(t, "GetUserByUsername", "user not found", func() error {
+		_, err := db.GetUserByUsername(ctx, "nobody")
+		return err
+	})
+
+	u, err := db.SetMyUserName(ctx, alice.Id, "alicia")
+	if err != nil || u.Username != "alicia" || u.Id != alice.Id {
+		t.Fatalf("SetMyUserName: got %+v, %v", u, err)
+	}
+	if _, err := db.GetUserByUsername(ctx, "alice"); err == nil {
+		t.Errorf("the old username is still found")
+	}
+	if u, err := db.SetMyUserName(ctx, alice.Id, "alicia"); err != nil || u.Username != "alicia" {
+		t.Errorf("SetMyUserName to the same name: got %+v, %v", u, err)
+	}
+	expectError(t, "taken username", "username already taken", func() error {
+		_, err := db.SetMyUserName(ctx, alice.Id, "bob")
+		return err
+	})
+	expectError(t, "invalid username", "username must be between 3 and 25 characters", func() error {
+		_, err := db.SetMyUserName(ctx, alice.Id, "x")
+		return err
+	})
+	expectError(t, "unknown user", "user not found", func() error {
+		_, err := db.SetMyUserName(ctx, 99, "someone")
+		return err
+	})
+
+	u, err = db.SetMyPhoto(ctx, alice.Id, pic)
+	if err != nil || u.Pic != pic {
+		t.Fatalf("SetMyPhoto: got %+v, %v", u, err)
+	}
+	expectError(t, "invalid photo", "invalid base64 photo data", func() error {
+		_, err := db.SetMyPhoto(ctx, alice.Id, "not base64!")
+		return err
+	})
+	expectError(t, "photo of unknown user", "user not found", func() error {
+		_, err := db.SetMyPhoto(ctx, 99, pic)
+		return err
+	})
+}
+
+func testSearchUser(t *testing.T, db database.AppDatabase) {
+	for _, name := range []string{"carol", "Bobby", "bob", "alice", "a_b", "axb"} {
+		login(t, db, name)
+	}
+
+	search := func(query string) []string {
+		t.Helper()
+		users, err := db.SearchUser(ctx, query)
+		if err != nil {
+			t.Fatalf("SearchUser(%q): %v", query, err)
+		}
+		if users == nil {
+			t.Fatalf("SearchUser(%q) returned nil, want an empty list", query)
+		}
+		var names []string
+		for _, u := range users {
+			names = append(names, u.Username)
+		}
+		return names
+	}
+
+	expectStrings(t, "case-insensitive", search("BOB"), "Bobby", "bob")
+	expectStrings(t, "substring", search("o"), "Bobby", "bob", "carol")
+	expectStrings(t, "'_' matches any character", search("a_b"), "a_b", "axb")
+	expectStrings(t, "no match", search("zzz"))
+	if len(search("")) != 6 {
+		t.Errorf("an empty query should match every user")
+	}
+}
+
+func testStartConversation(t *testing.T, db database.AppDatabase) {
+	alice, bob, carol := login(t, db, "alice"), login(t, db, "bob"), login(t, db, "carol")
+
+	conv, err := db.StartConversation(ctx, alice.Id, "bob")
+	if err != nil || conv.Id != 1 || conv.Type != "user" || conv.Name != nil || conv.Messages == nil ||
+		len(conv.Messages) != 0 {
+		t.Fatalf("StartConversation: got %+v, %v", conv, err)
+	}
+	expectSet(t, "participants", conv.Participants, "alice", "bob")
+
+	again, err := db.StartConversation(ctx, bob.Id, "alice")
+	if err != nil || again.Id != conv.Id {
+		t.Errorf("the direct conversation should be reused: got %+v, %v", again, err)
+	}
+	expectError(t, "unknown recipient", "recipient user not found", func() error {
+		_, err := db.StartConversation(ctx, alice.Id, "nobody")
+		return err
+	})
+
+	if got, err := db.GetConversation(ctx, conv.Id, 0); err != nil || got.Id != conv.Id {
+		t.Errorf("GetConversation without user: got %+v, %v", got, err)
+	}
+	expectError(t, "not participant", "user not participant in conversation", func() error {
+		_, err := db.GetConversation(ctx, conv.Id, carol.Id)
+		return err
+	})
+	expectError(t, "unknown conversation", "user not participant in conversation", func() error {
+		_, err := db.GetConversation(ctx, 99, alice.Id)
+		return err
+	})
+	expectError(t, "unknown conversation without user", "conversation not found", func() error {
+		_, err := db.GetConversation(ctx, 99, 0)
+		return err
+	})
+}
+
+// testConcurrentStart starts the direct conversation of two users from both sides at once: every call must return the
+// same conversation.
+func testConcurrentStart(t *testing.T, db database.AppDatabase) {
+	const callers = 64
+	alice, bob := login(t, db, "alice"), login(t, db, "bob")
+
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	ids := make([]int64, callers)
+	errs := make([]error, callers)
+	for i := range ids {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			sender, recipient := alice.Id, "bob"
+			if i%2 == 1 {
+				sender, recipient = bob.Id, "alice"
+			}
+			conv, err := db.StartConversation(ctx, sender, recipient)
+			if err == nil {
+				ids[i] = conv.Id
+			}
+			errs[i] = err
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+
+	var want int64
+	for i, err := range errs {
+		switch {
+		case err != nil:
+			t.Errorf("call %d: %v", i, err)
+		case want == 0:
+			want = ids[i]
+		case ids[i] != want:
+			t.Errorf("call %d: got the conversation %d, want %d", i, ids[i], want)
+		}
+	}
+	for _, u := range []*models.User{alice, bob} {
+		if list, err := db.GetMyConversations(ctx, u.Id); err != nil || len(list) != 1 {
+			t.Errorf("conversations of %s: got %d, %v, want 1", u.Username, len(list), err)
+		}
+	}
+}
+
+func testGetMyConversations(t *testing.T, db database.AppDatabase) {
+	alice, bob := login(t, db, "alice"), login(t, db, "bob")
+	login(t, db, "carol")
+	login(t, db, "dave")
+
+	empty, err := db.GetMyConversations(ctx, alice.Id)
+	if err != nil || empty == nil || len(empty) != 0 {
+		t.Fatalf("no conversations: got %v, %v, want an empty list", empty, err)
+	}
+
+	withBob := startConversation(t, db, alice.Id, "bob")
+	withCarol := startConversation(t, db, alice.Id, "carol")
+	withDave := startConversation(t, db, alice.Id, "dave")
+	group := createGroup(t, db, bob.Id, "friends")
+	if _, err := db.AddToGroup(ctx, group.Id, []string{"alice"}); err != nil {
+		t.Fatal(err)
+	}
+
+	sendText(t, db, withCarol, alice.Id, "first")
+	sendPhoto(t, db, withBob, bob.Id)
+	sendText(t, db, group.Id, bob.Id, "latest")
+
+	convs, err := db.GetMyConversations(ctx, alice.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var order []int64
+	for _, c := range convs {
+		order = append(order, c.Id)
+	}
+	// withDave has no messages, so it comes last
+	expectIDs(t, "order", order, group.Id, withBob, withCarol, withDave)
+	if len(convs) != 4 {
+		return
+	}
+	if convs[0].LastMessage == nil || convs[0].LastMessage.Preview != "latest" || convs[0].Type != "group" ||
+		convs[0].Name == nil || *convs[0].Name != "friends" {
+		t.Errorf("group summary: got %+v", convs[0])
+	}
+	if convs[1].LastMessage == nil || convs[1].LastMessage.Preview != "Photo" || convs[1].LastMessage.Timestamp.IsZero() {
+		t.Errorf("the preview of photos should be \"Photo\": got %+v", convs[1].LastMessage)
+	}
+	if convs[3].LastMessage != nil {
+		t.Errorf("conversation without messages: got last message %+v", convs[3].LastMessage)
+	}
+	expectSet(t, "group participants", convs[0].Participants, "alice", "bob")
+	expectSet(t, "direct participants", convs[2].Participants, "alice", "carol")
+}
+
+func testGroups(t *testing.T, db database.AppDatabase) {
+	alice, bob := login(t, db, "alice"), login(t, db, "bob")
+	login(t, db, "aaron") // sorts before the other users, though registered last
+	direct := startConversation(t, db, alice.Id, "bob")
+
+	group, err := db.CreateGroup(ctx, alice.Id, "friends")
+	if err != nil || group.Id != direct+1 || group.Name != "friends" || group.GroupPhoto != nil {
+		t.Fatalf("CreateGroup: got %+v, %v", group, err)
+	}
+	expectStrings(t, "creator", group.Members, "alice")
+
+	group, err = db.AddToGroup(ctx, group.Id, []string{"aaron", "nobody", "bob", "aaron"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectStrings(t, "members, sorted by username", group.Members, "aaron", "alice", "bob")
+
+	if group, err = db.SetGroupName(ctx, group.Id, "pals"); err != nil || group.Name != "pals" {
+		t.Errorf("SetGroupName: got %+v, %v", group, err)
+	}
+	if group, err = db.SetGroupPhoto(ctx, group.Id, pic); err != nil || group.GroupPhoto == nil || *group.GroupPhoto != pic {
+		t.Errorf("SetGroupPhoto: got %+v, %v", group, err)
+	}
+	expectError(t, "invalid group photo", "invalid base64 photo data", func() error {
+		_, err := db.SetGroupPhoto(ctx, group.Id, "not base64!")
+		return err
+	})
+
+	conv, err := db.GetConversation(ctx, group.Id, alice.Id)
+	if err != nil || conv.Type != "group" || conv.Name == nil || *conv.Name != "pals" || conv.ConvoPic == nil ||
+		*conv.ConvoPic != pic {
+		t.Errorf("the group as conversation: got %+v, %v", conv, err)
+	}
+
+	if err := db.LeaveGroup(ctx, group.Id, bob.Id); err != nil {
+		t.Errorf("LeaveGroup: %v", err)
+	}
+	expectError(t, "leaving twice", "user not member of group", func() error {
+		return db.LeaveGroup(ctx, group.Id, bob.Id)
+	})
+	if group, err = db.GetGroup(ctx, group.Id); err != nil {
+		t.Fatal(err)
+	}
+	expectStrings(t, "members after leaving", group.Members, "aaron", "alice")
+
+	aaron := login(t, db, "aaron")
+	ids, err := db.GetParticipantIDs(ctx, group.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectIDs(t, "participant IDs, sorted", ids, alice.Id, aaron.Id)
+	if ids, err = db.GetParticipantIDs(ctx, direct); err != nil {
+		t.Fatal(err)
+	}
+	expectIDs(t, "participant IDs of the direct chat", ids, alice.Id, bob.Id)
+	for _, id := range []int64{alice.Id, aaron.Id} {
+		if err := db.LeaveGroup(ctx, group.Id, id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if ids, err = db.GetParticipantIDs(ctx, group.Id); err != nil || ids == nil || len(ids) != 0 {
+		t.Errorf("participant IDs of a group left by everyone: got %v, %v, want an empty list", ids, err)
+	}
+	expectError(t, "participant IDs of an unknown conversation", "conversation not found", func() error {
+		_, err := db.GetParticipantIDs(ctx, 99)
+		return err
+	})
+
+	for name, fn := range map[string]func() error{
+		"GetGroup":      func() error { _, err := db.GetGroup(ctx, 99); return err },
+		"direct chat":   func() error { _, err := db.GetGroup(ctx, direct); return err },
+		"SetGroupName":  func() error { _, err := db.SetGroupName(ctx, direct, "x"); return err },
+		"SetGroupPhoto": func() error { _, err := db.SetGroupPhoto(ctx, 99, pic); return err },
+		"AddToGroup":    func() error { _, err := db.AddToGroup(ctx, direct, []string{"aaron"}); return err },
+	} {
+		expectError(t, name, database.ErrGroupNotFound, fn)
+	}
+}
+
+func testMessages(t *testing.T, db database.AppDatabase) {
+	alice, bob, carol := login(t, db, "alice"), login(t, db, "bob"), login(t, db, "carol")
+	conv := startConversation(t, db, alice.Id, "bob")
+	other := startConversation(t, db, alice.Id, "carol")
+
+	text := "hello"
+	msg, err := db.SendMessage(ctx, conv, alice.Id, models.NewMessage{Type: "text", Text: &text})
+	if err != nil || msg.Id != 1 || msg.Sender != "alice" || msg.Type != "text" || msg.Text == nil || *msg.Text != text ||
+		msg.Photo != nil || msg.Timestamp.IsZero() || msg.CommentsCount != 0 || msg.CommentsAuthors == nil {
+		t.Fatalf("SendMessage: got %+v, %v", msg, err)
+	}
+	photo := sendPhoto(t, db, conv, bob.Id)
+
+	expectError(t, "not participant", "user not participant in conversation", func() error {
+		_, err := db.SendMessage(ctx, conv, carol.Id, models.NewMessage{Type: "text", Text: &text})
+		return err
+	})
+	expectError(t, "invalid photo", "invalid base64 photo data", func() error {
+		bad := "not base64!"
+		_, err := db.SendMessage(ctx, conv, alice.Id, models.NewMessage{Type: "photo", Photo: &bad})
+		return err
+	})
+	expectError(t, "text message without text", "error sending message", func() error {
+		_, err := db.SendMessage(ctx, conv, alice.Id, models.NewMessage{Type: "text"})
+		return err
+	})
+
+	fwd, err := db.ForwardMessage(ctx, photo, other, alice.Id)
+	if err != nil || fwd.Sender != "alice" || fwd.Type != "photo" || fwd.Photo == nil || *fwd.Photo != pic {
+		t.Errorf("ForwardMessage: got %+v, %v", fwd, err)
+	}
+	expectError(t, "forward to a foreign conversation", "user not participant in recipient conversation", func() error {
+		_, err := db.ForwardMessage(ctx, msg.Id, other, bob.Id)
+		return err
+	})
+	expectError(t, "forward an unknown message", "original message not found", func() error {
+		_, err := db.ForwardMessage(ctx, 99, other, alice.Id)
+		return err
+	})
+
+	got, err := db.GetConversation(ctx, conv, bob.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var order []int64
+	for _, m := range got.Messages {
+		order = append(order, m.Id)
+	}
+	expectIDs(t, "messages, the oldest first", order, msg.Id, photo)
+
+	expectError(t, "delete an unknown message", "message not found", func() error {
+		return db.DeleteMessage(ctx, 99, conv, alice.Id)
+	})
+	expectError(t, "delete in the wrong conversation", "message does not belong to specified conversation", func() error {
+		return db.DeleteMessage(ctx, msg.Id, other, alice.Id)
+	})
+	expectError(t, "delete as another user", "unauthorized: user is not the sender", func() error {
+		return db.DeleteMessage(ctx, msg.Id, conv, bob.Id)
+	})
+	if err := db.DeleteMessage(ctx, msg.Id, conv, alice.Id); err != nil {
+		t.Errorf("DeleteMessage: %v", err)
+	}
+	if got, err = db.GetConversation(ctx, conv, bob.Id); err != nil || len(got.Messages) != 1 {
+		t.Errorf("after DeleteMessage: got %+v, %v", got, err)
+	}
+}
+
+func testComments(t *testing.T, db database.AppDatabase) {
+	alice, bob, carol := login(t, db, "alice"), login(t, db, "bob"), login(t, db, "carol")
+	conv := startConversation(t, db, alice.Id, "bob")
+	other := startConversation(t, db, alice.Id, "carol")
+	msg := sendText(t, db, conv, alice.Id, "hello")
+	otherMsg := sendText(t, db, other, carol.Id, "hi")
+
+	c, err := db.CommentMessage(ctx, msg, conv, bob.Id, models.NewComment{Text: "👍"})
+	if err != nil || c.Id != 1 || c.Author != "bob" || c.Text != "👍" {
+		t.Fatalf("CommentMessage: got %+v, %v", c, err)
+	}
+	comment(t, db, msg, conv, alice.Id, "❤️")
+	comment(t, db, msg, conv, bob.Id, "😂")
+	comment(t, db, msg, conv, alice.Id, "🎉")
+
+	comments, err := db.GetComments(ctx, msg, conv, bob.Id, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var texts []string
+	for _, c := range comments {
+		texts = append(texts, c.Author+":"+c.Text)
+	}
+	expectStrings(t, "comments, the oldest first", texts, "bob:👍", "alice:❤️", "bob:😂", "alice:🎉")
+
+	got, err := db.GetConversation(ctx, conv, alice.Id)
+	if err != nil || len(got.Messages) != 1 {
+		t.Fatalf("GetConversation: got %+v, %v", got, err)
+	}
+	if got.Messages[0].CommentsCount != 4 {
+		t.Errorf("CommentsCount: got %d, want 4", got.Messages[0].CommentsCount)
+	}
+	expectStrings(t, "comment authors, by first comment", got.Messages[0].CommentsAuthors, "bob", "alice")
+
+	expectError(t, "unknown message", "message not found", func() error {
+		_, err := db.CommentMessage(ctx, 99, conv, bob.Id, models.NewComment{Text: "x"})
+		return err
+	})
+	expectError(t, "wrong conversation", "message does not belong to specified conversation", func() error {
+		_, err := db.CommentMessage(ctx, msg, other, bob.Id, models.NewComment{Text: "x"})
+		return err
+	})
+	expectError(t, "not participant", "user not participant in conversation", func() error {
+		_, err := db.CommentMessage(ctx, otherMsg, other, bob.Id, models.NewComment{Text: "x"})
+		return err
+	})
+
+	// Only the participants read the comments, given the conversation of the message
+	expectError(t, "read as a non participant", "user not participant in conversation", func() error {
+		_, err := db.GetComments(ctx, otherMsg, other, bob.Id, 0, 0)
+		return err
+	})
+	expectError(t, "read in the wrong conversation", "message does not belong to specified conversation", func() error {
+		_, err := db.GetComments(ctx, otherMsg, conv, bob.Id, 0, 0)
+		return err
+	})
+	expectError(t, "read an unknown message", "message not found", func() error {
+		_, err := db.GetComments(ctx, 99, conv, bob.Id, 0, 0)
+		return err
+	})
+
+	// Uncommenting removes every comment of the user on the message
+	if err := db.UncommentMessage(ctx, msg, conv, bob.Id); err != nil {
+		t.Fatalf("UncommentMessage: %v", err)
+	}
+	if comments, err = db.GetComments(ctx, msg, conv, alice.Id, 0, 0); err != nil || len(comments) != 2 || comments[0].Author != "alice" {
+		t.Errorf("after UncommentMessage: got %+v, %v", comments, err)
+	}
+	expectError(t, "uncomment twice", "comment not found or user is not the author", func() error {
+		return db.UncommentMessage(ctx, msg, conv, bob.Id)
+	})
+	expectError(t, "uncomment an unknown message", "message not found", func() error {
+		return db.UncommentMessage(ctx, 99, conv, bob.Id)
+	})
+	expectError(t, "uncomment in the wrong conversation", "message does not belong to specified conversation", func() error {
+		return db.UncommentMessage(ctx, msg, other, alice.Id)
+	})
+
+	// Comments are deleted with their message
+	if err := db.DeleteMessage(ctx, msg, conv, alice.Id); err != nil {
+		t.Fatal(err)
+	}
+	expectError(t, "comments of a deleted message", "message not found", func() error {
+		_, err := db.GetComments(ctx, msg, conv, alice.Id, 0, 0)
+		return err
+	})
+}
+
+func testCancelled(t *testing.T, db database.AppDatabase) {
+	alice := login(t, db, "alice")
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+
+	for name, fn := range map[string]func() error{
+		"Ping":               func() error { return db.Ping(cancelled) },
+		"DoLogin":            func() error { _, _, err := db.DoLogin(cancelled, "bob"); return err },
+		"SearchUser":         func() error { _, err := db.SearchUser(cancelled, "a"); return err },
+		"GetMyConversations": func() error { _, err := db.GetMyConversations(cancelled, alice.Id); return err },
+		"CreateGroup":        func() error { _, err := db.CreateGroup(cancelled, alice.Id, "g"); return err },
+	} {
+		if err := fn(); !errors.Is(err, context.Canceled) {
+			t.Errorf("%s with a cancelled context: got %v, want context.Canceled", name, err)
+		}
+	}
+	if _, err := db.GetUserByUsername(ctx, "bob"); err == nil {
+		t.Errorf("DoLogin with a cancelled context registered the user")
+	}
+}
+
+func testTimestamps(t *testing.T, db database.AppDatabase, clock *globaltime.Fake) {
+	// Not in UTC, and with nanoseconds: the database must return the same instant, in UTC
+	clock.Set(time.Date(2025, time.March, 1, 10, 0, 0, 123456789, time.FixedZone("CET", 3600)))
+	alice, bob := login(t, db, "alice"), login(t, db, "bob")
+	login(t, db, "carol")
+	withBob := startConversation(t, db, alice.Id, "bob")
+	withCarol := startConversation(t, db, alice.Id, "carol")
+
+	text := "now"
+	first, err := db.SendMessage(ctx, withBob, alice.Id, models.NewMessage{Type: "text", Text: &text})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectTime(t, "SendMessage", first.Timestamp, clock.Now())
+
+	// The clock goes back: the order follows the timestamps, not the identifiers
+	clock.Advance(-time.Hour)
+	fwd, err := db.ForwardMessage(ctx, first.Id, withCarol, alice.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectTime(t, "ForwardMessage", fwd.Timestamp, clock.Now())
+	earlier := sendText(t, db, withBob, bob.Id, "earlier")
+
+	convs, err := db.GetMyConversations(ctx, alice.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var order []int64
+	for _, c := range convs {
+		order = append(order, c.Id)
+	}
+	expectIDs(t, "conversations, the most recent first", order, withBob, withCarol)
+	if len(convs) == 2 && convs[0].LastMessage != nil && convs[1].LastMessage != nil {
+		expectTime(t, "last message", convs[0].LastMessage.Timestamp, first.Timestamp)
+		expectTime(t, "last message", convs[1].LastMessage.Timestamp, fwd.Timestamp)
+	} else {
+		t.Errorf("GetMyConversations: got %+v", convs)
+	}
+
+	conv, err := db.GetConversation(ctx, withBob, alice.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	order = nil
+	for _, m := range conv.Messages {
+		order = append(order, m.Id)
+	}
+	expectIDs(t, "messages, the oldest first", order, earlier, first.Id)
+	if len(conv.Messages) == 2 {
+		expectTime(t, "GetConversation", conv.Messages[0].Timestamp, clock.Now())
+		expectTime(t, "GetConversation", conv.Messages[1].Timestamp, first.Timestamp)
+	}
+
+	// Comments are sorted by time too
+	clock.Advance(2 * time.Hour)
+	later, err := db.CommentMessage(ctx, first.Id, withBob, bob.Id, models.NewComment{Text: "later"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(-time.Minute)
+	sooner, err := db.CommentMessage(ctx, first.Id, withBob, alice.Id, models.NewComment{Text: "sooner"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectTime(t, "CommentMessage", sooner.Timestamp, clock.Now())
+	comments, err := db.GetComments(ctx, first.Id, withBob, alice.Id, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectIDs(t, "comments, the oldest first", commentIDs(comments), sooner.Id, later.Id)
+	expectTime(t, "GetComments", comments[1].Timestamp, later.Timestamp)
+
+	// The pages follow the same order
+	if comments, err = db.GetComments(ctx, first.Id, withBob, bob.Id, sooner.Id, 0); err != nil {
+		t.Fatal(err)
+	}
+	expectIDs(t, "comments after the oldest", commentIDs(comments), later.Id)
+}
+
+func testCommentEdits(t *testing.T, db database.AppDatabase, clock *globaltime.Fake) {
+	alice, bob, carol := login(t, db, "alice"), login(t, db, "bob"), login(t, db, "carol")
+	conv := startConversation(t, db, alice.Id, "bob")
+	other := startConversation(t, db, alice.Id, "carol")
+	msg := sendText(t, db, conv, alice.Id, "hello")
+	otherMsg := sendText(t, db, other, alice.Id, "hi")
+
+	var ids []int64
+	for i := 0; i < 5; i++ {
+		c, err := db.CommentMessage(ctx, msg, conv, bob.Id, models.NewComment{Text: strings.Repeat("x", i+1)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.EditedAt != nil {
+			t.Errorf("new comment: got edited_at %v, want none", c.EditedAt)
+		}
+		ids = append(ids, c.Id)
+		clock.Advance(time.Second)
+	}
+	carolComment, err := db.CommentMessage(ctx, otherMsg, other, carol.Id, models.NewComment{Text: "other"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Pages
+	page := func(after int64, limit int) []int64 {
+		t.Helper()
+		comments, err := db.GetComments(ctx, msg, conv, alice.Id, after, limit)
+		if err != nil {
+			t.Fatalf("GetComments(after %d, limit %d): %v", after, limit, err)
+		}
+		return commentIDs(comments)
+	}
+	expectIDs(t, "first page", page(0, 2), ids[0], ids[1])
+	expectIDs(t, "second page", page(ids[1], 2), ids[2], ids[3])
+	expectIDs(t, "last page", page(ids[3], 2), ids[4])
+	expectIDs(t, "after the last comment", page(ids[4], 2))
+	expectIDs(t, "no limit", page(ids[0], 0), ids[1:]...)
+	expectIDs(t, "limit above the page size", page(0, database.CommentsPageSize+1), ids...)
+	expectError(t, "after an unknown comment", "comment not found", func() error {
+		_, err := db.GetComments(ctx, msg, conv, alice.Id, 99, 0)
+		return err
+	})
+	expectError(t, "after a comment of another message", "comment not found", func() error {
+		_, err := db.GetComments(ctx, msg, conv, alice.Id, carolComment.Id, 0)
+		return err
+	})
+
+	// Edits keep the position and the timestamp of the comment
+	clock.Advance(time.Hour)
+	edited, err := db.EditComment(ctx, ids[1], msg, conv, bob.Id, models.NewComment{Text: "edited"})
+	if err != nil {
+		t.Fatalf("EditComment: %v", err)
+	}
+	if edited.Id != ids[1] || edited.Author != "bob" || edited.Text != "edited" || edited.EditedAt == nil {
+		t.Fatalf("EditComment: got %+v", edited)
+	}
+	expectTime(t, "edited_at", *edited.EditedAt, clock.Now())
+	expectTime(t, "timestamp of the edited comment", edited.Timestamp, Epoch.Add(time.Second))
+
+	comments, err := db.GetComments(ctx, msg, conv, bob.Id, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectIDs(t, "comments after the edit", commentIDs(comments), ids...)
+	if c := comments[1]; c.Text != "edited" || c.EditedAt == nil || !c.EditedAt.Equal(clock.Now()) {
+		t.Errorf("GetComments after the edit: got %+v", c)
+	}
+	if comments[0].EditedAt != nil {
+		t.Errorf("GetComments: got edited_at %v for a comment never edited", comments[0].EditedAt)
+	}
+
+	expectError(t, "edit an unknown comment", "comment not found", func() error {
+		_, err := db.EditComment(ctx, 99, msg, conv, bob.Id, models.NewComment{Text: "x"})
+		return err
+	})
+	expectError(t, "edit a comment of another message", "comment not found", func() error {
+		_, err := db.EditComment(ctx, carolComment.Id, msg, conv, carol.Id, models.NewComment{Text: "x"})
+		return err
+	})
+	expectError(t, "edit on an unknown message", "message not found", func() error {
+		_, err := db.EditComment(ctx, ids[0], 99, conv, bob.Id, models.NewComment{Text: "x"})
+		return err
+	})
+	expectError(t, "edit in the wrong conversation", "message does not belong to specified conversation", func() error {
+		_, err := db.EditComment(ctx, ids[0], msg, other, bob.Id, models.NewComment{Text: "x"})
+		return err
+	})
+	expectError(t, "edit as another user", "unauthorized: user is not the author", func() error {
+		_, err := db.EditComment(ctx, ids[0], msg, conv, alice.Id, models.NewComment{Text: "x"})
+		return err
+	})
+
+	// Thumbnails follow the profile picture, falling back to the default one
+	defaultThumb := comments[0].AuthorThumbnail
+	expectThumbnail(t, "default picture", defaultThumb, 5, 5)
+	if _, err := db.SetMyPhoto(ctx, bob.Id, pic); err != nil {
+		t.Fatal(err)
+	}
+	comments, err = db.GetComments(ctx, msg, conv, bob.Id, 0, 1)
+	if err != nil || len(comments) != 1 {
+		t.Fatalf("GetComments: got %+v, %v", comments, err)
+	}
+	expectThumbnail(t, "GIF picture", comments[0].AuthorThumbnail, 1, 1)
+	if _, err := db.SetMyPhoto(ctx, bob.Id, base64.StdEncoding.EncodeToString([]byte("not an image"))); err != nil {
+		t.Fatal(err)
+	}
+	edited, err = db.EditComment(ctx, ids[0], msg, conv, bob.Id, models.NewComment{Text: "again"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if edited.AuthorThumbnail != defaultThumb {
+		t.Errorf("thumbnail of an unsupported picture: got %q, want the default one", edited.AuthorThumbnail)
+	}
+}
+
+func testThreads(t *testing.T, db database.AppDatabase, clock *globaltime.Fake) {
+	alice, bob, carol := login(t, db, "alice"), login(t, db, "bob"), login(t, db, "carol")
+	dave := login(t, db, "dave")
+	group := createGroup(t, db, alice.Id, "friends")
+	if _, err := db.AddToGroup(ctx, group.Id, []string{"bob", "carol"}); err != nil {
+		t.Fatal(err)
+	}
+	direct := startConversation(t, db, alice.Id, "dave")
+	msg := sendText(t, db, group.Id, alice.Id, "dinner?")
+	other := sendText(t, db, group.Id, bob.Id, "ok")
+	directMsg := sendText(t, db, direct, dave.Id, "hi")
+
+	reply := func(senderID int64, text string) *models.Reply {
+		t.Helper()
+		clock.Advance(time.Minute)
+		r, err := db.ReplyToMessage(ctx, msg, group.Id, senderID, models.NewMessage{Type: "text", Text: &text})
+		if err != nil {
+			t.Fatalf("ReplyToMessage: %v", err)
+		}
+		return r
+	}
+	unread := func(userID int64) map[int64]int {
+		t.Helper()
+		threads, err := db.GetMyThreads(ctx, userID)
+		if err != nil {
+			t.Fatalf("GetMyThreads: %v", err)
+		}
+		counts := make(map[int64]int)
+		for _, th := range threads {
+			counts[th.MessageId] = th.UnreadCount
+		}
+		return counts
+	}
+
+	// Nobody follows a thread before the first reply
+	if threads, err := db.GetMyThreads(ctx, alice.Id); err != nil || threads == nil || len(threads) != 0 {
+		t.Fatalf("GetMyThreads before any reply: got %+v, %v, want an empty list", threads, err)
+	}
+
+	first := reply(bob.Id, "yes")
+	if first.Id != 1 || first.Sender != "bob" || first.Type != "text" || first.Text == nil || *first.Text != "yes" {
+		t.Fatalf("ReplyToMessage: got %+v", first)
+	}
+	expectTime(t, "reply", first.Timestamp, clock.Now())
+
+	// The sender of the message and the first replier follow the thread; carol does not
+	expectUnread(t, "alice after the first reply", unread(alice.Id), map[int64]int{msg: 1})
+	expectUnread(t, "bob after his reply", unread(bob.Id), map[int64]int{msg: 0})
+	expectUnread(t, "carol", unread(carol.Id), map[int64]int{})
+
+	second := reply(carol.Id, "me too")
+	third := reply(alice.Id, "8pm")
+	expectUnread(t, "alice after her reply", unread(alice.Id), map[int64]int{msg: 2})
+	expectUnread(t, "bob", unread(bob.Id), map[int64]int{msg: 2})
+	expectUnread(t, "carol after her reply", unread(carol.Id), map[int64]int{msg: 1})
+
+	// The message shows the size of the thread and its last reply
+	conv, err := db.GetConversation(ctx, group.Id, carol.Id)
+	if err != nil || len(conv.Messages) != 2 {
+		t.Fatalf("GetConversation: got %+v, %v", conv, err)
+	}
+	if m := conv.Messages[0]; m.ThreadReplyCount != 3 || m.LastReply == nil || m.LastReply.Preview != "8pm" {
+		t.Errorf("message with a thread: got %d replies, last %+v", m.ThreadReplyCount, m.LastReply)
+	} else {
+		expectTime(t, "last reply", m.LastReply.Timestamp, third.Timestamp)
+	}
+	if m := conv.Messages[1]; m.ThreadReplyCount != 0 || m.LastReply != nil {
+		t.Errorf("message without a thread: got %d replies, last %+v", m.ThreadReplyCount, m.LastReply)
+	}
+
+	// Pages of the thread
+	page := func(after int64, limit int) []int64 {
+		t.Helper()
+		replies, err := db.GetReplies(ctx, msg, group.Id, carol.Id, after, limit)
+		if err != nil {
+			t.Fatalf("GetReplies(after %d, limit %d): %v", after, limit, err)
+		}
+		var ids []int64
+		for _, r := range replies {
+			ids = append(ids, r.Id)
+		}
+		return ids
+	}
+	expectIDs(t, "first page", page(0, 2), first.Id, second.Id)
+	expectIDs(t, "second page", page(second.Id, 2), third.Id)
+	expectIDs(t, "no limit", page(0, 0), first.Id, second.Id, third.Id)
+	if replies, err := db.GetReplies(ctx, other, group.Id, bob.Id, 0, 0); err != nil || replies == nil || len(replies) != 0 {
+		t.Errorf("GetReplies without replies: got %+v, %v, want an empty list", replies, err)
+	}
+
+	// Reading
+	if err := db.MarkThreadRead(ctx, msg, group.Id, bob.Id, second.Id); err != nil {
+		t.Fatalf("MarkThreadRead: %v", err)
+	}
+	expectUnread(t, "bob after reading up to carol's reply", unread(bob.Id), map[int64]int{msg: 1})
+	if err := db.MarkThreadRead(ctx, msg, group.Id, bob.Id, first.Id); err != nil {
+		t.Fatalf("MarkThreadRead: %v", err)
+	}
+	expectUnread(t, "bob after reading an older reply", unread(bob.Id), map[int64]int{msg: 1})
+
+	// Unsubscribing, subscribing again (the replies before are read), and the threads of other messages
+	if err := db.UnsubscribeThread(ctx, msg, group.Id, bob.Id); err != nil {
+		t.Fatalf("UnsubscribeThread: %v", err)
+	}
+	if err := db.UnsubscribeThread(ctx, msg, group.Id, bob.Id); err != nil {
+		t.Errorf("UnsubscribeThread twice: %v", err)
+	}
+	expectUnread(t, "bob unsubscribed", unread(bob.Id), map[int64]int{})
+	if err := db.SubscribeThread(ctx, msg, group.Id, bob.Id); err != nil {
+		t.Fatalf("SubscribeThread: %v", err)
+	}
+	if err := db.SubscribeThread(ctx, other, group.Id, carol.Id); err != nil {
+		t.Fatalf("SubscribeThread: %v", err)
+	}
+	expectUnread(t, "bob subscribed again", unread(bob.Id), map[int64]int{msg: 0})
+
+	// Threads with recent replies first, then the ones without replies
+	clock.Advance(time.Minute)
+	text := "pic?"
+	if _, err := db.ReplyToMessage(ctx, directMsg, direct, alice.Id, models.NewMessage{Type: "text", Text: &text}); err != nil {
+		t.Fatal(err)
+	}
+	photo := pic
+	photoReply, err := db.ReplyToMessage(ctx, msg, group.Id, bob.Id, models.NewMessage{Type: "photo", Photo: &photo})
+	if err != nil || photoReply.Photo == nil || *photoReply.Photo != pic || photoReply.Text != nil {
+		t.Fatalf("photo reply: got %+v, %v", photoReply, err)
+	}
+	threads, err := db.GetMyThreads(ctx, carol.Id)
+	if err != nil || len(threads) != 2 {
+		t.Fatalf("GetMyThreads: got %+v, %v", threads, err)
+	}
+	if th := threads[0]; th.ConversationId != group.Id || th.MessageId != msg || th.ReplyCount != 4 ||
+		th.UnreadCount != 2 || th.Message.Preview != "dinner?" || th.LastReply == nil || th.LastReply.Preview != "Photo" {
+		t.Errorf("thread with replies: got %+v", th)
+	}
+	if th := threads[1]; th.MessageId != other || th.ReplyCount != 0 || th.UnreadCount != 0 || th.LastReply != nil {
+		t.Errorf("thread without replies: got %+v", th)
+	}
+	threads, err = db.GetMyThreads(ctx, alice.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var order []int64
+	for _, th := range threads {
+		order = append(order, th.MessageId)
+	}
+	expectIDs(t, "threads, the most recent reply first", order, msg, directMsg)
+
+	// Errors
+	for name, c := range map[string]struct {
+		msg, conv, user int64
+		err             string
+	}{
+		"unknown message":    {99, group.Id, bob.Id, "message not found"},
+		"wrong conversation": {msg, direct, alice.Id, "message does not belong to specified conversation"},
+		"not participant":    {msg, group.Id, dave.Id, "user not participant in conversation"},
+	} {
+		c := c
+		expectError(t, "reply, "+name, c.err, func() error {
+			_, err := db.ReplyToMessage(ctx, c.msg, c.conv, c.user, models.NewMessage{Type: "text", Text: &text})
+			return err
+		})
+		expectError(t, "replies, "+name, c.err, func() error {
+			_, err := db.GetReplies(ctx, c.msg, c.conv, c.user, 0, 0)
+			return err
+		})
+		expectError(t, "subscribe, "+name, c.err, func() error { return db.SubscribeThread(ctx, c.msg, c.conv, c.user) })
+		expectError(t, "unsubscribe, "+name, c.err, func() error { return db.UnsubscribeThread(ctx, c.msg, c.conv, c.user) })
+		expectError(t, "mark read, "+name, c.err, func() error {
+			return db.MarkThreadRead(ctx, c.msg, c.conv, c.user, first.Id)
+		})
+	}
+	expectError(t, "replies after an unknown reply", "reply not found", func() error {
+		_, err := db.GetReplies(ctx, msg, group.Id, bob.Id, 99, 0)
+		return err
+	})
+	expectError(t, "mark read a reply of another thread", "reply not found", func() error {
+		return db.MarkThreadRead(ctx, other, group.Id, carol.Id, first.Id)
+	})
+	if err := db.UnsubscribeThread(ctx, msg, group.Id, carol.Id); err != nil {
+		t.Fatal(err)
+	}
+	expectError(t, "mark read without subscription", "user not subscribed to thread", func() error {
+		return db.MarkThreadRead(ctx, msg, group.Id, carol.Id, first.Id)
+	})
+
+	// Only the first reply subscribes the sender of the message
+	expectUnread(t, "dave", unread(dave.Id), map[int64]int{directMsg: 1})
+	if err := db.UnsubscribeThread(ctx, directMsg, direct, dave.Id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.ReplyToMessage(ctx, directMsg, direct, alice.Id, models.NewMessage{Type: "text", Text: &text}); err != nil {
+		t.Fatal(err)
+	}
+	expectUnread(t, "dave after unsubscribing", unread(dave.Id), map[int64]int{})
+
+	// Leaving the group hides its threads, deleting the message deletes its thread
+	if err := db.LeaveGroup(ctx, group.Id, bob.Id); err != nil {
+		t.Fatal(err)
+	}
+	expectUnread(t, "bob after leaving the group", unread(bob.Id), map[int64]int{})
+	if err := db.DeleteMessage(ctx, msg, group.Id, alice.Id); err != nil {
+		t.Fatal(err)
+	}
+	expectUnread(t, "alice after deleting the message", unread(alice.Id), map[int64]int{directMsg: 0})
+}
+
+func testMentions(t *testing.T, db database.AppDatabase, clock *globaltime.Fake) {
+	alice, bob, carol := login(t, db, "alice"), login(t, db, "bob"), login(t, db, "carol")
+	dave := login(t, db, "dave")
+	group := createGroup(t, db, alice.Id, "friends")
+	if _, err := db.AddToGroup(ctx, group.Id, []string{"bob", "carol"}); err != nil {
+		t.Fatal(err)
+	}
+	direct := startConversation(t, db, alice.Id, "dave")
+
+	send := func(conversationID, senderID int64, text string) *models.Message {
+		t.Helper()
+		clock.Advance(time.Minute)
+		m, err := db.SendMessage(ctx, conversationID, senderID, models.NewMessage{Type: "text", Text: &text})
+		if err != nil {
+			t.Fatalf("SendMessage: %v", err)
+		}
+		return m
+	}
+	mentioned := func(userID, before int64, limit int) []int64 {
+		t.Helper()
+		found, err := db.GetMyMentions(ctx, userID, before, limit)
+		if err != nil || found == nil {
+			t.Fatalf("GetMyMentions: got %v, %v", found, err)
+		}
+		var ids []int64
+		for _, m := range found {
+			ids = append(ids, m.MessageId)
+		}
+		return ids
+	}
+
+	// Only the participants are mentioned: dave is not in the group, nobody does not exist, and e-mail addresses are
+	// not mentions. The offsets count UTF-16 code units, and the emoji takes two.
+	first := send(group.Id, alice.Id, "hi @bob and @carol, not @dave, @nobody or x@bob.com 😀 @bob")
+	want := []models.MessageEntity{
+		{Type: "mention", Offset: 3, Length: 4, UserId: bob.Id, Username: "bob"},
+		{Type: "mention", Offset: 12, Length: 6, UserId: carol.Id, Username: "carol"},
+		{Type: "mention", Offset: 55, Length: 4, UserId: bob.Id, Username: "bob"},
+	}
+	expectEntities(t, "SendMessage", first.Entities, want)
+	conv, err := db.GetConversation(ctx, group.Id, carol.Id)
+	if err != nil || len(conv.Messages) != 1 {
+		t.Fatalf("GetConversation: got %+v, %v", conv, err)
+	}
+	expectEntities(t, "GetConversation", conv.Messages[0].Entities, want)
+
+	// Direct conversations have no mentions
+	directMsg := send(direct, alice.Id, "@dave look")
+	expectEntities(t, "direct message", directMsg.Entities, nil)
+	plain := send(group.Id, carol.Id, "no mentions here")
+	expectEntities(t, "message without mentions", plain.Entities, nil)
+
+	// The mentions of the user by the others, the most recent first
+	second := send(group.Id, carol.Id, "@bob?")
+	self := send(group.Id, bob.Id, "@bob is here")
+	expectEntities(t, "mentioning oneself", self.Entities, []models.MessageEntity{
+		{Type: "mention", Offset: 0, Length: 4, UserId: bob.Id, Username: "bob"},
+	})
+	expectIDs(t, "mentions of bob", mentioned(bob.Id, 0, 0), second.Id, first.Id)
+	expectIDs(t, "mentions of carol", mentioned(carol.Id, 0, 0), first.Id)
+	expectIDs(t, "mentions of alice", mentioned(alice.Id, 0, 0))
+	expectIDs(t, "mentions of dave", mentioned(dave.Id, 0, 0))
+
+	found, err := db.GetMyMentions(ctx, bob.Id, 0, 1)
+	if err != nil || len(found) != 1 {
+		t.Fatalf("GetMyMentions: got %+v, %v", found, err)
+	}
+	m := found[0]
+	if m.ConversationId != group.Id || m.GroupName != "friends" || m.MessageId != second.Id || m.Sender != "carol" ||
+		m.Text != "@bob?" {
+		t.Errorf("GetMyMentions: got %+v", m)
+	}
+	expectTime(t, "mention", m.Timestamp, second.Timestamp)
+	expectEntities(t, "mention", m.Entities, []models.MessageEntity{
+		{Type: "mention", Offset: 0, Length: 4, UserId: bob.Id, Username: "bob"},
+	})
+
+	// Pages
+	expectIDs(t, "next page", mentioned(bob.Id, second.Id, 1), first.Id)
+	expectIDs(t, "after the last mention", mentioned(bob.Id, first.Id, 0))
+	expectError(t, "mentions before an unknown message", "mention not found", func() error {
+		_, err := db.GetMyMentions(ctx, bob.Id, 99, 0)
+		return err
+	})
+	expectError(t, "mentions before a message not mentioning the user", "mention not found", func() error {
+		_, err := db.GetMyMentions(ctx, bob.Id, plain.Id, 0)
+		return err
+	})
+
+	// The entities show the current username
+	if _, err := db.SetMyUserName(ctx, bob.Id, "robert"); err != nil {
+		t.Fatal(err)
+	}
+	conv, err = db.GetConversation(ctx, group.Id, alice.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectEntities(t, "after renaming", conv.Messages[0].Entities, []models.MessageEntity{
+		{Type: "mention", Offset: 3, Length: 4, UserId: bob.Id, Username: "robert"},
+		{Type: "mention", Offset: 12, Length: 6, UserId: carol.Id, Username: "carol"},
+		{Type: "mention", Offset: 55, Length: 4, UserId: bob.Id, Username: "robert"},
+	})
+
+	// Forwarded messages mention the participants of the recipient conversation
+	other := createGroup(t, db, alice.Id, "others")
+	if _, err := db.AddToGroup(ctx, other.Id, []string{"dave"}); err != nil {
+		t.Fatal(err)
+	}
+	forwarded, err := db.ForwardMessage(ctx, directMsg.Id, other.Id, alice.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectEntities(t, "forwarded message", forwarded.Entities, []models.MessageEntity{
+		{Type: "mention", Offset: 0, Length: 5, UserId: dave.Id, Username: "dave"},
+	})
+	expectIDs(t, "mentions of dave after the forward", mentioned(dave.Id, 0, 0), forwarded.Id)
+
+	// Leaving the group hides its mentions, deleting a message deletes its mentions
+	if err := db.LeaveGroup(ctx, group.Id, carol.Id); err != nil {
+		t.Fatal(err)
+	}
+	expectIDs(t, "mentions of carol after leaving", mentioned(carol.Id, 0, 0))
+	if err := db.DeleteMessage(ctx, first.Id, group.Id, alice.Id); err != nil {
+		t.Fatal(err)
+	}
+	expectIDs(t, "mentions of bob after the deletion", mentioned(bob.Id, 0, 0), second.Id)
+}
+
+func testRichText(t *testing.T, db database.AppDatabase) {
+	alice, bob := login(t, db, "alice"), login(t, db, "bob")
+	group := createGroup(t, db, alice.Id, "friends")
+	if _, err := db.AddToGroup(ctx, group.Id, []string{"bob"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The text is stored without markup. The @bob in the code and in the URL are not mentions.
+	text := "**hi** _there_ `@bob` see [docs](https://x.org) and https://y.org/@bob @bob"
+	m, err := db.SendMessage(ctx, group.Id, alice.Id, models.NewMessage{Type: "text", Text: &text})
+	if err != nil {
+		t.Fatalf("SendMessage: %v", err)
+	}
+	if m.Text == nil || *m.Text != "hi there @bob see docs and https://y.org/@bob @bob" {
+		t.Fatalf("SendMessage: got text %v", m.Text)
+	}
+	want := []models.MessageEntity{
+		{Type: "bold", Offset: 0, Length: 2},
+		{Type: "italic", Offset: 3, Length: 5},
+		{Type: "code", Offset: 9, Length: 4},
+		{Type: "text_link", Offset: 18, Length: 4, URL: "https://x.org"},
+		{Type: "url", Offset: 27, Length: 18},
+		{Type: "mention", Offset: 46, Length: 4, UserId: bob.Id, Username: "bob"},
+	}
+	expectEntities(t, "SendMessage", m.Entities, want)
+	conv, err := db.GetConversation(ctx, group.Id, bob.Id)
+	if err != nil || len(conv.Messages) != 1 {
+		t.Fatalf("GetConversation: got %+v, %v", conv, err)
+	}
+	expectEntities(t, "GetConversation", conv.Messages[0].Entities, want)
+
+	// Previews show the text without markup
+	convs, err := db.GetMyConversations(ctx, bob.Id)
+	if err != nil || len(convs) != 1 || convs[0].LastMessage == nil ||
+		convs[0].LastMessage.Preview != "hi there @bob see docs and https://y.org/@bob @bob" {
+		t.Fatalf("GetMyConversations: got %+v, %v", convs, err)
+	}
+
+	// Nested entities, the enclosing one first
+	text = "```go\nx := 1\n``` **[_a_ b](http://z.org)**"
+	nested, err := db.SendMessage(ctx, group.Id, bob.Id, models.NewMessage{Type: "text", Text: &text})
+	if err != nil {
+		t.Fatalf("SendMessage: %v", err)
+	}
+	if nested.Text == nil || *nested.Text != "x := 1 a b" {
+		t.Fatalf("SendMessage: got text %v", nested.Text)
+	}
+	expectEntities(t, "nested entities", nested.Entities, []models.MessageEntity{
+		{Type: "pre", Offset: 0, Length: 6, Language: "go"},
+		{Type: "bold", Offset: 7, Length: 3},
+		{Type: "text_link", Offset: 7, Length: 3, URL: "http://z.org"},
+		{Type: "italic", Offset: 7, Length: 1},
+	})
+
+	// Forwarded messages keep the formatting
+	other := createGroup(t, db, bob.Id, "others")
+	forwarded, err := db.ForwardMessage(ctx, m.Id, other.Id, bob.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if forwarded.Text == nil || *forwarded.Text != *m.Text {
+		t.Fatalf("ForwardMessage: got text %v", forwarded.Text)
+	}
+	expectEntities(t, "forwarded message", forwarded.Entities, want)
+
+	// Texts without markup have no entities, invalid links are refused
+	plain := "2*3*4 and snake_case"
+	m, err = db.SendMessage(ctx, group.Id, alice.Id, models.NewMessage{Type: "text", Text: &plain})
+	if err != nil || m.Text == nil || *m.Text != plain {
+		t.Fatalf("SendMessage: got %+v, %v", m, err)
+	}
+	expectEntities(t, "plain text", m.Entities, nil)
+	expectError(t, "link to a script", "invalid markup: links must be http or https URLs of at most 2048 characters",
+		func() error {
+			text := "[click](javascript:alert(1))"
+			_, err := db.SendMessage(ctx, group.Id, alice.Id, models.NewMessage{Type: "text", Text: &text})
+			return err
+		})
+}
+
+func testLinkPreviews(t *testing.T, db database.AppDatabase) {
+	alice := login(t, db, "alice")
+	login(t, db, "bob")
+	login(t, db, "carol")
+	conv := startConversation(t, db, alice.Id, "bob")
+	msg := sendText(t, db, conv, alice.Id, "https://example.com")
+	other := sendText(t, db, conv, alice.Id, "no links")
+
+	preview := models.LinkPreview{URL: "https://example.com", Title: "Example", Image: "https://example.com/a.png"}
+	if err := db.SetLinkPreview(ctx, msg, preview); err != nil {
+		t.Fatalf("SetLinkPreview: %v", err)
+	}
+	got, err := db.GetConversation(ctx, conv, alice.Id)
+	if err != nil || len(got.Messages) != 2 {
+		t.Fatalf("GetConversation: got %+v, %v", got, err)
+	}
+	if p := got.Messages[0].LinkPreview; p == nil || *p != preview {
+		t.Errorf("GetConversation: got link preview %+v, want %+v", p, preview)
+	}
+	if p := got.Messages[1].LinkPreview; p != nil {
+		t.Errorf("GetConversation: got link preview %+v for a message without one", p)
+	}
+
+	// A new preview replaces the previous one, and forwarded messages keep it
+	preview = models.LinkPreview{URL: "https://example.com", Title: "Example", Description: "An example"}
+	if err := db.SetLinkPreview(ctx, msg, preview); err != nil {
+		t.Fatalf("SetLinkPreview: %v", err)
+	}
+	direct := startConversation(t, db, alice.Id, "carol")
+	forwarded, err := db.ForwardMessage(ctx, msg, direct, alice.Id)
+	if err != nil {
+		t.Fatalf("ForwardMessage: %v", err)
+	}
+	if p := forwarded.LinkPreview; p == nil || *p != preview {
+		t.Errorf("ForwardMessage: got link preview %+v, want %+v", p, preview)
+	}
+
+	// The preview goes away with the message
+	if err := db.DeleteMessage(ctx, other, conv, alice.Id); err != nil {
+		t.Fatal(err)
+	}
+	expectError(t, "SetLinkPreview of a deleted message", "message not found", func() error {
+		return db.SetLinkPreview(ctx, other, preview)
+	})
+	if err := db.DeleteMessage(ctx, msg, conv, alice.Id); err != nil {
+		t.Fatalf("DeleteMessage of a message with a preview: %v", err)
+	}
+}
+
+func testFiles(t *testing.T, db database.AppDatabase) {
+	alice := login(t, db, "alice")
+	bob := login(t, db, "bob")
+	carol := login(t, db, "carol")
+	conv := startConversation(t, db, alice.Id, "bob")
+	text := sendText(t, db, conv, alice.Id, "hello")
+
+	data := []byte("%PDF-1.4 notes")
+	msg, err := db.SendFile(ctx, conv, alice.Id, models.NewFile{Name: "notes.pdf", MimeType: "application/pdf", Data: data})
+	want := models.File{Name: "notes.pdf", MimeType: "application/pdf", Size: int64(len(data))}
+	if err != nil || msg.Type != "file" || msg.Sender != "alice" || msg.File == nil || *msg.File != want ||
+		msg.Text != nil || msg.Photo != nil {
+		t.Fatalf("SendFile: got %+v, %v", msg, err)
+	}
+	got, err := db.GetConversation(ctx, conv, bob.Id)
+	if err != nil || len(got.Messages) != 2 || got.Messages[1].File == nil || *got.Messages[1].File != want ||
+		got.Messages[0].File != nil {
+		t.Fatalf("GetConversation: got %+v, %v", got, err)
+	}
+	convs, err := db.GetMyConversations(ctx, bob.Id)
+	if err != nil || len(convs) != 1 || convs[0].LastMessage == nil || convs[0].LastMessage.Preview != "File: notes.pdf" {
+		t.Errorf("GetMyConversations: got %+v, %v", convs, err)
+	}
+
+	file, content, err := db.GetFile(ctx, msg.Id, conv, bob.Id)
+	if err != nil || *file != want || string(content) != string(data) {
+		t.Errorf("GetFile: got %+v, %q, %v", file, content, err)
+	}
+	expectError(t, "GetFile of a non participant", "user not participant in conversation", func() error {
+		_, _, err := db.GetFile(ctx, msg.Id, conv, carol.Id)
+		return err
+	})
+	expectError(t, "GetFile of a text message", "message has no file", func() error {
+		_, _, err := db.GetFile(ctx, text, conv, bob.Id)
+		return err
+	})
+	expectError(t, "SendFile of a non participant", "user not participant in conversation", func() error {
+		_, err := db.SendFile(ctx, conv, carol.Id, models.NewFile{Name: "a.txt", MimeType: "text/plain", Data: data})
+		return err
+	})
+
+	// Forwarded messages keep the file, which is found only in their conversation
+	direct := startConversation(t, db, alice.Id, "carol")
+	forwarded, err := db.ForwardMessage(ctx, msg.Id, direct, alice.Id)
+	if err != nil || forwarded.Type != "file" || forwarded.File == nil || *forwarded.File != want {
+		t.Fatalf("ForwardMessage: got %+v, %v", forwarded, err)
+	}
+	if _, content, err := db.GetFile(ctx, forwarded.Id, direct, carol.Id); err != nil || string(content) != string(data) {
+		t.Errorf("GetFile of the forwarded message: got %q, %v", content, err)
+	}
+	expectError(t, "GetFile in another conversation", "message not found", func() error {
+		_, _, err := db.GetFile(ctx, msg.Id, direct, alice.Id)
+		return err
+	})
+
+	// The file goes away with the message
+	if err := db.DeleteMessage(ctx, msg.Id, conv, alice.Id); err != nil {
+		t.Fatalf("DeleteMessage of a file message: %v", err)
+	}
+	expectError(t, "GetFile of a deleted message", "message not found", func() error {
+		_, _, err := db.GetFile(ctx, msg.Id, conv, alice.Id)
+		return err
+	})
+	if _, _, err := db.GetFile(ctx, forwarded.Id, direct, alice.Id); err != nil {
+		t.Errorf("GetFile of the forwarded message after deleting the original: %v", err)
+	}
+}
+
+func testAudio(t *testing.T, db database.AppDatabase) {
+	alice := login(t, db, "alice")
+	bob := login(t, db, "bob")
+	carol := login(t, db, "carol")
+	conv := startConversation(t, db, alice.Id, "bob")
+	text := sendText(t, db, conv, alice.Id, "hello")
+
+	data := []byte("OggS voice")
+	waveform := []int{0, 50, 100, 25}
+	msg, err := db.SendAudio(ctx, conv, alice.Id, models.NewAudio{MimeType: "audio/ogg", Duration: 71500 * time.Millisecond,
+		Waveform: waveform, Data: data})
+	if err != nil || msg.Type != "audio" || msg.Audio == nil || msg.Text != nil || msg.File != nil {
+		t.Fatalf("SendAudio: got %+v, %v", msg, err)
+	}
+	expectAudio(t, "SendAudio", msg.Audio, "audio/ogg", int64(len(data)), 71500, waveform)
+	got, err := db.GetConversation(ctx, conv, bob.Id)
+	if err != nil || len(got.Messages) != 2 || got.Messages[0].Audio != nil {
+		t.Fatalf("GetConversation: got %+v, %v", got, err)
+	}
+	expectAudio(t, "GetConversation", got.Messages[1].Audio, "audio/ogg", int64(len(data)), 71500, waveform)
+
+	// The seconds of the previews are rounded up
+	for _, c := range []struct {
+		ms   int64
+		want string
+	}{{71500, "Voice message (1:12)"}, {300, "Voice message (0:01)"}, {9000, "Voice message (0:09)"}} {
+		if c.ms != 71500 {
+			if _, err := db.SendAudio(ctx, conv, bob.Id, models.NewAudio{MimeType: "audio/wav",
+				Duration: time.Duration(c.ms) * time.Millisecond, Data: data}); err != nil {
+				t.Fatalf("SendAudio: %v", err)
+			}
+		}
+		convs, err := db.GetMyConversations(ctx, alice.Id)
+		if err != nil || len(convs) != 1 || convs[0].LastMessage == nil || convs[0].LastMessage.Preview != c.want {
+			t.Errorf("GetMyConversations after a voice message of %d ms: got %+v, %v, want %q", c.ms, convs, err, c.want)
+		}
+	}
+
+	audio, content, err := db.GetAudio(ctx, msg.Id, conv, bob.Id)
+	if err != nil || string(content) != string(data) {
+		t.Fatalf("GetAudio: got %+v, %q, %v", audio, content, err)
+	}
+	expectAudio(t, "GetAudio", audio, "audio/ogg", int64(len(data)), 71500, waveform)
+	expectError(t, "GetAudio of a non participant", "user not participant in conversation", func() error {
+		_, _, err := db.GetAudio(ctx, msg.Id, conv, carol.Id)
+		return err
+	})
+	expectError(t, "GetAudio of a text message", "message has no audio", func() error {
+		_, _, err := db.GetAudio(ctx, text, conv, bob.Id)
+		return err
+	})
+	expectError(t, "SendAudio of a non participant", "user not participant in conversation", func() error {
+		_, err := db.SendAudio(ctx, conv, carol.Id, models.NewAudio{MimeType: "audio/ogg", Duration: time.Second, Data: data})
+		return err
+	})
+
+	// Forwarded messages keep the recording, which goes away with the message
+	direct := startConversation(t, db, alice.Id, "carol")
+	forwarded, err := db.ForwardMessage(ctx, msg.Id, direct, alice.Id)
+	if err != nil || forwarded.Type != "audio" {
+		t.Fatalf("ForwardMessage: got %+v, %v", forwarded, err)
+	}
+	expectAudio(t, "ForwardMessage", forwarded.Audio, "audio/ogg", int64(len(data)), 71500, waveform)
+	if err := db.DeleteMessage(ctx, msg.Id, conv, alice.Id); err != nil {
+		t.Fatalf("DeleteMessage of a voice message: %v", err)
+	}
+	expectError(t, "GetAudio of a deleted message", "message not found", func() error {
+		_, _, err := db.GetAudio(ctx, msg.Id, conv, alice.Id)
+		return err
+	})
+	if _, _, err := db.GetAudio(ctx, forwarded.Id, direct, carol.Id); err != nil {
+		t.Errorf("GetAudio of the forwarded message after deleting the original: %v", err)
+	}
+}
+
+// expectAudio compares the recording of a voice message
+func expectAudio(t *testing.T, name string, got *models.Audio, mimeType string, size, durationMs int64, waveform []int) {
+	t.Helper()
+	if got == nil {
+		t.Errorf("%s: the message has no audio", name)
+		return
+	}
+	if got.MimeType != mimeType || got.Size != size || got.DurationMs != durationMs ||
+		fmt.Sprint(got.Waveform) != fmt.Sprint(waveform) {
+		t.Errorf("%s: got audio %+v, want %s of %d bytes and %d ms, waveform %v", name, got, mimeType, size,
+			durationMs, waveform)
+	}
+}
+
+// expectEntities compares the entities of a message
+func expectEntities(t *testing.T, name string, got, want []models.MessageEntity) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Errorf("%s: got entities %+v, want %+v", name, got, want)
+		return
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("%s: got entities %+v, want %+v", name, got, want)
+			return
+		}
+	}
+}
+
+// expectUnread compares the unread replies of each thread, by message ID
+func expectUnread(t *testing.T, name string, got, want map[int64]int) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Errorf("%s: got unread replies %v, want %v", name, got, want)
+		return
+	}
+	for id, n := range want {
+		if g, ok := got[id]; !ok || g != n {
+			t.Errorf("%s: got unread replies %v, want %v", name, got, want)
+			return
+		}
+	}
+}
+
+// expectThumbnail checks that a thumbnail is a base64 PNG image of the given size
+func expectThumbnail(t *testing.T, name, thumb string, width, height int) {
+	t.Helper()
+	data, err := base64.StdEncoding.DecodeString(thumb)
+	if err != nil {
+		t.Errorf("%s: the thumbnail is not base64: %v", name, err)
+		return
+	}
+	cfg, err := png.DecodeConfig(bytes.NewReader(data))
+	if err != nil || cfg.Width != width || cfg.Height != height {
+		t.Errorf("%s: got thumbnail %dx%d (%v), want a PNG of %dx%d", name, cfg.Width, cfg.Height, err, width, height)
+	}
+}
+
+func commentIDs(comments []models.Comment) []int64 {
+	var ids []int64
+	for _, c := range comments {
+		ids = append(ids, c.Id)
+	}
+	return ids
+}
+
+// expectTime checks that a timestamp read from the database is the instant `want`, in UTC
+func expectTime(t *testing.T, name string, got, want time.Time) {
+	t.Helper()
+	if !got.Equal(want) || got.Location() != time.UTC {
+		t.Errorf("%s: got timestamp %s, want %s", name, got.Format(time.RFC3339Nano), want.UTC().Format(time.RFC3339Nano))
+	}
+}
+
+func login(t *testing.T, db database.AppDatabase, username string) *models.User {
+	t.Helper()
+	u, _, err := db.DoLogin(ctx, username)
+	if err != nil {
+		t.Fatalf("DoLogin(%q): %v", username, err)
+	}
+	return u
+}
+
+func startConversation(t *testing.T, db database.AppDatabase, senderID int64, recipient string) int64 {
+	t.Helper()
+	c, err := db.StartConversation(ctx, senderID, recipient)
+	if err != nil {
+		t.Fatalf("StartConversation(%d, %q): %v", senderID, recipient, err)
+	}
+	return c.Id
+}
+
+func createGroup(t *testing.T, db database.AppDatabase, creatorID int64, name string) *models.Group {
+	t.Helper()
+	g, err := db.CreateGroup(ctx, creatorID, name)
+	if err != nil {
+		t.Fatalf("CreateGroup(%q): %v", name, err)
+	}
+	return g
+}
+
+func sendText(t *testing.T, db database.AppDatabase, conversationID, senderID int64, text string) int64 {
+	t.Helper()
+	m, err := db.SendMessage(ctx, conversationID, senderID, models.NewMessage{Type: "text", Text: &text})
+	if err != nil {
+		t.Fatalf("SendMessage: %v", err)
+	}
+	return m.Id
+}
+
+func sendPhoto(t *testing.T, db database.AppDatabase, conversationID, senderID int64) int64 {
+	t.Helper()
+	photo := pic
+	m, err := db.SendMessage(ctx, conversationID, senderID, models.NewMessage{Type: "photo", Photo: &photo})
+	if err != nil {
+		t.Fatalf("SendMessage: %v", err)
+	}
+	if m.Photo == nil || *m.Photo != pic || m.Text != nil {
+		t.Fatalf("SendMessage: got photo %v, text %v", m.Photo, m.Text)
+	}
+	if _, err := base64.StdEncoding.DecodeString(*m.Photo); err != nil {
+		t.Fatalf("SendMessage: the photo is not base64: %v", err)
+	}
+	return m.Id
+}
+
+func comment(t *testing.T, db database.AppDatabase, messageID, conversationID, authorID int64, text string) {
+	t.Helper()
+	if _, err := db.CommentMessage(ctx, messageID, conversationID, authorID, models.NewComment{Text: text}); err != nil {
+		t.Fatalf("CommentMessage: %v", err)
+	}
+}
+
+// expectError checks that fn fails with an error starting with msg (the SQL implementation appends the details of
+// unexpected errors)
+func expectError(t *testing.T, name, msg string, fn func() error) {
+	t.Helper()
+	err := fn()
+	if err == nil || !strings.HasPrefix(err.Error(), msg) {
+		t.Errorf("%s: got error %v, want %q", name, err, msg)
+	}
+}
+
+func expectStrings(t *testing.T, name string, got []string, want ...string) {
+	t.Helper()
+	if strings.Join(got, "\x00") != strings.Join(want, "\x00") || len(got) != len(want) {
+		t.Errorf("%s: got %q, want %q", name, got, want)
+	}
+}
+
+// expectSet compares lists whose order is not specified
+func expectSet(t *testing.T, name string, got []string, want ...string) {
+	t.Helper()
+	sorted := append([]string(nil), got...)
+	sort.Strings(sorted)
+	sort.Strings(want)
+	expectStrings(t, name, sorted, want...)
+}
+
+func expectIDs(t *testing.T, name string, got []int64, want ...int64) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Errorf("%s: got %v, want %v", name, got, want)
+		return
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			t.Errorf("%s: got %v, want %v", name, got, want)
+			return
+		}
+	}
+}
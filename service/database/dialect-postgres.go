@@ -0,0 +1,236 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// postgresDialect is the dialect of PostgreSQL drivers (pgx's database/sql driver registers itself as "pgx", lib/pq
+// as "postgres").
+type postgresDialect struct{}
+
+func (postgresDialect) rebind(query string) string {
+	return rebindDollar(query)
+}
+
+func (postgresDialect) caseInsensitiveLike() string {
+	return "ILIKE"
+}
+
+func (postgresDialect) timestamp(t time.Time) interface{} {
+	return t.UTC()
+}
+
+func (postgresDialect) migrations() [][]string {
+	return postgresMigrations
+}
+
+func (postgresDialect) prepareMigrations(context.Context, *sql.Conn) (func() error, error) {
+	// Tables are altered in place, and the constraints stay enabled
+	return func() error { return nil }, nil
+}
+
+func (postgresDialect) checkMigration(context.Context, queryer) error {
+	return nil
+}
+
+// postgresMigrations is the PostgreSQL migration set. Versions match the ones in sqliteMigrations, so the schema
+// version means the same thing on both backends.
+var postgresMigrations = [][]string{
+	// 1: initial schema
+	{
+		// users table
+		`CREATE TABLE users (
+			id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+			username TEXT NOT NULL UNIQUE,
+			pic BYTEA NOT NULL,
+			created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+		);`,
+
+		// conversations table (convo_pic holds the base64 text as sent by the client)
+		`CREATE TABLE conversations (
+			id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+			name TEXT,
+			type TEXT NOT NULL CHECK (type IN ('user', 'group')),
+			convo_pic TEXT,
+			created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+		);`,
+
+		// conversation_participants table
+		`CREATE TABLE conversation_participants (
+			conversation_id BIGINT REFERENCES conversations(id) ON DELETE CASCADE,
+			user_id BIGINT REFERENCES users(id) ON DELETE CASCADE,
+			joined_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (conversation_id, user_id)
+		);`,
+
+		// groups table
+		`CREATE TABLE groups (
+			id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+			name TEXT NOT NULL,
+			conversation_id BIGINT NOT NULL REFERENCES conversations(id) ON DELETE CASCADE,
+			group_photo BYTEA,
+			created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+		);`,
+
+		// group_members table
+		`CREATE TABLE group_members (
+			group_id BIGINT REFERENCES groups(id) ON DELETE CASCADE,
+			user_id BIGINT REFERENCES users(id) ON DELETE CASCADE,
+			joined_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (group_id, user_id)
+		);`,
+
+		// messages table
+		`CREATE TABLE messages (
+			id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+			conversation_id BIGINT NOT NULL REFERENCES conversations(id) ON DELETE CASCADE,
+			sender_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			type TEXT NOT NULL CHECK (type IN ('text', 'photo')),
+			text TEXT,
+			photo BYTEA,
+			timestamp TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+			CHECK ((type = 'text' AND text IS NOT NULL) OR (type = 'photo' AND photo IS NOT NULL))
+		);`,
+
+		// comments table
+		`CREATE TABLE comments (
+			id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+			message_id BIGINT NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+			user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			text TEXT NOT NULL,
+			timestamp TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+		);`,
+
+		// Index for faster comment lookups
+		`CREATE INDEX idx_comments_message ON comments(message_id);`,
+
+		// Index for faster message lookups
+		`CREATE INDEX idx_messages_conversation ON messages(conversation_id, timestamp DESC);`,
+	},
+
+	// 2: uniqueness of direct chats
+	{
+		`CREATE TABLE direct_conversations (
+			conversation_id BIGINT PRIMARY KEY REFERENCES conversations(id) ON DELETE CASCADE,
+			user_low BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			user_high BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			UNIQUE (user_low, user_high)
+		);`,
+	},
+
+	// 3: listing the conversations of a user
+	{
+		`CREATE INDEX idx_participants_user ON conversation_participants(user_id);`,
+	},
+
+	// 4: canonical timestamps
+	{
+		// Nothing to rewrite: TIMESTAMPTZ values are already stored in UTC
+	},
+
+	// 5: comment timestamps, edits and pages
+	{
+		`ALTER TABLE users ADD COLUMN pic_thumb BYTEA;`,
+		`ALTER TABLE comments ADD COLUMN edited_at TIMESTAMPTZ;`,
+		`DROP INDEX idx_comments_message;`,
+		`CREATE INDEX idx_comments_message_time ON comments(message_id, timestamp, id);`,
+	},
+
+	// 6: threads
+	{
+		`CREATE TABLE replies (
+			id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+			message_id BIGINT NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+			sender_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			type TEXT NOT NULL CHECK (type IN ('text', 'photo')),
+			text TEXT,
+			photo BYTEA,
+			timestamp TIMESTAMPTZ NOT NULL,
+			CHECK ((type = 'text' AND text IS NOT NULL) OR (type = 'photo' AND photo IS NOT NULL))
+		);`,
+		`CREATE INDEX idx_replies_message_time ON replies(message_id, timestamp, id);`,
+		`CREATE TABLE thread_subscriptions (
+			message_id BIGINT NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+			user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			last_read_reply_id BIGINT NOT NULL DEFAULT 0,
+			PRIMARY KEY (message_id, user_id)
+		);`,
+		`CREATE INDEX idx_thread_subscriptions_user ON thread_subscriptions(user_id);`,
+	},
+
+	// 7: mentions
+	{
+		`CREATE TABLE mentions (
+			message_id BIGINT NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+			user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			text_offset INTEGER NOT NULL,
+			text_length INTEGER NOT NULL,
+			PRIMARY KEY (message_id, text_offset)
+		);`,
+		`CREATE INDEX idx_mentions_user ON mentions(user_id, message_id);`,
+	},
+
+	// 8: rich text
+	{
+		`CREATE TABLE message_entities (
+			message_id BIGINT NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+			position INTEGER NOT NULL,
+			type TEXT NOT NULL CHECK (type IN ('bold', 'italic', 'code', 'pre', 'text_link', 'url')),
+			text_offset INTEGER NOT NULL,
+			text_length INTEGER NOT NULL,
+			url TEXT,
+			language TEXT,
+			PRIMARY KEY (message_id, position)
+		);`,
+	},
+
+	// 9: link previews
+	{
+		`CREATE TABLE link_previews (
+			message_id BIGINT PRIMARY KEY REFERENCES messages(id) ON DELETE CASCADE,
+			url TEXT NOT NULL,
+			title TEXT NOT NULL,
+			description TEXT NOT NULL,
+			image TEXT NOT NULL
+		);`,
+	},
+
+	// 10: file messages
+	{
+		// The constraints have the default names of the column and table constraints of migration 1
+		`ALTER TABLE messages DROP CONSTRAINT messages_type_check;`,
+		`ALTER TABLE messages DROP CONSTRAINT messages_check;`,
+		`ALTER TABLE messages ADD CONSTRAINT messages_type_check CHECK (type IN ('text', 'photo', 'file'));`,
+		`ALTER TABLE messages ADD CONSTRAINT messages_check
+			CHECK ((type = 'text' AND text IS NOT NULL) OR (type = 'photo' AND photo IS NOT NULL) OR type = 'file');`,
+		`CREATE TABLE files (
+			message_id BIGINT PRIMARY KEY REFERENCES messages(id) ON DELETE CASCADE,
+			name TEXT NOT NULL,
+			mime_type TEXT NOT NULL,
+			size BIGINT NOT NULL,
+			data BYTEA NOT NULL
+		);`,
+	},
+
+	// 11: voice messages
+	{
+		`ALTER TABLE messages DROP CONSTRAINT messages_type_check;`,
+		`ALTER TABLE messages DROP CONSTRAINT messages_check;`,
+		`ALTER TABLE messages ADD CONSTRAINT messages_type_check CHECK (type IN ('text', 'photo', 'file', 'audio'));`,
+		`ALTER TABLE messages ADD CONSTRAINT messages_check
+			CHECK ((type = 'text' AND text IS NOT NULL) OR (type = 'photo' AND photo IS NOT NULL) OR type IN ('file', 'audio'));`,
+		`CREATE TABLE audio (
+			message_id BIGINT PRIMARY KEY REFERENCES messages(id) ON DELETE CASCADE,
+			mime_type TEXT NOT NULL,
+			size BIGINT NOT NULL,
+			duration_ms BIGINT NOT NULL,
+			waveform BYTEA NOT NULL,
+			data BYTEA NOT NULL
+		);`,
+	},
+}
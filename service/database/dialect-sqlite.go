@@ -0,0 +1,359 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"time"
+)
+
+// sqliteTimestampFormat is the format of the timestamps written by AppDatabase in SQLite, which has no time type:
+// always UTC and with all the nanosecond digits, so that comparing and sorting the text sorts by time. It is also a
+// format that the driver parses back into time.Time for DATETIME columns.
+const sqliteTimestampFormat = "2006-01-02 15:04:05.000000000+00:00"
+
+// sqliteDialect is the dialect of the SQLite driver (github.com/mattn/go-sqlite3, registered as "sqlite3").
+type sqliteDialect struct{}
+
+func (sqliteDialect) rebind(query string) string {
+	return query
+}
+
+func (sqliteDialect) caseInsensitiveLike() string {
+	// LIKE is already case-insensitive for ASCII characters in SQLite
+	return "LIKE"
+}
+
+func (sqliteDialect) timestamp(t time.Time) interface{} {
+	return t.UTC().Format(sqliteTimestampFormat)
+}
+
+func (sqliteDialect) migrations() [][]string {
+	return sqliteMigrations
+}
+
+// prepareMigrations disables the foreign keys: SQLite can't alter most constraints, so migrations rebuild the table
+// (create the new one, copy the rows, drop the old one and rename the new one), and DROP TABLE would otherwise delete
+// the rows referencing the old table. The pragma has no effect inside transactions, hence the dedicated connection.
+func (sqliteDialect) prepareMigrations(ctx context.Context, conn *sql.Conn) (func() error, error) {
+	if _, err := conn.ExecContext(ctx, "PRAGMA foreign_keys = OFF"); err != nil {
+		return nil, fmt.Errorf("error disabling foreign keys: %w", err)
+	}
+	return func() error {
+		// The connection goes back to the pool, where the foreign keys must be enforced
+		if _, err := conn.ExecContext(context.Background(), "PRAGMA foreign_keys = ON"); err != nil {
+			_ = conn.Raw(func(interface{}) error { return driver.ErrBadConn })
+			return fmt.Errorf("error enabling foreign keys: %w", err)
+		}
+		return nil
+	}, nil
+}
+
+// checkMigration fails if a migration left rows referencing missing rows
+func (sqliteDialect) checkMigration(ctx context.Context, tx queryer) error {
+	rows, err := tx.QueryContext(ctx, "PRAGMA foreign_key_check")
+	if err != nil {
+		return fmt.Errorf("error checking foreign keys: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+	if rows.Next() {
+		var table string
+		var rowid sql.NullInt64
+		var parent string
+		var fkid int
+		if err := rows.Scan(&table, &rowid, &parent, &fkid); err != nil {
+			return fmt.Errorf("error checking foreign keys: %w", err)
+		}
+		return fmt.Errorf("foreign key violation: a row of %s references a missing row of %s", table, parent)
+	}
+	return rows.Err()
+}
+
+// sqliteMigrations is the SQLite migration set. Databases created before schema versioning was introduced already have
+// the tables of the first migrations, which is why these use IF NOT EXISTS.
+var sqliteMigrations = [][]string{
+	// 1: initial schema
+	{
+		// users table
+		`CREATE TABLE IF NOT EXISTS users (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			username TEXT NOT NULL UNIQUE,
+			pic BLOB NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		);`,
+
+		// conversations table
+		`CREATE TABLE IF NOT EXISTS conversations (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT,
+			type TEXT NOT NULL CHECK (type IN ('user', 'group')),
+			convo_pic BLOB,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		);`,
+
+		// conversation_participants table
+		`CREATE TABLE IF NOT EXISTS conversation_participants (
+			conversation_id INTEGER,
+			user_id INTEGER,
+			joined_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (conversation_id, user_id),
+			FOREIGN KEY (conversation_id) REFERENCES conversations(id) ON DELETE CASCADE,
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+		);`,
+
+		// groups table
+		`CREATE TABLE IF NOT EXISTS groups (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT NOT NULL,
+			conversation_id INTEGER NOT NULL,
+			group_photo BLOB,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (conversation_id) REFERENCES conversations(id) ON DELETE CASCADE
+		);`,
+
+		// group_members table
+		`CREATE TABLE IF NOT EXISTS group_members (
+			group_id INTEGER,
+			user_id INTEGER,
+			joined_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (group_id, user_id),
+			FOREIGN KEY (group_id) REFERENCES groups(id) ON DELETE CASCADE,
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+		);`,
+
+		// messages table
+		`CREATE TABLE IF NOT EXISTS messages (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			conversation_id INTEGER NOT NULL,
+			sender_id INTEGER NOT NULL,
+			type TEXT NOT NULL CHECK (type IN ('text', 'photo')),
+			text TEXT,
+			photo BLOB,
+			timestamp DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (conversation_id) REFERENCES conversations(id) ON DELETE CASCADE,
+			FOREIGN KEY (sender_id) REFERENCES users(id) ON DELETE CASCADE,
+			CHECK ((type = 'text' AND text IS NOT NULL) OR (type = 'photo' AND photo IS NOT NULL))
+		);`,
+
+		// comments table
+		`CREATE TABLE IF NOT EXISTS comments (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			message_id INTEGER NOT NULL,
+			user_id INTEGER NOT NULL,
+			text TEXT NOT NULL,
+			timestamp DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (message_id) REFERENCES messages(id) ON DELETE CASCADE,
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+		);`,
+
+		// Index for faster comment lookups
+		`CREATE INDEX IF NOT EXISTS idx_comments_message ON comments(message_id);`,
+
+		// Index for faster message lookups
+		`CREATE INDEX IF NOT EXISTS idx_messages_conversation ON messages(conversation_id, timestamp DESC);`,
+	},
+
+	// 2: uniqueness of direct chats
+	{
+		// direct_conversations table: one row per direct chat, keyed by the ordered pair of participants so that the
+		// same two users can never share more than one 'user' conversation
+		`CREATE TABLE IF NOT EXISTS direct_conversations (
+			conversation_id INTEGER PRIMARY KEY,
+			user_low INTEGER NOT NULL,
+			user_high INTEGER NOT NULL,
+			UNIQUE (user_low, user_high),
+			FOREIGN KEY (conversation_id) REFERENCES conversations(id) ON DELETE CASCADE,
+			FOREIGN KEY (user_low) REFERENCES users(id) ON DELETE CASCADE,
+			FOREIGN KEY (user_high) REFERENCES users(id) ON DELETE CASCADE
+		);`,
+
+		// Register direct chats created before direct_conversations existed (duplicates keep the first one)
+		`INSERT OR IGNORE INTO direct_conversations (conversation_id, user_low, user_high)
+			SELECT c.id, MIN(cp.user_id), MAX(cp.user_id)
+			FROM conversations c
+			INNER JOIN conversation_participants cp ON c.id = cp.conversation_id
+			WHERE c.type = 'user'
+			GROUP BY c.id
+			HAVING COUNT(*) = 2
+			ORDER BY c.id;`,
+	},
+
+	// 3: listing the conversations of a user
+	{
+		// Index for listing the conversations of a user (the primary key starts with conversation_id)
+		`CREATE INDEX IF NOT EXISTS idx_participants_user ON conversation_participants(user_id);`,
+	},
+
+	// 4: canonical timestamps
+	{
+		// Rewrite the timestamps written before (by the driver, with the offset of the server, and by CURRENT_TIMESTAMP)
+		// in sqliteTimestampFormat. SQLite keeps only the milliseconds.
+		`UPDATE users SET created_at = strftime('%Y-%m-%d %H:%M:%f', created_at) || '000000+00:00' WHERE strftime('%Y-%m-%d %H:%M:%f', created_at) IS NOT NULL;`,
+		`UPDATE users SET updated_at = strftime('%Y-%m-%d %H:%M:%f', updated_at) || '000000+00:00' WHERE strftime('%Y-%m-%d %H:%M:%f', updated_at) IS NOT NULL;`,
+		`UPDATE conversations SET created_at = strftime('%Y-%m-%d %H:%M:%f', created_at) || '000000+00:00' WHERE strftime('%Y-%m-%d %H:%M:%f', created_at) IS NOT NULL;`,
+		`UPDATE conversations SET updated_at = strftime('%Y-%m-%d %H:%M:%f', updated_at) || '000000+00:00' WHERE strftime('%Y-%m-%d %H:%M:%f', updated_at) IS NOT NULL;`,
+		`UPDATE conversation_participants SET joined_at = strftime('%Y-%m-%d %H:%M:%f', joined_at) || '000000+00:00' WHERE strftime('%Y-%m-%d %H:%M:%f', joined_at) IS NOT NULL;`,
+		`UPDATE messages SET timestamp = strftime('%Y-%m-%d %H:%M:%f', timestamp) || '000000+00:00' WHERE strftime('%Y-%m-%d %H:%M:%f', timestamp) IS NOT NULL;`,
+		`UPDATE comments SET timestamp = strftime('%Y-%m-%d %H:%M:%f', timestamp) || '000000+00:00' WHERE strftime('%Y-%m-%d %H:%M:%f', timestamp) IS NOT NULL;`,
+	},
+
+	// 5: comment timestamps, edits and pages
+	{
+		// Thumbnail of the profile picture, filled by the application (NULL until then)
+		`ALTER TABLE users ADD COLUMN pic_thumb BLOB;`,
+		`ALTER TABLE comments ADD COLUMN edited_at DATETIME;`,
+
+		// Pages of comments are sorted by (timestamp, id)
+		`DROP INDEX IF EXISTS idx_comments_message;`,
+		`CREATE INDEX IF NOT EXISTS idx_comments_message_time ON comments(message_id, timestamp, id);`,
+	},
+
+	// 6: threads
+	{
+		// replies table: the messages of the thread of a message
+		`CREATE TABLE IF NOT EXISTS replies (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			message_id INTEGER NOT NULL,
+			sender_id INTEGER NOT NULL,
+			type TEXT NOT NULL CHECK (type IN ('text', 'photo')),
+			text TEXT,
+			photo BLOB,
+			timestamp DATETIME NOT NULL,
+			FOREIGN KEY (message_id) REFERENCES messages(id) ON DELETE CASCADE,
+			FOREIGN KEY (sender_id) REFERENCES users(id) ON DELETE CASCADE,
+			CHECK ((type = 'text' AND text IS NOT NULL) OR (type = 'photo' AND photo IS NOT NULL))
+		);`,
+		`CREATE INDEX IF NOT EXISTS idx_replies_message_time ON replies(message_id, timestamp, id);`,
+
+		// thread_subscriptions table: the users following a thread, and the last reply each of them has read
+		`CREATE TABLE IF NOT EXISTS thread_subscriptions (
+			message_id INTEGER NOT NULL,
+			user_id INTEGER NOT NULL,
+			last_read_reply_id INTEGER NOT NULL DEFAULT 0,
+			PRIMARY KEY (message_id, user_id),
+			FOREIGN KEY (message_id) REFERENCES messages(id) ON DELETE CASCADE,
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+		);`,
+		`CREATE INDEX IF NOT EXISTS idx_thread_subscriptions_user ON thread_subscriptions(user_id);`,
+	},
+
+	// 7: mentions
+	{
+		// mentions table: the participants mentioned (@username) by the text of a group message. text_offset and
+		// text_length count UTF-16 code units.
+		`CREATE TABLE IF NOT EXISTS mentions (
+			message_id INTEGER NOT NULL,
+			user_id INTEGER NOT NULL,
+			text_offset INTEGER NOT NULL,
+			text_length INTEGER NOT NULL,
+			PRIMARY KEY (message_id, text_offset),
+			FOREIGN KEY (message_id) REFERENCES messages(id) ON DELETE CASCADE,
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+		);`,
+		`CREATE INDEX IF NOT EXISTS idx_mentions_user ON mentions(user_id, message_id);`,
+	},
+
+	// 8: rich text
+	{
+		// message_entities table: the formatting of the text of a message (see the markup package), in the order of
+		// the parser. Mentions are in the mentions table.
+		`CREATE TABLE IF NOT EXISTS message_entities (
+			message_id INTEGER NOT NULL,
+			position INTEGER NOT NULL,
+			type TEXT NOT NULL CHECK (type IN ('bold', 'italic', 'code', 'pre', 'text_link', 'url')),
+			text_offset INTEGER NOT NULL,
+			text_length INTEGER NOT NULL,
+			url TEXT,
+			language TEXT,
+			PRIMARY KEY (message_id, position),
+			FOREIGN KEY (message_id) REFERENCES messages(id) ON DELETE CASCADE
+		);`,
+	},
+
+	// 9: link previews
+	{
+		// link_previews table: the preview of the first link of a message, added after the message is sent
+		`CREATE TABLE IF NOT EXISTS link_previews (
+			message_id INTEGER NOT NULL PRIMARY KEY,
+			url TEXT NOT NULL,
+			title TEXT NOT NULL,
+			description TEXT NOT NULL,
+			image TEXT NOT NULL,
+			FOREIGN KEY (message_id) REFERENCES messages(id) ON DELETE CASCADE
+		);`,
+	},
+
+	// 10: file messages
+	{
+		// The CHECK constraints of messages allow the new type, so the table is rebuilt (see prepareMigrations). The
+		// AUTOINCREMENT sequence is carried over, so that the IDs of deleted messages are not used again.
+		`CREATE TABLE messages_new (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			conversation_id INTEGER NOT NULL,
+			sender_id INTEGER NOT NULL,
+			type TEXT NOT NULL CHECK (type IN ('text', 'photo', 'file')),
+			text TEXT,
+			photo BLOB,
+			timestamp DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (conversation_id) REFERENCES conversations(id) ON DELETE CASCADE,
+			FOREIGN KEY (sender_id) REFERENCES users(id) ON DELETE CASCADE,
+			CHECK ((type = 'text' AND text IS NOT NULL) OR (type = 'photo' AND photo IS NOT NULL) OR type = 'file')
+		);`,
+		`INSERT INTO messages_new (id, conversation_id, sender_id, type, text, photo, timestamp)
+			SELECT id, conversation_id, sender_id, type, text, photo, timestamp FROM messages;`,
+		`DELETE FROM sqlite_sequence WHERE name = 'messages_new';`,
+		`INSERT INTO sqlite_sequence (name, seq) SELECT 'messages_new', seq FROM sqlite_sequence WHERE name = 'messages';`,
+		`DROP TABLE messages;`,
+		`ALTER TABLE messages_new RENAME TO messages;`,
+		`CREATE INDEX idx_messages_conversation ON messages(conversation_id, timestamp DESC);`,
+
+		// files table: the file of a "file" message
+		`CREATE TABLE IF NOT EXISTS files (
+			message_id INTEGER NOT NULL PRIMARY KEY,
+			name TEXT NOT NULL,
+			mime_type TEXT NOT NULL,
+			size INTEGER NOT NULL,
+			data BLOB NOT NULL,
+			FOREIGN KEY (message_id) REFERENCES messages(id) ON DELETE CASCADE
+		);`,
+	},
+
+	// 11: voice messages
+	{
+		// The messages table is rebuilt for the new type, as in migration 10
+		`CREATE TABLE messages_new (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			conversation_id INTEGER NOT NULL,
+			sender_id INTEGER NOT NULL,
+			type TEXT NOT NULL CHECK (type IN ('text', 'photo', 'file', 'audio')),
+			text TEXT,
+			photo BLOB,
+			timestamp DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (conversation_id) REFERENCES conversations(id) ON DELETE CASCADE,
+			FOREIGN KEY (sender_id) REFERENCES users(id) ON DELETE CASCADE,
+			CHECK ((type = 'text' AND text IS NOT NULL) OR (type = 'photo' AND photo IS NOT NULL) OR type IN ('file', 'audio'))
+		);`,
+		`INSERT INTO messages_new (id, conversation_id, sender_id, type, text, photo, timestamp)
+			SELECT id, conversation_id, sender_id, type, text, photo, timestamp FROM messages;`,
+		`DELETE FROM sqlite_sequence WHERE name = 'messages_new';`,
+		`INSERT INTO sqlite_sequence (name, seq) SELECT 'messages_new', seq FROM sqlite_sequence WHERE name = 'messages';`,
+		`DROP TABLE messages;`,
+		`ALTER TABLE messages_new RENAME TO messages;`,
+		`CREATE INDEX idx_messages_conversation ON messages(conversation_id, timestamp DESC);`,
+
+		// audio table: the recording of an "audio" message, with its duration and waveform (a byte per bar)
+		`CREATE TABLE IF NOT EXISTS audio (
+			message_id INTEGER NOT NULL PRIMARY KEY,
+			mime_type TEXT NOT NULL,
+			size INTEGER NOT NULL,
+			duration_ms INTEGER NOT NULL,
+			waveform BLOB NOT NULL,
+			data BLOB NOT NULL,
+			FOREIGN KEY (message_id) REFERENCES messages(id) ON DELETE CASCADE
+		);`,
+	},
+}
@@ -0,0 +1,96 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// dialect hides the differences between the SQL flavours supported by AppDatabase. Queries in this package are written
+// once, using '?' placeholders and the syntax shared by SQLite and PostgreSQL (INSERT ... ON CONFLICT DO NOTHING,
+// INSERT ... RETURNING id); only what cannot be shared lives here.
+type dialect interface {
+	// rebind converts a query written with '?' placeholders to the placeholder syntax of the driver
+	rebind(query string) string
+
+	// caseInsensitiveLike returns the operator used for case-insensitive pattern matching
+	caseInsensitiveLike() string
+
+	// timestamp returns the value stored for the time t, in UTC
+	timestamp(t time.Time) interface{}
+
+	// migrations returns the schema migrations of this dialect. Migration N (1-based) brings the schema to version N,
+	// and each migration is a list of statements applied in a single transaction.
+	migrations() [][]string
+
+	// prepareMigrations prepares the connection running the migrations, and returns the function restoring it
+	prepareMigrations(ctx context.Context, conn *sql.Conn) (restore func() error, err error)
+
+	// checkMigration verifies, before the commit of a migration, the constraints that prepareMigrations disabled
+	checkMigration(ctx context.Context, tx queryer) error
+}
+
+// dialectFor returns the dialect for the given database/sql driver name.
+func dialectFor(driver string) (dialect, error) {
+	switch driver {
+	case "", "sqlite3":
+		return sqliteDialect{}, nil
+	case "pgx", "postgres":
+		return postgresDialect{}, nil
+	default:
+		return nil, fmt.Errorf("unsupported database driver %q", driver)
+	}
+}
+
+// rebindDollar rewrites '?' placeholders as $1, $2, ... skipping the ones inside quoted strings and identifiers.
+func rebindDollar(query string) string {
+	var b strings.Builder
+	b.Grow(len(query) + 8)
+
+	n := 0
+	var quote rune
+	for _, r := range query {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '\'' || r == '"':
+			quote = r
+		case r == '?':
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// queryer is the subset of *sql.DB and *sql.Tx used by AppDatabase methods.
+type queryer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// rebound is a queryer that rebinds every query for the dialect before sending it to the wrapped *sql.DB or *sql.Tx.
+type rebound struct {
+	q queryer
+	d dialect
+}
+
+func (r rebound) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return r.q.ExecContext(ctx, r.d.rebind(query), args...)
+}
+
+func (r rebound) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	return r.q.QueryContext(ctx, r.d.rebind(query), args...)
+}
+
+func (r rebound) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	return r.q.QueryRowContext(ctx, r.d.rebind(query), args...)
+}
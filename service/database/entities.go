@@ -0,0 +1,84 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/val7e/wasaText/service/markup"
+	"github.com/val7e/wasaText/service/models"
+)
+
+// parseText parses the markup of the text of a new message: it returns the text to store and its formatting
+func parseText(text *string) (sql.NullString, []models.MessageEntity, error) {
+	if text == nil {
+		return sql.NullString{}, nil, nil
+	}
+	plain, entities, err := markup.Parse(*text)
+	if err != nil {
+		return sql.NullString{}, nil, fmt.Errorf("invalid markup: %w", err)
+	}
+	return sql.NullString{String: plain, Valid: true}, entities, nil
+}
+
+// insertEntities stores the formatting of the text of a message
+func insertEntities(ctx context.Context, tx queryer, messageID int64, entities []models.MessageEntity) error {
+	for i, e := range entities {
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO message_entities (message_id, position, type, text_offset, text_length, url, language)
+			VALUES (?, ?, ?, ?, ?, ?, ?)
+		`, messageID, i, e.Type, e.Offset, e.Length,
+			sql.NullString{String: e.URL, Valid: e.URL != ""},
+			sql.NullString{String: e.Language, Valid: e.Language != ""})
+		if err != nil {
+			return fmt.Errorf("error inserting entity: %w", err)
+		}
+	}
+	return nil
+}
+
+// getEntities returns the entities (formatting and mentions) of the messages `m` selected by the condition `where`,
+// sorted by markup.Sort and keyed by message ID
+func (db *appdbimpl) getEntities(ctx context.Context, where string, args ...interface{}) (map[int64][]models.MessageEntity, error) {
+	entities, err := getFormatting(ctx, db.c, where, args...)
+	if err != nil {
+		return nil, err
+	}
+	mentioned, err := db.getMentionEntities(ctx, where, args...)
+	if err != nil {
+		return nil, err
+	}
+	for id, list := range mentioned {
+		entities[id] = append(entities[id], list...)
+		markup.Sort(entities[id])
+	}
+	return entities, nil
+}
+
+// getFormatting returns the formatting of the messages `m` selected by the condition `where`, keyed by message ID
+func getFormatting(ctx context.Context, q queryer, where string, args ...interface{}) (map[int64][]models.MessageEntity, error) {
+	rows, err := q.QueryContext(ctx, `
+		SELECT e.message_id, e.type, e.text_offset, e.text_length, e.url, e.language
+		FROM message_entities e
+		INNER JOIN messages m ON e.message_id = m.id
+		WHERE `+where+`
+		ORDER BY e.message_id, e.position
+	`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error getting entities: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	entities := make(map[int64][]models.MessageEntity)
+	for rows.Next() {
+		var messageID int64
+		var entity models.MessageEntity
+		var url, language sql.NullString
+		if err := rows.Scan(&messageID, &entity.Type, &entity.Offset, &entity.Length, &url, &language); err != nil {
+			return nil, fmt.Errorf("error scanning entity: %w", err)
+		}
+		entity.URL, entity.Language = url.String, language.String
+		entities[messageID] = append(entities[messageID], entity)
+	}
+	return entities, rows.Err()
+}
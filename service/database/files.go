@@ -0,0 +1,127 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/val7e/wasaText/service/models"
+)
+
+// fileJoin adds the columns of fileColumns to the messages `m` of a query
+const fileJoin = `LEFT JOIN files f ON f.message_id = m.id`
+
+// fileColumns are scanned in the fields of a fileScan, in order
+const fileColumns = `f.name, f.mime_type, f.size`
+
+// fileScan holds the file columns of a message, NULL if it is not a file message
+type fileScan struct {
+	name, mimeType sql.NullString
+	size           sql.NullInt64
+}
+
+func (s *fileScan) file() *models.File {
+	if !s.name.Valid {
+		return nil
+	}
+	return &models.File{Name: s.name.String, MimeType: s.mimeType.String, Size: s.size.Int64}
+}
+
+// previewOf returns the SQL expression of the preview of the message `m` (the alias of the messages table): the
+// text, "File: " and the name of the file, "Voice message (m:ss)", or "Photo"
+func previewOf(m string) string {
+	return `COALESCE(` + m + `.text,
+		(SELECT 'File: ' || pf.name FROM files pf WHERE pf.message_id = ` + m + `.id),
+		(SELECT ` + audioPreview + ` FROM audio pa WHERE pa.message_id = ` + m + `.id),
+		'Photo')`
+}
+
+// SendFile sends a file message in a conversation. The name and the type of the file are stored as given.
+func (db *appdbimpl) SendFile(ctx context.Context, conversationID, senderID int64, file models.NewFile) (*models.Message, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	var messageID int64
+	err := db.withTx(ctx, func(tx queryer) error {
+		var participantCount int
+		err := tx.QueryRowContext(ctx, `
+			SELECT COUNT(*) FROM conversation_participants
+			WHERE conversation_id = ? AND user_id = ?
+		`, conversationID, senderID).Scan(&participantCount)
+		if err != nil || participantCount == 0 {
+			return fmt.Errorf("user not participant in conversation")
+		}
+
+		err = tx.QueryRowContext(ctx, `
+			INSERT INTO messages (conversation_id, sender_id, type, timestamp)
+			VALUES (?, ?, 'file', ?)
+			RETURNING id
+		`, conversationID, senderID, db.now()).Scan(&messageID)
+		if err != nil {
+			return fmt.Errorf("error sending message: %w", err)
+		}
+
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO files (message_id, name, mime_type, size, data) VALUES (?, ?, ?, ?, ?)
+		`, messageID, file.Name, file.MimeType, len(file.Data), file.Data)
+		if err != nil {
+			return fmt.Errorf("error storing file: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return db.getMessageByID(ctx, messageID)
+}
+
+// GetFile returns the file of the message messageID of a conversation of the user, and its content.
+func (db *appdbimpl) GetFile(ctx context.Context, messageID, conversationID, userID int64) (*models.File, []byte, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	var participantCount int
+	err := db.c.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM conversation_participants
+		WHERE conversation_id = ? AND user_id = ?
+	`, conversationID, userID).Scan(&participantCount)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error checking participant: %w", err)
+	}
+	if participantCount == 0 {
+		return nil, nil, fmt.Errorf("user not participant in conversation")
+	}
+
+	var file fileScan
+	var data []byte
+	err = db.c.QueryRowContext(ctx, `
+		SELECT `+fileColumns+`, f.data
+		FROM messages m
+		`+fileJoin+`
+		WHERE m.id = ? AND m.conversation_id = ?
+	`, messageID, conversationID).Scan(&file.name, &file.mimeType, &file.size, &data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil, fmt.Errorf("message not found")
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("error getting file: %w", err)
+	}
+	if !file.name.Valid {
+		return nil, nil, fmt.Errorf("message has no file")
+	}
+	return file.file(), data, nil
+}
+
+// copyFile gives the file of a message to its forwarded copy
+func copyFile(ctx context.Context, tx queryer, messageID, copyID int64) error {
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO files (message_id, name, mime_type, size, data)
+		SELECT ?, name, mime_type, size, data FROM files WHERE message_id = ?
+	`, copyID, messageID)
+	if err != nil {
+		return fmt.Errorf("error copying file: %w", err)
+	}
+	return nil
+}
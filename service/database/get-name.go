@@ -1,8 +1,13 @@
 package database
 
+import "context"
+
 // GetName is an example that shows you how to query data
-func (db *appdbimpl) GetName() (string, error) {
+func (db *appdbimpl) GetName(ctx context.Context) (string, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
 	var name string
-	err := db.c.QueryRow("SELECT name FROM example_table WHERE id=1").Scan(&name)
+	err := db.c.QueryRowContext(ctx, "SELECT name FROM example_table WHERE id=1").Scan(&name)
 	return name, err
 }
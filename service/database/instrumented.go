@@ -0,0 +1,329 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/val7e/wasaText/service/metrics"
+	"github.com/val7e/wasaText/service/models"
+	"github.com/val7e/wasaText/service/tracing"
+)
+
+// InstrumentConfig is used to provide the instruments to NewInstrumented. All of them are optional.
+type InstrumentConfig struct {
+	// Metrics is the registry where the call metrics are recorded:
+	//   - db_call_duration_seconds{method}: histogram of the call durations, errors included
+	//   - db_call_errors_total{method}: number of calls that returned an error
+	Metrics *metrics.Registry
+
+	// Tracer records a span for each call, child of the span in the context passed to the method
+	Tracer *tracing.Tracer
+}
+
+// instrumented is an AppDatabase recording the duration and the outcome of every call to the AppDatabase it wraps.
+type instrumented struct {
+	next AppDatabase
+
+	duration *metrics.HistogramVec
+	errors   *metrics.CounterVec
+	tracer   *tracing.Tracer
+}
+
+// NewInstrumented returns an AppDatabase that forwards every call to `db`, recording metrics and trace spans as
+// configured in `cfg`.
+func NewInstrumented(db AppDatabase, cfg InstrumentConfig) AppDatabase {
+	i := &instrumented{
+		next:   db,
+		tracer: cfg.Tracer,
+	}
+	if cfg.Metrics != nil {
+		i.duration = cfg.Metrics.NewHistogramVec("db_call_duration_seconds", "Duration of the AppDatabase calls.", nil, "method")
+		i.errors = cfg.Metrics.NewCounterVec("db_call_errors_total", "AppDatabase calls that returned an error.", "method")
+	}
+	return i
+}
+
+// start begins recording a call of `method`. The returned context carries the span of the call, and the returned
+// function must be called with the outcome of the call when it ends.
+func (db *instrumented) start(ctx context.Context, method string) (context.Context, func(err error)) {
+	start := time.Now()
+	ctx, span := db.tracer.Start(ctx, "AppDatabase."+method, tracing.SpanKindClient)
+	span.SetAttribute("db.operation", method)
+
+	return ctx, func(err error) {
+		if db.duration != nil {
+			db.duration.Observe(time.Since(start).Seconds(), method)
+			if err != nil {
+				db.errors.Inc(method)
+			}
+		}
+		span.SetError(err)
+		span.End()
+	}
+}
+
+func (db *instrumented) GetName(ctx context.Context) (string, error) {
+	ctx, done := db.start(ctx, "GetName")
+	name, err := db.next.GetName(ctx)
+	done(err)
+	return name, err
+}
+
+func (db *instrumented) SetName(ctx context.Context, name string) error {
+	ctx, done := db.start(ctx, "SetName")
+	err := db.next.SetName(ctx, name)
+	done(err)
+	return err
+}
+
+func (db *instrumented) Ping(ctx context.Context) error {
+	ctx, done := db.start(ctx, "Ping")
+	err := db.next.Ping(ctx)
+	done(err)
+	return err
+}
+
+func (db *instrumented) DoLogin(ctx context.Context, username string) (*models.User, bool, error) {
+	ctx, done := db.start(ctx, "DoLogin")
+	user, created, err := db.next.DoLogin(ctx, username)
+	done(err)
+	return user, created, err
+}
+
+func (db *instrumented) SearchUser(ctx context.Context, query string) ([]models.User, error) {
+	ctx, done := db.start(ctx, "SearchUser")
+	users, err := db.next.SearchUser(ctx, query)
+	done(err)
+	return users, err
+}
+
+func (db *instrumented) SetMyUserName(ctx context.Context, userID int64, newUsername string) (*models.User, error) {
+	ctx, done := db.start(ctx, "SetMyUserName")
+	user, err := db.next.SetMyUserName(ctx, userID, newUsername)
+	done(err)
+	return user, err
+}
+
+func (db *instrumented) SetMyPhoto(ctx context.Context, userID int64, newPic string) (*models.User, error) {
+	ctx, done := db.start(ctx, "SetMyPhoto")
+	user, err := db.next.SetMyPhoto(ctx, userID, newPic)
+	done(err)
+	return user, err
+}
+
+func (db *instrumented) GetUserByID(ctx context.Context, userID int64) (*models.User, error) {
+	ctx, done := db.start(ctx, "GetUserByID")
+	user, err := db.next.GetUserByID(ctx, userID)
+	done(err)
+	return user, err
+}
+
+func (db *instrumented) GetUserByUsername(ctx context.Context, username string) (*models.User, error) {
+	ctx, done := db.start(ctx, "GetUserByUsername")
+	user, err := db.next.GetUserByUsername(ctx, username)
+	done(err)
+	return user, err
+}
+
+func (db *instrumented) GetMyConversations(ctx context.Context, userID int64) ([]models.ConversationSummary, error) {
+	ctx, done := db.start(ctx, "GetMyConversations")
+	convs, err := db.next.GetMyConversations(ctx, userID)
+	done(err)
+	return convs, err
+}
+
+func (db *instrumented) GetConversation(ctx context.Context, conversationID int64, userID int64) (*models.Conversation, error) {
+	ctx, done := db.start(ctx, "GetConversation")
+	conv, err := db.next.GetConversation(ctx, conversationID, userID)
+	done(err)
+	return conv, err
+}
+
+func (db *instrumented) StartConversation(ctx context.Context, senderID int64, recipientUsername string) (*models.Conversation, error) {
+	ctx, done := db.start(ctx, "StartConversation")
+	conv, err := db.next.StartConversation(ctx, senderID, recipientUsername)
+	done(err)
+	return conv, err
+}
+
+func (db *instrumented) GetParticipantIDs(ctx context.Context, conversationID int64) ([]int64, error) {
+	ctx, done := db.start(ctx, "GetParticipantIDs")
+	ids, err := db.next.GetParticipantIDs(ctx, conversationID)
+	done(err)
+	return ids, err
+}
+
+func (db *instrumented) CreateGroup(ctx context.Context, creatorID int64, name string) (*models.Group, error) {
+	ctx, done := db.start(ctx, "CreateGroup")
+	group, err := db.next.CreateGroup(ctx, creatorID, name)
+	done(err)
+	return group, err
+}
+
+func (db *instrumented) GetGroup(ctx context.Context, groupID int64) (*models.Group, error) {
+	ctx, done := db.start(ctx, "GetGroup")
+	group, err := db.next.GetGroup(ctx, groupID)
+	done(err)
+	return group, err
+}
+
+func (db *instrumented) SetGroupName(ctx context.Context, groupID int64, name string) (*models.Group, error) {
+	ctx, done := db.start(ctx, "SetGroupName")
+	group, err := db.next.SetGroupName(ctx, groupID, name)
+	done(err)
+	return group, err
+}
+
+func (db *instrumented) SetGroupPhoto(ctx context.Context, groupID int64, photo string) (*models.Group, error) {
+	ctx, done := db.start(ctx, "SetGroupPhoto")
+	group, err := db.next.SetGroupPhoto(ctx, groupID, photo)
+	done(err)
+	return group, err
+}
+
+func (db *instrumented) AddToGroup(ctx context.Context, groupID int64, memberUsernames []string) (*models.Group, error) {
+	ctx, done := db.start(ctx, "AddToGroup")
+	group, err := db.next.AddToGroup(ctx, groupID, memberUsernames)
+	done(err)
+	return group, err
+}
+
+func (db *instrumented) LeaveGroup(ctx context.Context, groupID int64, userID int64) error {
+	ctx, done := db.start(ctx, "LeaveGroup")
+	err := db.next.LeaveGroup(ctx, groupID, userID)
+	done(err)
+	return err
+}
+
+func (db *instrumented) SendMessage(ctx context.Context, conversationID int64, senderID int64, message models.NewMessage) (*models.Message, error) {
+	ctx, done := db.start(ctx, "SendMessage")
+	msg, err := db.next.SendMessage(ctx, conversationID, senderID, message)
+	done(err)
+	return msg, err
+}
+
+func (db *instrumented) ForwardMessage(ctx context.Context, messageID, recipientConversationID int64, authorID int64) (*models.Message, error) {
+	ctx, done := db.start(ctx, "ForwardMessage")
+	msg, err := db.next.ForwardMessage(ctx, messageID, recipientConversationID, authorID)
+	done(err)
+	return msg, err
+}
+
+func (db *instrumented) DeleteMessage(ctx context.Context, messageID, conversationID int64, userID int64) error {
+	ctx, done := db.start(ctx, "DeleteMessage")
+	err := db.next.DeleteMessage(ctx, messageID, conversationID, userID)
+	done(err)
+	return err
+}
+
+func (db *instrumented) CommentMessage(ctx context.Context, messageID, conversationID int64, authorID int64, comment models.NewComment) (*models.Comment, error) {
+	ctx, done := db.start(ctx, "CommentMessage")
+	c, err := db.next.CommentMessage(ctx, messageID, conversationID, authorID, comment)
+	done(err)
+	return c, err
+}
+
+func (db *instrumented) EditComment(ctx context.Context, commentID, messageID, conversationID int64, userID int64, comment models.NewComment) (*models.Comment, error) {
+	ctx, done := db.start(ctx, "EditComment")
+	c, err := db.next.EditComment(ctx, commentID, messageID, conversationID, userID, comment)
+	done(err)
+	return c, err
+}
+
+func (db *instrumented) UncommentMessage(ctx context.Context, messageID, conversationID int64, userID int64) error {
+	ctx, done := db.start(ctx, "UncommentMessage")
+	err := db.next.UncommentMessage(ctx, messageID, conversationID, userID)
+	done(err)
+	return err
+}
+
+func (db *instrumented) GetComments(ctx context.Context, messageID, conversationID int64, userID int64, after int64, limit int) ([]models.Comment, error) {
+	ctx, done := db.start(ctx, "GetComments")
+	comments, err := db.next.GetComments(ctx, messageID, conversationID, userID, after, limit)
+	done(err)
+	return comments, err
+}
+
+func (db *instrumented) ReplyToMessage(ctx context.Context, messageID, conversationID int64, senderID int64, reply models.NewMessage) (*models.Reply, error) {
+	ctx, done := db.start(ctx, "ReplyToMessage")
+	r, err := db.next.ReplyToMessage(ctx, messageID, conversationID, senderID, reply)
+	done(err)
+	return r, err
+}
+
+func (db *instrumented) GetReplies(ctx context.Context, messageID, conversationID int64, userID int64, after int64, limit int) ([]models.Reply, error) {
+	ctx, done := db.start(ctx, "GetReplies")
+	replies, err := db.next.GetReplies(ctx, messageID, conversationID, userID, after, limit)
+	done(err)
+	return replies, err
+}
+
+func (db *instrumented) SubscribeThread(ctx context.Context, messageID, conversationID int64, userID int64) error {
+	ctx, done := db.start(ctx, "SubscribeThread")
+	err := db.next.SubscribeThread(ctx, messageID, conversationID, userID)
+	done(err)
+	return err
+}
+
+func (db *instrumented) UnsubscribeThread(ctx context.Context, messageID, conversationID int64, userID int64) error {
+	ctx, done := db.start(ctx, "UnsubscribeThread")
+	err := db.next.UnsubscribeThread(ctx, messageID, conversationID, userID)
+	done(err)
+	return err
+}
+
+func (db *instrumented) MarkThreadRead(ctx context.Context, messageID, conversationID int64, userID int64, replyID int64) error {
+	ctx, done := db.start(ctx, "MarkThreadRead")
+	err := db.next.MarkThreadRead(ctx, messageID, conversationID, userID, replyID)
+	done(err)
+	return err
+}
+
+func (db *instrumented) GetMyThreads(ctx context.Context, userID int64) ([]models.ThreadSummary, error) {
+	ctx, done := db.start(ctx, "GetMyThreads")
+	threads, err := db.next.GetMyThreads(ctx, userID)
+	done(err)
+	return threads, err
+}
+
+func (db *instrumented) GetMyMentions(ctx context.Context, userID int64, before int64, limit int) ([]models.Mention, error) {
+	ctx, done := db.start(ctx, "GetMyMentions")
+	mentions, err := db.next.GetMyMentions(ctx, userID, before, limit)
+	done(err)
+	return mentions, err
+}
+
+func (db *instrumented) SetLinkPreview(ctx context.Context, messageID int64, preview models.LinkPreview) error {
+	ctx, done := db.start(ctx, "SetLinkPreview")
+	err := db.next.SetLinkPreview(ctx, messageID, preview)
+	done(err)
+	return err
+}
+
+func (db *instrumented) SendFile(ctx context.Context, conversationID, senderID int64, file models.NewFile) (*models.Message, error) {
+	ctx, done := db.start(ctx, "SendFile")
+	msg, err := db.next.SendFile(ctx, conversationID, senderID, file)
+	done(err)
+	return msg, err
+}
+
+func (db *instrumented) GetFile(ctx context.Context, messageID, conversationID, userID int64) (*models.File, []byte, error) {
+	ctx, done := db.start(ctx, "GetFile")
+	file, data, err := db.next.GetFile(ctx, messageID, conversationID, userID)
+	done(err)
+	return file, data, err
+}
+
+func (db *instrumented) SendAudio(ctx context.Context, conversationID, senderID int64, audio models.NewAudio) (*models.Message, error) {
+	ctx, done := db.start(ctx, "SendAudio")
+	msg, err := db.next.SendAudio(ctx, conversationID, senderID, audio)
+	done(err)
+	return msg, err
+}
+
+func (db *instrumented) GetAudio(ctx context.Context, messageID, conversationID, userID int64) (*models.Audio, []byte, error) {
+	ctx, done := db.start(ctx, "GetAudio")
+	audio, data, err := db.next.GetAudio(ctx, messageID, conversationID, userID)
+	done(err)
+	return audio, data, err
+}
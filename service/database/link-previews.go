@@ -0,0 +1,66 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/val7e/wasaText/service/models"
+)
+
+// linkPreviewJoin adds the columns of linkPreviewColumns to the messages `m` of a query
+const linkPreviewJoin = `LEFT JOIN link_previews lp ON lp.message_id = m.id`
+
+// linkPreviewColumns are scanned in the fields of a linkPreviewScan, in order
+const linkPreviewColumns = `lp.url, lp.title, lp.description, lp.image`
+
+// linkPreviewScan holds the link preview columns of a message, NULL if it has no preview
+type linkPreviewScan struct {
+	url, title, description, image sql.NullString
+}
+
+func (s *linkPreviewScan) preview() *models.LinkPreview {
+	if !s.url.Valid {
+		return nil
+	}
+	return &models.LinkPreview{URL: s.url.String, Title: s.title.String, Description: s.description.String,
+		Image: s.image.String}
+}
+
+// SetLinkPreview stores the preview of the link of a message, replacing the previous one.
+func (db *appdbimpl) SetLinkPreview(ctx context.Context, messageID int64, preview models.LinkPreview) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	return db.withTx(ctx, func(tx queryer) error {
+		var count int
+		if err := tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM messages WHERE id = ?", messageID).Scan(&count); err != nil {
+			return fmt.Errorf("error finding message: %w", err)
+		}
+		if count == 0 {
+			return fmt.Errorf("message not found")
+		}
+
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO link_previews (message_id, url, title, description, image) VALUES (?, ?, ?, ?, ?)
+			ON CONFLICT (message_id) DO UPDATE
+			SET url = excluded.url, title = excluded.title, description = excluded.description, image = excluded.image
+		`, messageID, preview.URL, preview.Title, preview.Description, preview.Image)
+		if err != nil {
+			return fmt.Errorf("error storing link preview: %w", err)
+		}
+		return nil
+	})
+}
+
+// copyLinkPreview gives the preview of a message to its forwarded copy
+func copyLinkPreview(ctx context.Context, tx queryer, messageID, copyID int64) error {
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO link_previews (message_id, url, title, description, image)
+		SELECT ?, url, title, description, image FROM link_previews WHERE message_id = ?
+	`, copyID, messageID)
+	if err != nil {
+		return fmt.Errorf("error copying link preview: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,295 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+	"github.com/val7e/wasaText/service/models"
+)
+
+// The listing benchmarks run on a SQLite database seeded once per test binary with:
+//   - benchUsers users;
+//   - benchConversations conversations of user 1, half of them direct and half groups of five, with five messages
+//     each;
+//   - benchLongMessages messages in conversation 1, one in two commented by three users.
+//
+// Besides the latency, they report the statements sent to the driver by each call as queries/op. The baseline of
+// GetMyConversations is the per-row listing it replaced, BenchmarkGetMyConversationsPerRow; on a local SQLite file,
+// where a statement costs no round trip, the two take about the same time, and the batched listing also builds the
+// previews of files and audio messages:
+//
+//	BenchmarkGetMyConversationsPerRow   13.4 ms/op   1001 queries/op   1.5 MB/op   50043 allocs/op
+//	BenchmarkGetMyConversations         15.1 ms/op      2 queries/op   2.8 MB/op   70603 allocs/op
+//
+// With PostgreSQL every statement is a round trip to the server, 1000 of them for the per-row listing.
+const (
+	benchUsers         = 3000
+	benchConversations = 2000
+	benchLongMessages  = 3000
+)
+
+// countingDriver is the name of the SQLite driver counting the statements it runs in queries
+const countingDriver = "sqlite3-counting"
+
+var queries int64
+
+var (
+	benchOnce sync.Once
+	benchDir  string
+	benchErr  error
+)
+
+type countingSQLite struct{ sqlite3.SQLiteDriver }
+
+func (d *countingSQLite) Open(name string) (driver.Conn, error) {
+	conn, err := d.SQLiteDriver.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &countingConn{conn.(*sqlite3.SQLiteConn)}, nil
+}
+
+type countingConn struct{ *sqlite3.SQLiteConn }
+
+func (c *countingConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	atomic.AddInt64(&queries, 1)
+	return c.SQLiteConn.QueryContext(ctx, query, args)
+}
+
+func (c *countingConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	atomic.AddInt64(&queries, 1)
+	return c.SQLiteConn.ExecContext(ctx, query, args)
+}
+
+func (c *countingConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	atomic.AddInt64(&queries, 1)
+	return c.SQLiteConn.PrepareContext(ctx, query)
+}
+
+func TestMain(m *testing.M) {
+	code := m.Run()
+	if benchDir != "" {
+		_ = os.RemoveAll(benchDir)
+	}
+	os.Exit(code)
+}
+
+// openListing returns the seeded database, creating it on the first call
+func openListing(b testing.TB) *appdbimpl {
+	benchOnce.Do(func() {
+		sql.Register(countingDriver, &countingSQLite{})
+		benchDir, benchErr = os.MkdirTemp("", "wasatext-bench")
+		if benchErr == nil {
+			benchErr = seedListing(filepath.Join(benchDir, "wasatext.db"))
+		}
+	})
+	if benchErr != nil {
+		b.Fatal(benchErr)
+	}
+
+	conn, err := sql.Open(countingDriver, filepath.Join(benchDir, "wasatext.db")+"?_foreign_keys=true&_journal_mode=WAL")
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { _ = conn.Close() })
+	db, err := New(conn, Config{Driver: "sqlite3"})
+	if err != nil {
+		b.Fatal(err)
+	}
+	return db.(*appdbimpl)
+}
+
+// seedListing creates the database of the listing benchmarks at path, inserting the rows directly
+func seedListing(path string) error {
+	conn, err := sql.Open("sqlite3", path+"?_foreign_keys=true&_journal_mode=WAL")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = conn.Close() }()
+	if _, err := New(conn, Config{}); err != nil {
+		return err
+	}
+
+	tx, err := conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	exec := func(query string, args ...interface{}) {
+		if err == nil {
+			_, err = tx.Exec(query, args...)
+		}
+	}
+	for i := 1; i <= benchUsers; i++ {
+		exec("INSERT INTO users (id, username, pic) VALUES (?, ?, x'00')", i, fmt.Sprintf("user%d", i))
+	}
+
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	messageID := 0
+	for c := 1; c <= benchConversations; c++ {
+		members := []int{1, 1 + c%(benchUsers-1) + 1}
+		if c <= benchConversations/2 {
+			exec("INSERT INTO conversations (id, type, name) VALUES (?, 'user', ?)", c, fmt.Sprintf("c%d", c))
+			exec("INSERT INTO direct_conversations VALUES (?, ?, ?)", c, members[0], members[1])
+		} else {
+			exec("INSERT INTO conversations (id, type, name) VALUES (?, 'group', ?)", c, fmt.Sprintf("c%d", c))
+			members = append(members, 3+c%500, 4+c%700, 5+c%900)
+		}
+		for _, m := range members {
+			exec("INSERT OR IGNORE INTO conversation_participants (conversation_id, user_id) VALUES (?, ?)", c, m)
+		}
+
+		n := 5
+		if c == 1 {
+			n = benchLongMessages
+		}
+		for k := 0; k < n; k++ {
+			messageID++
+			ts := start.Add(time.Duration(messageID) * time.Second)
+			exec("INSERT INTO messages (id, conversation_id, sender_id, type, text, timestamp) VALUES (?, ?, ?, 'text', ?, ?)",
+				messageID, c, members[k%len(members)], fmt.Sprintf("message %d", messageID), ts)
+			if c == 1 && k%2 == 0 {
+				for u := 1; u <= 3; u++ {
+					exec("INSERT INTO comments (message_id, user_id, text, timestamp) VALUES (?, ?, 'ok', ?)", messageID, u, ts)
+				}
+			}
+		}
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// benchmarkQueries runs f b.N times, reporting the statements it runs as queries/op
+func benchmarkQueries(b *testing.B, f func(ctx context.Context) error) {
+	ctx := context.Background()
+	b.ReportAllocs()
+	b.ResetTimer()
+	atomic.StoreInt64(&queries, 0)
+	for i := 0; i < b.N; i++ {
+		if err := f(ctx); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.StopTimer()
+	b.ReportMetric(float64(atomic.LoadInt64(&queries))/float64(b.N), "queries/op")
+}
+
+func BenchmarkGetMyConversations(b *testing.B) {
+	db := openListing(b)
+	benchmarkQueries(b, func(ctx context.Context) error {
+		// the list is limited to the 1000 most recent conversations
+		conversations, err := db.GetMyConversations(ctx, 1)
+		if err == nil && len(conversations) != 1000 {
+			err = fmt.Errorf("got %d conversations, want 1000", len(conversations))
+		}
+		return err
+	})
+}
+
+// getMyConversationsPerRow is GetMyConversations before the participants were loaded in a single statement: the last
+// message is found by two correlated subqueries, and the participants are queried for each conversation
+func getMyConversationsPerRow(ctx context.Context, db *appdbimpl, userID int64) ([]models.ConversationSummary, error) {
+	rows, err := db.c.QueryContext(ctx, `
+		SELECT DISTINCT
+			c.id,
+			c.type,
+			c.name,
+			c.convo_pic,
+			(SELECT m.timestamp
+			 FROM messages m
+			 WHERE m.conversation_id = c.id
+			 ORDER BY m.timestamp DESC
+			 LIMIT 1) as last_message_timestamp,
+			(SELECT COALESCE(m.text, 'Photo')
+			 FROM messages m
+			 WHERE m.conversation_id = c.id
+			 ORDER BY m.timestamp DESC
+			 LIMIT 1) as last_message_preview
+		FROM conversations c
+		INNER JOIN conversation_participants cp ON c.id = cp.conversation_id
+		WHERE cp.user_id = ?
+		ORDER BY last_message_timestamp DESC NULLS LAST
+		LIMIT 1000
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	var conversations []models.ConversationSummary
+	for rows.Next() {
+		var conv models.ConversationSummary
+		var lastMsgTimestamp, lastMsgPreview sql.NullString
+		if err := rows.Scan(&conv.Id, &conv.Type, &conv.Name, &conv.ConvoPic, &lastMsgTimestamp, &lastMsgPreview); err != nil {
+			return nil, err
+		}
+		if conv.Participants, err = db.getConversationParticipants(ctx, conv.Id); err != nil {
+			return nil, err
+		}
+		if lastMsgTimestamp.Valid && lastMsgPreview.Valid {
+			timestamp, _ := time.Parse(time.RFC3339, lastMsgTimestamp.String)
+			conv.LastMessage = &models.MessagePreview{Timestamp: timestamp, Preview: lastMsgPreview.String}
+		}
+		conversations = append(conversations, conv)
+	}
+	return conversations, rows.Err()
+}
+
+func BenchmarkGetMyConversationsPerRow(b *testing.B) {
+	db := openListing(b)
+	benchmarkQueries(b, func(ctx context.Context) error {
+		conversations, err := getMyConversationsPerRow(ctx, db, 1)
+		if err == nil && len(conversations) != 1000 {
+			err = fmt.Errorf("got %d conversations, want 1000", len(conversations))
+		}
+		return err
+	})
+}
+
+// TestGetMyConversationsQueries checks that the statements run by GetMyConversations don't grow with the conversations:
+// one for the list, and one for the participants of all of them
+func TestGetMyConversationsQueries(t *testing.T) {
+	db := openListing(t)
+	atomic.StoreInt64(&queries, 0)
+	conversations, err := db.GetMyConversations(context.Background(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt64(&queries); n > 2 {
+		t.Errorf("got %d queries for %d conversations, want at most 2", n, len(conversations))
+	}
+}
+
+func BenchmarkGetConversation(b *testing.B) {
+	db := openListing(b)
+	benchmarkQueries(b, func(ctx context.Context) error {
+		conversation, err := db.GetConversation(ctx, 1, 1)
+		if err == nil && len(conversation.Messages) != benchLongMessages {
+			err = fmt.Errorf("got %d messages, want %d", len(conversation.Messages), benchLongMessages)
+		}
+		return err
+	})
+}
+
+func BenchmarkGetConversationCommentAuthors(b *testing.B) {
+	db := openListing(b)
+	benchmarkQueries(b, func(ctx context.Context) error {
+		authors, err := db.getConversationCommentAuthors(ctx, 1)
+		if err == nil && len(authors) != benchLongMessages/2 {
+			err = fmt.Errorf("got the authors of %d messages, want %d", len(authors), benchLongMessages/2)
+		}
+		return err
+	})
+}
@@ -0,0 +1,75 @@
+package memdb
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/val7e/wasaText/service/models"
+)
+
+func (db *memdb) SendAudio(ctx context.Context, conversationID, senderID int64, a models.NewAudio) (*models.Message, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if !db.isParticipant(conversationID, senderID) {
+		return nil, fmt.Errorf("user not participant in conversation")
+	}
+	m, err := db.insertMessage(conversationID, senderID, "audio", nil, nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error sending message: %w", err)
+	}
+	m.audio = &audio{
+		mimeType:   a.MimeType,
+		durationMs: a.Duration.Milliseconds(),
+		waveform:   storedWaveform(a.Waveform),
+		data:       append([]byte(nil), a.Data...),
+	}
+	return db.getMessage(m.id)
+}
+
+func (db *memdb) GetAudio(ctx context.Context, messageID, conversationID, userID int64) (*models.Audio, []byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if !db.isParticipant(conversationID, userID) {
+		return nil, nil, fmt.Errorf("user not participant in conversation")
+	}
+	m, ok := db.messages[messageID]
+	if !ok || m.conversationID != conversationID {
+		return nil, nil, fmt.Errorf("message not found")
+	}
+	if m.audio == nil {
+		return nil, nil, fmt.Errorf("message has no audio")
+	}
+	return m.audio.model(), append([]byte(nil), m.audio.data...), nil
+}
+
+func (a *audio) model() *models.Audio {
+	return &models.Audio{
+		MimeType:   a.mimeType,
+		Size:       int64(len(a.data)),
+		DurationMs: a.durationMs,
+		Waveform:   append([]int{}, a.waveform...),
+	}
+}
+
+// storedWaveform clamps the bars to a byte each, as the SQL database stores them
+func storedWaveform(bars []int) []int {
+	stored := make([]int, len(bars))
+	for i, v := range bars {
+		switch {
+		case v < 0:
+			v = 0
+		case v > 255:
+			v = 255
+		}
+		stored[i] = v
+	}
+	return stored
+}
@@ -0,0 +1,227 @@
+package memdb
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"sort"
+
+	"github.com/val7e/wasaText/service/models"
+)
+
+// conversationsLimit is the maximum number of conversations returned by GetMyConversations
+const conversationsLimit = 1000
+
+func (db *memdb) GetMyConversations(ctx context.Context, userID int64) ([]models.ConversationSummary, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	var conversations = []models.ConversationSummary{}
+	last := make(map[int64]*message)
+	for _, c := range db.conversations {
+		if _, ok := c.participants[userID]; !ok {
+			continue
+		}
+		conv := models.ConversationSummary{
+			Id:           c.id,
+			Type:         c.typ,
+			Name:         copyString(c.name),
+			ConvoPic:     copyString(c.pic),
+			Participants: db.usernamesOf(c.participantIDs()),
+		}
+		if m := db.lastMessage(c.id); m != nil {
+			conv.LastMessage = &models.MessagePreview{Timestamp: m.timestamp, Preview: messagePreview(m)}
+			last[c.id] = m
+		}
+		conversations = append(conversations, conv)
+	}
+
+	// The most recent first (by the identifier of the last message for equal times), conversations without messages
+	// last
+	sort.Slice(conversations, func(i, j int) bool {
+		a, b := last[conversations[i].Id], last[conversations[j].Id]
+		switch {
+		case a != nil && b != nil && !a.timestamp.Equal(b.timestamp):
+			return a.timestamp.After(b.timestamp)
+		case a != nil && b != nil && a.id != b.id:
+			return a.id > b.id
+		case (a == nil) != (b == nil):
+			return a != nil
+		}
+		return conversations[i].Id < conversations[j].Id
+	})
+	if len(conversations) > conversationsLimit {
+		conversations = conversations[:conversationsLimit]
+	}
+	return conversations, nil
+}
+
+func (db *memdb) GetConversation(ctx context.Context, conversationID int64, userID int64) (*models.Conversation, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	return db.getConversation(conversationID, userID)
+}
+
+func (db *memdb) StartConversation(ctx context.Context, senderID int64, recipientUsername string) (*models.Conversation, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	recipientID, ok := db.usernames[recipientUsername]
+	if !ok {
+		return nil, fmt.Errorf("recipient user not found")
+	}
+
+	key := directKey{low: senderID, high: recipientID}
+	if key.low > key.high {
+		key.low, key.high = key.high, key.low
+	}
+	convID, ok := db.direct[key]
+	if !ok {
+		// The SQL implementation fails on the constraints of the participants table
+		if _, ok := db.users[senderID]; !ok {
+			return nil, fmt.Errorf("error adding participants: user %d not found", senderID)
+		}
+		if senderID == recipientID {
+			return nil, fmt.Errorf("error adding participants: duplicate participant")
+		}
+
+		db.seq.conversations++
+		convID = db.seq.conversations
+		db.conversations[convID] = &conversation{
+			id:           convID,
+			typ:          "user",
+			participants: map[int64]struct{}{senderID: {}, recipientID: {}},
+		}
+		db.direct[key] = convID
+	}
+
+	return db.getConversation(convID, senderID)
+}
+
+func (db *memdb) GetParticipantIDs(ctx context.Context, conversationID int64) ([]int64, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	c, ok := db.conversations[conversationID]
+	if !ok {
+		return nil, fmt.Errorf("conversation not found")
+	}
+	return c.participantIDs(), nil
+}
+
+func (db *memdb) getConversation(conversationID, userID int64) (*models.Conversation, error) {
+	// Only check participation if userID is provided (not 0)
+	if userID != 0 && !db.isParticipant(conversationID, userID) {
+		return nil, fmt.Errorf("user not participant in conversation")
+	}
+
+	c, ok := db.conversations[conversationID]
+	if !ok {
+		return nil, fmt.Errorf("conversation not found")
+	}
+	return &models.Conversation{
+		Id:           c.id,
+		Name:         copyString(c.name),
+		Type:         c.typ,
+		Participants: db.usernamesOf(c.participantIDs()),
+		ConvoPic:     copyString(c.pic),
+		Messages:     db.conversationMessages(c.id),
+	}, nil
+}
+
+// conversationMessages returns the messages of a conversation, the oldest first, with the authors of their comments
+// in the order of their first comment
+func (db *memdb) conversationMessages(conversationID int64) []models.Message {
+	msgs := db.messagesOf(conversationID)
+	sort.Slice(msgs, func(i, j int) bool {
+		if !msgs[i].timestamp.Equal(msgs[j].timestamp) {
+			return msgs[i].timestamp.Before(msgs[j].timestamp)
+		}
+		return msgs[i].id < msgs[j].id
+	})
+
+	var messages = []models.Message{}
+	for _, m := range msgs {
+		comments := db.commentsOf(m.id)
+		sort.Slice(comments, func(i, j int) bool { return comments[i].id < comments[j].id })
+
+		authors := []string{}
+		seen := make(map[string]bool)
+		for _, c := range comments {
+			if u, ok := db.users[c.userID]; ok && !seen[u.username] {
+				seen[u.username] = true
+				authors = append(authors, u.username)
+			}
+		}
+
+		msg := db.messageModel(m)
+		msg.CommentsCount = len(comments)
+		msg.CommentsAuthors = authors
+		messages = append(messages, msg)
+	}
+	return messages
+}
+
+// lastMessage returns the most recent message of a conversation, or nil if it has no messages
+func (db *memdb) lastMessage(conversationID int64) *message {
+	var last *message
+	for _, m := range db.messagesOf(conversationID) {
+		if last == nil || m.timestamp.After(last.timestamp) ||
+			(m.timestamp.Equal(last.timestamp) && m.id > last.id) {
+			last = m
+		}
+	}
+	return last
+}
+
+// messageModel converts a message, without the comments
+func (db *memdb) messageModel(m *message) models.Message {
+	msg := models.Message{
+		Id:        m.id,
+		Timestamp: m.timestamp,
+		Type:      m.typ,
+		Text:      copyString(m.text),
+	}
+	replies := db.repliesOf(m.id)
+	msg.ThreadReplyCount = len(replies)
+	if len(replies) > 0 {
+		msg.LastReply = replyPreview(replies[len(replies)-1])
+	}
+	if u, ok := db.users[m.senderID]; ok {
+		msg.Sender = u.username
+	}
+	if len(m.photo) > 0 {
+		photo := base64.StdEncoding.EncodeToString(m.photo)
+		msg.Photo = &photo
+	}
+	msg.Entities = db.entities(m)
+	msg.LinkPreview = copyLinkPreview(m.linkPreview)
+	if m.file != nil {
+		msg.File = &models.File{Name: m.file.name, MimeType: m.file.mimeType, Size: int64(len(m.file.data))}
+	}
+	if m.audio != nil {
+		msg.Audio = m.audio.model()
+	}
+	return msg
+}
+
+func copyString(s *string) *string {
+	if s == nil {
+		return nil
+	}
+	v := *s
+	return &v
+}
@@ -0,0 +1,64 @@
+package memdb
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/val7e/wasaText/service/models"
+)
+
+func (db *memdb) SendFile(ctx context.Context, conversationID, senderID int64, f models.NewFile) (*models.Message, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if !db.isParticipant(conversationID, senderID) {
+		return nil, fmt.Errorf("user not participant in conversation")
+	}
+	m, err := db.insertMessage(conversationID, senderID, "file", nil, nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error sending message: %w", err)
+	}
+	m.file = &file{name: f.Name, mimeType: f.MimeType, data: append([]byte(nil), f.Data...)}
+	return db.getMessage(m.id)
+}
+
+func (db *memdb) GetFile(ctx context.Context, messageID, conversationID, userID int64) (*models.File, []byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if !db.isParticipant(conversationID, userID) {
+		return nil, nil, fmt.Errorf("user not participant in conversation")
+	}
+	m, ok := db.messages[messageID]
+	if !ok || m.conversationID != conversationID {
+		return nil, nil, fmt.Errorf("message not found")
+	}
+	if m.file == nil {
+		return nil, nil, fmt.Errorf("message has no file")
+	}
+	info := &models.File{Name: m.file.name, MimeType: m.file.mimeType, Size: int64(len(m.file.data))}
+	return info, append([]byte(nil), m.file.data...), nil
+}
+
+// messagePreview returns the preview of a message: the text, "File: " and the name of the file,
+// "Voice message (m:ss)", or "Photo"
+func messagePreview(m *message) string {
+	switch {
+	case m.text != nil:
+		return *m.text
+	case m.file != nil:
+		return "File: " + m.file.name
+	case m.audio != nil:
+		// The seconds are rounded up, as in the SQL database
+		seconds := (m.audio.durationMs + 999) / 1000
+		return fmt.Sprintf("Voice message (%d:%02d)", seconds/60, seconds%60)
+	default:
+		return "Photo"
+	}
+}
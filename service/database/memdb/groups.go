@@ -0,0 +1,132 @@
+package memdb
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"sort"
+
+	"github.com/val7e/wasaText/service/database"
+	"github.com/val7e/wasaText/service/models"
+)
+
+func (db *memdb) CreateGroup(ctx context.Context, creatorID int64, name string) (*models.Group, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if _, ok := db.users[creatorID]; !ok {
+		return nil, fmt.Errorf("error adding creator to conversation: user %d not found", creatorID)
+	}
+
+	db.seq.conversations++
+	c := &conversation{
+		id:           db.seq.conversations,
+		name:         &name,
+		typ:          "group",
+		participants: map[int64]struct{}{creatorID: {}},
+	}
+	db.conversations[c.id] = c
+	return db.getGroup(c.id)
+}
+
+func (db *memdb) GetGroup(ctx context.Context, groupID int64) (*models.Group, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	return db.getGroup(groupID)
+}
+
+func (db *memdb) SetGroupName(ctx context.Context, groupID int64, name string) (*models.Group, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	c, ok := db.conversations[groupID]
+	if !ok || c.typ != "group" {
+		return nil, fmt.Errorf(database.ErrGroupNotFound)
+	}
+	c.name = &name
+	return db.getGroup(groupID)
+}
+
+func (db *memdb) SetGroupPhoto(ctx context.Context, groupID int64, photo string) (*models.Group, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if _, err := base64.StdEncoding.DecodeString(photo); err != nil {
+		return nil, fmt.Errorf("invalid base64 photo data: %w", err)
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	c, ok := db.conversations[groupID]
+	if !ok || c.typ != "group" {
+		return nil, fmt.Errorf(database.ErrGroupNotFound)
+	}
+	c.pic = &photo
+	return db.getGroup(groupID)
+}
+
+func (db *memdb) AddToGroup(ctx context.Context, groupID int64, memberUsernames []string) (*models.Group, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	c, ok := db.conversations[groupID]
+	if !ok || c.typ != "group" {
+		return nil, fmt.Errorf(database.ErrGroupNotFound)
+	}
+	// Unknown users are skipped
+	for _, username := range memberUsernames {
+		if id, ok := db.usernames[username]; ok {
+			c.participants[id] = struct{}{}
+		}
+	}
+	return db.getGroup(groupID)
+}
+
+// LeaveGroup removes the user from the participants. Like the SQL implementation, it works on any conversation.
+func (db *memdb) LeaveGroup(ctx context.Context, groupID int64, userID int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if !db.isParticipant(groupID, userID) {
+		return fmt.Errorf("user not member of group")
+	}
+	delete(db.conversations[groupID].participants, userID)
+	return nil
+}
+
+func (db *memdb) getGroup(groupID int64) (*models.Group, error) {
+	c, ok := db.conversations[groupID]
+	if !ok || c.typ != "group" {
+		return nil, fmt.Errorf(database.ErrGroupNotFound)
+	}
+
+	members := db.usernamesOf(c.participantIDs())
+	sort.Strings(members)
+
+	group := &models.Group{
+		Id:         c.id,
+		Members:    members,
+		GroupPhoto: copyString(c.pic),
+	}
+	if c.name != nil {
+		group.Name = *c.name
+	}
+	return group, nil
+}
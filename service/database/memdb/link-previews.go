@@ -0,0 +1,31 @@
+package memdb
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/val7e/wasaText/service/models"
+)
+
+func (db *memdb) SetLinkPreview(ctx context.Context, messageID int64, preview models.LinkPreview) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	m, ok := db.messages[messageID]
+	if !ok {
+		return fmt.Errorf("message not found")
+	}
+	m.linkPreview = &preview
+	return nil
+}
+
+func copyLinkPreview(p *models.LinkPreview) *models.LinkPreview {
+	if p == nil {
+		return nil
+	}
+	v := *p
+	return &v
+}
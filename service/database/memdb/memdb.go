@@ -0,0 +1,228 @@
+/*
+Package memdb is an in-memory implementation of database.AppDatabase, backed by maps and protected by a single lock.
+
+It keeps the semantics of the SQL implementation, including the error messages compared by the API handlers, the
+order of the returned lists and the identifiers (assigned from 1, and never reused, per table). It is meant for tests
+and local experiments: nothing is persisted, and each instance starts empty.
+
+	appdb := memdb.New(memdb.Config{})
+	router, err := api.New(api.Config{Logger: logger, Database: appdb})
+
+The databasetest package checks that both implementations follow the same contract.
+*/
+package memdb
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/val7e/wasaText/service/database"
+	"github.com/val7e/wasaText/service/globaltime"
+	"github.com/val7e/wasaText/service/models"
+)
+
+type user struct {
+	id       int64
+	username string
+	pic      []byte
+	thumb    []byte
+}
+
+type conversation struct {
+	id   int64
+	name *string
+	typ  string
+
+	// pic is the group photo, stored as received (base64), like the SQL implementation does
+	pic *string
+
+	participants map[int64]struct{}
+}
+
+type message struct {
+	id             int64
+	conversationID int64
+	senderID       int64
+	typ            string
+	text           *string
+	photo          []byte
+	timestamp      time.Time
+
+	// formatting are the entities of the markup of the text, mentions the participants it mentions (sorted by offset)
+	formatting []models.MessageEntity
+	mentions   []mention
+
+	// linkPreview is set by SetLinkPreview
+	linkPreview *models.LinkPreview
+
+	// file is the file of a "file" message
+	file *file
+
+	// audio is the recording of an "audio" message
+	audio *audio
+}
+
+type file struct {
+	name, mimeType string
+	data           []byte
+}
+
+type audio struct {
+	mimeType   string
+	durationMs int64
+	waveform   []int
+	data       []byte
+}
+
+type mention struct {
+	userID         int64
+	offset, length int
+}
+
+type comment struct {
+	id        int64
+	messageID int64
+	userID    int64
+	text      string
+	timestamp time.Time
+	editedAt  *time.Time
+}
+
+// reply is a message in the thread of the message messageID
+type reply struct {
+	id        int64
+	messageID int64
+	senderID  int64
+	typ       string
+	text      *string
+	photo     []byte
+	timestamp time.Time
+}
+
+// subscriptionKey identifies the subscription of a user to the thread of a message
+type subscriptionKey struct {
+	messageID, userID int64
+}
+
+// directKey identifies a direct conversation by the ordered pair of its participants
+type directKey struct {
+	low, high int64
+}
+
+// sequences are the last identifiers assigned to each table
+type sequences struct {
+	users, conversations, messages, comments, replies int64
+}
+
+type memdb struct {
+	mu sync.RWMutex
+
+	name *string
+
+	users         map[int64]*user
+	usernames     map[string]int64
+	conversations map[int64]*conversation
+	direct        map[directKey]int64
+	messages      map[int64]*message
+	comments      map[int64]*comment
+	replies       map[int64]*reply
+
+	// subscriptions holds the last reply read by each subscriber of a thread
+	subscriptions map[subscriptionKey]int64
+
+	seq sequences
+
+	clock globaltime.Clock
+}
+
+var _ database.AppDatabase = (*memdb)(nil)
+
+// Config is used to provide options to the New function.
+type Config struct {
+	// Clock gives the timestamps of messages and comments (default: globaltime.System)
+	Clock globaltime.Clock
+}
+
+// New returns an empty in-memory AppDatabase.
+func New(cfg Config) database.AppDatabase {
+	return &memdb{
+		clock:         globaltime.OrSystem(cfg.Clock),
+		users:         make(map[int64]*user),
+		usernames:     make(map[string]int64),
+		conversations: make(map[int64]*conversation),
+		direct:        make(map[directKey]int64),
+		messages:      make(map[int64]*message),
+		comments:      make(map[int64]*comment),
+		replies:       make(map[int64]*reply),
+		subscriptions: make(map[subscriptionKey]int64),
+	}
+}
+
+func (db *memdb) Ping(ctx context.Context) error {
+	return ctx.Err()
+}
+
+// GetName returns the name saved by SetName, or sql.ErrNoRows.
+func (db *memdb) GetName(ctx context.Context) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if db.name == nil {
+		return "", sql.ErrNoRows
+	}
+	return *db.name, nil
+}
+
+// SetName saves the name returned by GetName.
+func (db *memdb) SetName(ctx context.Context, name string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.name = &name
+	return nil
+}
+
+// isParticipant reports whether the user takes part in the conversation (which may not exist)
+func (db *memdb) isParticipant(conversationID, userID int64) bool {
+	c, ok := db.conversations[conversationID]
+	if !ok {
+		return false
+	}
+	_, ok = c.participants[userID]
+	return ok
+}
+
+// participantIDs returns the participants of a conversation, sorted by identifier as the SQL primary key does
+func (c *conversation) participantIDs() []int64 {
+	ids := make([]int64, 0, len(c.participants))
+	for id := range c.participants {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// usernamesOf returns the usernames of the given users
+func (db *memdb) usernamesOf(ids []int64) []string {
+	var names []string
+	for _, id := range ids {
+		if u, ok := db.users[id]; ok {
+			names = append(names, u.username)
+		}
+	}
+	return names
+}
+
+// now returns the timestamp of new rows
+func (db *memdb) now() time.Time {
+	return db.clock.Now().UTC()
+}
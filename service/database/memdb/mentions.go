@@ -0,0 +1,114 @@
+package memdb
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/val7e/wasaText/service/database"
+	"github.com/val7e/wasaText/service/markup"
+	"github.com/val7e/wasaText/service/mentions"
+	"github.com/val7e/wasaText/service/models"
+)
+
+func (db *memdb) GetMyMentions(ctx context.Context, userID int64, before int64, limit int) ([]models.Mention, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if before != 0 {
+		if m, ok := db.messages[before]; !ok || !m.mentionsUser(userID) {
+			return nil, fmt.Errorf("mention not found")
+		}
+	}
+
+	var found []*message
+	for _, m := range db.messages {
+		if m.mentionsUser(userID) && m.senderID != userID && db.isParticipant(m.conversationID, userID) {
+			found = append(found, m)
+		}
+	}
+
+	// The most recent first
+	sort.Slice(found, func(i, j int) bool {
+		if !found[i].timestamp.Equal(found[j].timestamp) {
+			return found[i].timestamp.After(found[j].timestamp)
+		}
+		return found[i].id > found[j].id
+	})
+	if before != 0 {
+		cursor := db.messages[before]
+		for len(found) > 0 && !(found[0].timestamp.Before(cursor.timestamp) ||
+			found[0].timestamp.Equal(cursor.timestamp) && found[0].id < cursor.id) {
+			found = found[1:]
+		}
+	}
+	if limit <= 0 || limit > database.MentionsPageSize {
+		limit = database.MentionsPageSize
+	}
+	if len(found) > limit {
+		found = found[:limit]
+	}
+
+	var out = []models.Mention{}
+	for _, m := range found {
+		mention := models.Mention{
+			ConversationId: m.conversationID,
+			MessageId:      m.id,
+			Timestamp:      m.timestamp,
+			Text:           *m.text,
+			Entities:       db.entities(m),
+		}
+		if c, ok := db.conversations[m.conversationID]; ok && c.name != nil {
+			mention.GroupName = *c.name
+		}
+		if u, ok := db.users[m.senderID]; ok {
+			mention.Sender = u.username
+		}
+		out = append(out, mention)
+	}
+	return out, nil
+}
+
+// findMentions resolves the mentions in the text of a message against the participants of the conversation, outside
+// the code and the URLs of the formatting
+func (db *memdb) findMentions(c *conversation, text string, formatting []models.MessageEntity) []mention {
+	var found []mention
+	for _, candidate := range mentions.Find(text) {
+		if markup.InLiteral(formatting, candidate.Offset, candidate.Length) {
+			continue
+		}
+		for id := range c.participants {
+			if u, ok := db.users[id]; ok && u.username == candidate.Username {
+				found = append(found, mention{userID: id, offset: candidate.Offset, length: candidate.Length})
+				break
+			}
+		}
+	}
+	return found
+}
+
+func (m *message) mentionsUser(userID int64) bool {
+	for _, mn := range m.mentions {
+		if mn.userID == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// entities returns the formatting and the mentions of a message, with the current usernames
+func (db *memdb) entities(m *message) []models.MessageEntity {
+	entities := append([]models.MessageEntity(nil), m.formatting...)
+	for _, mn := range m.mentions {
+		entity := models.MessageEntity{Type: markup.Mention, Offset: mn.offset, Length: mn.length, UserId: mn.userID}
+		if u, ok := db.users[mn.userID]; ok {
+			entity.Username = u.username
+		}
+		entities = append(entities, entity)
+	}
+	markup.Sort(entities)
+	return entities
+}
@@ -0,0 +1,357 @@
+package memdb
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"sort"
+
+	"github.com/val7e/wasaText/service/database"
+	"github.com/val7e/wasaText/service/markup"
+	"github.com/val7e/wasaText/service/models"
+)
+
+func (db *memdb) SendMessage(ctx context.Context, conversationID int64, senderID int64, message models.NewMessage) (*models.Message, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var photoBytes []byte
+	if message.Photo != nil && *message.Photo != "" {
+		var err error
+		photoBytes, err = base64.StdEncoding.DecodeString(*message.Photo)
+		if err != nil {
+			return nil, fmt.Errorf("invalid base64 photo data: %w", err)
+		}
+	}
+
+	// The markup is stored as entities
+	var text *string
+	var formatting []models.MessageEntity
+	if message.Text != nil {
+		plain, entities, err := markup.Parse(*message.Text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid markup: %w", err)
+		}
+		text, formatting = &plain, entities
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if !db.isParticipant(conversationID, senderID) {
+		return nil, fmt.Errorf("user not participant in conversation")
+	}
+	m, err := db.insertMessage(conversationID, senderID, message.Type, text, photoBytes, formatting)
+	if err != nil {
+		return nil, fmt.Errorf("error sending message: %w", err)
+	}
+	return db.getMessage(m.id)
+}
+
+func (db *memdb) ForwardMessage(ctx context.Context, messageID, recipientConversationID int64, authorID int64) (*models.Message, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if !db.isParticipant(recipientConversationID, authorID) {
+		return nil, fmt.Errorf("user not participant in recipient conversation")
+	}
+	original, ok := db.messages[messageID]
+	if !ok {
+		return nil, fmt.Errorf("original message not found")
+	}
+	m, err := db.insertMessage(recipientConversationID, authorID, original.typ, copyString(original.text), original.photo,
+		original.formatting)
+	if err != nil {
+		return nil, fmt.Errorf("error forwarding message: %w", err)
+	}
+	m.linkPreview = copyLinkPreview(original.linkPreview)
+	m.file = original.file
+	m.audio = original.audio
+	return db.getMessage(m.id)
+}
+
+func (db *memdb) DeleteMessage(ctx context.Context, messageID, conversationID int64, userID int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	m, ok := db.messages[messageID]
+	if !ok {
+		return fmt.Errorf("message not found")
+	}
+	if m.conversationID != conversationID {
+		return fmt.Errorf("message does not belong to specified conversation")
+	}
+	if m.senderID != userID {
+		return fmt.Errorf("unauthorized: user is not the sender")
+	}
+
+	// The comments and the thread are deleted with the message
+	for _, c := range db.commentsOf(messageID) {
+		delete(db.comments, c.id)
+	}
+	for _, r := range db.repliesOf(messageID) {
+		delete(db.replies, r.id)
+	}
+	for key := range db.subscriptions {
+		if key.messageID == messageID {
+			delete(db.subscriptions, key)
+		}
+	}
+	delete(db.messages, messageID)
+	return nil
+}
+
+func (db *memdb) CommentMessage(ctx context.Context, messageID, conversationID int64, authorID int64, newComment models.NewComment) (*models.Comment, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	m, ok := db.messages[messageID]
+	if !ok {
+		return nil, fmt.Errorf("message not found")
+	}
+	if m.conversationID != conversationID {
+		return nil, fmt.Errorf("message does not belong to specified conversation")
+	}
+	if !db.isParticipant(conversationID, authorID) {
+		return nil, fmt.Errorf("user not participant in conversation")
+	}
+	author, ok := db.users[authorID]
+	if !ok {
+		return nil, fmt.Errorf("error adding comment: user %d not found", authorID)
+	}
+
+	db.seq.comments++
+	c := &comment{
+		id:        db.seq.comments,
+		messageID: messageID,
+		userID:    authorID,
+		text:      newComment.Text,
+		timestamp: db.now(),
+	}
+	db.comments[c.id] = c
+	created := c.model(author)
+	return &created, nil
+}
+
+func (db *memdb) EditComment(ctx context.Context, commentID, messageID, conversationID int64, userID int64, edit models.NewComment) (*models.Comment, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	m, ok := db.messages[messageID]
+	if !ok {
+		return nil, fmt.Errorf("message not found")
+	}
+	if m.conversationID != conversationID {
+		return nil, fmt.Errorf("message does not belong to specified conversation")
+	}
+	c, ok := db.comments[commentID]
+	if !ok || c.messageID != messageID {
+		return nil, fmt.Errorf("comment not found")
+	}
+	if c.userID != userID {
+		return nil, fmt.Errorf("unauthorized: user is not the author")
+	}
+	if !db.isParticipant(conversationID, userID) {
+		return nil, fmt.Errorf("user not participant in conversation")
+	}
+
+	now := db.now()
+	c.text, c.editedAt = edit.Text, &now
+	edited := c.model(db.users[userID])
+	return &edited, nil
+}
+
+// UncommentMessage removes every comment of the user on the message, like the SQL implementation.
+func (db *memdb) UncommentMessage(ctx context.Context, messageID, conversationID int64, userID int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	m, ok := db.messages[messageID]
+	if !ok {
+		return fmt.Errorf("message not found")
+	}
+	if m.conversationID != conversationID {
+		return fmt.Errorf("message does not belong to specified conversation")
+	}
+
+	deleted := 0
+	for _, c := range db.commentsOf(messageID) {
+		if c.userID == userID {
+			delete(db.comments, c.id)
+			deleted++
+		}
+	}
+	if deleted == 0 {
+		return fmt.Errorf("comment not found or user is not the author")
+	}
+	return nil
+}
+
+func (db *memdb) GetComments(ctx context.Context, messageID, conversationID int64, userID int64, after int64, limit int) ([]models.Comment, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if _, err := db.checkThread(messageID, conversationID, userID); err != nil {
+		return nil, err
+	}
+
+	found := db.commentsOf(messageID)
+	sort.Slice(found, func(i, j int) bool {
+		if !found[i].timestamp.Equal(found[j].timestamp) {
+			return found[i].timestamp.Before(found[j].timestamp)
+		}
+		return found[i].id < found[j].id
+	})
+	if after != 0 {
+		cursor, ok := db.comments[after]
+		if !ok || cursor.messageID != messageID {
+			return nil, fmt.Errorf("comment not found")
+		}
+		for len(found) > 0 && found[0] != cursor {
+			found = found[1:]
+		}
+		found = found[1:]
+	}
+	if limit <= 0 || limit > database.CommentsPageSize {
+		limit = database.CommentsPageSize
+	}
+	if len(found) > limit {
+		found = found[:limit]
+	}
+
+	var comments = []models.Comment{}
+	for _, c := range found {
+		if u, ok := db.users[c.userID]; ok {
+			comments = append(comments, c.model(u))
+		}
+	}
+	return comments, nil
+}
+
+// model converts a comment written by `author`
+func (c *comment) model(author *user) models.Comment {
+	comment := models.Comment{
+		Id:              c.id,
+		Author:          author.username,
+		Text:            c.text,
+		Timestamp:       c.timestamp,
+		AuthorThumbnail: base64.StdEncoding.EncodeToString(author.thumb),
+	}
+	if c.editedAt != nil {
+		editedAt := *c.editedAt
+		comment.EditedAt = &editedAt
+	}
+	return comment
+}
+
+// insertMessage adds a message, checking the constraints of the messages table
+func (db *memdb) insertMessage(conversationID, senderID int64, typ string, text *string, photo []byte, formatting []models.MessageEntity) (*message, error) {
+	switch {
+	case typ == "text" && text == nil:
+		return nil, fmt.Errorf("a text message requires the text")
+	case typ == "photo" && photo == nil:
+		return nil, fmt.Errorf("a photo message requires the photo")
+	case typ != "text" && typ != "photo" && typ != "file" && typ != "audio":
+		return nil, fmt.Errorf("invalid message type %q", typ)
+	}
+	if _, ok := db.users[senderID]; !ok {
+		return nil, fmt.Errorf("user %d not found", senderID)
+	}
+
+	db.seq.messages++
+	m := &message{
+		id:             db.seq.messages,
+		conversationID: conversationID,
+		senderID:       senderID,
+		typ:            typ,
+		text:           text,
+		photo:          photo,
+		timestamp:      db.now(),
+		formatting:     append([]models.MessageEntity(nil), formatting...),
+	}
+	if c, ok := db.conversations[conversationID]; ok && c.typ == "group" && text != nil {
+		m.mentions = db.findMentions(c, *text, formatting)
+	}
+	db.messages[m.id] = m
+	return m, nil
+}
+
+// getMessage returns a message with the number of comments and the (up to 3) most recent authors
+func (db *memdb) getMessage(messageID int64) (*models.Message, error) {
+	m, ok := db.messages[messageID]
+	if !ok {
+		return nil, fmt.Errorf("message not found")
+	}
+	msg := db.messageModel(m)
+
+	comments := db.commentsOf(messageID)
+	latest := make(map[string]*comment)
+	for _, c := range comments {
+		u, ok := db.users[c.userID]
+		if !ok {
+			continue
+		}
+		if l, ok := latest[u.username]; !ok || c.timestamp.After(l.timestamp) {
+			latest[u.username] = c
+		}
+	}
+	authors := make([]string, 0, len(latest))
+	for username := range latest {
+		authors = append(authors, username)
+	}
+	sort.Slice(authors, func(i, j int) bool {
+		a, b := latest[authors[i]], latest[authors[j]]
+		if !a.timestamp.Equal(b.timestamp) {
+			return a.timestamp.After(b.timestamp)
+		}
+		return a.id > b.id
+	})
+	if len(authors) > 3 {
+		authors = authors[:3]
+	}
+
+	msg.CommentsCount = len(comments)
+	msg.CommentsAuthors = authors
+	return &msg, nil
+}
+
+// messagesOf returns the messages of a conversation, in no particular order
+func (db *memdb) messagesOf(conversationID int64) []*message {
+	var msgs []*message
+	for _, m := range db.messages {
+		if m.conversationID == conversationID {
+			msgs = append(msgs, m)
+		}
+	}
+	return msgs
+}
+
+// commentsOf returns the comments of a message, in no particular order
+func (db *memdb) commentsOf(messageID int64) []*comment {
+	var comments []*comment
+	for _, c := range db.comments {
+		if c.messageID == messageID {
+			comments = append(comments, c)
+		}
+	}
+	return comments
+}
@@ -0,0 +1,284 @@
+package memdb
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"sort"
+
+	"github.com/val7e/wasaText/service/database"
+	"github.com/val7e/wasaText/service/models"
+)
+
+// threadsLimit is the maximum number of threads returned by GetMyThreads
+const threadsLimit = 1000
+
+func (db *memdb) ReplyToMessage(ctx context.Context, messageID, conversationID int64, senderID int64, newReply models.NewMessage) (*models.Reply, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var photoBytes []byte
+	if newReply.Photo != nil && *newReply.Photo != "" {
+		var err error
+		photoBytes, err = base64.StdEncoding.DecodeString(*newReply.Photo)
+		if err != nil {
+			return nil, fmt.Errorf("invalid base64 photo data: %w", err)
+		}
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	m, err := db.checkThread(messageID, conversationID, senderID)
+	if err != nil {
+		return nil, err
+	}
+
+	// The constraints of the replies table are the ones of messages
+	switch {
+	case newReply.Type == "text" && newReply.Text == nil:
+		return nil, fmt.Errorf("error sending reply: a text reply requires the text")
+	case newReply.Type == "photo" && photoBytes == nil:
+		return nil, fmt.Errorf("error sending reply: a photo reply requires the photo")
+	case newReply.Type != "text" && newReply.Type != "photo":
+		return nil, fmt.Errorf("error sending reply: invalid reply type %q", newReply.Type)
+	}
+
+	db.seq.replies++
+	r := &reply{
+		id:        db.seq.replies,
+		messageID: messageID,
+		senderID:  senderID,
+		typ:       newReply.Type,
+		text:      copyString(newReply.Text),
+		photo:     photoBytes,
+		timestamp: db.now(),
+	}
+	db.replies[r.id] = r
+
+	// The sender follows the thread, and the sender of the message too when the thread starts
+	if _, ok := db.subscriptions[subscriptionKey{messageID, senderID}]; !ok {
+		db.subscriptions[subscriptionKey{messageID, senderID}] = r.id
+	}
+	if len(db.repliesOf(messageID)) == 1 && db.isParticipant(conversationID, m.senderID) {
+		if _, ok := db.subscriptions[subscriptionKey{messageID, m.senderID}]; !ok {
+			db.subscriptions[subscriptionKey{messageID, m.senderID}] = 0
+		}
+	}
+
+	created := db.replyModel(r)
+	return &created, nil
+}
+
+func (db *memdb) GetReplies(ctx context.Context, messageID, conversationID int64, userID int64, after int64, limit int) ([]models.Reply, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if _, err := db.checkThread(messageID, conversationID, userID); err != nil {
+		return nil, err
+	}
+
+	found := db.repliesOf(messageID)
+	if after != 0 {
+		cursor, ok := db.replies[after]
+		if !ok || cursor.messageID != messageID {
+			return nil, fmt.Errorf("reply not found")
+		}
+		for len(found) > 0 && found[0] != cursor {
+			found = found[1:]
+		}
+		found = found[1:]
+	}
+	if limit <= 0 || limit > database.RepliesPageSize {
+		limit = database.RepliesPageSize
+	}
+	if len(found) > limit {
+		found = found[:limit]
+	}
+
+	var replies = []models.Reply{}
+	for _, r := range found {
+		replies = append(replies, db.replyModel(r))
+	}
+	return replies, nil
+}
+
+func (db *memdb) SubscribeThread(ctx context.Context, messageID, conversationID int64, userID int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if _, err := db.checkThread(messageID, conversationID, userID); err != nil {
+		return err
+	}
+	key := subscriptionKey{messageID, userID}
+	if _, ok := db.subscriptions[key]; !ok {
+		// The replies sent before are read
+		var lastRead int64
+		for _, r := range db.repliesOf(messageID) {
+			if r.id > lastRead {
+				lastRead = r.id
+			}
+		}
+		db.subscriptions[key] = lastRead
+	}
+	return nil
+}
+
+func (db *memdb) UnsubscribeThread(ctx context.Context, messageID, conversationID int64, userID int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if _, err := db.checkThread(messageID, conversationID, userID); err != nil {
+		return err
+	}
+	delete(db.subscriptions, subscriptionKey{messageID, userID})
+	return nil
+}
+
+func (db *memdb) MarkThreadRead(ctx context.Context, messageID, conversationID int64, userID int64, replyID int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if _, err := db.checkThread(messageID, conversationID, userID); err != nil {
+		return err
+	}
+	if r, ok := db.replies[replyID]; !ok || r.messageID != messageID {
+		return fmt.Errorf("reply not found")
+	}
+	key := subscriptionKey{messageID, userID}
+	lastRead, ok := db.subscriptions[key]
+	if !ok {
+		return fmt.Errorf("user not subscribed to thread")
+	}
+	if replyID > lastRead {
+		db.subscriptions[key] = replyID
+	}
+	return nil
+}
+
+func (db *memdb) GetMyThreads(ctx context.Context, userID int64) ([]models.ThreadSummary, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	var threads = []models.ThreadSummary{}
+	last := make(map[int64]*reply)
+	for key, lastRead := range db.subscriptions {
+		if key.userID != userID {
+			continue
+		}
+		m, ok := db.messages[key.messageID]
+		if !ok || !db.isParticipant(m.conversationID, userID) {
+			continue
+		}
+
+		preview := messagePreview(m)
+		thread := models.ThreadSummary{
+			ConversationId: m.conversationID,
+			MessageId:      m.id,
+			Message:        models.MessagePreview{Timestamp: m.timestamp, Preview: preview},
+		}
+		replies := db.repliesOf(m.id)
+		thread.ReplyCount = len(replies)
+		for _, r := range replies {
+			if r.id > lastRead && r.senderID != userID {
+				thread.UnreadCount++
+			}
+		}
+		if len(replies) > 0 {
+			last[m.id] = replies[len(replies)-1]
+			thread.LastReply = replyPreview(last[m.id])
+		}
+		threads = append(threads, thread)
+	}
+
+	// The most recent reply first (by the identifier of the reply for equal times), threads without replies last
+	sort.Slice(threads, func(i, j int) bool {
+		a, b := last[threads[i].MessageId], last[threads[j].MessageId]
+		switch {
+		case a != nil && b != nil && !a.timestamp.Equal(b.timestamp):
+			return a.timestamp.After(b.timestamp)
+		case a != nil && b != nil && a.id != b.id:
+			return a.id > b.id
+		case (a == nil) != (b == nil):
+			return a != nil
+		}
+		return threads[i].MessageId > threads[j].MessageId
+	})
+	if len(threads) > threadsLimit {
+		threads = threads[:threadsLimit]
+	}
+	return threads, nil
+}
+
+// checkThread returns the message, after checking that it is in the conversation and that the user is a participant
+func (db *memdb) checkThread(messageID, conversationID, userID int64) (*message, error) {
+	m, ok := db.messages[messageID]
+	if !ok {
+		return nil, fmt.Errorf("message not found")
+	}
+	if m.conversationID != conversationID {
+		return nil, fmt.Errorf("message does not belong to specified conversation")
+	}
+	if !db.isParticipant(conversationID, userID) {
+		return nil, fmt.Errorf("user not participant in conversation")
+	}
+	return m, nil
+}
+
+// repliesOf returns the thread of a message, the oldest reply first
+func (db *memdb) repliesOf(messageID int64) []*reply {
+	var replies []*reply
+	for _, r := range db.replies {
+		if r.messageID == messageID {
+			replies = append(replies, r)
+		}
+	}
+	sort.Slice(replies, func(i, j int) bool {
+		if !replies[i].timestamp.Equal(replies[j].timestamp) {
+			return replies[i].timestamp.Before(replies[j].timestamp)
+		}
+		return replies[i].id < replies[j].id
+	})
+	return replies
+}
+
+func (db *memdb) replyModel(r *reply) models.Reply {
+	out := models.Reply{
+		Id:        r.id,
+		Timestamp: r.timestamp,
+		Type:      r.typ,
+		Text:      copyString(r.text),
+	}
+	if u, ok := db.users[r.senderID]; ok {
+		out.Sender = u.username
+	}
+	if len(r.photo) > 0 {
+		photo := base64.StdEncoding.EncodeToString(r.photo)
+		out.Photo = &photo
+	}
+	return out
+}
+
+func replyPreview(r *reply) *models.MessagePreview {
+	preview := "Photo"
+	if r.text != nil {
+		preview = *r.text
+	}
+	return &models.MessagePreview{Timestamp: r.timestamp, Preview: preview}
+}
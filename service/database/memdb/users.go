@@ -0,0 +1,214 @@
+package memdb
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/val7e/wasaText/service/models"
+	"github.com/val7e/wasaText/service/thumbnail"
+)
+
+// defaultPhotoBase64 is the picture of new users, the same of the SQL implementation
+const defaultPhotoBase64 = "iVBORw0KGgoAAAANSUhEUgAAAAUAAAAFCAYAAACNbyblAAAAHElEQVQI12P4//8/w38GIAXDIBKE0DHxgljNBAAO9TXL0Y4OHwAAAABJRU5ErkJggg=="
+
+var (
+	defaultPhotoBytes, _ = base64.StdEncoding.DecodeString(defaultPhotoBase64)
+	defaultThumbBytes, _ = thumbnail.Make(defaultPhotoBytes)
+	usernameRegex        = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+)
+
+// thumbnailOf returns the thumbnail of a profile picture, with the same fallback of the SQL implementation
+func thumbnailOf(pic []byte) []byte {
+	thumb, err := thumbnail.Make(pic)
+	if err != nil {
+		return defaultThumbBytes
+	}
+	return thumb
+}
+
+// searchLimit is the maximum number of users returned by SearchUser
+const searchLimit = 700
+
+func validateUsername(username string) error {
+	if len(username) < 3 || len(username) > 25 {
+		return fmt.Errorf("username must be between 3 and 25 characters")
+	}
+	if !usernameRegex.MatchString(username) {
+		return fmt.Errorf("username can only contain letters, numbers, _, and -")
+	}
+	return nil
+}
+
+func (u *user) model() *models.User {
+	return &models.User{
+		Id:       u.id,
+		Username: u.username,
+		Pic:      base64.StdEncoding.EncodeToString(u.pic),
+	}
+}
+
+func (db *memdb) DoLogin(ctx context.Context, username string) (*models.User, bool, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, false, err
+	}
+	if err := validateUsername(username); err != nil {
+		return nil, false, err
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if id, ok := db.usernames[username]; ok {
+		return db.users[id].model(), false, nil
+	}
+
+	db.seq.users++
+	u := &user{id: db.seq.users, username: username, pic: defaultPhotoBytes, thumb: defaultThumbBytes}
+	db.users[u.id] = u
+	db.usernames[username] = u.id
+	return u.model(), true, nil
+}
+
+func (db *memdb) SearchUser(ctx context.Context, query string) ([]models.User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	var found []*user
+	for _, u := range db.users {
+		if like(u.username, "%"+query+"%") {
+			found = append(found, u)
+		}
+	}
+	sort.Slice(found, func(i, j int) bool { return found[i].username < found[j].username })
+	if len(found) > searchLimit {
+		found = found[:searchLimit]
+	}
+
+	var users = []models.User{}
+	for _, u := range found {
+		users = append(users, *u.model())
+	}
+	return users, nil
+}
+
+func (db *memdb) SetMyUserName(ctx context.Context, userID int64, newUsername string) (*models.User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if err := validateUsername(newUsername); err != nil {
+		return nil, err
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if existingID, ok := db.usernames[newUsername]; ok {
+		if existingID != userID {
+			return nil, fmt.Errorf("username already taken")
+		}
+	} else if u, ok := db.users[userID]; ok {
+		delete(db.usernames, u.username)
+		u.username = newUsername
+		db.usernames[newUsername] = userID
+	}
+
+	return db.getUserByID(userID)
+}
+
+func (db *memdb) SetMyPhoto(ctx context.Context, userID int64, newPic string) (*models.User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	picBytes, err := base64.StdEncoding.DecodeString(newPic)
+	if err != nil {
+		return nil, fmt.Errorf("invalid base64 photo data")
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if u, ok := db.users[userID]; ok {
+		u.pic, u.thumb = picBytes, thumbnailOf(picBytes)
+	}
+	return db.getUserByID(userID)
+}
+
+func (db *memdb) GetUserByID(ctx context.Context, userID int64) (*models.User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	return db.getUserByID(userID)
+}
+
+func (db *memdb) GetUserByUsername(ctx context.Context, username string) (*models.User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	id, ok := db.usernames[username]
+	if !ok {
+		return nil, fmt.Errorf("user not found")
+	}
+	return db.users[id].model(), nil
+}
+
+func (db *memdb) getUserByID(userID int64) (*models.User, error) {
+	u, ok := db.users[userID]
+	if !ok {
+		return nil, fmt.Errorf("user not found")
+	}
+	return u.model(), nil
+}
+
+// like reports whether s matches the SQL LIKE pattern: '%' matches any sequence of characters, '_' any single
+// character, and ASCII letters match regardless of case (as in SQLite, and ILIKE in PostgreSQL).
+func like(s, pattern string) bool {
+	s, pattern = asciiLower(s), asciiLower(pattern)
+
+	// Iterative wildcard matching with backtracking to the last '%'
+	var si, pi int
+	star, mark := -1, 0
+	rs, rp := []rune(s), []rune(pattern)
+	for si < len(rs) {
+		switch {
+		case pi < len(rp) && (rp[pi] == '_' || rp[pi] == rs[si]):
+			si++
+			pi++
+		case pi < len(rp) && rp[pi] == '%':
+			star, mark = pi, si
+			pi++
+		case star >= 0:
+			pi = star + 1
+			mark++
+			si = mark
+		default:
+			return false
+		}
+	}
+	for pi < len(rp) && rp[pi] == '%' {
+		pi++
+	}
+	return pi == len(rp)
+}
+
+// asciiLower lowercases ASCII letters only, leaving the other characters untouched
+func asciiLower(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'A' && r <= 'Z' {
+			return r + 'a' - 'A'
+		}
+		return r
+	}, s)
+}
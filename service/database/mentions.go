@@ -0,0 +1,181 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/val7e/wasaText/service/markup"
+	"github.com/val7e/wasaText/service/mentions"
+	"github.com/val7e/wasaText/service/models"
+)
+
+// MentionsPageSize is the maximum number of mentions returned by GetMyMentions.
+const MentionsPageSize = 100
+
+// GetMyMentions retrieves a page of the group messages mentioning the user, the most recent first: at most `limit`
+// messages (at most MentionsPageSize, also if limit is not positive) preceding the message `before` (from the most
+// recent one if before is 0). The messages of the user and of the groups they left are not listed.
+func (db *appdbimpl) GetMyMentions(ctx context.Context, userID int64, before int64, limit int) ([]models.Mention, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	if limit <= 0 || limit > MentionsPageSize {
+		limit = MentionsPageSize
+	}
+
+	page := ""
+	args := []interface{}{userID, userID, userID}
+	if before != 0 {
+		// The page starts before the position of the message in the list
+		var count int
+		err := db.c.QueryRowContext(ctx, "SELECT COUNT(*) FROM mentions WHERE message_id = ? AND user_id = ?",
+			before, userID).Scan(&count)
+		if err != nil {
+			return nil, fmt.Errorf("error finding mention: %w", err)
+		}
+		if count == 0 {
+			return nil, fmt.Errorf("mention not found")
+		}
+		page = "AND (m.timestamp, m.id) < (SELECT timestamp, id FROM messages WHERE id = ?)"
+		args = append(args, before)
+	}
+	args = append(args, limit)
+
+	rows, err := db.c.QueryContext(ctx, `
+		SELECT m.conversation_id, COALESCE(c.name, ''), m.id, u.username, m.timestamp, m.text
+		FROM messages m
+		INNER JOIN conversations c ON m.conversation_id = c.id
+		INNER JOIN users u ON m.sender_id = u.id
+		INNER JOIN conversation_participants cp ON cp.conversation_id = m.conversation_id AND cp.user_id = ?
+		WHERE m.id IN (SELECT message_id FROM mentions WHERE user_id = ?) AND m.sender_id <> ?
+		`+page+`
+		ORDER BY m.timestamp DESC, m.id DESC
+		LIMIT ?
+	`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error getting mentions: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	var found = []models.Mention{}
+	var ids []interface{}
+	for rows.Next() {
+		var mention models.Mention
+		var timestamp time.Time
+		if err := rows.Scan(&mention.ConversationId, &mention.GroupName, &mention.MessageId, &mention.Sender,
+			&timestamp, &mention.Text); err != nil {
+			return nil, fmt.Errorf("error scanning mention: %w", err)
+		}
+		mention.Timestamp = timestamp
+		found = append(found, mention)
+		ids = append(ids, mention.MessageId)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating mentions: %w", err)
+	}
+	_ = rows.Close()
+
+	if len(ids) == 0 {
+		return found, nil
+	}
+	entities, err := db.getEntities(ctx, "m.id IN (?"+strings.Repeat(", ?", len(ids)-1)+")", ids...)
+	if err != nil {
+		return nil, err
+	}
+	for i := range found {
+		found[i].Entities = entities[found[i].MessageId]
+	}
+	return found, nil
+}
+
+// insertMentions stores the mentions of the participants in the text of a group message. Texts in direct
+// conversations, @usernames of users who are not participants, and @usernames in the code or the URLs of the
+// formatting mention nobody.
+func insertMentions(ctx context.Context, tx queryer, conversationID, messageID int64, text sql.NullString, formatting []models.MessageEntity) error {
+	if !text.Valid {
+		return nil
+	}
+	candidates := mentions.Find(text.String)
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	var conversationType string
+	err := tx.QueryRowContext(ctx, "SELECT type FROM conversations WHERE id = ?", conversationID).Scan(&conversationType)
+	if err != nil {
+		return fmt.Errorf("error getting conversation type: %w", err)
+	}
+	if conversationType != "group" {
+		return nil
+	}
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT u.id, u.username
+		FROM conversation_participants cp
+		INNER JOIN users u ON cp.user_id = u.id
+		WHERE cp.conversation_id = ?
+	`, conversationID)
+	if err != nil {
+		return fmt.Errorf("error getting participants: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	participants := make(map[string]int64)
+	for rows.Next() {
+		var id int64
+		var username string
+		if err := rows.Scan(&id, &username); err != nil {
+			return fmt.Errorf("error scanning participant: %w", err)
+		}
+		participants[username] = id
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error iterating participants: %w", err)
+	}
+	_ = rows.Close()
+
+	for _, c := range candidates {
+		userID, ok := participants[c.Username]
+		if !ok || markup.InLiteral(formatting, c.Offset, c.Length) {
+			continue
+		}
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO mentions (message_id, user_id, text_offset, text_length) VALUES (?, ?, ?, ?)
+		`, messageID, userID, c.Offset, c.Length)
+		if err != nil {
+			return fmt.Errorf("error inserting mention: %w", err)
+		}
+	}
+	return nil
+}
+
+// getMentionEntities returns the mentions in the messages `m` selected by the condition `where`, as entities sorted by
+// offset and keyed by message ID. getEntities adds the formatting.
+func (db *appdbimpl) getMentionEntities(ctx context.Context, where string, args ...interface{}) (map[int64][]models.MessageEntity, error) {
+	rows, err := db.c.QueryContext(ctx, `
+		SELECT mn.message_id, mn.text_offset, mn.text_length, u.id, u.username
+		FROM mentions mn
+		INNER JOIN messages m ON mn.message_id = m.id
+		INNER JOIN users u ON mn.user_id = u.id
+		WHERE `+where+`
+		ORDER BY mn.message_id, mn.text_offset
+	`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error getting mentions: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	entities := make(map[int64][]models.MessageEntity)
+	for rows.Next() {
+		var messageID int64
+		entity := models.MessageEntity{Type: markup.Mention}
+		if err := rows.Scan(&messageID, &entity.Offset, &entity.Length, &entity.UserId, &entity.Username); err != nil {
+			return nil, fmt.Errorf("error scanning mention: %w", err)
+		}
+		entities[messageID] = append(entities[messageID], entity)
+	}
+	return entities, rows.Err()
+}
@@ -1,6 +1,7 @@
 package database
 
 import (
+	"context"
 	"database/sql"
 	"encoding/base64"
 	"errors"
@@ -11,258 +12,416 @@ import (
 )
 
 // SendMessage sends a message in a conversation
-func (db *appdbimpl) SendMessage(conversationID, senderID int64, message models.NewMessage) (*models.Message, error) {
-	// Verify user is participant in conversation
-	var participantCount int
-	err := db.c.QueryRow(`
-		SELECT COUNT(*) FROM conversation_participants
-		WHERE conversation_id = ? AND user_id = ?
-	`, conversationID, senderID).Scan(&participantCount)
-
-	if err != nil || participantCount == 0 {
-		return nil, fmt.Errorf("user not participant in conversation")
-	}
+func (db *appdbimpl) SendMessage(ctx context.Context, conversationID, senderID int64, message models.NewMessage) (*models.Message, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
 
 	// Handle photo if present
 	var photoBytes []byte
 	if message.Photo != nil && *message.Photo != "" {
+		var err error
 		photoBytes, err = base64.StdEncoding.DecodeString(*message.Photo)
 		if err != nil {
 			return nil, fmt.Errorf("invalid base64 photo data: %w", err)
 		}
 	}
 
-	// Handle text
-	var text sql.NullString
-	if message.Text != nil {
-		text = sql.NullString{String: *message.Text, Valid: true}
+	// Handle text: the markup is stored as entities
+	text, entities, err := parseText(message.Text)
+	if err != nil {
+		return nil, err
 	}
 
-	// Insert message
-	result, err := db.c.Exec(`
-		INSERT INTO messages (conversation_id, sender_id, type, text, photo, timestamp)
-		VALUES (?, ?, ?, ?, ?, ?)
-	`, conversationID, senderID, message.Type, text, photoBytes, time.Now())
+	var messageID int64
+	err = db.withTx(ctx, func(tx queryer) error {
+		// Verify user is participant in conversation
+		var participantCount int
+		err := tx.QueryRowContext(ctx, `
+			SELECT COUNT(*) FROM conversation_participants
+			WHERE conversation_id = ? AND user_id = ?
+		`, conversationID, senderID).Scan(&participantCount)
 
-	if err != nil {
-		return nil, fmt.Errorf("error sending message: %w", err)
-	}
+		if err != nil || participantCount == 0 {
+			return fmt.Errorf("user not participant in conversation")
+		}
+
+		// Insert message
+		err = tx.QueryRowContext(ctx, `
+			INSERT INTO messages (conversation_id, sender_id, type, text, photo, timestamp)
+			VALUES (?, ?, ?, ?, ?, ?)
+			RETURNING id
+		`, conversationID, senderID, message.Type, text, photoBytes, db.now()).Scan(&messageID)
 
-	messageID, err := result.LastInsertId()
+		if err != nil {
+			return fmt.Errorf("error sending message: %w", err)
+		}
+		if err := insertEntities(ctx, tx, messageID, entities); err != nil {
+			return err
+		}
+		return insertMentions(ctx, tx, conversationID, messageID, text, entities)
+	})
 	if err != nil {
-		return nil, fmt.Errorf("error getting message ID: %w", err)
+		return nil, err
 	}
 
 	// Return the created message
-	return db.getMessageByID(messageID)
+	return db.getMessageByID(ctx, messageID)
 }
 
 // ForwardMessage forwards an existing message to another conversation
-func (db *appdbimpl) ForwardMessage(messageID, recipientConversationID, authorID int64) (*models.Message, error) {
-	// Verify author is participant in recipient conversation
-	var participantCount int
-	err := db.c.QueryRow(`
-		SELECT COUNT(*) FROM conversation_participants
-		WHERE conversation_id = ? AND user_id = ?
-	`, recipientConversationID, authorID).Scan(&participantCount)
-
-	if err != nil || participantCount == 0 {
-		return nil, fmt.Errorf("user not participant in recipient conversation")
-	}
+func (db *appdbimpl) ForwardMessage(ctx context.Context, messageID, recipientConversationID, authorID int64) (*models.Message, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	var newMessageID int64
+	err := db.withTx(ctx, func(tx queryer) error {
+		// Verify author is participant in recipient conversation
+		var participantCount int
+		err := tx.QueryRowContext(ctx, `
+			SELECT COUNT(*) FROM conversation_participants
+			WHERE conversation_id = ? AND user_id = ?
+		`, recipientConversationID, authorID).Scan(&participantCount)
+
+		if err != nil || participantCount == 0 {
+			return fmt.Errorf("user not participant in recipient conversation")
+		}
 
-	// Get original message
-	var text sql.NullString
-	var photoBytes []byte
-	var msgType string
+		// Get original message
+		var text sql.NullString
+		var photoBytes []byte
+		var msgType string
 
-	err = db.c.QueryRow(`
-		SELECT type, text, photo FROM messages WHERE id = ?
-	`, messageID).Scan(&msgType, &text, &photoBytes)
+		err = tx.QueryRowContext(ctx, `
+			SELECT type, text, photo FROM messages WHERE id = ?
+		`, messageID).Scan(&msgType, &text, &photoBytes)
 
-	if errors.Is(err, sql.ErrNoRows) {
-		return nil, fmt.Errorf("original message not found")
-	}
-	if err != nil {
-		return nil, fmt.Errorf("error getting original message: %w", err)
-	}
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("original message not found")
+		}
+		if err != nil {
+			return fmt.Errorf("error getting original message: %w", err)
+		}
 
-	// Create forwarded message
-	result, err := db.c.Exec(`
-		INSERT INTO messages (conversation_id, sender_id, type, text, photo, timestamp)
-		VALUES (?, ?, ?, ?, ?, ?)
-	`, recipientConversationID, authorID, msgType, text, photoBytes, time.Now())
+		// Create forwarded message
+		err = tx.QueryRowContext(ctx, `
+			INSERT INTO messages (conversation_id, sender_id, type, text, photo, timestamp)
+			VALUES (?, ?, ?, ?, ?, ?)
+			RETURNING id
+		`, recipientConversationID, authorID, msgType, text, photoBytes, db.now()).Scan(&newMessageID)
 
-	if err != nil {
-		return nil, fmt.Errorf("error forwarding message: %w", err)
-	}
+		if err != nil {
+			return fmt.Errorf("error forwarding message: %w", err)
+		}
 
-	newMessageID, err := result.LastInsertId()
+		// The text keeps its formatting, and mentions the participants of the recipient conversation
+		formatting, err := getFormatting(ctx, tx, "m.id = ?", messageID)
+		if err != nil {
+			return err
+		}
+		if err := insertEntities(ctx, tx, newMessageID, formatting[messageID]); err != nil {
+			return err
+		}
+		if err := copyLinkPreview(ctx, tx, messageID, newMessageID); err != nil {
+			return err
+		}
+		if err := copyFile(ctx, tx, messageID, newMessageID); err != nil {
+			return err
+		}
+		if err := copyAudio(ctx, tx, messageID, newMessageID); err != nil {
+			return err
+		}
+		return insertMentions(ctx, tx, recipientConversationID, newMessageID, text, formatting[messageID])
+	})
 	if err != nil {
-		return nil, fmt.Errorf("error getting forwarded message ID: %w", err)
+		return nil, err
 	}
 
-	return db.getMessageByID(newMessageID)
+	return db.getMessageByID(ctx, newMessageID)
 }
 
 // DeleteMessage deletes a message
-func (db *appdbimpl) DeleteMessage(messageID, conversationID, userID int64) error {
-	// Verify message exists in the specified conversation and user is the sender
-	var senderID int64
-	var msgConversationID int64
+func (db *appdbimpl) DeleteMessage(ctx context.Context, messageID, conversationID, userID int64) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	return db.withTx(ctx, func(tx queryer) error {
+		// Verify message exists in the specified conversation and user is the sender
+		var senderID int64
+		var msgConversationID int64
+
+		err := tx.QueryRowContext(ctx,
+			"SELECT sender_id, conversation_id FROM messages WHERE id = ?",
+			messageID,
+		).Scan(&senderID, &msgConversationID)
+
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("message not found")
+		}
+		if err != nil {
+			return fmt.Errorf("error finding message: %w", err)
+		}
 
-	err := db.c.QueryRow(
-		"SELECT sender_id, conversation_id FROM messages WHERE id = ?",
-		messageID,
-	).Scan(&senderID, &msgConversationID)
+		// Verify conversation ID matches
+		if msgConversationID != conversationID {
+			return fmt.Errorf("message does not belong to specified conversation")
+		}
 
-	if errors.Is(err, sql.ErrNoRows) {
-		return fmt.Errorf("message not found")
-	}
-	if err != nil {
-		return fmt.Errorf("error finding message: %w", err)
-	}
+		// Verify user is the sender
+		if senderID != userID {
+			return fmt.Errorf("unauthorized: user is not the sender")
+		}
 
-	// Verify conversation ID matches
-	if msgConversationID != conversationID {
-		return fmt.Errorf("message does not belong to specified conversation")
-	}
+		// Delete message (and related comments)
+		_, err = tx.ExecContext(ctx, "DELETE FROM messages WHERE id = ?", messageID)
+		if err != nil {
+			return fmt.Errorf("error deleting message: %w", err)
+		}
 
-	// Verify user is the sender
-	if senderID != userID {
-		return fmt.Errorf("unauthorized: user is not the sender")
-	}
+		return nil
+	})
+}
+
+// CommentMessage adds a comment to a message
+func (db *appdbimpl) CommentMessage(ctx context.Context, messageID, conversationID, authorID int64, comment models.NewComment) (*models.Comment, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	var created models.Comment
+	err := db.withTx(ctx, func(tx queryer) error {
+		// Verify message exists and belongs to the conversation
+		var msgConversationID int64
+		err := tx.QueryRowContext(ctx,
+			"SELECT conversation_id FROM messages WHERE id = ?",
+			messageID,
+		).Scan(&msgConversationID)
+
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("message not found")
+		}
+		if err != nil {
+			return fmt.Errorf("error finding message: %w", err)
+		}
 
-	// Delete message (and related comments)
-	_, err = db.c.Exec("DELETE FROM messages WHERE id = ?", messageID)
+		if msgConversationID != conversationID {
+			return fmt.Errorf("message does not belong to specified conversation")
+		}
+
+		// Verify author is participant in conversation
+		var participantCount int
+		err = tx.QueryRowContext(ctx, `
+			SELECT COUNT(*) FROM conversation_participants
+			WHERE conversation_id = ? AND user_id = ?
+		`, conversationID, authorID).Scan(&participantCount)
+
+		if err != nil || participantCount == 0 {
+			return fmt.Errorf("user not participant in conversation")
+		}
+
+		// Insert comment
+		var commentID int64
+		err = tx.QueryRowContext(ctx, `
+			INSERT INTO comments (message_id, user_id, text, timestamp)
+			VALUES (?, ?, ?, ?)
+			RETURNING id
+		`, messageID, authorID, comment.Text, db.now()).Scan(&commentID)
+
+		if err != nil {
+			return fmt.Errorf("error adding comment: %w", err)
+		}
+
+		// Read it back, with the author
+		created, err = getComment(ctx, tx, commentID)
+		if err != nil {
+			return fmt.Errorf("error getting comment: %w", err)
+		}
+		return nil
+	})
 	if err != nil {
-		return fmt.Errorf("error deleting message: %w", err)
+		return nil, err
 	}
 
-	return nil
+	return &created, nil
 }
 
-// CommentMessage adds a comment to a message
-func (db *appdbimpl) CommentMessage(messageID, conversationID, authorID int64, comment models.NewComment) (*models.Comment, error) {
-	// Verify message exists and belongs to the conversation
-	var msgConversationID int64
-	err := db.c.QueryRow(
-		"SELECT conversation_id FROM messages WHERE id = ?",
-		messageID,
-	).Scan(&msgConversationID)
+// EditComment replaces the text of a comment. Only the author can edit it, while still a participant of the
+// conversation.
+func (db *appdbimpl) EditComment(ctx context.Context, commentID, messageID, conversationID, userID int64, comment models.NewComment) (*models.Comment, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	var edited models.Comment
+	err := db.withTx(ctx, func(tx queryer) error {
+		// Verify message exists and belongs to the conversation
+		var msgConversationID int64
+		err := tx.QueryRowContext(ctx,
+			"SELECT conversation_id FROM messages WHERE id = ?",
+			messageID,
+		).Scan(&msgConversationID)
+
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("message not found")
+		}
+		if err != nil {
+			return fmt.Errorf("error finding message: %w", err)
+		}
 
-	if errors.Is(err, sql.ErrNoRows) {
-		return nil, fmt.Errorf("message not found")
-	}
-	if err != nil {
-		return nil, fmt.Errorf("error finding message: %w", err)
-	}
+		if msgConversationID != conversationID {
+			return fmt.Errorf("message does not belong to specified conversation")
+		}
 
-	if msgConversationID != conversationID {
-		return nil, fmt.Errorf("message does not belong to specified conversation")
-	}
+		// Verify the comment is on the message, and written by the user
+		var authorID, commentMessageID int64
+		err = tx.QueryRowContext(ctx,
+			"SELECT user_id, message_id FROM comments WHERE id = ?",
+			commentID,
+		).Scan(&authorID, &commentMessageID)
 
-	// Verify author is participant in conversation
-	var participantCount int
-	err = db.c.QueryRow(`
-		SELECT COUNT(*) FROM conversation_participants
-		WHERE conversation_id = ? AND user_id = ?
-	`, conversationID, authorID).Scan(&participantCount)
+		if errors.Is(err, sql.ErrNoRows) || (err == nil && commentMessageID != messageID) {
+			return fmt.Errorf("comment not found")
+		}
+		if err != nil {
+			return fmt.Errorf("error finding comment: %w", err)
+		}
 
-	if err != nil || participantCount == 0 {
-		return nil, fmt.Errorf("user not participant in conversation")
-	}
+		if authorID != userID {
+			return fmt.Errorf("unauthorized: user is not the author")
+		}
 
-	// Insert comment
-	result, err := db.c.Exec(`
-		INSERT INTO comments (message_id, user_id, text, timestamp)
-		VALUES (?, ?, ?, ?)
-	`, messageID, authorID, comment.Text, time.Now())
+		// Verify author is still participant in conversation
+		var participantCount int
+		err = tx.QueryRowContext(ctx, `
+			SELECT COUNT(*) FROM conversation_participants
+			WHERE conversation_id = ? AND user_id = ?
+		`, conversationID, userID).Scan(&participantCount)
 
-	if err != nil {
-		return nil, fmt.Errorf("error adding comment: %w", err)
-	}
+		if err != nil || participantCount == 0 {
+			return fmt.Errorf("user not participant in conversation")
+		}
 
-	commentID, err := result.LastInsertId()
-	if err != nil {
-		return nil, fmt.Errorf("error getting comment ID: %w", err)
-	}
+		_, err = tx.ExecContext(ctx,
+			"UPDATE comments SET text = ?, edited_at = ? WHERE id = ?",
+			comment.Text, db.now(), commentID,
+		)
+		if err != nil {
+			return fmt.Errorf("error editing comment: %w", err)
+		}
 
-	// Get username
-	var username string
-	err = db.c.QueryRow("SELECT username FROM users WHERE id = ?", authorID).Scan(&username)
+		edited, err = getComment(ctx, tx, commentID)
+		if err != nil {
+			return fmt.Errorf("error getting comment: %w", err)
+		}
+		return nil
+	})
 	if err != nil {
-		return nil, fmt.Errorf("error getting username: %w", err)
+		return nil, err
 	}
 
-	return &models.Comment{
-		Id:     commentID,
-		Author: username,
-		Text:   comment.Text,
-	}, nil
+	return &edited, nil
 }
 
 // UncommentMessage deletes a comment from a message
-func (db *appdbimpl) UncommentMessage(messageID, conversationID, userID int64) error {
-	// Verify message belongs to conversation
-	var msgConversationID int64
-	err := db.c.QueryRow(
-		"SELECT conversation_id FROM messages WHERE id = ?",
-		messageID,
-	).Scan(&msgConversationID)
+func (db *appdbimpl) UncommentMessage(ctx context.Context, messageID, conversationID, userID int64) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	return db.withTx(ctx, func(tx queryer) error {
+		// Verify message belongs to conversation
+		var msgConversationID int64
+		err := tx.QueryRowContext(ctx,
+			"SELECT conversation_id FROM messages WHERE id = ?",
+			messageID,
+		).Scan(&msgConversationID)
+
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("message not found")
+		}
+		if err != nil {
+			return fmt.Errorf("error finding message: %w", err)
+		}
 
-	if errors.Is(err, sql.ErrNoRows) {
-		return fmt.Errorf("message not found")
-	}
-	if err != nil {
-		return fmt.Errorf("error finding message: %w", err)
-	}
+		if msgConversationID != conversationID {
+			return fmt.Errorf("message does not belong to specified conversation")
+		}
 
-	if msgConversationID != conversationID {
-		return fmt.Errorf("message does not belong to specified conversation")
-	}
+		// Find and delete user's comment on this message
+		result, err := tx.ExecContext(ctx,
+			"DELETE FROM comments WHERE message_id = ? AND user_id = ?",
+			messageID, userID,
+		)
+		if err != nil {
+			return fmt.Errorf("error deleting comment: %w", err)
+		}
 
-	// Find and delete user's comment on this message
-	result, err := db.c.Exec(
-		"DELETE FROM comments WHERE message_id = ? AND user_id = ?",
-		messageID, userID,
-	)
-	if err != nil {
-		return fmt.Errorf("error deleting comment: %w", err)
-	}
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("error checking result: %w", err)
+		}
 
-	rowsAffected, err := result.RowsAffected()
-	if err != nil {
-		return fmt.Errorf("error checking result: %w", err)
-	}
+		if rowsAffected == 0 {
+			return fmt.Errorf("comment not found or user is not the author")
+		}
 
-	if rowsAffected == 0 {
-		return fmt.Errorf("comment not found or user is not the author")
+		return nil
+	})
+}
+
+// GetComments retrieves a page of the comments of a message, the oldest first: at most `limit` comments (at most
+// CommentsPageSize, also if limit is not positive) following the comment `after` (from the first one if after is 0).
+// The message must be in the conversation, and the user a participant.
+func (db *appdbimpl) GetComments(ctx context.Context, messageID, conversationID int64, userID int64, after int64, limit int) ([]models.Comment, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	if limit <= 0 || limit > CommentsPageSize {
+		limit = CommentsPageSize
 	}
 
-	return nil
-}
+	if _, err := checkThread(ctx, db.c, messageID, conversationID, userID); err != nil {
+		return nil, err
+	}
 
-// GetComments retrieves all comments for a message
-func (db *appdbimpl) GetComments(messageID int64) ([]models.Comment, error) {
-	rows, err := db.c.Query(`
-		SELECT c.id, u.username, c.text
+	query := `
+		SELECT ` + commentColumns + `
 		FROM comments c
 		INNER JOIN users u ON c.user_id = u.id
 		WHERE c.message_id = ?
-		ORDER BY c.timestamp ASC
-		LIMIT 100
-	`, messageID)
+		ORDER BY c.timestamp ASC, c.id ASC
+		LIMIT ?
+	`
+	args := []interface{}{messageID, limit}
+	if after != 0 {
+		// The page starts after the position of the comment in the list
+		var afterMessageID int64
+		err := db.c.QueryRowContext(ctx, "SELECT message_id FROM comments WHERE id = ?", after).Scan(&afterMessageID)
+		if errors.Is(err, sql.ErrNoRows) || (err == nil && afterMessageID != messageID) {
+			return nil, fmt.Errorf("comment not found")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error finding comment: %w", err)
+		}
 
+		query = `
+			SELECT ` + commentColumns + `
+			FROM comments c
+			INNER JOIN users u ON c.user_id = u.id
+			WHERE c.message_id = ? AND (c.timestamp, c.id) > (SELECT timestamp, id FROM comments WHERE id = ?)
+			ORDER BY c.timestamp ASC, c.id ASC
+			LIMIT ?
+		`
+		args = []interface{}{messageID, after, limit}
+	}
+
+	rows, err := db.c.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, fmt.Errorf("error getting comments: %w", err)
 	}
 
 	defer func() { _ = rows.Close() }()
 
-	var comments []models.Comment
+	var comments = []models.Comment{}
 	for rows.Next() {
-		var comment models.Comment
-		if err := rows.Scan(&comment.Id, &comment.Author, &comment.Text); err != nil {
+		comment, err := scanComment(rows)
+		if err != nil {
 			return nil, fmt.Errorf("error scanning comment: %w", err)
 		}
 		comments = append(comments, comment)
@@ -275,20 +434,81 @@ func (db *appdbimpl) GetComments(messageID int64) ([]models.Comment, error) {
 	return comments, nil
 }
 
+// CommentsPageSize is the maximum number of comments returned by GetComments.
+const CommentsPageSize = 100
+
+// commentColumns are the columns read by scanComment, from the comments `c` joined with their authors `u`
+const commentColumns = "c.id, u.username, c.text, c.timestamp, c.edited_at, u.pic_thumb"
+
+// scanner is a *sql.Row or *sql.Rows
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanComment reads a comment selected with commentColumns
+func scanComment(row scanner) (models.Comment, error) {
+	var comment models.Comment
+	var editedAt sql.NullTime
+	var thumb []byte
+	if err := row.Scan(&comment.Id, &comment.Author, &comment.Text, &comment.Timestamp, &editedAt, &thumb); err != nil {
+		return comment, err
+	}
+	comment.Timestamp = comment.Timestamp.UTC()
+	if editedAt.Valid {
+		t := editedAt.Time.UTC()
+		comment.EditedAt = &t
+	}
+	if len(thumb) == 0 {
+		thumb = defaultThumbBytes
+	}
+	comment.AuthorThumbnail = base64.StdEncoding.EncodeToString(thumb)
+	return comment, nil
+}
+
+// getComment retrieves a comment by its ID
+func getComment(ctx context.Context, q queryer, commentID int64) (models.Comment, error) {
+	return scanComment(q.QueryRowContext(ctx, `
+		SELECT `+commentColumns+`
+		FROM comments c
+		INNER JOIN users u ON c.user_id = u.id
+		WHERE c.id = ?
+	`, commentID))
+}
+
 // getMessageByID retrieve a message by its ID
-func (db *appdbimpl) getMessageByID(messageID int64) (*models.Message, error) {
+func (db *appdbimpl) getMessageByID(ctx context.Context, messageID int64) (*models.Message, error) {
 	var msg models.Message
 	var text sql.NullString
 	var photoBytes []byte
 	var timestamp time.Time
 	var senderUsername string
-
-	err := db.c.QueryRow(`
-		SELECT m.id, u.username, m.type, m.text, m.photo, m.timestamp
+	var lastReplyTimestamp sql.NullTime
+	var lastReplyPreview string
+	var linkPreview linkPreviewScan
+	var file fileScan
+	var audio audioScan
+
+	err := db.c.QueryRowContext(ctx, `
+		SELECT
+			m.id, u.username, m.type, m.text, m.photo, m.timestamp,
+			(SELECT COUNT(*) FROM replies r WHERE r.message_id = m.id),
+			lr.timestamp,
+			COALESCE(lr.text, 'Photo'),
+			`+linkPreviewColumns+`,
+			`+fileColumns+`,
+			`+audioColumns+`
 		FROM messages m
 		INNER JOIN users u ON m.sender_id = u.id
+		`+lastReplyJoin+`
+		`+linkPreviewJoin+`
+		`+fileJoin+`
+		`+audioJoin+`
 		WHERE m.id = ?
-	`, messageID).Scan(&msg.Id, &senderUsername, &msg.Type, &text, &photoBytes, &timestamp)
+	`, messageID).Scan(&msg.Id, &senderUsername, &msg.Type, &text, &photoBytes, &timestamp,
+		&msg.ThreadReplyCount, &lastReplyTimestamp, &lastReplyPreview,
+		&linkPreview.url, &linkPreview.title, &linkPreview.description, &linkPreview.image,
+		&file.name, &file.mimeType, &file.size,
+		&audio.mimeType, &audio.size, &audio.durationMs, &audio.waveform)
 
 	if errors.Is(err, sql.ErrNoRows) {
 		return nil, fmt.Errorf("message not found")
@@ -299,6 +519,10 @@ func (db *appdbimpl) getMessageByID(messageID int64) (*models.Message, error) {
 
 	msg.Sender = senderUsername
 	msg.Timestamp = timestamp
+	msg.LastReply = lastReply(lastReplyTimestamp, lastReplyPreview)
+	msg.LinkPreview = linkPreview.preview()
+	msg.File = file.file()
+	msg.Audio = audio.audio()
 
 	// Set text if present
 	if text.Valid {
@@ -312,21 +536,28 @@ func (db *appdbimpl) getMessageByID(messageID int64) (*models.Message, error) {
 		msg.Photo = &pic
 	}
 
+	entities, err := db.getEntities(ctx, "m.id = ?", messageID)
+	if err != nil {
+		return nil, err
+	}
+	msg.Entities = entities[messageID]
+
 	// Get comment count
 	var commentCount int
-	err = db.c.QueryRow("SELECT COUNT(*) FROM comments WHERE message_id = ?", messageID).Scan(&commentCount)
+	err = db.c.QueryRowContext(ctx, "SELECT COUNT(*) FROM comments WHERE message_id = ?", messageID).Scan(&commentCount)
 	if err != nil {
 		return nil, fmt.Errorf("error counting comments: %w", err)
 	}
 	msg.CommentsCount = commentCount
 
 	// Get comment authors (up to 3)
-	rows, err := db.c.Query(`
-		SELECT DISTINCT u.username
+	rows, err := db.c.QueryContext(ctx, `
+		SELECT u.username
 		FROM comments c
 		INNER JOIN users u ON c.user_id = u.id
 		WHERE c.message_id = ?
-		ORDER BY c.timestamp DESC
+		GROUP BY u.username
+		ORDER BY MAX(c.timestamp) DESC, MAX(c.id) DESC
 		LIMIT 3
 	`, messageID)
 
@@ -0,0 +1,83 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// migrate brings the schema to the latest version of the dialect migration set. Applied versions are recorded in the
+// schema_migrations table, and each migration runs in its own transaction together with its bookkeeping row. In
+// SQLite, the foreign keys are disabled during the migrations, and checked before each commit.
+func (db *appdbimpl) migrate(ctx context.Context) error {
+	_, err := db.raw.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		applied_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);`)
+	if err != nil {
+		return fmt.Errorf("error creating schema_migrations: %w", err)
+	}
+
+	current, err := db.schemaVersion(ctx)
+	if err != nil {
+		return err
+	}
+
+	migrations := db.dialect.migrations()
+	if current > len(migrations) {
+		return fmt.Errorf("database schema version %d is newer than the latest known version %d", current, len(migrations))
+	}
+	if current == len(migrations) {
+		return nil
+	}
+
+	// Migrations run on their own connection, prepared by the dialect
+	conn, err := db.raw.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("error connecting for the migrations: %w", err)
+	}
+	defer func() { _ = conn.Close() }()
+	restore, err := db.dialect.prepareMigrations(ctx, conn)
+	if err != nil {
+		return err
+	}
+
+	for version := current + 1; version <= len(migrations); version++ {
+		if err := db.migrateTo(ctx, conn, version, migrations[version-1]); err != nil {
+			_ = restore()
+			return err
+		}
+	}
+	return restore()
+}
+
+// migrateTo applies the statements of the migration to `version` in a transaction of conn
+func (db *appdbimpl) migrateTo(ctx context.Context, conn *sql.Conn, version int, statements []string) error {
+	sqltx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting migration %d: %w", version, err)
+	}
+	defer func() { _ = sqltx.Rollback() }()
+	tx := rebound{q: sqltx, d: db.dialect}
+
+	for i, query := range statements {
+		if _, err := tx.ExecContext(ctx, query); err != nil {
+			return fmt.Errorf("error applying migration %d, statement %d: %w", version, i+1, err)
+		}
+	}
+	if err := db.dialect.checkMigration(ctx, tx); err != nil {
+		return fmt.Errorf("error applying migration %d: %w", version, err)
+	}
+	if _, err := tx.ExecContext(ctx, "INSERT INTO schema_migrations (version) VALUES (?)", version); err != nil {
+		return fmt.Errorf("error recording migration %d: %w", version, err)
+	}
+	if err := sqltx.Commit(); err != nil {
+		return fmt.Errorf("error committing migration %d: %w", version, err)
+	}
+	return nil
+}
+
+// schemaVersion returns the latest migration applied to the database (0 for an empty database).
+func (db *appdbimpl) schemaVersion(ctx context.Context) (int, error) {
+	return SchemaVersion(ctx, db.raw)
+}
@@ -0,0 +1,397 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/val7e/wasaText/service/models"
+)
+
+// RepliesPageSize is the maximum number of replies returned by GetReplies.
+const RepliesPageSize = 100
+
+// lastReplyJoin joins the most recent reply `lr` of each message `m`, through the (message_id, timestamp, id) index
+const lastReplyJoin = `
+	LEFT JOIN replies lr ON lr.id = (
+		SELECT r.id
+		FROM replies r
+		WHERE r.message_id = m.id
+		ORDER BY r.timestamp DESC, r.id DESC
+		LIMIT 1
+	)`
+
+// replyColumns are the columns read by scanReply, from the replies `r` joined with their senders `u`
+const replyColumns = "r.id, u.username, r.type, r.text, r.photo, r.timestamp"
+
+// ReplyToMessage adds a reply to the thread of a message. The sender follows the thread from then on, and so does the
+// sender of the message when the thread starts.
+func (db *appdbimpl) ReplyToMessage(ctx context.Context, messageID, conversationID, senderID int64, reply models.NewMessage) (*models.Reply, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	// Handle photo if present
+	var photoBytes []byte
+	if reply.Photo != nil && *reply.Photo != "" {
+		var err error
+		photoBytes, err = base64.StdEncoding.DecodeString(*reply.Photo)
+		if err != nil {
+			return nil, fmt.Errorf("invalid base64 photo data: %w", err)
+		}
+	}
+
+	// Handle text
+	var text sql.NullString
+	if reply.Text != nil {
+		text = sql.NullString{String: *reply.Text, Valid: true}
+	}
+
+	var created models.Reply
+	err := db.withTx(ctx, func(tx queryer) error {
+		messageSenderID, err := checkThread(ctx, tx, messageID, conversationID, senderID)
+		if err != nil {
+			return err
+		}
+
+		// Insert reply
+		var replyID int64
+		err = tx.QueryRowContext(ctx, `
+			INSERT INTO replies (message_id, sender_id, type, text, photo, timestamp)
+			VALUES (?, ?, ?, ?, ?, ?)
+			RETURNING id
+		`, messageID, senderID, reply.Type, text, photoBytes, db.now()).Scan(&replyID)
+		if err != nil {
+			return fmt.Errorf("error sending reply: %w", err)
+		}
+
+		// Subscribe the sender, who has read the thread up to the reply
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO thread_subscriptions (message_id, user_id, last_read_reply_id)
+			VALUES (?, ?, ?)
+			ON CONFLICT (message_id, user_id) DO NOTHING
+		`, messageID, senderID, replyID)
+		if err != nil {
+			return fmt.Errorf("error subscribing to thread: %w", err)
+		}
+
+		// The first reply starts the thread: subscribe the sender of the message too, if still a participant (later
+		// replies don't, so that an unsubscribed sender stays so)
+		var replyCount int
+		err = tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM replies WHERE message_id = ?", messageID).Scan(&replyCount)
+		if err != nil {
+			return fmt.Errorf("error counting replies: %w", err)
+		}
+		if replyCount == 1 {
+			_, err = tx.ExecContext(ctx, `
+				INSERT INTO thread_subscriptions (message_id, user_id, last_read_reply_id)
+				SELECT ?, user_id, 0 FROM conversation_participants
+				WHERE conversation_id = ? AND user_id = ?
+				ON CONFLICT (message_id, user_id) DO NOTHING
+			`, messageID, conversationID, messageSenderID)
+			if err != nil {
+				return fmt.Errorf("error subscribing to thread: %w", err)
+			}
+		}
+
+		created, err = scanReply(tx.QueryRowContext(ctx, `
+			SELECT `+replyColumns+`
+			FROM replies r
+			INNER JOIN users u ON r.sender_id = u.id
+			WHERE r.id = ?
+		`, replyID))
+		if err != nil {
+			return fmt.Errorf("error getting reply: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &created, nil
+}
+
+// GetReplies retrieves a page of the thread of a message, the oldest reply first: at most `limit` replies (at most
+// RepliesPageSize, also if limit is not positive) following the reply `after` (from the first one if after is 0).
+func (db *appdbimpl) GetReplies(ctx context.Context, messageID, conversationID, userID, after int64, limit int) ([]models.Reply, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	if limit <= 0 || limit > RepliesPageSize {
+		limit = RepliesPageSize
+	}
+
+	if _, err := checkThread(ctx, db.c, messageID, conversationID, userID); err != nil {
+		return nil, err
+	}
+
+	query := `
+		SELECT ` + replyColumns + `
+		FROM replies r
+		INNER JOIN users u ON r.sender_id = u.id
+		WHERE r.message_id = ?
+		ORDER BY r.timestamp ASC, r.id ASC
+		LIMIT ?
+	`
+	args := []interface{}{messageID, limit}
+	if after != 0 {
+		// The page starts after the position of the reply in the thread
+		if err := checkReply(ctx, db.c, messageID, after); err != nil {
+			return nil, err
+		}
+
+		query = `
+			SELECT ` + replyColumns + `
+			FROM replies r
+			INNER JOIN users u ON r.sender_id = u.id
+			WHERE r.message_id = ? AND (r.timestamp, r.id) > (SELECT timestamp, id FROM replies WHERE id = ?)
+			ORDER BY r.timestamp ASC, r.id ASC
+			LIMIT ?
+		`
+		args = []interface{}{messageID, after, limit}
+	}
+
+	rows, err := db.c.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error getting replies: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	var replies = []models.Reply{}
+	for rows.Next() {
+		reply, err := scanReply(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning reply: %w", err)
+		}
+		replies = append(replies, reply)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating replies: %w", err)
+	}
+
+	return replies, nil
+}
+
+// SubscribeThread makes the user follow the thread of a message. The replies sent before are considered read.
+// Subscribing again changes nothing.
+func (db *appdbimpl) SubscribeThread(ctx context.Context, messageID, conversationID, userID int64) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	return db.withTx(ctx, func(tx queryer) error {
+		if _, err := checkThread(ctx, tx, messageID, conversationID, userID); err != nil {
+			return err
+		}
+
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO thread_subscriptions (message_id, user_id, last_read_reply_id)
+			VALUES (?, ?, (SELECT COALESCE(MAX(id), 0) FROM replies WHERE message_id = ?))
+			ON CONFLICT (message_id, user_id) DO NOTHING
+		`, messageID, userID, messageID)
+		if err != nil {
+			return fmt.Errorf("error subscribing to thread: %w", err)
+		}
+		return nil
+	})
+}
+
+// UnsubscribeThread stops following the thread of a message. Unsubscribing from a thread not followed changes nothing.
+func (db *appdbimpl) UnsubscribeThread(ctx context.Context, messageID, conversationID, userID int64) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	return db.withTx(ctx, func(tx queryer) error {
+		if _, err := checkThread(ctx, tx, messageID, conversationID, userID); err != nil {
+			return err
+		}
+
+		_, err := tx.ExecContext(ctx,
+			"DELETE FROM thread_subscriptions WHERE message_id = ? AND user_id = ?",
+			messageID, userID,
+		)
+		if err != nil {
+			return fmt.Errorf("error unsubscribing from thread: %w", err)
+		}
+		return nil
+	})
+}
+
+// MarkThreadRead marks the replies of a followed thread as read, up to the reply `replyID` (included). The read
+// position never goes back.
+func (db *appdbimpl) MarkThreadRead(ctx context.Context, messageID, conversationID, userID, replyID int64) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	return db.withTx(ctx, func(tx queryer) error {
+		if _, err := checkThread(ctx, tx, messageID, conversationID, userID); err != nil {
+			return err
+		}
+		if err := checkReply(ctx, tx, messageID, replyID); err != nil {
+			return err
+		}
+
+		var lastRead int64
+		err := tx.QueryRowContext(ctx,
+			"SELECT last_read_reply_id FROM thread_subscriptions WHERE message_id = ? AND user_id = ?",
+			messageID, userID,
+		).Scan(&lastRead)
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("user not subscribed to thread")
+		}
+		if err != nil {
+			return fmt.Errorf("error finding subscription: %w", err)
+		}
+
+		if replyID <= lastRead {
+			return nil
+		}
+		_, err = tx.ExecContext(ctx,
+			"UPDATE thread_subscriptions SET last_read_reply_id = ? WHERE message_id = ? AND user_id = ?",
+			replyID, messageID, userID,
+		)
+		if err != nil {
+			return fmt.Errorf("error marking thread read: %w", err)
+		}
+		return nil
+	})
+}
+
+// GetMyThreads retrieves the threads followed by a user in the conversations the user is still part of, the most
+// recent reply first (threads without replies last). The unread replies are the ones sent by others after the last
+// reply marked as read.
+func (db *appdbimpl) GetMyThreads(ctx context.Context, userID int64) ([]models.ThreadSummary, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := db.c.QueryContext(ctx, `
+		SELECT
+			m.conversation_id,
+			m.id,
+			m.timestamp,
+			`+previewOf("m")+`,
+			(SELECT COUNT(*) FROM replies r WHERE r.message_id = m.id) AS reply_count,
+			(SELECT COUNT(*) FROM replies r
+				WHERE r.message_id = m.id AND r.id > ts.last_read_reply_id AND r.sender_id <> ts.user_id) AS unread_count,
+			lr.timestamp AS last_reply_timestamp,
+			COALESCE(lr.text, 'Photo') AS last_reply_preview
+		FROM thread_subscriptions ts
+		INNER JOIN messages m ON m.id = ts.message_id
+		INNER JOIN conversation_participants cp ON cp.conversation_id = m.conversation_id AND cp.user_id = ts.user_id
+		`+lastReplyJoin+`
+		WHERE ts.user_id = ?
+		ORDER BY last_reply_timestamp DESC NULLS LAST, lr.id DESC, m.id DESC
+		LIMIT 1000
+	`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching threads: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	var threads = []models.ThreadSummary{}
+	for rows.Next() {
+		var thread models.ThreadSummary
+		var lastReplyTimestamp sql.NullTime
+		var lastReplyPreview string
+
+		err := rows.Scan(
+			&thread.ConversationId,
+			&thread.MessageId,
+			&thread.Message.Timestamp,
+			&thread.Message.Preview,
+			&thread.ReplyCount,
+			&thread.UnreadCount,
+			&lastReplyTimestamp,
+			&lastReplyPreview,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning thread: %w", err)
+		}
+		thread.Message.Timestamp = thread.Message.Timestamp.UTC()
+		thread.LastReply = lastReply(lastReplyTimestamp, lastReplyPreview)
+
+		threads = append(threads, thread)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating threads: %w", err)
+	}
+
+	return threads, nil
+}
+
+// checkThread verifies that the message exists in the conversation, and that the user is a participant. It returns
+// the sender of the message.
+func checkThread(ctx context.Context, q queryer, messageID, conversationID, userID int64) (int64, error) {
+	var msgConversationID, senderID int64
+	err := q.QueryRowContext(ctx,
+		"SELECT conversation_id, sender_id FROM messages WHERE id = ?",
+		messageID,
+	).Scan(&msgConversationID, &senderID)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, fmt.Errorf("message not found")
+	}
+	if err != nil {
+		return 0, fmt.Errorf("error finding message: %w", err)
+	}
+
+	if msgConversationID != conversationID {
+		return 0, fmt.Errorf("message does not belong to specified conversation")
+	}
+
+	var participantCount int
+	err = q.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM conversation_participants
+		WHERE conversation_id = ? AND user_id = ?
+	`, conversationID, userID).Scan(&participantCount)
+
+	if err != nil || participantCount == 0 {
+		return 0, fmt.Errorf("user not participant in conversation")
+	}
+	return senderID, nil
+}
+
+// checkReply verifies that the reply is in the thread of the message
+func checkReply(ctx context.Context, q queryer, messageID, replyID int64) error {
+	var replyMessageID int64
+	err := q.QueryRowContext(ctx, "SELECT message_id FROM replies WHERE id = ?", replyID).Scan(&replyMessageID)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && replyMessageID != messageID) {
+		return fmt.Errorf("reply not found")
+	}
+	if err != nil {
+		return fmt.Errorf("error finding reply: %w", err)
+	}
+	return nil
+}
+
+// scanReply reads a reply selected with replyColumns
+func scanReply(row scanner) (models.Reply, error) {
+	var reply models.Reply
+	var text sql.NullString
+	var photoBytes []byte
+	var timestamp time.Time
+	if err := row.Scan(&reply.Id, &reply.Sender, &reply.Type, &text, &photoBytes, &timestamp); err != nil {
+		return reply, err
+	}
+	reply.Timestamp = timestamp.UTC()
+	if text.Valid {
+		reply.Text = &text.String
+	}
+	if len(photoBytes) > 0 {
+		photo := base64.StdEncoding.EncodeToString(photoBytes)
+		reply.Photo = &photo
+	}
+	return reply, nil
+}
+
+// lastReply returns the preview of the last reply read through lastReplyJoin, nil if the thread has no replies
+func lastReply(timestamp sql.NullTime, preview string) *models.MessagePreview {
+	if !timestamp.Valid {
+		return nil
+	}
+	return &models.MessagePreview{Timestamp: timestamp.Time.UTC(), Preview: preview}
+}
@@ -1,6 +1,7 @@
 package database
 
 import (
+	"context"
 	"database/sql"
 	"encoding/base64"
 	"errors"
@@ -8,6 +9,7 @@ import (
 	"regexp"
 
 	"github.com/val7e/wasaText/service/models"
+	"github.com/val7e/wasaText/service/thumbnail"
 )
 
 // creates a default photo: 5x5 red square PNG in base64
@@ -15,6 +17,7 @@ const defaultPhotoBase64 = "iVBORw0KGgoAAAANSUhEUgAAAAUAAAAFCAYAAACNbyblAAAAHElE
 
 var (
 	defaultPhotoBytes []byte
+	defaultThumbBytes []byte
 	// Username validation regex
 	usernameRegex = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
 )
@@ -26,6 +29,53 @@ func init() {
 	if err != nil {
 		panic("Failed to decode default photo: " + err.Error())
 	}
+	defaultThumbBytes, err = thumbnail.Make(defaultPhotoBytes)
+	if err != nil {
+		panic("Failed to make the default thumbnail: " + err.Error())
+	}
+}
+
+// thumbnailOf returns the thumbnail of a profile picture, or the default one if the picture is not an image that
+// thumbnail.Make supports
+func thumbnailOf(pic []byte) []byte {
+	thumb, err := thumbnail.Make(pic)
+	if err != nil {
+		return defaultThumbBytes
+	}
+	return thumb
+}
+
+// fillThumbnails makes the thumbnails of the profile pictures set before thumbnails existed (schema version 5).
+func (db *appdbimpl) fillThumbnails(ctx context.Context) error {
+	rows, err := db.c.QueryContext(ctx, "SELECT id, pic FROM users WHERE pic_thumb IS NULL")
+	if err != nil {
+		return fmt.Errorf("error finding users without thumbnail: %w", err)
+	}
+	type missing struct {
+		id  int64
+		pic []byte
+	}
+	var users []missing
+	for rows.Next() {
+		var u missing
+		if err := rows.Scan(&u.id, &u.pic); err != nil {
+			_ = rows.Close()
+			return fmt.Errorf("error scanning user: %w", err)
+		}
+		users = append(users, u)
+	}
+	if err := rows.Err(); err != nil {
+		_ = rows.Close()
+		return fmt.Errorf("error iterating users: %w", err)
+	}
+	_ = rows.Close()
+
+	for _, u := range users {
+		if _, err := db.c.ExecContext(ctx, "UPDATE users SET pic_thumb = ? WHERE id = ?", thumbnailOf(u.pic), u.id); err != nil {
+			return fmt.Errorf("error saving the thumbnail of user %d: %w", u.id, err)
+		}
+	}
+	return nil
 }
 
 // validateUsername checks if username meets requirements
@@ -44,60 +94,69 @@ func validateUsername(username string) error {
 }
 
 // DoLogin handles user's login/registration
-func (db *appdbimpl) DoLogin(username string) (*models.User, bool, error) {
+func (db *appdbimpl) DoLogin(ctx context.Context, username string) (*models.User, bool, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
 	// Validate username format
 	if err := validateUsername(username); err != nil {
 		return nil, false, err
 	}
 
-	// Checks if the user already exists
 	var user models.User
 	var picBytes []byte
-	err := db.c.QueryRow(
-		"SELECT id, username, pic FROM users WHERE username = ?",
-		username,
-	).Scan(&user.Id, &user.Username, &picBytes)
-
-	if err == nil {
-		// User already exists - login
-		user.Pic = base64.StdEncoding.EncodeToString(picBytes)
-		return &user, false, nil
-	}
-
-	if !errors.Is(err, sql.ErrNoRows) {
-		return nil, false, fmt.Errorf("error checking user existence: %w", err)
-	}
+	var isNewUser bool
+
+	err := db.withTx(ctx, func(tx queryer) error {
+		// Checks if the user already exists
+		err := tx.QueryRowContext(ctx,
+			"SELECT id, username, pic FROM users WHERE username = ?",
+			username,
+		).Scan(&user.Id, &user.Username, &picBytes)
+
+		if err == nil {
+			// User already exists - login
+			return nil
+		}
 
-	// User doesn't exist - registration with default pic
-	result, err := db.c.Exec(
-		"INSERT INTO users (username, pic) VALUES (?, ?)",
-		username,
-		defaultPhotoBytes,
-	)
-	if err != nil {
-		return nil, false, fmt.Errorf("error creating user: %w", err)
-	}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("error checking user existence: %w", err)
+		}
 
-	userID, err := result.LastInsertId()
+		// User doesn't exist - registration with default pic
+		now := db.now()
+		err = tx.QueryRowContext(ctx,
+			"INSERT INTO users (username, pic, pic_thumb, created_at, updated_at) VALUES (?, ?, ?, ?, ?) RETURNING id",
+			username,
+			defaultPhotoBytes,
+			defaultThumbBytes,
+			now, now,
+		).Scan(&user.Id)
+		if err != nil {
+			return fmt.Errorf("error creating user: %w", err)
+		}
+		user.Username = username
+		picBytes = defaultPhotoBytes
+		isNewUser = true
+		return nil
+	})
 	if err != nil {
-		return nil, false, fmt.Errorf("error getting new user ID: %w", err)
-	}
-
-	// Return the profile of the new user
-	newUser := models.User{
-		Id:       userID,
-		Username: username,
-		Pic:      base64.StdEncoding.EncodeToString(defaultPhotoBytes),
+		return nil, false, err
 	}
 
-	return &newUser, true, nil
+	// Return the profile of the (possibly new) user
+	user.Pic = base64.StdEncoding.EncodeToString(picBytes)
+	return &user, isNewUser, nil
 }
 
 // SearchUser searches for users by username pattern
-func (db *appdbimpl) SearchUser(query string) ([]models.User, error) {
+func (db *appdbimpl) SearchUser(ctx context.Context, query string) ([]models.User, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
 	searchPattern := "%" + query + "%"
-	rows, err := db.c.Query(
-		"SELECT id, username, pic FROM users WHERE username LIKE ? ORDER BY username LIMIT 700",
+	rows, err := db.c.QueryContext(ctx,
+		"SELECT id, username, pic FROM users WHERE username "+db.dialect.caseInsensitiveLike()+" ? ORDER BY username LIMIT 700",
 		searchPattern,
 	)
 	if err != nil {
@@ -105,7 +164,7 @@ func (db *appdbimpl) SearchUser(query string) ([]models.User, error) {
 	}
 	defer func() { _ = rows.Close() }()
 
-	var users []models.User
+	var users = []models.User{}
 	for rows.Next() {
 		var user models.User
 		var picBytes []byte
@@ -125,54 +184,68 @@ func (db *appdbimpl) SearchUser(query string) ([]models.User, error) {
 }
 
 // SetMyUserName updates the user's username
-func (db *appdbimpl) SetMyUserName(userID int64, newUsername string) (*models.User, error) {
+func (db *appdbimpl) SetMyUserName(ctx context.Context, userID int64, newUsername string) (*models.User, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
 	// Validate new username format
 	if err := validateUsername(newUsername); err != nil {
 		return nil, err
 	}
 
-	var existingID int64
-	err := db.c.QueryRow("SELECT id FROM users WHERE username = ?", newUsername).Scan(&existingID)
-
-	if err == nil {
-		// Username exists, check if it's a different user
-		if existingID != userID {
-			return nil, fmt.Errorf("username already taken")
+	err := db.withTx(ctx, func(tx queryer) error {
+		var existingID int64
+		err := tx.QueryRowContext(ctx, "SELECT id FROM users WHERE username = ?", newUsername).Scan(&existingID)
+
+		if err == nil {
+			// Username exists, check if it's a different user
+			if existingID != userID {
+				return fmt.Errorf("username already taken")
+			}
+			// Same user, same username - no update needed
+			return nil
 		}
-		// Same user, same username - just return current user (no update needed)
-		return db.GetUserByID(userID)
-	}
 
-	if !errors.Is(err, sql.ErrNoRows) {
-		// Database error
-		return nil, fmt.Errorf("error checking username availability: %w", err)
-	}
+		if !errors.Is(err, sql.ErrNoRows) {
+			// Database error
+			return fmt.Errorf("error checking username availability: %w", err)
+		}
 
-	// Username is available, update it
-	_, err = db.c.Exec(
-		"UPDATE users SET username = ? WHERE id = ?",
-		newUsername, userID,
-	)
+		// Username is available, update it
+		_, err = tx.ExecContext(ctx,
+			"UPDATE users SET username = ?, updated_at = ? WHERE id = ?",
+			newUsername, db.now(), userID,
+		)
+		if err != nil {
+			return fmt.Errorf("error updating username: %w", err)
+		}
+		return nil
+	})
 	if err != nil {
-		return nil, fmt.Errorf("error updating username: %w", err)
+		return nil, err
 	}
 
 	// Return the updated user
-	return db.GetUserByID(userID)
+	return db.GetUserByID(ctx, userID)
 }
 
 // SetMyPhoto updates the user's profile picture
-func (db *appdbimpl) SetMyPhoto(userID int64, newPicBase64 string) (*models.User, error) {
+func (db *appdbimpl) SetMyPhoto(ctx context.Context, userID int64, newPicBase64 string) (*models.User, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
 	// Decode base64 string to binary data
 	picBytes, err := base64.StdEncoding.DecodeString(newPicBase64)
 	if err != nil {
 		return nil, fmt.Errorf("invalid base64 photo data")
 	}
 
-	// Update the photo in database as BLOB
-	_, err = db.c.Exec(
-		"UPDATE users SET pic = ? WHERE id = ?",
+	// Update the photo in database as BLOB, with its thumbnail
+	_, err = db.c.ExecContext(ctx,
+		"UPDATE users SET pic = ?, pic_thumb = ?, updated_at = ? WHERE id = ?",
 		picBytes,
+		thumbnailOf(picBytes),
+		db.now(),
 		userID,
 	)
 	if err != nil {
@@ -180,14 +253,17 @@ func (db *appdbimpl) SetMyPhoto(userID int64, newPicBase64 string) (*models.User
 	}
 
 	// Returns the updated user
-	return db.GetUserByID(userID)
+	return db.GetUserByID(ctx, userID)
 }
 
 // GetUserByID retrieves a user by ID
-func (db *appdbimpl) GetUserByID(userID int64) (*models.User, error) {
+func (db *appdbimpl) GetUserByID(ctx context.Context, userID int64) (*models.User, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
 	var user models.User
 	var picBytes []byte
-	err := db.c.QueryRow(
+	err := db.c.QueryRowContext(ctx,
 		"SELECT id, username, pic FROM users WHERE id = ?",
 		userID,
 	).Scan(&user.Id, &user.Username, &picBytes)
@@ -204,10 +280,13 @@ func (db *appdbimpl) GetUserByID(userID int64) (*models.User, error) {
 }
 
 // GetUserByUsername retrieves a user by username
-func (db *appdbimpl) GetUserByUsername(username string) (*models.User, error) {
+func (db *appdbimpl) GetUserByUsername(ctx context.Context, username string) (*models.User, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
 	var user models.User
 	var picBytes []byte
-	err := db.c.QueryRow(
+	err := db.c.QueryRowContext(ctx,
 		"SELECT id, username, pic FROM users WHERE username = ?",
 		username,
 	).Scan(&user.Id, &user.Username, &picBytes)
@@ -0,0 +1,62 @@
+package globaltime
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock tells the current time. Components that record or compare timestamps take a Clock in their configuration,
+// so that tests can control the time they see.
+type Clock interface {
+	Now() time.Time
+}
+
+// System is the Clock of the Now function: the current time, or FixedTime if set.
+var System Clock = systemClock{}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return Now()
+}
+
+// OrSystem returns c, or System if c is nil. Use it to give a default to the optional Clock of a configuration.
+func OrSystem(c Clock) Clock {
+	if c == nil {
+		return System
+	}
+	return c
+}
+
+// Fake is a Clock for tests: the time stands still until it is moved with Advance or Set. It is safe for concurrent
+// use.
+type Fake struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+// NewFake returns a Fake clock set to `now`.
+func NewFake(now time.Time) *Fake {
+	return &Fake{now: now}
+}
+
+// Now returns the time of the clock.
+func (f *Fake) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+// Advance moves the clock forward by d (backward if d is negative).
+func (f *Fake) Advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.now = f.now.Add(d)
+}
+
+// Set moves the clock to `now`.
+func (f *Fake) Set(now time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.now = now
+}
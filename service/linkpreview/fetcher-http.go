@@ -0,0 +1,192 @@
+package linkpreview
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+	"unicode/utf8"
+
+	"github.com/val7e/wasaText/service/models"
+)
+
+// Errors returned by HTTPFetcher
+var (
+	ErrPrivateNetwork = errors.New("the address is in a private network")
+	ErrNotHTML        = errors.New("the page is not HTML")
+	ErrNoPreview      = errors.New("the page has no title nor description")
+)
+
+// HTTPConfig is used to provide options to NewHTTPFetcher.
+type HTTPConfig struct {
+	// Timeout bounds each fetch, from the connection to the last byte read (default 5s)
+	Timeout time.Duration
+
+	// MaxBytes is the maximum number of bytes read from a page (default 512 KiB). The previews are in the head of
+	// the page, so the rest is not needed.
+	MaxBytes int64
+
+	// MaxRedirects is the maximum number of redirects followed (default 5)
+	MaxRedirects int
+
+	// UserAgent is sent to the web servers (default "wasaText-linkpreview/1.0")
+	UserAgent string
+
+	// AllowPrivateNetworks allows the addresses of private networks, of the loopback and link-local ones (useful in
+	// development). Otherwise they are refused, after the name resolution and at each redirect, so that the links of
+	// the users can't reach the services behind the server.
+	AllowPrivateNetworks bool
+}
+
+// HTTPFetcher fetches the previews from the web, reading the title, the description and the image of the head of
+// the HTML pages (Open Graph and Twitter tags first, then <title> and <meta name="description">).
+type HTTPFetcher struct {
+	cfg    HTTPConfig
+	client *http.Client
+}
+
+// NewHTTPFetcher returns an HTTPFetcher.
+func NewHTTPFetcher(cfg HTTPConfig) *HTTPFetcher {
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 5 * time.Second
+	}
+	if cfg.MaxBytes <= 0 {
+		cfg.MaxBytes = 512 << 10
+	}
+	if cfg.MaxRedirects <= 0 {
+		cfg.MaxRedirects = 5
+	}
+	if cfg.UserAgent == "" {
+		cfg.UserAgent = "wasaText-linkpreview/1.0"
+	}
+
+	dialer := &net.Dialer{Timeout: cfg.Timeout}
+	if !cfg.AllowPrivateNetworks {
+		// The check runs on the resolved address of each connection, so that DNS names can't point inside
+		dialer.Control = func(network, address string, _ syscall.RawConn) error {
+			return checkAddress(address)
+		}
+	}
+	transport := &http.Transport{
+		// No proxy: it would connect in our place, skipping the check of the addresses
+		Proxy:                 nil,
+		DialContext:           dialer.DialContext,
+		TLSHandshakeTimeout:   cfg.Timeout,
+		ResponseHeaderTimeout: cfg.Timeout,
+		MaxIdleConns:          10,
+		IdleConnTimeout:       30 * time.Second,
+	}
+	client := &http.Client{
+		Transport: transport,
+		Timeout:   cfg.Timeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) > cfg.MaxRedirects {
+				return errors.New("too many redirects")
+			}
+			if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+				return fmt.Errorf("redirect to a %s URL", req.URL.Scheme)
+			}
+			return nil
+		},
+	}
+	return &HTTPFetcher{cfg: cfg, client: client}
+}
+
+// Fetch returns the preview of the page at link, an http or https URL.
+func (f *HTTPFetcher) Fetch(ctx context.Context, link string) (*models.LinkPreview, error) {
+	u, err := url.Parse(link)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, link, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", f.cfg.UserAgent)
+	req.Header.Set("Accept", "text/html,application/xhtml+xml")
+
+	res, err := f.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = res.Body.Close() }()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status %s", res.Status)
+	}
+	mediaType, _, err := mime.ParseMediaType(res.Header.Get("Content-Type"))
+	if err != nil || (mediaType != "text/html" && mediaType != "application/xhtml+xml") {
+		return nil, ErrNotHTML
+	}
+	body, err := io.ReadAll(io.LimitReader(res.Body, f.cfg.MaxBytes))
+	if err != nil {
+		return nil, fmt.Errorf("reading the page: %w", err)
+	}
+
+	page := string(body)
+	if !utf8.ValidString(page) {
+		page = strings.ToValidUTF8(page, "�")
+	}
+	preview := parseHead(page, res.Request.URL)
+	if preview.Title == "" && preview.Description == "" {
+		return nil, ErrNoPreview
+	}
+	preview.URL = link
+	return &preview, nil
+}
+
+// checkAddress returns ErrPrivateNetwork if address, the host:port of a connection after the name resolution, is in a
+// private network. The tests replace it to let some of their servers, all on the loopback, through.
+var checkAddress = func(address string) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || isPrivate(ip) {
+		return ErrPrivateNetwork
+	}
+	return nil
+}
+
+// reserved are the networks of addresses that are not public, besides the ones of the methods of net.IP
+var reserved = []*net.IPNet{
+	mustParseCIDR("0.0.0.0/8"),     // "this" network
+	mustParseCIDR("100.64.0.0/10"), // carrier-grade NAT
+	mustParseCIDR("192.0.0.0/24"),  // IETF protocol assignments
+	mustParseCIDR("198.18.0.0/15"), // benchmarking
+	mustParseCIDR("240.0.0.0/4"),   // reserved, and broadcast
+	mustParseCIDR("64:ff9b::/96"),  // NAT64, which maps the IPv4 addresses, private ones too
+}
+
+// isPrivate returns true if ip can't be reached from the internet
+func isPrivate(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return true
+	}
+	for _, n := range reserved {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func mustParseCIDR(s string) *net.IPNet {
+	_, n, err := net.ParseCIDR(s)
+	if err != nil {
+		panic(err)
+	}
+	return n
+}
@@ -0,0 +1,173 @@
+package linkpreview
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestIsPrivate(t *testing.T) {
+	tests := []struct {
+		ip      string
+		private bool
+	}{
+		{"127.0.0.1", true},
+		{"127.1.2.3", true},
+		{"::1", true},
+		{"10.1.2.3", true},
+		{"172.16.0.1", true},
+		{"172.31.255.255", true},
+		{"192.168.1.1", true},
+		{"fc00::1", true},
+		{"169.254.169.254", true}, // cloud metadata services
+		{"fe80::1", true},
+		{"::ffff:127.0.0.1", true},
+		{"::ffff:10.0.0.1", true},
+		{"::ffff:169.254.169.254", true},
+		{"64:ff9b::7f00:1", true}, // NAT64 of 127.0.0.1
+		{"64:ff9b::a00:1", true},  // NAT64 of 10.0.0.1
+		{"0.0.0.0", true},
+		{"::", true},
+		{"100.64.0.1", true},
+		{"224.0.0.1", true},
+		{"255.255.255.255", true},
+
+		{"8.8.8.8", false},
+		{"172.32.0.1", false},
+		{"2001:4860:4860::8888", false},
+		{"::ffff:8.8.8.8", false},
+	}
+	for _, tt := range tests {
+		ip := net.ParseIP(tt.ip)
+		if ip == nil {
+			t.Fatalf("invalid test address %s", tt.ip)
+		}
+		if got := isPrivate(ip); got != tt.private {
+			t.Errorf("isPrivate(%s) = %v, want %v", tt.ip, got, tt.private)
+		}
+	}
+}
+
+// allowAddresses lets the connections to the given host:port addresses through the private network check, until the
+// end of the test
+func allowAddresses(t *testing.T, addresses ...string) {
+	check := checkAddress
+	t.Cleanup(func() { checkAddress = check })
+	checkAddress = func(address string) error {
+		for _, a := range addresses {
+			if address == a {
+				return nil
+			}
+		}
+		return check(address)
+	}
+}
+
+func serveHTML(page string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_, _ = w.Write([]byte(page))
+	}
+}
+
+func TestFetch(t *testing.T) {
+	srv := httptest.NewServer(serveHTML(`<html><head><title>Hello</title>
+		<meta name="description" content="A page"><meta property="og:image" content="/logo.png"></head></html>`))
+	defer srv.Close()
+
+	f := NewHTTPFetcher(HTTPConfig{AllowPrivateNetworks: true})
+	preview, err := f.Fetch(context.Background(), srv.URL+"/page")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if preview.URL != srv.URL+"/page" || preview.Title != "Hello" || preview.Description != "A page" ||
+		preview.Image != srv.URL+"/logo.png" {
+		t.Errorf("got %+v", preview)
+	}
+}
+
+func TestFetchPrivateNetwork(t *testing.T) {
+	var reached int32
+	internal := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.StoreInt32(&reached, 1)
+		serveHTML("<title>Internal</title>")(w, r)
+	}))
+	defer internal.Close()
+	redirect := httptest.NewServer(http.RedirectHandler(internal.URL, http.StatusFound))
+	defer redirect.Close()
+
+	// The first server stands for a public one, redirecting to an internal address
+	allowAddresses(t, redirect.Listener.Addr().String())
+	f := NewHTTPFetcher(HTTPConfig{})
+
+	for name, link := range map[string]string{
+		"direct":    internal.URL,
+		"localhost": strings.Replace(internal.URL, "127.0.0.1", "localhost", 1),
+		"redirect":  redirect.URL,
+	} {
+		if preview, err := f.Fetch(context.Background(), link); !errors.Is(err, ErrPrivateNetwork) {
+			t.Errorf("%s: got %+v, %v, want %v", name, preview, err, ErrPrivateNetwork)
+		}
+	}
+	if atomic.LoadInt32(&reached) != 0 {
+		t.Error("the internal server was reached")
+	}
+}
+
+func TestFetchTimeout(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer srv.Close()
+
+	f := NewHTTPFetcher(HTTPConfig{Timeout: 100 * time.Millisecond, AllowPrivateNetworks: true})
+	start := time.Now()
+	if _, err := f.Fetch(context.Background(), srv.URL); err == nil {
+		t.Fatal("the fetch of a page that never answers succeeded")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("the fetch took %v, want about the 100ms timeout", elapsed)
+	}
+}
+
+func TestFetchMaxBytes(t *testing.T) {
+	padding := strings.Repeat(" ", 2048)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/late" {
+			serveHTML("<head>"+padding+"<title>Late</title></head>")(w, r)
+		} else {
+			serveHTML("<head><title>Early</title>"+padding+"</head>")(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	f := NewHTTPFetcher(HTTPConfig{MaxBytes: 1024, AllowPrivateNetworks: true})
+	if preview, err := f.Fetch(context.Background(), srv.URL+"/early"); err != nil || preview.Title != "Early" {
+		t.Errorf("title within the limit: got %+v, %v", preview, err)
+	}
+	if preview, err := f.Fetch(context.Background(), srv.URL+"/late"); !errors.Is(err, ErrNoPreview) {
+		t.Errorf("title past the limit: got %+v, %v, want %v", preview, err, ErrNoPreview)
+	}
+}
+
+func TestFetchNotHTML(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		_, _ = w.Write([]byte("<title>Not a page</title>"))
+	}))
+	defer srv.Close()
+
+	f := NewHTTPFetcher(HTTPConfig{AllowPrivateNetworks: true})
+	if preview, err := f.Fetch(context.Background(), srv.URL); !errors.Is(err, ErrNotHTML) {
+		t.Errorf("got %+v, %v, want %v", preview, err, ErrNotHTML)
+	}
+}
@@ -0,0 +1,163 @@
+package linkpreview
+
+import (
+	"html"
+	"net/url"
+	"strings"
+
+	"github.com/val7e/wasaText/service/markup"
+	"github.com/val7e/wasaText/service/models"
+)
+
+// Maximum lengths of the texts of a preview, in characters
+const (
+	maxTitle       = 200
+	maxDescription = 500
+)
+
+// parseHead reads the preview in the head of an HTML page, ending at the <body>. base is the URL of the page, for the
+// relative URLs of the images.
+func parseHead(page string, base *url.URL) models.LinkPreview {
+	// Properties and names of the <meta> tags, by priority
+	var (
+		title       = []string{"og:title", "twitter:title"}
+		description = []string{"og:description", "twitter:description", "description"}
+		image       = []string{"og:image", "og:image:url", "og:image:secure_url", "twitter:image"}
+	)
+	meta := make(map[string]string)
+	var titleTag string
+
+	for i := 0; i < len(page); {
+		lt := strings.IndexByte(page[i:], '<')
+		if lt < 0 {
+			break
+		}
+		i += lt
+		if strings.HasPrefix(page[i:], "<!--") {
+			end := strings.Index(page[i+4:], "-->")
+			if end < 0 {
+				break
+			}
+			i += 4 + end + 3
+			continue
+		}
+
+		name, attrs, end := readTag(page[i:])
+		i += end
+		switch name {
+		case "body", "/head":
+			i = len(page)
+		case "title", "script", "style":
+			// Their content is text, up to the closing tag
+			close := strings.Index(strings.ToLower(page[i:]), "</"+name)
+			if close < 0 {
+				close = len(page) - i
+			}
+			if name == "title" && titleTag == "" {
+				titleTag = page[i : i+close]
+			}
+			i += close
+		case "meta":
+			key := strings.ToLower(attrs["property"])
+			if key == "" {
+				key = strings.ToLower(attrs["name"])
+			}
+			if _, ok := meta[key]; !ok && key != "" {
+				meta[key] = attrs["content"]
+			}
+		}
+	}
+
+	preview := models.LinkPreview{
+		Title:       clean(first(meta, title), maxTitle),
+		Description: clean(first(meta, description), maxDescription),
+	}
+	if preview.Title == "" {
+		preview.Title = clean(titleTag, maxTitle)
+	}
+	if src := strings.TrimSpace(html.UnescapeString(first(meta, image))); src != "" {
+		if u, err := base.Parse(src); err == nil && (u.Scheme == "http" || u.Scheme == "https") &&
+			len(u.String()) <= markup.MaxURLLength {
+			preview.Image = u.String()
+		}
+	}
+	return preview
+}
+
+// readTag reads the tag at the start of s (starting with <): it returns its lowercase name (with the / of the
+// closing tags), its attributes (with raw values), and the length of the tag
+func readTag(s string) (string, map[string]string, int) {
+	i := 1
+	for i < len(s) && !isSpace(s[i]) && s[i] != '>' {
+		i++
+	}
+	name := strings.ToLower(strings.TrimSuffix(s[1:i], "/"))
+
+	attrs := make(map[string]string)
+	for i < len(s) && s[i] != '>' {
+		for i < len(s) && (isSpace(s[i]) || s[i] == '/') {
+			i++
+		}
+		start := i
+		for i < len(s) && !isSpace(s[i]) && s[i] != '=' && s[i] != '>' {
+			i++
+		}
+		key := strings.ToLower(s[start:i])
+		for i < len(s) && isSpace(s[i]) {
+			i++
+		}
+		value := ""
+		if i < len(s) && s[i] == '=' {
+			i++
+			for i < len(s) && isSpace(s[i]) {
+				i++
+			}
+			if i < len(s) && (s[i] == '"' || s[i] == '\'') {
+				quote := s[i]
+				end := strings.IndexByte(s[i+1:], quote)
+				if end < 0 {
+					return name, attrs, len(s)
+				}
+				value = s[i+1 : i+1+end]
+				i += end + 2
+			} else {
+				start := i
+				for i < len(s) && !isSpace(s[i]) && s[i] != '>' {
+					i++
+				}
+				value = s[start:i]
+			}
+		}
+		if key != "" {
+			attrs[key] = value
+		} else if i == start {
+			i++
+		}
+	}
+	if i < len(s) {
+		i++ // the >
+	}
+	return name, attrs, i
+}
+
+func first(meta map[string]string, keys []string) string {
+	for _, k := range keys {
+		if v := strings.TrimSpace(meta[k]); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// clean decodes the HTML entities of s, collapses its spaces and truncates it to max characters
+func clean(s string, max int) string {
+	s = strings.Join(strings.Fields(html.UnescapeString(s)), " ")
+	if r := []rune(s); len(r) > max {
+		s = strings.TrimSpace(string(r[:max-1])) + "…"
+	}
+	return s
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+}
@@ -0,0 +1,133 @@
+package linkpreview
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/val7e/wasaText/service/models"
+)
+
+func TestParseHead(t *testing.T) {
+	base, err := url.Parse("https://example.com/news/today.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		page string
+		want models.LinkPreview
+	}{
+		{
+			"Open Graph first",
+			`<head><title>Tag</title><meta name="twitter:title" content="Twitter">
+			<meta property="og:title" content="OG"><meta property="og:description" content="Described"></head>`,
+			models.LinkPreview{Title: "OG", Description: "Described"},
+		},
+		{
+			"Twitter before the title tag",
+			`<head><title>Tag</title><meta name="twitter:title" content="Twitter">
+			<meta name="twitter:description" content="Tweet"><meta name="description" content="Plain"></head>`,
+			models.LinkPreview{Title: "Twitter", Description: "Tweet"},
+		},
+		{
+			"title tag and description",
+			`<HEAD><TITLE>  Just   the
+			tag </TITLE><META NAME="Description" CONTENT="Plain"></HEAD>`,
+			models.LinkPreview{Title: "Just the tag", Description: "Plain"},
+		},
+		{
+			"first of the repeated tags",
+			`<meta property="og:title" content="First"><meta property="og:title" content="Second">`,
+			models.LinkPreview{Title: "First"},
+		},
+		{
+			"absolute image path",
+			`<title>x</title><meta property="og:image" content="/img/a.png">`,
+			models.LinkPreview{Title: "x", Image: "https://example.com/img/a.png"},
+		},
+		{
+			"relative image path",
+			`<title>x</title><meta property="og:image" content="a.png?w=1&amp;h=2">`,
+			models.LinkPreview{Title: "x", Image: "https://example.com/news/a.png?w=1&h=2"},
+		},
+		{
+			"image without scheme",
+			`<title>x</title><meta property="og:image" content="//cdn.example.org/a.png">`,
+			models.LinkPreview{Title: "x", Image: "https://cdn.example.org/a.png"},
+		},
+		{
+			"image not on the web",
+			`<title>x</title><meta property="og:image" content="javascript:alert(1)">`,
+			models.LinkPreview{Title: "x"},
+		},
+		{
+			"entities",
+			`<title>Tom &amp; Jerry &#8212; &lt;b&gt;</title><meta property="og:description" content='&quot;Quoted&quot; &eacute;t&eacute;'>`,
+			models.LinkPreview{Title: "Tom & Jerry — <b>", Description: `"Quoted" été`},
+		},
+		{
+			"unquoted attributes",
+			`<meta property=og:title content=Bare>`,
+			models.LinkPreview{Title: "Bare"},
+		},
+		{
+			"meta in comments and scripts",
+			`<!-- <meta property="og:title" content="Comment"> --><script>var s = '<meta property="og:title" content="Script">'</script>
+			<title>Real</title>`,
+			models.LinkPreview{Title: "Real"},
+		},
+		{
+			"after the body",
+			`<head><title>Head</title></head><body><meta property="og:description" content="Body">`,
+			models.LinkPreview{Title: "Head"},
+		},
+		{
+			"unclosed title",
+			`<head><title>Never closed`,
+			models.LinkPreview{Title: "Never closed"},
+		},
+		{
+			"unterminated attribute",
+			`<title>Kept</title><meta property="og:title" content="Unterminated`,
+			models.LinkPreview{Title: "Kept"},
+		},
+		{
+			"unterminated tag",
+			`<title>Kept</title><meta property="og:description" content="Open"`,
+			models.LinkPreview{Title: "Kept", Description: "Open"},
+		},
+		{
+			"unterminated comment",
+			`<title>Kept</title><!-- <meta property="og:description" content="Hidden">`,
+			models.LinkPreview{Title: "Kept"},
+		},
+		{
+			"lone brackets",
+			`<title>a < b</title><`,
+			models.LinkPreview{Title: "a < b"},
+		},
+	}
+	for _, tt := range tests {
+		if got := parseHead(tt.page, base); got != tt.want {
+			t.Errorf("%s: got %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParseHeadLengths(t *testing.T) {
+	base, _ := url.Parse("https://example.com/")
+	page := `<title>` + strings.Repeat("t", 1000) + `</title><meta name="description" content="` +
+		strings.Repeat("é", 1000) + `"><meta property="og:image" content="/` + strings.Repeat("i", 5000) + `">`
+
+	got := parseHead(page, base)
+	if n := len([]rune(got.Title)); n != maxTitle || !strings.HasSuffix(got.Title, "…") {
+		t.Errorf("got a title of %d characters, want %d ending with an ellipsis", n, maxTitle)
+	}
+	if n := len([]rune(got.Description)); n != maxDescription {
+		t.Errorf("got a description of %d characters, want %d", n, maxDescription)
+	}
+	if got.Image != "" {
+		t.Errorf("got an image URL of %d bytes, want none", len(got.Image))
+	}
+}
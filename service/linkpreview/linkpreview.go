@@ -0,0 +1,251 @@
+/*
+Package linkpreview adds previews (title, description and image of the page) to the messages with links, in the
+background: the message is sent right away, and the preview is stored with it when the page has been fetched.
+
+A Service has a queue of links and a few workers. Each worker fetches the page with the Fetcher (HTTPFetcher on the
+web, Stub offline) and stores the preview in the Store (the database):
+
+	previews, err := linkpreview.New(linkpreview.Config{
+		Fetcher: linkpreview.NewHTTPFetcher(linkpreview.HTTPConfig{}),
+		Store:   db,
+		Logger:  logger,
+	})
+	if err != nil {
+		return err
+	}
+	defer func() { _ = previews.Close(context.Background()) }()
+
+	if link := linkpreview.Link(*msg.Text, msg.Entities); link != "" {
+		previews.Enqueue(msg.Id, link, func(ctx context.Context, preview models.LinkPreview) {
+			// push the message with its preview to the participants
+		})
+	}
+
+Only the first link of a message gets a preview. Failed fetches are logged and not retried: the message stays
+without preview. A nil *Service is valid and disabled: Enqueue drops the links.
+*/
+package linkpreview
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"time"
+	"unicode/utf16"
+
+	"github.com/sirupsen/logrus"
+	"github.com/val7e/wasaText/service/markup"
+	"github.com/val7e/wasaText/service/models"
+)
+
+// Fetcher returns the preview of the page at a URL.
+type Fetcher interface {
+	Fetch(ctx context.Context, link string) (*models.LinkPreview, error)
+}
+
+// Store saves the previews. database.AppDatabase is a Store.
+type Store interface {
+	SetLinkPreview(ctx context.Context, messageID int64, preview models.LinkPreview) error
+}
+
+// Config is used to provide options to New.
+type Config struct {
+	// Fetcher fetches the previews. It is required.
+	Fetcher Fetcher
+
+	// Store saves the previews. It is required.
+	Store Store
+
+	// Logger receives the failures (optional)
+	Logger logrus.FieldLogger
+
+	// Workers is the number of pages fetched at the same time (default 2)
+	Workers int
+
+	// QueueSize is the number of links waiting for a worker above which new links are dropped (default 256)
+	QueueSize int
+
+	// Timeout bounds the fetch and the storage of each preview (default 10s)
+	Timeout time.Duration
+}
+
+// Service fetches the previews of the links in the background.
+type Service struct {
+	cfg Config
+
+	queue  chan job
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu      sync.Mutex
+	closed  bool
+	pending int           // links enqueued and not processed yet
+	idle    chan struct{} // closed when pending drops to 0
+}
+
+type job struct {
+	messageID int64
+	link      string
+	stored    func(ctx context.Context, preview models.LinkPreview)
+}
+
+// New returns a Service with its workers started. Call Close to stop them.
+func New(cfg Config) (*Service, error) {
+	if cfg.Fetcher == nil {
+		return nil, errors.New("fetcher is required")
+	}
+	if cfg.Store == nil {
+		return nil, errors.New("store is required")
+	}
+	if cfg.Logger == nil {
+		logger := logrus.New()
+		logger.SetOutput(io.Discard)
+		cfg.Logger = logger
+	}
+	if cfg.Workers <= 0 {
+		cfg.Workers = 2
+	}
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = 256
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 10 * time.Second
+	}
+
+	s := &Service{cfg: cfg, queue: make(chan job, cfg.QueueSize), idle: make(chan struct{})}
+	close(s.idle)
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	s.wg.Add(cfg.Workers)
+	for i := 0; i < cfg.Workers; i++ {
+		go s.work()
+	}
+	return s, nil
+}
+
+// Enqueue schedules the preview of link for the message messageID. stored, if not nil, is called by the worker once the
+// preview is stored, with the context bounded by Config.Timeout. It returns false if the link was dropped: the queue is
+// full, or the service is closed or nil.
+func (s *Service) Enqueue(messageID int64, link string, stored func(ctx context.Context, preview models.LinkPreview)) bool {
+	if s == nil {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	select {
+	case s.queue <- job{messageID: messageID, link: link, stored: stored}:
+	default:
+		s.cfg.Logger.WithField("message_id", messageID).Warning("link preview queue full, dropping the link")
+		return false
+	}
+	if s.pending == 0 {
+		s.idle = make(chan struct{})
+	}
+	s.pending++
+	return true
+}
+
+// Flush waits until the links enqueued so far are processed, or ctx is done.
+func (s *Service) Flush(ctx context.Context) error {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	idle := s.idle
+	s.mu.Unlock()
+
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close stops accepting links and waits for the queued ones to be processed. When ctx is done, the fetches in
+// progress are cancelled and the remaining links dropped.
+func (s *Service) Close(ctx context.Context) error {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	if !s.closed {
+		s.closed = true
+		close(s.queue)
+	}
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		s.cancel()
+		return nil
+	case <-ctx.Done():
+		s.cancel()
+		<-done
+		return ctx.Err()
+	}
+}
+
+func (s *Service) work() {
+	defer s.wg.Done()
+	for j := range s.queue {
+		if s.ctx.Err() == nil {
+			s.preview(j)
+		}
+		s.mu.Lock()
+		s.pending--
+		if s.pending == 0 {
+			close(s.idle)
+		}
+		s.mu.Unlock()
+	}
+}
+
+// preview fetches and stores the preview of a link
+func (s *Service) preview(j job) {
+	ctx, cancel := context.WithTimeout(s.ctx, s.cfg.Timeout)
+	defer cancel()
+
+	logger := s.cfg.Logger.WithField("message_id", j.messageID).WithField("url", j.link)
+	preview, err := s.cfg.Fetcher.Fetch(ctx, j.link)
+	if err != nil {
+		logger.WithError(err).Debug("no link preview")
+		return
+	}
+	if err := s.cfg.Store.SetLinkPreview(ctx, j.messageID, *preview); err != nil {
+		// The message may have been deleted in the meantime
+		logger.WithError(err).Warning("error storing the link preview")
+		return
+	}
+	if j.stored != nil {
+		j.stored(ctx, *preview)
+	}
+}
+
+// Link returns the first link (a URL or the target of a text link) of a text with the entities of the markup
+// package, or an empty string if the text has no links.
+func Link(text string, entities []models.MessageEntity) string {
+	for _, e := range entities {
+		switch e.Type {
+		case markup.TextLink:
+			return e.URL
+		case markup.URL:
+			// Offsets count UTF-16 code units
+			units := utf16.Encode([]rune(text))
+			if e.Offset < 0 || e.Length <= 0 || e.Offset+e.Length > len(units) {
+				continue
+			}
+			return string(utf16.Decode(units[e.Offset : e.Offset+e.Length]))
+		}
+	}
+	return ""
+}
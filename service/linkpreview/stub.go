@@ -0,0 +1,28 @@
+package linkpreview
+
+import (
+	"context"
+	"errors"
+
+	"github.com/val7e/wasaText/service/models"
+)
+
+// ErrNotFound is returned by Stub for the URLs it doesn't know.
+var ErrNotFound = errors.New("page not found")
+
+// Stub is an offline Fetcher, for tests and development: it returns the previews of a fixed set of pages, keyed by
+// URL. The URL of the previews is set by Fetch.
+type Stub map[string]models.LinkPreview
+
+// Fetch returns the preview of link, or ErrNotFound.
+func (s Stub) Fetch(ctx context.Context, link string) (*models.LinkPreview, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	preview, ok := s[link]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	preview.URL = link
+	return &preview, nil
+}
@@ -0,0 +1,523 @@
+/*
+Package markup parses the lightweight markup of text messages into entities, so that every client renders the same
+formatting without its own parser.
+
+The markup is:
+
+	**bold**
+	_italic_
+	`inline code`
+	```code block```  (a word alone on the first line is the language: ```go\nfmt.Println()\n```)
+	[label](https://example.com)
+
+and the URLs starting with http:// or https:// are recognized as they are. A backslash before one of \ * _ ` [ ] ( )
+writes the character itself. Bold, italic and links can contain each other (but links can't contain links); code
+contains no other entity. Markers that are not closed in the same range, like the * of 2*3, are kept as text, so
+plain text never fails.
+
+Parse returns the text without the markers and the entities over it. Offsets and lengths count UTF-16 code units,
+as JavaScript strings do (and as the mentions package does).
+*/
+package markup
+
+import (
+	"errors"
+	"net/url"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/val7e/wasaText/service/models"
+)
+
+// Entity types
+const (
+	Bold     = "bold"
+	Italic   = "italic"
+	Code     = "code"
+	Pre      = "pre"
+	TextLink = "text_link"
+	URL      = "url"
+	Mention  = "mention"
+)
+
+// Limits of the markup of a message
+const (
+	// MaxEntities is the maximum number of entities of a text
+	MaxEntities = 100
+
+	// MaxURLLength is the maximum length of the URL of a link, in bytes
+	MaxURLLength = 2048
+)
+
+// Errors returned by Parse
+var (
+	ErrTooManyEntities = errors.New("too many entities")
+	ErrInvalidLink     = errors.New("links must be http or https URLs of at most 2048 characters")
+)
+
+// Parse returns the text without markup and its entities, sorted as Sort does.
+func Parse(text string) (string, []models.MessageEntity, error) {
+	p := &parser{in: []rune(text)}
+	p.code()
+	if err := p.inline(0, len(p.in), false); err != nil {
+		return "", nil, err
+	}
+	if len(p.entities) > MaxEntities {
+		return "", nil, ErrTooManyEntities
+	}
+	Sort(p.entities)
+	return p.out.String(), p.entities, nil
+}
+
+// Sort sorts entities by offset, the enclosing entity first. Entities with the same range keep their order.
+func Sort(entities []models.MessageEntity) {
+	sort.SliceStable(entities, func(i, j int) bool {
+		if entities[i].Offset != entities[j].Offset {
+			return entities[i].Offset < entities[j].Offset
+		}
+		return entities[i].Length > entities[j].Length
+	})
+}
+
+// InLiteral returns true if the range overlaps code or a URL, where an @username is not a mention
+func InLiteral(entities []models.MessageEntity, offset, length int) bool {
+	for _, e := range entities {
+		literal := e.Type == Code || e.Type == Pre || e.Type == URL
+		if literal && offset < e.Offset+e.Length && e.Offset < offset+length {
+			return true
+		}
+	}
+	return false
+}
+
+type parser struct {
+	in []rune
+
+	// spans are the code spans and blocks, found before the rest since their content is not markup
+	spans []span
+
+	// scans are the last searches for the marker closing an entity, by marker and end of the range searched (see
+	// search)
+	scans map[scanKey]scan
+
+	// word is the last word read for a URL (see urlLength), parens the last search for the ) closing the target of a
+	// link (see target)
+	word   word
+	parens run
+
+	out      strings.Builder
+	offset   int // length of out, in UTF-16 code units
+	entities []models.MessageEntity
+}
+
+// scanKey identifies the searches for a marker before the same end
+type scanKey struct {
+	marker string
+	end    int
+}
+
+// scan is a search for a marker: the first one after in[from] is in[close] (-1 if none). For the ] closing the label
+// of a link, once checked, target is the URL between the parentheses after it, up to in[targetEnd], or "" if there
+// is none.
+type scan struct {
+	from, close int
+
+	checked   bool
+	target    string
+	targetEnd int
+}
+
+// run is the result of a search for some characters, the same for all the positions it read: in[from:stop] has none
+// of them
+type run struct {
+	from, stop int
+}
+
+// word is a word read for a URL: in[from:stop] has no spaces nor backticks, and in[trimmed:trimStop] is the trailing
+// punctuation of in[:trimStop]
+type word struct {
+	run
+	trimStop, trimmed int
+}
+
+// span is a code span or block: in[start:end] with the markers, in[contentStart:contentEnd] without
+type span struct {
+	start, end               int
+	contentStart, contentEnd int
+	typ, language            string
+}
+
+// code finds the code spans and blocks. A ``` opens a block closed by the next ```, a ` opens a span closed by the
+// next ` on the same line.
+func (p *parser) code() {
+	for i := 0; i < len(p.in); i++ {
+		switch {
+		case p.in[i] == '\\' && i+1 < len(p.in) && isEscapable(p.in[i+1]):
+			i++
+		case p.has(i, "```"):
+			end := p.index(i+3, len(p.in), "```")
+			if end < 0 || end == i+3 {
+				i += 2
+				continue
+			}
+			s := span{start: i, end: end + 3, contentStart: i + 3, contentEnd: end, typ: Pre}
+			if nl := p.index(s.contentStart, end, "\n"); nl >= 0 && isLanguage(p.in[s.contentStart:nl]) {
+				s.language = string(p.in[s.contentStart:nl])
+				s.contentStart = nl + 1
+			} else if p.in[s.contentStart] == '\n' {
+				s.contentStart++
+			}
+			if s.contentEnd > s.contentStart && p.in[s.contentEnd-1] == '\n' {
+				s.contentEnd--
+			}
+			if s.contentEnd > s.contentStart {
+				p.spans = append(p.spans, s)
+			}
+			i = end + 2
+		case p.in[i] == '`':
+			end := i + 1
+			for end < len(p.in) && p.in[end] != '`' && p.in[end] != '\n' {
+				end++
+			}
+			if end < len(p.in) && p.in[end] == '`' && end > i+1 {
+				p.spans = append(p.spans, span{start: i, end: end + 1, contentStart: i + 1, contentEnd: end, typ: Code})
+				i = end
+			}
+		}
+	}
+}
+
+// inline writes in[start:end], parsing the markup. inLink is true in the label of a link.
+func (p *parser) inline(start, end int, inLink bool) error {
+	for i := start; i < end; {
+		if s := p.spanAt(i); s != nil {
+			if s.end > end {
+				// The code crosses the end of the enclosing entity: keep the marker as text
+				p.write(p.in[i])
+				i++
+				continue
+			}
+			e := p.open(s.typ)
+			p.entities[e].Language = s.language
+			for _, r := range p.in[s.contentStart:s.contentEnd] {
+				p.write(r)
+			}
+			p.close(e)
+			i = s.end
+			continue
+		}
+
+		r := p.in[i]
+		switch {
+		case r == '\\' && i+1 < end && isEscapable(p.in[i+1]):
+			p.write(p.in[i+1])
+			i += 2
+			continue
+
+		case p.has(i, "**") && i+2 < end && !unicode.IsSpace(p.in[i+2]):
+			if close := p.closer(i+2, end, "**"); close >= 0 {
+				e := p.open(Bold)
+				if err := p.inline(i+2, close, inLink); err != nil {
+					return err
+				}
+				p.close(e)
+				i = close + 2
+				continue
+			}
+
+		case r == '_' && (i == 0 || !isWordChar(p.in[i-1]) && p.in[i-1] != '@') && i+1 < end &&
+			!unicode.IsSpace(p.in[i+1]) && p.in[i+1] != '_':
+			if close := p.closer(i+1, end, "_"); close >= 0 {
+				e := p.open(Italic)
+				if err := p.inline(i+1, close, inLink); err != nil {
+					return err
+				}
+				p.close(e)
+				i = close + 1
+				continue
+			}
+
+		case r == '[' && !inLink:
+			next, err := p.link(i, end)
+			if err != nil {
+				return err
+			}
+			if next > i {
+				i = next
+				continue
+			}
+
+		case (p.has(i, "http://") || p.has(i, "https://")) && !inLink && (i == 0 || !isWordChar(p.in[i-1])):
+			if n := p.urlLength(i, end); n > 0 {
+				e := p.open(URL)
+				for _, r := range p.in[i : i+n] {
+					p.write(r)
+				}
+				p.close(e)
+				i += n
+				continue
+			}
+		}
+
+		p.write(r)
+		i++
+	}
+	return nil
+}
+
+// link parses the link starting with the [ at in[i], and returns the position after it, or i if there is no link
+func (p *parser) link(i, end int) (int, error) {
+	labelEnd := p.search("]", i, end, func(j int) bool { return p.in[j] == ']' })
+	if labelEnd <= i+1 {
+		return i, nil
+	}
+	key := scanKey{"]", end}
+	sc := p.scans[key]
+	if !sc.checked {
+		sc.target, sc.targetEnd = p.target(labelEnd, end)
+		sc.checked = true
+		p.scans[key] = sc
+	}
+	if sc.target == "" {
+		return i, nil
+	}
+
+	// Other schemes than http and https, like javascript:, are refused
+	u, _ := url.Parse(sc.target)
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || len(sc.target) > MaxURLLength {
+		return 0, ErrInvalidLink
+	}
+	e := p.open(TextLink)
+	p.entities[e].URL = sc.target
+	if err := p.inline(i+1, labelEnd, true); err != nil {
+		return 0, err
+	}
+	p.close(e)
+	return sc.targetEnd + 1, nil
+}
+
+// target returns the target of the link whose label ends at in[labelEnd], with the position of the ) after it: the
+// URL between the parentheses following the label, before `end`. It returns "" if there is none: targets without a
+// scheme, like the 2 of [1](2), are not links.
+func (p *parser) target(labelEnd, end int) (string, int) {
+	if labelEnd+1 >= end || p.in[labelEnd+1] != '(' {
+		return "", 0
+	}
+	start := labelEnd + 2
+	if start < p.parens.from || start >= p.parens.stop {
+		stop := start
+		for stop < len(p.in) && !unicode.IsSpace(p.in[stop]) && p.in[stop] != ')' {
+			stop++
+		}
+		p.parens = run{from: start, stop: stop}
+	}
+	j := p.parens.stop
+	if j >= end || p.in[j] != ')' {
+		return "", 0
+	}
+	target := string(p.in[start:j])
+	if u, err := url.Parse(target); err != nil || u.Scheme == "" {
+		return "", 0
+	}
+	return target, j
+}
+
+// closer returns the position of the marker closing the entity whose content starts at `start`, or -1. The marker
+// must follow a non-space character, outside code and escapes, before `end`.
+func (p *parser) closer(start, end int, marker string) int {
+	return p.search(marker, start, end, func(j int) bool {
+		if j+len(marker) > end || !p.has(j, marker) || unicode.IsSpace(p.in[j-1]) {
+			return false
+		}
+		return marker != "_" || j+1 >= len(p.in) || !isWordChar(p.in[j+1]) && p.in[j+1] != '_'
+	})
+}
+
+// search returns the position of the first character after in[from] and before `end` where match is true, outside
+// code and escapes, or -1.
+//
+// The last search for the same marker before the same end is reused, if it started before in[from] and found nothing
+// up to it: both searches read the same characters once they meet, so this one only reads the characters that the
+// last one jumped over (the code starting at in[from], or the character it escapes) until then. A text full of
+// markers that are never closed is read once, not once for each marker.
+func (p *parser) search(marker string, from, end int, match func(j int) bool) int {
+	key := scanKey{marker, end}
+	j := from + 1
+	if last, ok := p.scans[key]; ok && last.from < from && (last.close < 0 || from < last.close) &&
+		!p.inSpan(last.from, from) {
+		// The last search read in[from] and went on from k
+		k := p.next(from, end)
+		for j < end && (last.close < 0 || k <= last.close) {
+			if j == k {
+				return p.remember(key, from, last.close)
+			}
+			if j > k {
+				k = p.next(k, end)
+				continue
+			}
+			if p.plain(j, end) && match(j) {
+				return p.remember(key, from, j)
+			}
+			j = p.next(j, end)
+		}
+	}
+	for ; j < end; j = p.next(j, end) {
+		if p.plain(j, end) && match(j) {
+			return p.remember(key, from, j)
+		}
+	}
+	return p.remember(key, from, -1)
+}
+
+// remember saves the search for a marker from in[from], that found it at in[close], and returns close
+func (p *parser) remember(key scanKey, from, close int) int {
+	if p.scans == nil {
+		p.scans = make(map[scanKey]scan)
+	}
+	sc, ok := p.scans[key]
+	if !ok || sc.close != close {
+		sc = scan{close: close}
+	}
+	sc.from = from
+	p.scans[key] = sc
+	return close
+}
+
+// next returns the position read by a search after in[j]: the end of the code starting at in[j], the character after
+// the one escaped by in[j], or the next one
+func (p *parser) next(j, end int) int {
+	if s := p.spanAt(j); s != nil {
+		return s.end
+	}
+	if p.isEscape(j, end) {
+		return j + 2
+	}
+	return j + 1
+}
+
+// plain returns true if in[j] is not the start of code nor an escape
+func (p *parser) plain(j, end int) bool {
+	return p.spanAt(j) == nil && !p.isEscape(j, end)
+}
+
+// open starts an entity at the current offset, and returns its index
+func (p *parser) open(typ string) int {
+	p.entities = append(p.entities, models.MessageEntity{Type: typ, Offset: p.offset})
+	return len(p.entities) - 1
+}
+
+// close ends the entity e at the current offset
+func (p *parser) close(e int) {
+	p.entities[e].Length = p.offset - p.entities[e].Offset
+}
+
+func (p *parser) write(r rune) {
+	p.out.WriteRune(r)
+	if r >= 0x10000 {
+		p.offset += 2
+	} else {
+		p.offset++
+	}
+}
+
+// spanAt returns the code span or block starting at in[i]
+func (p *parser) spanAt(i int) *span {
+	n := sort.Search(len(p.spans), func(k int) bool { return p.spans[k].start >= i })
+	if n < len(p.spans) && p.spans[n].start == i {
+		return &p.spans[n]
+	}
+	return nil
+}
+
+// inSpan returns true if in[x] is inside a code span or block starting after in[from]
+func (p *parser) inSpan(from, x int) bool {
+	n := sort.Search(len(p.spans), func(k int) bool { return p.spans[k].start >= x })
+	return n > 0 && p.spans[n-1].start > from && p.spans[n-1].end > x
+}
+
+// isEscape returns true if in[i] is a backslash escaping the next character, before `end`
+func (p *parser) isEscape(i, end int) bool {
+	return p.in[i] == '\\' && i+1 < end && isEscapable(p.in[i+1])
+}
+
+// has returns true if in[i:] starts with s
+func (p *parser) has(i int, s string) bool {
+	for _, r := range s {
+		if i >= len(p.in) || p.in[i] != r {
+			return false
+		}
+		i++
+	}
+	return true
+}
+
+// index returns the position of the first s in in[start:end], or -1
+func (p *parser) index(start, end int, s string) int {
+	for i := start; i+len(s) <= end; i++ {
+		if p.has(i, s) {
+			return i
+		}
+	}
+	return -1
+}
+
+// urlLength returns the length of the URL starting at in[i], before `end`, without the trailing punctuation, or 0.
+// The URL ends at the end of the word; the end of the word and its trailing punctuation are the same for every URL in
+// it, so they are read once.
+func (p *parser) urlLength(i, end int) int {
+	if i < p.word.from || i >= p.word.stop {
+		stop := i
+		for stop < len(p.in) && !unicode.IsSpace(p.in[stop]) && p.in[stop] != '`' {
+			stop++
+		}
+		p.word = word{run: run{from: i, stop: stop}}
+	}
+	stop := p.word.stop
+	if stop > end {
+		stop = end
+	}
+	if stop != p.word.trimStop {
+		trimmed := stop
+		for trimmed > i && strings.ContainsRune(".,;:!?'\"*_", p.in[trimmed-1]) {
+			trimmed--
+		}
+		p.word.trimStop, p.word.trimmed = stop, trimmed
+	}
+
+	// A URL longer than MaxURLLength is not a link; one more for the ) dropped below
+	n := p.word.trimmed - i
+	if n <= 0 || n > MaxURLLength+1 {
+		return 0
+	}
+	s := p.in[i : i+n]
+	if s[n-1] == ')' && !strings.ContainsRune(string(s), '(') {
+		n--
+	}
+	u, err := url.Parse(string(s[:n]))
+	if err != nil || u.Host == "" || n > MaxURLLength {
+		return 0
+	}
+	return n
+}
+
+func isEscapable(r rune) bool {
+	return strings.ContainsRune("\\*_`[]()", r)
+}
+
+func isWordChar(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// isLanguage returns true if s is the language of a code block, like go or c++
+func isLanguage(s []rune) bool {
+	if len(s) == 0 || len(s) > 20 {
+		return false
+	}
+	for _, r := range s {
+		if !isWordChar(r) && !strings.ContainsRune("+#-_.", r) {
+			return false
+		}
+	}
+	return true
+}
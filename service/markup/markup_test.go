@@ -0,0 +1,159 @@
+package markup_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/val7e/wasaText/service/markup"
+	"github.com/val7e/wasaText/service/models"
+)
+
+type entity = models.MessageEntity
+
+// longURL is longer than markup.MaxURLLength, so it is not a link
+var longURL = "https://" + strings.Repeat("a", markup.MaxURLLength) + ".com"
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		in, text string
+		entities []entity
+	}{
+		{"plain text", "plain text", nil},
+		{"**bold** and _italic_", "bold and italic", []entity{
+			{Type: markup.Bold, Offset: 0, Length: 4},
+			{Type: markup.Italic, Offset: 9, Length: 6},
+		}},
+		{"`a*b*`", "a*b*", []entity{{Type: markup.Code, Offset: 0, Length: 4}}},
+		{"```go\nfmt.Println()\n```", "fmt.Println()", []entity{
+			{Type: markup.Pre, Offset: 0, Length: 13, Language: "go"},
+		}},
+		{"```\nx\n```", "x", []entity{{Type: markup.Pre, Offset: 0, Length: 1}}},
+		{"[label](https://example.com/a)", "label", []entity{
+			{Type: markup.TextLink, Offset: 0, Length: 5, URL: "https://example.com/a"},
+		}},
+		{"[a](http://x.y) [b](https://z.w)", "a b", []entity{
+			{Type: markup.TextLink, Offset: 0, Length: 1, URL: "http://x.y"},
+			{Type: markup.TextLink, Offset: 2, Length: 1, URL: "https://z.w"},
+		}},
+		{"**[_x_](http://a.b)**", "x", []entity{
+			{Type: markup.Bold, Offset: 0, Length: 1},
+			{Type: markup.TextLink, Offset: 0, Length: 1, URL: "http://a.b"},
+			{Type: markup.Italic, Offset: 0, Length: 1},
+		}},
+		{"[[a](http://x.y)", "[a", []entity{{Type: markup.TextLink, Offset: 0, Length: 2, URL: "http://x.y"}}},
+		{"_a `_` b_", "a _ b", []entity{
+			{Type: markup.Italic, Offset: 0, Length: 5},
+			{Type: markup.Code, Offset: 2, Length: 1},
+		}},
+
+		// URLs, without the trailing punctuation
+		{"see https://example.com/path.", "see https://example.com/path.", []entity{
+			{Type: markup.URL, Offset: 4, Length: 24},
+		}},
+		{"(https://example.com/a)", "(https://example.com/a)", []entity{{Type: markup.URL, Offset: 1, Length: 21}}},
+		{longURL, longURL, nil},
+
+		// Escapes and markers that are not closed are text
+		{"\\*not bold\\*", "*not bold*", nil},
+		{"2*3 and a_b_c", "2*3 and a_b_c", nil},
+		{"** a**", "** a**", nil},
+		{"[a](2)", "[a](2)", nil},
+		{"`[a](http://x.y)`", "[a](http://x.y)", []entity{{Type: markup.Code, Offset: 0, Length: 15}}},
+
+		// Offsets count UTF-16 code units
+		{"😀 **b**", "😀 b", []entity{{Type: markup.Bold, Offset: 3, Length: 1}}},
+	}
+	for _, tt := range tests {
+		text, entities, err := markup.Parse(tt.in)
+		if err != nil {
+			t.Errorf("Parse(%.40q): %v", tt.in, err)
+			continue
+		}
+		if text != tt.text || len(entities) != len(tt.entities) ||
+			len(entities) > 0 && !reflect.DeepEqual(entities, tt.entities) {
+			t.Errorf("Parse(%.40q) = %.40q, %+v; want %.40q, %+v", tt.in, text, entities, tt.text, tt.entities)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		in  string
+		err error
+	}{
+		{"[x](javascript:alert(1))", markup.ErrInvalidLink},
+		{"[x](ftp://example.com)", markup.ErrInvalidLink},
+		{"[x](https://example.com/" + strings.Repeat("a", markup.MaxURLLength) + ")", markup.ErrInvalidLink},
+		{strings.Repeat("**a** ", markup.MaxEntities+1), markup.ErrTooManyEntities},
+	}
+	for _, tt := range tests {
+		if _, _, err := markup.Parse(tt.in); err != tt.err {
+			t.Errorf("Parse(%.40q): got error %v, want %v", tt.in, err, tt.err)
+		}
+	}
+}
+
+// TestParseLinear checks that texts full of markers that are never closed are read in linear time: each of them
+// takes seconds if every marker reads the rest of the text.
+func TestParseLinear(t *testing.T) {
+	for _, unit := range []string{
+		"[", "[]", "[a](bbbb", "[[[x](y", "_a ", "**a ", "**[a** ", "_`a`go\n", "_\\[ ", "http:///", "/http://a.....",
+		"```a", "`",
+	} {
+		in := strings.Repeat(unit, (1<<16)/len(unit))
+		start := time.Now()
+		if _, _, err := markup.Parse(in); err != nil && err != markup.ErrTooManyEntities {
+			t.Errorf("%q: %v", unit, err)
+		}
+		if d := time.Since(start); d > time.Second {
+			t.Errorf("%q repeated to %d bytes: parsed in %v", unit, len(in), d)
+		}
+	}
+}
+
+// FuzzParse checks that the entities of any text are sorted, nested and inside the text without markup
+func FuzzParse(f *testing.F) {
+	for _, s := range []string{
+		"**bold** _italic_ `code`", "```go\nx\n```", "[label](https://example.com)", "see https://example.com.",
+		"\\*a\\* [a](2) 2*3", "**[_x_](http://a.b)**", "😀 _a `_` b_", "[[[x](y", "_`a`go\n_",
+	} {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, in string) {
+		if !utf8.ValidString(in) {
+			return
+		}
+		text, entities, err := markup.Parse(in)
+		if err != nil {
+			return
+		}
+		if utf8.RuneCountInString(text) > utf8.RuneCountInString(in) {
+			t.Fatalf("%q: the text %q is longer than the input", in, text)
+		}
+		if len(entities) > markup.MaxEntities {
+			t.Fatalf("%q: %d entities", in, len(entities))
+		}
+		length := len(utf16.Encode([]rune(text)))
+		for i, e := range entities {
+			if e.Offset < 0 || e.Length <= 0 || e.Offset+e.Length > length {
+				t.Fatalf("%q: entity %+v outside of %q", in, e, text)
+			}
+			if i == 0 {
+				continue
+			}
+			prev := entities[i-1]
+			if prev.Offset > e.Offset || prev.Offset == e.Offset && prev.Length < e.Length {
+				t.Fatalf("%q: entities not sorted: %+v", in, entities)
+			}
+			for _, o := range entities[:i] {
+				if e.Offset < o.Offset+o.Length && e.Offset+e.Length > o.Offset+o.Length {
+					t.Fatalf("%q: entities %+v and %+v overlap", in, o, e)
+				}
+			}
+		}
+	})
+}
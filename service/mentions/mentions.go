@@ -0,0 +1,63 @@
+/*
+Package mentions finds the @username mentions in the text of a message.
+
+A mention is an @ followed by the characters allowed in usernames (letters, digits, _ and -). The @ must start the text
+or follow a character that can't be part of a username, so that e-mail addresses like alice@example.com are not
+mentions. Find only looks at the syntax: it is up to the caller to resolve the usernames against the participants of
+the conversation.
+
+Offsets and lengths count UTF-16 code units, as JavaScript strings do, so that web clients can slice the text
+directly.
+*/
+package mentions
+
+// Minimum and maximum length of usernames, without the @
+const (
+	minUsername = 3
+	maxUsername = 25
+)
+
+// Mention is an @username in a text. The range includes the @.
+type Mention struct {
+	Username string
+	Offset   int
+	Length   int
+}
+
+// Find returns the mentions in text, in order.
+func Find(text string) []Mention {
+	var found []Mention
+	runes := []rune(text)
+	offset := 0 // in UTF-16 code units
+	for i := 0; i < len(runes); i++ {
+		if runes[i] != '@' || (i > 0 && (isUsernameChar(runes[i-1]) || runes[i-1] == '@')) {
+			offset += width(runes[i])
+			continue
+		}
+
+		// Usernames are ASCII, so a rune is a code unit
+		end := i + 1
+		for end < len(runes) && isUsernameChar(runes[end]) {
+			end++
+		}
+		if n := end - i - 1; n >= minUsername && n <= maxUsername {
+			found = append(found, Mention{Username: string(runes[i+1 : end]), Offset: offset, Length: n + 1})
+		}
+		offset += end - i
+		i = end - 1
+	}
+	return found
+}
+
+func isUsernameChar(r rune) bool {
+	return r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '-'
+}
+
+// width returns the number of UTF-16 code units of r: runes outside the Basic Multilingual Plane (most emoji) take a
+// surrogate pair
+func width(r rune) int {
+	if r >= 0x10000 {
+		return 2
+	}
+	return 1
+}
@@ -0,0 +1,283 @@
+/*
+Package metrics is a minimal metrics registry exposed in the Prometheus text exposition format (version 0.0.4), so that
+the service can be scraped by Prometheus without depending on its client library. Only what the service uses is
+implemented: counters and histograms, both with labels.
+
+Metrics are created from a Registry, and the Registry is served over HTTP by its Handler:
+
+	reg := metrics.NewRegistry()
+	requests := reg.NewCounterVec("http_requests_total", "HTTP requests served.", "route", "status")
+	requests.Inc("/users", "200")
+
+	mux.Handle("/metrics", reg.Handler())
+*/
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets are the default histogram buckets (in seconds), suited for the latency of network services.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Registry is a set of metrics that can be exposed together.
+type Registry struct {
+	mu      sync.Mutex
+	metrics []metric
+}
+
+// metric is a family of samples sharing name, help and type.
+type metric interface {
+	write(w io.Writer)
+}
+
+// NewRegistry returns an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// NewCounterVec registers and returns a counter with the given label names.
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{
+		family: family{name: name, help: help, labels: labels},
+		values: map[string]*counterValue{},
+	}
+	r.register(c)
+	return c
+}
+
+// NewHistogramVec registers and returns a histogram with the given (increasing) bucket upper bounds and label names.
+// If buckets is nil, DefaultBuckets are used.
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+	h := &HistogramVec{
+		family:  family{name: name, help: help, labels: labels},
+		buckets: buckets,
+		values:  map[string]*histogramValue{},
+	}
+	r.register(h)
+	return h
+}
+
+func (r *Registry) register(m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.metrics = append(r.metrics, m)
+}
+
+// WriteText writes all the metrics of the registry in the Prometheus text format.
+func (r *Registry) WriteText(w io.Writer) {
+	r.mu.Lock()
+	metrics := append([]metric(nil), r.metrics...)
+	r.mu.Unlock()
+
+	for _, m := range metrics {
+		m.write(w)
+	}
+}
+
+// Handler returns an HTTP handler serving the metrics of the registry, to be scraped by Prometheus.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		bw := bufio.NewWriter(w)
+		r.WriteText(bw)
+		_ = bw.Flush()
+	})
+}
+
+// family holds what is shared by all the samples of a metric.
+type family struct {
+	name   string
+	help   string
+	labels []string
+}
+
+// key returns the map key of a combination of label values.
+func (f *family) key(labelValues []string) string {
+	if len(labelValues) != len(f.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", f.name, len(f.labels), len(labelValues)))
+	}
+	return strings.Join(labelValues, "\xff")
+}
+
+func (f *family) writeHeader(w io.Writer, typ string) {
+	_, _ = fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", f.name, escapeHelp(f.help), f.name, typ)
+}
+
+// labelPairs formats the labels of a sample, with an optional extra label (used for the histogram "le").
+func (f *family) labelPairs(labelValues []string, extraName, extraValue string) string {
+	if len(labelValues) == 0 && extraName == "" {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, name := range f.labels {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(name + `="` + escapeLabelValue(labelValues[i]) + `"`)
+	}
+	if extraName != "" {
+		if len(labelValues) > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(extraName + `="` + extraValue + `"`)
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+// CounterVec is a monotonically increasing value, one per combination of label values.
+type CounterVec struct {
+	family
+
+	mu     sync.Mutex
+	values map[string]*counterValue
+}
+
+type counterValue struct {
+	labelValues []string
+	value       float64
+}
+
+// Inc increments by one the counter with the given label values.
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add adds v (which must not be negative) to the counter with the given label values.
+func (c *CounterVec) Add(v float64, labelValues ...string) {
+	key := c.key(labelValues)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cv, ok := c.values[key]
+	if !ok {
+		cv = &counterValue{labelValues: append([]string(nil), labelValues...)}
+		c.values[key] = cv
+	}
+	cv.value += v
+}
+
+func (c *CounterVec) write(w io.Writer) {
+	c.writeHeader(w, "counter")
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, key := range c.sortedKeys() {
+		cv := c.values[key]
+		_, _ = fmt.Fprintf(w, "%s%s %s\n", c.name, c.labelPairs(cv.labelValues, "", ""), formatFloat(cv.value))
+	}
+}
+
+// HistogramVec counts observations (like request durations) in buckets, one histogram per combination of label
+// values.
+type HistogramVec struct {
+	family
+	buckets []float64
+
+	mu     sync.Mutex
+	values map[string]*histogramValue
+}
+
+type histogramValue struct {
+	labelValues []string
+	// counts[i] is the number of observations in (buckets[i-1], buckets[i]]; the cumulative counts are computed on
+	// write
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// Observe adds an observation to the histogram with the given label values.
+func (h *HistogramVec) Observe(v float64, labelValues ...string) {
+	key := h.key(labelValues)
+	i := sort.SearchFloat64s(h.buckets, v)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	hv, ok := h.values[key]
+	if !ok {
+		hv = &histogramValue{
+			labelValues: append([]string(nil), labelValues...),
+			counts:      make([]uint64, len(h.buckets)),
+		}
+		h.values[key] = hv
+	}
+	if i < len(h.buckets) {
+		hv.counts[i]++
+	}
+	hv.count++
+	hv.sum += v
+}
+
+func (h *HistogramVec) write(w io.Writer) {
+	h.writeHeader(w, "histogram")
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, key := range h.sortedKeys() {
+		hv := h.values[key]
+		var cumulative uint64
+		for i, le := range h.buckets {
+			cumulative += hv.counts[i]
+			_, _ = fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelPairs(hv.labelValues, "le", formatFloat(le)), cumulative)
+		}
+		_, _ = fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelPairs(hv.labelValues, "le", "+Inf"), hv.count)
+		_, _ = fmt.Fprintf(w, "%s_sum%s %s\n", h.name, h.labelPairs(hv.labelValues, "", ""), formatFloat(hv.sum))
+		_, _ = fmt.Fprintf(w, "%s_count%s %d\n", h.name, h.labelPairs(hv.labelValues, "", ""), hv.count)
+	}
+}
+
+func (c *CounterVec) sortedKeys() []string {
+	keys := make([]string, 0, len(c.values))
+	for k := range c.values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (h *HistogramVec) sortedKeys() []string {
+	keys := make([]string, 0, len(h.values))
+	for k := range h.values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, +1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
+
+var (
+	helpEscaper       = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelValueEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+func escapeLabelValue(s string) string {
+	return labelValueEscaper.Replace(s)
+}
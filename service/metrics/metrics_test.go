@@ -0,0 +1,70 @@
+package metrics
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWriteText(t *testing.T) {
+	reg := NewRegistry()
+	requests := reg.NewCounterVec("requests_total", "Requests served,\nby path.", "path", "status")
+	requests.Inc("/b", "200")
+	requests.Add(2, "/a", "200")
+	requests.Inc(`C:\dir "quoted"`+"\nline", "500")
+	duration := reg.NewHistogramVec("duration_seconds", `Duration \ latency.`, []float64{0.1, 0.5, 1}, "path")
+	for _, v := range []float64{0.05, 0.1, 0.3, 0.7, 3} {
+		duration.Observe(v, "/a")
+	}
+	reg.NewCounterVec("empty_total", "Nothing yet.")
+	untyped := reg.NewHistogramVec("plain_seconds", "No labels.", []float64{1})
+	untyped.Observe(2)
+
+	want := `# HELP requests_total Requests served,\nby path.
+# TYPE requests_total counter
+requests_total{path="/a",status="200"} 2
+requests_total{path="/b",status="200"} 1
+requests_total{path="C:\\dir \"quoted\"\nline",status="500"} 1
+# HELP duration_seconds Duration \\ latency.
+# TYPE duration_seconds histogram
+duration_seconds_bucket{path="/a",le="0.1"} 2
+duration_seconds_bucket{path="/a",le="0.5"} 3
+duration_seconds_bucket{path="/a",le="1"} 4
+duration_seconds_bucket{path="/a",le="+Inf"} 5
+duration_seconds_sum{path="/a"} 4.15
+duration_seconds_count{path="/a"} 5
+# HELP empty_total Nothing yet.
+# TYPE empty_total counter
+# HELP plain_seconds No labels.
+# TYPE plain_seconds histogram
+plain_seconds_bucket{le="1"} 0
+plain_seconds_bucket{le="+Inf"} 1
+plain_seconds_sum 2
+plain_seconds_count 1
+`
+	var got strings.Builder
+	reg.WriteText(&got)
+	if got.String() != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got.String(), want)
+	}
+
+	res := httptest.NewRecorder()
+	reg.Handler().ServeHTTP(res, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := io.ReadAll(res.Body)
+	if ct := res.Header().Get("Content-Type"); ct != "text/plain; version=0.0.4; charset=utf-8" {
+		t.Errorf("got the content type %q", ct)
+	}
+	if string(body) != want {
+		t.Errorf("the handler served:\n%s", body)
+	}
+}
+
+func TestLabelCount(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("no panic with a missing label value")
+		}
+	}()
+	NewRegistry().NewCounterVec("c_total", "C.", "a", "b").Inc("x")
+}
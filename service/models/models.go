@@ -37,8 +37,115 @@ type Message struct {
 	CommentsCount   int       `json:"comments_count"`
 	CommentsAuthors []string  `json:"comments_authors"`
 
+	// ThreadReplyCount is the number of replies in the thread of the message, LastReply the most recent of them
+	ThreadReplyCount int             `json:"thread_reply_count"`
+	LastReply        *MessagePreview `json:"last_reply,omitempty"`
+
 	Text  *string `json:"text,omitempty"`
 	Photo *string `json:"photo,omitempty"`
+
+	// File describes the file of a "file" message, downloaded separately
+	File *File `json:"file,omitempty"`
+
+	// Audio describes the recording of an "audio" message (a voice message), downloaded separately
+	Audio *Audio `json:"audio,omitempty"`
+
+	// Entities are the ranges of Text with a special meaning, sorted by offset
+	Entities []MessageEntity `json:"entities,omitempty"`
+
+	// LinkPreview describes the first link of Text. It is added in the background, shortly after the message is sent.
+	LinkPreview *LinkPreview `json:"link_preview,omitempty"`
+}
+
+// LinkPreview is the title, the description and the image of a web page, as the page describes itself
+type LinkPreview struct {
+	URL         string `json:"url"`
+	Title       string `json:"title,omitempty"`
+	Description string `json:"description,omitempty"`
+
+	// Image is the URL of the image of the page
+	Image string `json:"image,omitempty"`
+}
+
+// MessageEntity is a range of the text of a message. Offset and Length count UTF-16 code units.
+type MessageEntity struct {
+	Type   string `json:"type"`
+	Offset int    `json:"offset"`
+	Length int    `json:"length"`
+
+	// UserId and Username are the user of a "mention"
+	UserId   int64  `json:"user_id,omitempty"`
+	Username string `json:"username,omitempty"`
+
+	// URL is the target of a "text_link", Language the language of a "pre" code block
+	URL      string `json:"url,omitempty"`
+	Language string `json:"language,omitempty"`
+}
+
+// Mention is a group message mentioning the user
+type Mention struct {
+	ConversationId int64           `json:"conversation_id"`
+	GroupName      string          `json:"group_name"`
+	MessageId      int64           `json:"message_id"`
+	Sender         string          `json:"sender"`
+	Timestamp      time.Time       `json:"timestamp"`
+	Text           string          `json:"text"`
+	Entities       []MessageEntity `json:"entities"`
+}
+
+// Reply is a message in the thread of another message
+type Reply struct {
+	Id        int64     `json:"id"`
+	Timestamp time.Time `json:"timestamp"`
+	Sender    string    `json:"sender"`
+	Type      string    `json:"type"`
+
+	Text  *string `json:"text,omitempty"`
+	Photo *string `json:"photo,omitempty"`
+}
+
+// ThreadSummary is a thread followed by a user
+type ThreadSummary struct {
+	ConversationId int64 `json:"conversation_id"`
+	MessageId      int64 `json:"message_id"`
+
+	// Message is the preview of the message that started the thread
+	Message     MessagePreview  `json:"message"`
+	ReplyCount  int             `json:"reply_count"`
+	UnreadCount int             `json:"unread_count"`
+	LastReply   *MessagePreview `json:"last_reply,omitempty"`
+}
+
+// File is a document attached to a message
+type File struct {
+	Name     string `json:"name"`
+	MimeType string `json:"mime_type"`
+	Size     int64  `json:"size"`
+}
+
+// NewFile is a file uploaded in a conversation
+type NewFile struct {
+	Name     string
+	MimeType string
+	Data     []byte
+}
+
+// Audio is the recording of a voice message
+type Audio struct {
+	MimeType   string `json:"mime_type"`
+	Size       int64  `json:"size"`
+	DurationMs int64  `json:"duration_ms"`
+
+	// Waveform are the levels (0 to 100) of the parts of the recording, for the player
+	Waveform []int `json:"waveform"`
+}
+
+// NewAudio is a voice message recorded in a conversation, with the metadata read from the recording
+type NewAudio struct {
+	MimeType string
+	Duration time.Duration
+	Waveform []int
+	Data     []byte
 }
 
 type NewMessage struct {
@@ -58,9 +165,14 @@ type ConversationSummary struct {
 }
 
 type Comment struct {
-	Id     int64  `json:"id"`
-	Author string `json:"username"`
-	Text   string `json:"text"`
+	Id        int64      `json:"id"`
+	Author    string     `json:"username"`
+	Text      string     `json:"text"`
+	Timestamp time.Time  `json:"timestamp"`
+	EditedAt  *time.Time `json:"edited_at,omitempty"`
+
+	// AuthorThumbnail is the thumbnail of the profile picture of the author (base64 PNG)
+	AuthorThumbnail string `json:"thumbnail"`
 }
 
 type NewComment struct {
@@ -0,0 +1,411 @@
+/*
+Package openapi validates HTTP requests and responses against an OpenAPI 3.0 document (like doc/api.yaml).
+
+Only the parts of the specification used by the document are supported: path and query parameters, JSON request
+and response bodies, and the schema keywords type, nullable, enum, format (date-time), pattern, minLength, maxLength,
+minimum, maximum, minItems, maxItems, items, properties, required, additionalProperties, allOf, anyOf and oneOf.
+References ($ref) are resolved within the document.
+
+	spec, err := openapi.Load(doc.APISpec)
+	if err != nil {
+		return err
+	}
+	op, pathParams := spec.FindOperation(r.Method, r.URL.Path)
+	if op != nil {
+		err = op.ValidateRequest(r, pathParams, body)
+	}
+*/
+package openapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v2"
+)
+
+// methods are the HTTP methods that can be used as keys of a path item.
+var methods = []string{"get", "put", "post", "delete", "options", "head", "patch", "trace"}
+
+// Spec is a parsed OpenAPI document.
+type Spec struct {
+	doc map[string]interface{}
+	ops []*Operation
+
+	mu       sync.Mutex
+	patterns map[string]*regexp.Regexp
+}
+
+// Operation is an operation of the document: a method on a path.
+type Operation struct {
+	ID     string
+	Method string
+
+	// Path is the path template of the document, like /groups/{group_id}
+	Path string
+
+	spec        *Spec
+	segments    []string
+	params      []map[string]interface{}
+	requestBody map[string]interface{}
+	responses   map[string]interface{}
+}
+
+// ValidationError lists the differences between a request (or a response) and the document.
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return strings.Join(e.Problems, "; ")
+}
+
+// Load parses an OpenAPI 3.0 document in YAML (or JSON).
+func Load(data []byte) (*Spec, error) {
+	var raw interface{}
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("parsing the document: %w", err)
+	}
+	doc, ok := normalize(raw).(map[string]interface{})
+	if !ok {
+		return nil, errors.New("the document is not an object")
+	}
+	if v, _ := doc["openapi"].(string); !strings.HasPrefix(v, "3.") {
+		return nil, fmt.Errorf("unsupported OpenAPI version %q", v)
+	}
+
+	s := &Spec{doc: doc, patterns: map[string]*regexp.Regexp{}}
+	paths, _ := doc["paths"].(map[string]interface{})
+	for path, item := range paths {
+		item := s.resolve(item)
+		if item == nil {
+			return nil, fmt.Errorf("path %s: invalid path item", path)
+		}
+		for _, method := range methods {
+			raw, ok := item[method]
+			if !ok {
+				continue
+			}
+			opDoc := s.resolve(raw)
+			if opDoc == nil {
+				return nil, fmt.Errorf("%s %s: invalid operation", strings.ToUpper(method), path)
+			}
+			op := &Operation{
+				Method:      strings.ToUpper(method),
+				Path:        path,
+				spec:        s,
+				segments:    strings.Split(strings.Trim(path, "/"), "/"),
+				requestBody: s.resolve(opDoc["requestBody"]),
+			}
+			op.ID, _ = opDoc["operationId"].(string)
+			op.responses, _ = opDoc["responses"].(map[string]interface{})
+			op.params = s.parameters(item["parameters"], opDoc["parameters"])
+			s.ops = append(s.ops, op)
+		}
+	}
+	sort.Slice(s.ops, func(i, j int) bool {
+		if s.ops[i].Path != s.ops[j].Path {
+			return s.ops[i].Path < s.ops[j].Path
+		}
+		return s.ops[i].Method < s.ops[j].Method
+	})
+	return s, nil
+}
+
+// Operations returns all the operations of the document, sorted by path and method.
+func (s *Spec) Operations() []*Operation {
+	return append([]*Operation(nil), s.ops...)
+}
+
+// Operation returns the operation for method on the path template (as written in the document, like
+// /groups/{group_id}), or nil.
+func (s *Spec) Operation(method, path string) *Operation {
+	for _, op := range s.ops {
+		if op.Method == method && op.Path == path {
+			return op
+		}
+	}
+	return nil
+}
+
+// FindOperation returns the operation matching the method and the path of a request, with the values of the path
+// parameters. It returns nil if the path or the method are not in the document. When more paths match, the one
+// with more literal segments wins (/users/me over /users/{id}).
+func (s *Spec) FindOperation(method, path string) (*Operation, map[string]string) {
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+
+	var best *Operation
+	bestLiterals := -1
+	for _, op := range s.ops {
+		if op.Method != method || len(op.segments) != len(segments) {
+			continue
+		}
+		literals, ok := 0, true
+		for i, seg := range op.segments {
+			if isTemplate(seg) {
+				ok = segments[i] != ""
+			} else if seg == segments[i] {
+				literals++
+			} else {
+				ok = false
+			}
+			if !ok {
+				break
+			}
+		}
+		if ok && literals > bestLiterals {
+			best, bestLiterals = op, literals
+		}
+	}
+	if best == nil {
+		return nil, nil
+	}
+
+	params := map[string]string{}
+	for i, seg := range best.segments {
+		if isTemplate(seg) {
+			params[seg[1:len(seg)-1]] = segments[i]
+		}
+	}
+	return best, params
+}
+
+func isTemplate(segment string) bool {
+	return strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}")
+}
+
+// ValidateRequest validates the parameters and the body of a request. pathParams are the values returned by
+// FindOperation, body is the request body (r.Body is not read). The returned error, if any, is a *ValidationError.
+func (op *Operation) ValidateRequest(r *http.Request, pathParams map[string]string, body []byte) error {
+	var problems []string
+
+	query := r.URL.Query()
+	for _, p := range op.params {
+		name, _ := p["name"].(string)
+		in, _ := p["in"].(string)
+		required, _ := p["required"].(bool)
+
+		var value string
+		var present bool
+		switch in {
+		case "path":
+			value, present = pathParams[name]
+		case "query":
+			if values, ok := query[name]; ok && len(values) > 0 {
+				value, present = values[0], true
+			}
+		case "header":
+			value = r.Header.Get(name)
+			present = value != ""
+		default:
+			continue
+		}
+		if !present {
+			if required || in == "path" {
+				problems = append(problems, fmt.Sprintf("%s parameter %q is required", in, name))
+			}
+			continue
+		}
+		schema := op.spec.resolve(p["schema"])
+		op.spec.validate(schema, op.spec.parseParameter(schema, value), in+"."+name, &problems)
+	}
+
+	if op.requestBody != nil {
+		required, _ := op.requestBody["required"].(bool)
+		if len(body) == 0 {
+			if required {
+				problems = append(problems, "the request body is required")
+			}
+		} else {
+			schema, problem := op.spec.mediaSchema(op.requestBody, r.Header.Get("Content-Type"))
+			if problem != "" {
+				problems = append(problems, "request "+problem)
+			} else if schema != nil {
+				op.spec.validateJSON(schema, body, &problems)
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+	return nil
+}
+
+// ValidateResponse validates the status code and the body of a response. Bodies of responses documented without
+// content are not checked. The returned error, if any, is a *ValidationError.
+func (op *Operation) ValidateResponse(status int, header http.Header, body []byte) error {
+	var problems []string
+
+	code := strconv.Itoa(status)
+	raw, ok := op.responses[code]
+	if !ok {
+		raw, ok = op.responses[code[:1]+"XX"]
+	}
+	if !ok {
+		raw, ok = op.responses["default"]
+	}
+	if !ok {
+		problems = append(problems, fmt.Sprintf("status %d is not documented", status))
+	} else if resp := op.spec.resolve(raw); resp != nil && resp["content"] != nil && len(body) > 0 {
+		schema, problem := op.spec.mediaSchema(resp, header.Get("Content-Type"))
+		if problem != "" {
+			problems = append(problems, "response "+problem)
+		} else if schema != nil {
+			op.spec.validateJSON(schema, body, &problems)
+		}
+	}
+
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+	return nil
+}
+
+// EventStream returns true if the successful response of the operation is a stream of server-sent events
+// (text/event-stream), which doesn't end and can't be buffered to be validated.
+func (op *Operation) EventStream() bool {
+	content, _ := op.spec.resolve(op.responses["200"])["content"].(map[string]interface{})
+	_, ok := content["text/event-stream"]
+	return ok
+}
+
+// mediaSchema returns the schema of the JSON content of a request body or a response. A missing Content-Type is
+// taken as JSON. Media types not documented exactly match the ranges "type/*" and "*/*", if documented.
+func (s *Spec) mediaSchema(obj map[string]interface{}, contentType string) (map[string]interface{}, string) {
+	content, _ := obj["content"].(map[string]interface{})
+	mediaType := "application/json"
+	if contentType != "" {
+		mt, _, err := mime.ParseMediaType(contentType)
+		if err != nil {
+			return nil, fmt.Sprintf("content type %q is invalid", contentType)
+		}
+		mediaType = mt
+	}
+	media, ok := content[mediaType]
+	if !ok {
+		media, ok = content[mediaType[:strings.Index(mediaType, "/")+1]+"*"]
+	}
+	if !ok {
+		media, ok = content["*/*"]
+	}
+	if !ok {
+		return nil, fmt.Sprintf("content type %q is not documented", mediaType)
+	}
+	if mediaType != "application/json" {
+		return nil, ""
+	}
+	return s.resolve(s.resolve(media)["schema"]), ""
+}
+
+func (s *Spec) validateJSON(schema map[string]interface{}, body []byte, problems *[]string) {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		*problems = append(*problems, "body: invalid JSON: "+err.Error())
+		return
+	}
+	s.validate(schema, v, "body", problems)
+}
+
+// parseParameter converts a parameter value to the type of its schema, so that it can be validated like JSON. Values
+// that can't be converted are left as strings, and fail the type check.
+func (s *Spec) parseParameter(schema map[string]interface{}, value string) interface{} {
+	switch t, _ := schema["type"].(string); t {
+	case "integer", "number":
+		if _, err := strconv.ParseFloat(value, 64); err == nil {
+			return json.Number(value)
+		}
+	case "boolean":
+		if b, err := strconv.ParseBool(value); err == nil {
+			return b
+		}
+	}
+	return value
+}
+
+// parameters merges the parameters of a path item with those of an operation, which override them.
+func (s *Spec) parameters(pathParams, opParams interface{}) []map[string]interface{} {
+	var params []map[string]interface{}
+	index := map[string]int{}
+	for _, list := range []interface{}{pathParams, opParams} {
+		items, _ := list.([]interface{})
+		for _, item := range items {
+			p := s.resolve(item)
+			if p == nil {
+				continue
+			}
+			key := fmt.Sprint(p["in"], ":", p["name"])
+			if i, ok := index[key]; ok {
+				params[i] = p
+				continue
+			}
+			index[key] = len(params)
+			params = append(params, p)
+		}
+	}
+	return params
+}
+
+// resolve returns the object v, following its $ref if it is a reference. It returns nil if v is not an object or
+// the reference can't be resolved.
+func (s *Spec) resolve(v interface{}) map[string]interface{} {
+	for i := 0; i < 32; i++ {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		ref, ok := m["$ref"].(string)
+		if !ok {
+			return m
+		}
+		v = s.lookup(ref)
+	}
+	return nil
+}
+
+// lookup returns the value of a local reference, like #/components/schemas/Id.
+func (s *Spec) lookup(ref string) interface{} {
+	if !strings.HasPrefix(ref, "#/") {
+		return nil
+	}
+	var v interface{} = s.doc
+	for _, token := range strings.Split(ref[2:], "/") {
+		token = strings.NewReplacer("~1", "/", "~0", "~").Replace(token)
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		v = m[token]
+	}
+	return v
+}
+
+// normalize converts the maps decoded by yaml.v2 (with interface{} keys) into map[string]interface{}, like JSON.
+func normalize(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, val := range v {
+			m[fmt.Sprint(k)] = normalize(val)
+		}
+		return m
+	case []interface{}:
+		for i := range v {
+			v[i] = normalize(v[i])
+		}
+		return v
+	default:
+		return v
+	}
+}
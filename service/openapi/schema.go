@@ -0,0 +1,235 @@
+package openapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// validate appends to problems the differences between the value v (as decoded by encoding/json, with UseNumber)
+// and the schema. `at` is the location of v in the request or the response, used in the messages.
+func (s *Spec) validate(schema map[string]interface{}, v interface{}, at string, problems *[]string) {
+	if schema == nil {
+		return
+	}
+	add := func(format string, args ...interface{}) {
+		*problems = append(*problems, at+": "+fmt.Sprintf(format, args...))
+	}
+
+	if v == nil {
+		if nullable, _ := schema["nullable"].(bool); !nullable && schema["type"] != nil {
+			add("null is not allowed")
+		}
+		return
+	}
+
+	if t, ok := schema["type"].(string); ok && !hasType(v, t) {
+		add("expected %s, got %s", t, typeOf(v))
+		return
+	}
+
+	if enum, ok := schema["enum"].([]interface{}); ok {
+		found := false
+		for _, e := range enum {
+			if fmt.Sprint(e) == fmt.Sprint(v) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			add("%v is not one of %v", v, enum)
+		}
+	}
+
+	switch v := v.(type) {
+	case string:
+		length := utf8.RuneCountInString(v)
+		if min, ok := number(schema["minLength"]); ok && float64(length) < min {
+			add("length %d is less than minLength %v", length, min)
+		}
+		if max, ok := number(schema["maxLength"]); ok && float64(length) > max {
+			add("length %d is more than maxLength %v", length, max)
+		}
+		if pattern, ok := schema["pattern"].(string); ok {
+			re, err := s.pattern(pattern)
+			if err != nil {
+				add("invalid pattern %q in the document: %v", pattern, err)
+			} else if !re.MatchString(v) {
+				add("%q does not match the pattern %q", truncate(v), pattern)
+			}
+		}
+		if format, _ := schema["format"].(string); format == "date-time" {
+			if _, err := time.Parse(time.RFC3339, v); err != nil {
+				add("%q is not a date-time (RFC 3339)", truncate(v))
+			}
+		}
+
+	case json.Number:
+		f, _ := v.Float64()
+		if min, ok := number(schema["minimum"]); ok && f < min {
+			add("%v is less than minimum %v", v, min)
+		}
+		if max, ok := number(schema["maximum"]); ok && f > max {
+			add("%v is more than maximum %v", v, max)
+		}
+
+	case []interface{}:
+		if min, ok := number(schema["minItems"]); ok && float64(len(v)) < min {
+			add("%d items are less than minItems %v", len(v), min)
+		}
+		if max, ok := number(schema["maxItems"]); ok && float64(len(v)) > max {
+			add("%d items are more than maxItems %v", len(v), max)
+		}
+		if items := s.resolve(schema["items"]); items != nil {
+			for i, item := range v {
+				s.validate(items, item, at+"["+strconv.Itoa(i)+"]", problems)
+			}
+		}
+
+	case map[string]interface{}:
+		required, _ := schema["required"].([]interface{})
+		for _, r := range required {
+			if _, ok := v[fmt.Sprint(r)]; !ok {
+				add("property %q is required", r)
+			}
+		}
+		properties, _ := schema["properties"].(map[string]interface{})
+		for _, name := range sortedKeys(v) {
+			if prop, ok := properties[name]; ok {
+				s.validate(s.resolve(prop), v[name], at+"."+name, problems)
+				continue
+			}
+			switch additional := schema["additionalProperties"].(type) {
+			case bool:
+				if !additional {
+					add("property %q is not allowed", name)
+				}
+			case map[string]interface{}:
+				s.validate(s.resolve(additional), v[name], at+"."+name, problems)
+			}
+		}
+	}
+
+	if all, ok := schema["allOf"].([]interface{}); ok {
+		for _, sub := range all {
+			s.validate(s.resolve(sub), v, at, problems)
+		}
+	}
+	if any, ok := schema["anyOf"].([]interface{}); ok {
+		if matched, _ := s.countMatches(any, v, at); matched == 0 {
+			add("does not match any schema of anyOf")
+		}
+	}
+	if one, ok := schema["oneOf"].([]interface{}); ok {
+		matched, subProblems := s.countMatches(one, v, at)
+		switch {
+		case matched == 0:
+			add("does not match any schema of oneOf (%s)", strings.Join(subProblems, "; "))
+		case matched > 1:
+			add("matches %d schemas of oneOf, instead of exactly one", matched)
+		}
+	}
+}
+
+// countMatches returns the number of schemas matched by v, and the problems of the schemas that don't match.
+func (s *Spec) countMatches(schemas []interface{}, v interface{}, at string) (int, []string) {
+	matched := 0
+	var all []string
+	for _, sub := range schemas {
+		var problems []string
+		s.validate(s.resolve(sub), v, at, &problems)
+		if len(problems) == 0 {
+			matched++
+		}
+		all = append(all, problems...)
+	}
+	return matched, all
+}
+
+// pattern returns the compiled regular expression, caching it.
+func (s *Spec) pattern(p string) (*regexp.Regexp, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if re, ok := s.patterns[p]; ok {
+		return re, nil
+	}
+	re, err := regexp.Compile(p)
+	if err != nil {
+		return nil, err
+	}
+	s.patterns[p] = re
+	return re, nil
+}
+
+func hasType(v interface{}, t string) bool {
+	switch v := v.(type) {
+	case string:
+		return t == "string"
+	case bool:
+		return t == "boolean"
+	case json.Number:
+		if t == "number" {
+			return true
+		}
+		_, err := strconv.ParseInt(string(v), 10, 64)
+		return t == "integer" && err == nil
+	case []interface{}:
+		return t == "array"
+	case map[string]interface{}:
+		return t == "object"
+	}
+	return false
+}
+
+func typeOf(v interface{}) string {
+	switch v.(type) {
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case json.Number:
+		return "number"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+// number returns the numeric value of a schema keyword, as decoded by yaml.v2.
+func number(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
+
+// truncate shortens long values (like base64 photos) in the messages.
+func truncate(v string) string {
+	if len(v) > 40 {
+		return v[:40] + "..."
+	}
+	return v
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,95 @@
+/*
+Package thumbnail makes the small pictures shown next to the names of users (for example, the authors of comments),
+so that lists don't carry full-size profile pictures.
+
+Make decodes a JPEG, PNG or GIF image and returns it as a PNG that fits in Size x Size pixels, keeping the aspect
+ratio. Images of other formats (WebP is not supported by the standard library) are rejected with ErrUnsupported:
+callers are expected to fall back to the thumbnail of a default picture.
+*/
+package thumbnail
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif"  // GIF decoder
+	_ "image/jpeg" // JPEG decoder
+	"image/png"
+)
+
+// Size is the maximum width and height of thumbnails, in pixels.
+const Size = 64
+
+// maxPixels bounds the size of the decoded images, to refuse pictures that are small when compressed but huge in
+// memory
+const maxPixels = 40_000_000
+
+// ErrUnsupported is returned for data that is not an image of a supported format.
+var ErrUnsupported = errors.New("unsupported image format")
+
+// Make returns the PNG thumbnail of the image `data`.
+func Make(data []byte) ([]byte, error) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrUnsupported
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 || cfg.Width*cfg.Height > maxPixels {
+		return nil, fmt.Errorf("image of %dx%d pixels not supported", cfg.Width, cfg.Height)
+	}
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("decoding the image: %w", err)
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, scale(src)); err != nil {
+		return nil, fmt.Errorf("encoding the thumbnail: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// scale shrinks src to fit in Size x Size (smaller images keep their size): each pixel of the result is the average of
+// the pixels of src that it covers.
+func scale(src image.Image) image.Image {
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	tw, th := w, h
+	if w > Size || h > Size {
+		if w >= h {
+			tw, th = Size, max(1, h*Size/w)
+		} else {
+			tw, th = max(1, w*Size/h), Size
+		}
+	}
+
+	dst := image.NewNRGBA(image.Rect(0, 0, tw, th))
+	for y := 0; y < th; y++ {
+		y0, y1 := b.Min.Y+y*h/th, b.Min.Y+(y+1)*h/th
+		for x := 0; x < tw; x++ {
+			x0, x1 := b.Min.X+x*w/tw, b.Min.X+(x+1)*w/tw
+
+			// Sum of the premultiplied colors of the covered pixels
+			var r, g, bl, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := src.At(sx, sy).RGBA()
+					r, g, bl, a = r+uint64(cr), g+uint64(cg), bl+uint64(cb), a+uint64(ca)
+					n++
+				}
+			}
+			dst.Set(x, y, color.RGBA64{
+				R: uint16(r / n), G: uint16(g / n), B: uint16(bl / n), A: uint16(a / n),
+			})
+		}
+	}
+	return dst
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,162 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testSpans returns a root span and its child, as exported
+func testSpans() []SpanData {
+	start := time.Unix(1700000000, 123456789).UTC()
+	root := SpanData{
+		Name:    "GET /users",
+		Kind:    SpanKindServer,
+		TraceID: TraceID{0x4b, 0xf9, 0x2f, 0x35, 0x77, 0xb3, 0x4d, 0xa6, 0xa3, 0xce, 0x92, 0x9d, 0x0e, 0x0e, 0x47, 0x36},
+		SpanID:  SpanID{0x00, 0xf0, 0x67, 0xaa, 0x0b, 0xa9, 0x02, 0xb7},
+		Start:   start,
+		End:     start.Add(1500 * time.Microsecond),
+		Attributes: []Attribute{
+			{Key: "http.method", Value: "GET"},
+			{Key: "http.status_code", Value: 500},
+			{Key: "http.response_size", Value: int64(1 << 40)},
+			{Key: "cached", Value: true},
+			{Key: "ratio", Value: 0.5},
+			{Key: "timeout", Value: time.Second},
+		},
+		Error: "Internal Server Error",
+	}
+	child := SpanData{
+		Name:         "db.SearchUser",
+		Kind:         SpanKindInternal,
+		TraceID:      root.TraceID,
+		SpanID:       SpanID{1, 2, 3, 4, 5, 6, 7, 8},
+		ParentSpanID: root.SpanID,
+		Start:        start,
+		End:          start.Add(time.Millisecond),
+	}
+	return []SpanData{root, child}
+}
+
+func TestOTLPExporter(t *testing.T) {
+	var got struct {
+		path, contentType string
+		body              []byte
+	}
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got.path, got.contentType = r.URL.Path, r.Header.Get("Content-Type")
+		got.body, _ = io.ReadAll(r.Body)
+	}))
+	defer collector.Close()
+
+	exporter := NewOTLPExporter(collector.URL+"/v1/traces", "wasatext")
+	if err := exporter.ExportSpans(context.Background(), testSpans()); err != nil {
+		t.Fatal(err)
+	}
+	if got.path != "/v1/traces" || got.contentType != "application/json" {
+		t.Errorf("got a request to %s with %s", got.path, got.contentType)
+	}
+
+	want := `{"resourceSpans": [{
+		"resource": {"attributes": [{"key": "service.name", "value": {"stringValue": "wasatext"}}]},
+		"scopeSpans": [{
+			"scope": {"name": "github.com/val7e/wasaText/service/tracing"},
+			"spans": [
+				{
+					"traceId": "4bf92f3577b34da6a3ce929d0e0e4736",
+					"spanId": "00f067aa0ba902b7",
+					"name": "GET /users",
+					"kind": 2,
+					"startTimeUnixNano": "1700000000123456789",
+					"endTimeUnixNano": "1700000000124956789",
+					"attributes": [
+						{"key": "http.method", "value": {"stringValue": "GET"}},
+						{"key": "http.status_code", "value": {"intValue": "500"}},
+						{"key": "http.response_size", "value": {"intValue": "1099511627776"}},
+						{"key": "cached", "value": {"boolValue": true}},
+						{"key": "ratio", "value": {"doubleValue": 0.5}},
+						{"key": "timeout", "value": {"stringValue": "1s"}}
+					],
+					"status": {"code": 2, "message": "Internal Server Error"}
+				},
+				{
+					"traceId": "4bf92f3577b34da6a3ce929d0e0e4736",
+					"spanId": "0102030405060708",
+					"parentSpanId": "00f067aa0ba902b7",
+					"name": "db.SearchUser",
+					"kind": 1,
+					"startTimeUnixNano": "1700000000123456789",
+					"endTimeUnixNano": "1700000000124456789",
+					"status": {}
+				}
+			]
+		}]
+	}]}`
+	expectJSON(t, got.body, want)
+}
+
+func TestOTLPExporterCollectorError(t *testing.T) {
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "overloaded", http.StatusServiceUnavailable)
+	}))
+	defer collector.Close()
+
+	err := NewOTLPExporter(collector.URL, "wasatext").ExportSpans(context.Background(), testSpans())
+	if err == nil || !strings.Contains(err.Error(), "503") {
+		t.Errorf("got %v, want the status of the collector", err)
+	}
+}
+
+func TestStdoutExporter(t *testing.T) {
+	var out bytes.Buffer
+	if err := NewStdoutExporter(&out).ExportSpans(context.Background(), testSpans()); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %d lines, want one per span:\n%s", len(lines), out.String())
+	}
+	expectJSON(t, []byte(lines[0]), `{
+		"name": "GET /users",
+		"trace_id": "4bf92f3577b34da6a3ce929d0e0e4736",
+		"span_id": "00f067aa0ba902b7",
+		"start": "2023-11-14T22:13:20.123456789Z",
+		"duration_ms": 1.5,
+		"attributes": {
+			"http.method": "GET", "http.status_code": 500, "http.response_size": 1099511627776, "cached": true,
+			"ratio": 0.5, "timeout": 1000000000
+		},
+		"error": "Internal Server Error"
+	}`)
+	expectJSON(t, []byte(lines[1]), `{
+		"name": "db.SearchUser",
+		"trace_id": "4bf92f3577b34da6a3ce929d0e0e4736",
+		"span_id": "0102030405060708",
+		"parent_span_id": "00f067aa0ba902b7",
+		"start": "2023-11-14T22:13:20.123456789Z",
+		"duration_ms": 1
+	}`)
+}
+
+// expectJSON compares the JSON documents got and want, ignoring the formatting
+func expectJSON(t *testing.T, got []byte, want string) {
+	t.Helper()
+	var g, w interface{}
+	if err := json.Unmarshal(got, &g); err != nil {
+		t.Fatalf("%v: %s", err, got)
+	}
+	if err := json.Unmarshal([]byte(want), &w); err != nil {
+		t.Fatal(err)
+	}
+	gb, _ := json.Marshal(g)
+	wb, _ := json.Marshal(w)
+	if !bytes.Equal(gb, wb) {
+		t.Errorf("got\n%s\nwant\n%s", gb, wb)
+	}
+}
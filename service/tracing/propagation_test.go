@@ -0,0 +1,126 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+const (
+	testTraceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	testSpanID  = "00f067aa0ba902b7"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		ok      bool
+		sampled bool
+	}{
+		{"sampled", "00-" + testTraceID + "-" + testSpanID + "-01", true, true},
+		{"not sampled", "00-" + testTraceID + "-" + testSpanID + "-00", true, false},
+		{"other flags", "00-" + testTraceID + "-" + testSpanID + "-03", true, true},
+		{"spaces", "  00-" + testTraceID + "-" + testSpanID + "-01 ", true, true},
+		{"later version with more fields", "cc-" + testTraceID + "-" + testSpanID + "-01-what-comes-next", true, true},
+
+		{"empty", "", false, false},
+		{"version ff", "ff-" + testTraceID + "-" + testSpanID + "-01", false, false},
+		{"version 00 with more fields", "00-" + testTraceID + "-" + testSpanID + "-01-extra", false, false},
+		{"version of 3 digits", "000-" + testTraceID + "-" + testSpanID + "-01", false, false},
+		{"missing flags", "00-" + testTraceID + "-" + testSpanID, false, false},
+		{"all-zero trace ID", "00-00000000000000000000000000000000-" + testSpanID + "-01", false, false},
+		{"all-zero span ID", "00-" + testTraceID + "-0000000000000000-01", false, false},
+		{"short trace ID", "00-" + testTraceID[1:] + "-" + testSpanID + "-01", false, false},
+		{"long trace ID", "00-" + testTraceID + "0-" + testSpanID + "-01", false, false},
+		{"short span ID", "00-" + testTraceID + "-" + testSpanID[1:] + "-01", false, false},
+		{"long span ID", "00-" + testTraceID + "-" + testSpanID + "0-01", false, false},
+		{"long flags", "00-" + testTraceID + "-" + testSpanID + "-001", false, false},
+		{"uppercase trace ID", "00-4BF92F3577B34DA6A3CE929D0E0E4736-" + testSpanID + "-01", false, false},
+		{"uppercase span ID", "00-" + testTraceID + "-00F067AA0BA902B7-01", false, false},
+		{"uppercase flags", "00-" + testTraceID + "-" + testSpanID + "-0A", false, false},
+		{"not hex", "00-" + testTraceID + "-00f067aa0ba902bg-01", false, false},
+	}
+	for _, tt := range tests {
+		sc, ok := ParseTraceparent(tt.value)
+		if ok != tt.ok {
+			t.Errorf("%s: got ok=%v, want %v", tt.name, ok, tt.ok)
+			continue
+		}
+		if !ok {
+			if sc != (SpanContext{}) {
+				t.Errorf("%s: got %+v with ok=false", tt.name, sc)
+			}
+			continue
+		}
+		if sc.TraceID.String() != testTraceID || sc.SpanID.String() != testSpanID || sc.Sampled != tt.sampled {
+			t.Errorf("%s: got %s %s sampled=%v", tt.name, sc.TraceID, sc.SpanID, sc.Sampled)
+		}
+	}
+}
+
+func TestFormatTraceparent(t *testing.T) {
+	for _, value := range []string{
+		"00-" + testTraceID + "-" + testSpanID + "-01",
+		"00-" + testTraceID + "-" + testSpanID + "-00",
+	} {
+		sc, ok := ParseTraceparent(value)
+		if !ok {
+			t.Fatalf("%s is not valid", value)
+		}
+		if got := FormatTraceparent(sc); got != value {
+			t.Errorf("got %s, want %s", got, value)
+		}
+	}
+}
+
+// TestInjectExtract sends the span of a service to another one through the headers of a request: the span started by
+// the receiver continues the trace, as a child of the sender
+func TestInjectExtract(t *testing.T) {
+	exporter := &recordingExporter{}
+	tracer := newTestTracer(t, exporter)
+
+	ctx, client := tracer.Start(context.Background(), "GET /users", SpanKindClient)
+	h := http.Header{}
+	Inject(ctx, h)
+	if got, want := h.Get(TraceparentHeader), FormatTraceparent(client.SpanContext()); got != want {
+		t.Fatalf("got the header %q, want %q", got, want)
+	}
+
+	_, server := tracer.Start(Extract(context.Background(), h), "GET /users", SpanKindServer)
+	server.End()
+	client.End()
+	spans := exporter.flush(t, tracer)
+	if len(spans) != 2 {
+		t.Fatalf("got %d spans, want 2", len(spans))
+	}
+	if spans[0].TraceID != client.SpanContext().TraceID || spans[0].ParentSpanID != client.SpanContext().SpanID ||
+		spans[0].SpanID == client.SpanContext().SpanID {
+		t.Errorf("the server span %s/%s has the parent %s, want a child of %s/%s", spans[0].TraceID, spans[0].SpanID,
+			spans[0].ParentSpanID, client.SpanContext().TraceID, client.SpanContext().SpanID)
+	}
+}
+
+func TestInjectExtractNothing(t *testing.T) {
+	h := http.Header{}
+	Inject(context.Background(), h)
+	if _, ok := h[http.CanonicalHeaderKey(TraceparentHeader)]; ok {
+		t.Errorf("got the header %q without a span", h.Get(TraceparentHeader))
+	}
+
+	// A malformed header is ignored: the next span is a root
+	h.Set(TraceparentHeader, "00-"+testTraceID+"-0000000000000000-01")
+	if sc := SpanContextFromContext(Extract(context.Background(), h)); sc.IsValid() {
+		t.Errorf("got the parent %+v from a malformed header", sc)
+	}
+
+	// The decision of the caller not to sample is kept
+	h.Set(TraceparentHeader, "00-"+testTraceID+"-"+testSpanID+"-00")
+	exporter := &recordingExporter{}
+	tracer := newTestTracer(t, exporter)
+	_, span := tracer.Start(Extract(context.Background(), h), "GET /users", SpanKindServer)
+	span.End()
+	if spans := exporter.flush(t, tracer); len(spans) != 0 {
+		t.Errorf("got %d spans of a trace not sampled", len(spans))
+	}
+}
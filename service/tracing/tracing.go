@@ -0,0 +1,382 @@
+/*
+Package tracing records request traces, in the spirit of OpenTelemetry but without its SDK: a trace is a tree of spans
+(timed operations, like an HTTP request and the database calls it makes), identified by the W3C Trace Context IDs and
+propagated across services with the `traceparent` header.
+
+Finished spans are batched and handed to an Exporter: StdoutExporter prints them as JSON lines, OTLPExporter sends
+them to an OpenTelemetry collector using OTLP over HTTP (JSON encoding).
+
+	tracer, err := tracing.NewTracer(tracing.Config{ServiceName: "wasatext", Exporter: tracing.NewStdoutExporter(os.Stdout)})
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tracer.Shutdown(context.Background()) }()
+
+	ctx, span := tracer.Start(ctx, "GET /users", tracing.SpanKindServer)
+	defer span.End()
+	span.SetAttribute("http.method", "GET")
+
+A nil *Tracer is valid and disabled: Start returns a nil *Span, and all the *Span methods do nothing on nil.
+*/
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// TraceID identifies a trace, SpanID a span within a trace.
+type (
+	TraceID [16]byte
+	SpanID  [8]byte
+)
+
+func (t TraceID) String() string { return hex.EncodeToString(t[:]) }
+func (s SpanID) String() string  { return hex.EncodeToString(s[:]) }
+
+// IsValid reports whether the ID is not all zeros, as required by the W3C Trace Context.
+func (t TraceID) IsValid() bool { return t != TraceID{} }
+
+// IsValid reports whether the ID is not all zeros, as required by the W3C Trace Context.
+func (s SpanID) IsValid() bool { return s != SpanID{} }
+
+// SpanKind is the role of a span in the trace, with the values of OTLP.
+type SpanKind int
+
+const (
+	SpanKindInternal SpanKind = 1
+	SpanKindServer   SpanKind = 2
+	SpanKindClient   SpanKind = 3
+)
+
+// SpanContext is the part of a span propagated to its children, in the same process (through context.Context) or
+// in other services (through the traceparent header).
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool
+}
+
+// IsValid reports whether both IDs are valid.
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID.IsValid() && sc.SpanID.IsValid()
+}
+
+// Attribute is a key-value pair describing a span. Values are strings, booleans, integers or floats.
+type Attribute struct {
+	Key   string
+	Value interface{}
+}
+
+// SpanData is the read-only snapshot of a finished span, handed to the Exporter.
+type SpanData struct {
+	Name         string
+	Kind         SpanKind
+	TraceID      TraceID
+	SpanID       SpanID
+	ParentSpanID SpanID
+	Start        time.Time
+	End          time.Time
+	Attributes   []Attribute
+
+	// Error is the error message if the operation failed, empty otherwise
+	Error string
+}
+
+// Exporter sends finished spans to their destination. ExportSpans is never called concurrently.
+type Exporter interface {
+	ExportSpans(ctx context.Context, spans []SpanData) error
+	Shutdown(ctx context.Context) error
+}
+
+// Config is used to provide options to NewTracer.
+type Config struct {
+	// ServiceName is the name of the service producing the spans (the OpenTelemetry "service.name")
+	ServiceName string
+
+	// Exporter receives the finished spans. It is required.
+	Exporter Exporter
+
+	// BatchSize is the maximum number of spans per export (default 512)
+	BatchSize int
+
+	// BatchTimeout is the maximum time a finished span waits before being exported (default 5s)
+	BatchTimeout time.Duration
+
+	// OnError is called when an export fails (optional)
+	OnError func(error)
+}
+
+// Tracer creates spans and exports them, in batches, from a background goroutine.
+type Tracer struct {
+	cfg Config
+
+	queue    chan SpanData
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+
+	mu      sync.Mutex
+	dropped int
+}
+
+// queueSize is the number of finished spans waiting for an export above which new spans are dropped.
+const queueSize = 4096
+
+// NewTracer returns a Tracer exporting to cfg.Exporter. Call Shutdown to flush the last spans.
+func NewTracer(cfg Config) (*Tracer, error) {
+	if cfg.Exporter == nil {
+		return nil, errors.New("exporter is required")
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 512
+	}
+	if cfg.BatchTimeout <= 0 {
+		cfg.BatchTimeout = 5 * time.Second
+	}
+
+	t := &Tracer{
+		cfg:   cfg,
+		queue: make(chan SpanData, queueSize),
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	go t.run()
+	return t, nil
+}
+
+// ServiceName returns the name of the service, as configured.
+func (t *Tracer) ServiceName() string {
+	return t.cfg.ServiceName
+}
+
+// Start creates a span as child of the span in ctx (if any), and returns a context holding the new span. The span
+// is a root span if ctx has no span, or the child of a remote span if ctx was returned by ContextWithRemoteParent.
+func (t *Tracer) Start(ctx context.Context, name string, kind SpanKind) (context.Context, *Span) {
+	if t == nil {
+		return ctx, nil
+	}
+
+	parent := SpanContextFromContext(ctx)
+	sc := SpanContext{Sampled: true}
+	var parentID SpanID
+	if parent.IsValid() {
+		sc.TraceID = parent.TraceID
+		sc.Sampled = parent.Sampled
+		parentID = parent.SpanID
+	} else {
+		_, _ = rand.Read(sc.TraceID[:])
+	}
+	_, _ = rand.Read(sc.SpanID[:])
+
+	s := &Span{
+		tracer: t,
+		sc:     sc,
+		data: SpanData{
+			Name:         name,
+			Kind:         kind,
+			TraceID:      sc.TraceID,
+			SpanID:       sc.SpanID,
+			ParentSpanID: parentID,
+			Start:        time.Now(),
+		},
+	}
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+// Shutdown exports the spans still queued and shuts the exporter down. Spans ended afterwards are dropped.
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	if t == nil {
+		return nil
+	}
+	t.stopOnce.Do(func() { close(t.stop) })
+	select {
+	case <-t.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return t.cfg.Exporter.Shutdown(ctx)
+}
+
+// enqueue hands a finished span to the exporting goroutine, dropping it if the queue is full.
+func (t *Tracer) enqueue(d SpanData) {
+	select {
+	case <-t.stop:
+		return
+	default:
+	}
+	select {
+	case t.queue <- d:
+	default:
+		t.mu.Lock()
+		t.dropped++
+		t.mu.Unlock()
+	}
+}
+
+// run is the exporting goroutine: spans are exported when a batch is full, when the oldest span of the batch waited
+// for BatchTimeout, and on shutdown.
+func (t *Tracer) run() {
+	defer close(t.done)
+
+	ticker := time.NewTicker(t.cfg.BatchTimeout)
+	defer ticker.Stop()
+
+	batch := make([]SpanData, 0, t.cfg.BatchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), t.cfg.BatchTimeout)
+		err := t.cfg.Exporter.ExportSpans(ctx, batch)
+		cancel()
+		if err != nil && t.cfg.OnError != nil {
+			t.cfg.OnError(err)
+		}
+		batch = make([]SpanData, 0, t.cfg.BatchSize)
+	}
+
+	for {
+		select {
+		case d := <-t.queue:
+			batch = append(batch, d)
+			if len(batch) >= t.cfg.BatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+			t.reportDropped()
+		case <-t.stop:
+			for {
+				select {
+				case d := <-t.queue:
+					batch = append(batch, d)
+					if len(batch) >= t.cfg.BatchSize {
+						flush()
+					}
+				default:
+					flush()
+					t.reportDropped()
+					return
+				}
+			}
+		}
+	}
+}
+
+func (t *Tracer) reportDropped() {
+	t.mu.Lock()
+	dropped := t.dropped
+	t.dropped = 0
+	t.mu.Unlock()
+	if dropped > 0 && t.cfg.OnError != nil {
+		t.cfg.OnError(&DroppedSpansError{Count: dropped})
+	}
+}
+
+// DroppedSpansError reports spans dropped because the exporter could not keep up.
+type DroppedSpansError struct {
+	Count int
+}
+
+func (e *DroppedSpansError) Error() string {
+	return fmt.Sprintf("tracing: export queue full, %d spans dropped", e.Count)
+}
+
+// Span is a timed operation in a trace. Its methods are safe for concurrent use, and do nothing on a nil *Span.
+type Span struct {
+	tracer *Tracer
+	sc     SpanContext
+
+	mu    sync.Mutex
+	data  SpanData
+	ended bool
+}
+
+// SpanContext returns the propagated part of the span.
+func (s *Span) SpanContext() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.sc
+}
+
+// SetAttribute adds (or replaces) an attribute of the span.
+func (s *Span) SetAttribute(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.data.Attributes {
+		if s.data.Attributes[i].Key == key {
+			s.data.Attributes[i].Value = value
+			return
+		}
+	}
+	s.data.Attributes = append(s.data.Attributes, Attribute{Key: key, Value: value})
+}
+
+// SetError marks the span as failed with err. A nil err is ignored.
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Error = err.Error()
+}
+
+// End records the end time of the span and queues it for export, if sampled. Calls after the first are ignored.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = time.Now()
+	d := s.data
+	d.Attributes = append([]Attribute(nil), s.data.Attributes...)
+	s.mu.Unlock()
+
+	if s.sc.Sampled {
+		s.tracer.enqueue(d)
+	}
+}
+
+type spanKey struct{}
+type remoteKey struct{}
+
+// SpanFromContext returns the span in ctx, or nil.
+func SpanFromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(spanKey{}).(*Span)
+	return s
+}
+
+// SpanContextFromContext returns the context of the span in ctx, or the remote parent set by
+// ContextWithRemoteParent, or an invalid SpanContext.
+func SpanContextFromContext(ctx context.Context) SpanContext {
+	if s := SpanFromContext(ctx); s != nil {
+		return s.sc
+	}
+	sc, _ := ctx.Value(remoteKey{}).(SpanContext)
+	return sc
+}
+
+// ContextWithRemoteParent returns a context where the next span started is a child of the remote span sc (usually
+// parsed from a traceparent header).
+func ContextWithRemoteParent(ctx context.Context, sc SpanContext) context.Context {
+	if !sc.IsValid() {
+		return ctx
+	}
+	return context.WithValue(ctx, remoteKey{}, sc)
+}
@@ -0,0 +1,119 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingExporter keeps the spans it receives
+type recordingExporter struct {
+	mu    sync.Mutex
+	spans []SpanData
+}
+
+func (e *recordingExporter) ExportSpans(_ context.Context, spans []SpanData) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = append(e.spans, spans...)
+	return nil
+}
+
+func (e *recordingExporter) Shutdown(context.Context) error {
+	return nil
+}
+
+// flush shuts the tracer down, and returns the spans exported, in the order they ended
+func (e *recordingExporter) flush(t *testing.T, tracer *Tracer) []SpanData {
+	t.Helper()
+	if err := tracer.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.spans
+}
+
+func newTestTracer(t *testing.T, exporter Exporter) *Tracer {
+	t.Helper()
+	tracer, err := NewTracer(Config{ServiceName: "test", Exporter: exporter, BatchTimeout: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tracer
+}
+
+func TestSpanParenting(t *testing.T) {
+	exporter := &recordingExporter{}
+	tracer := newTestTracer(t, exporter)
+
+	ctx, root := tracer.Start(context.Background(), "GET /conversations", SpanKindServer)
+	childCtx, child := tracer.Start(ctx, "db.GetMyConversations", SpanKindInternal)
+	_, grandchild := tracer.Start(childCtx, "sql", SpanKindClient)
+	_, sibling := tracer.Start(ctx, "db.GetUserByID", SpanKindInternal)
+	_, other := tracer.Start(context.Background(), "GET /users", SpanKindServer)
+
+	if SpanFromContext(ctx) != root || SpanFromContext(childCtx) != child {
+		t.Error("the contexts don't hold their spans")
+	}
+	grandchild.SetAttribute("rows", 3)
+	grandchild.SetAttribute("rows", 4)
+	grandchild.SetError(errors.New("interrupted"))
+	grandchild.SetError(nil)
+	for _, s := range []*Span{grandchild, child, sibling, root, other, root} {
+		s.End()
+	}
+
+	spans := exporter.flush(t, tracer)
+	if len(spans) != 5 {
+		t.Fatalf("got %d spans, want 5 (ending twice exports once)", len(spans))
+	}
+	byName := map[string]SpanData{}
+	for _, s := range spans {
+		byName[s.Name] = s
+		if !s.SpanID.IsValid() || s.End.Before(s.Start) {
+			t.Errorf("%s: got the ID %s, from %v to %v", s.Name, s.SpanID, s.Start, s.End)
+		}
+	}
+
+	r := byName["GET /conversations"]
+	if r.ParentSpanID.IsValid() || r.Kind != SpanKindServer || !r.TraceID.IsValid() {
+		t.Errorf("root: got the parent %s, kind %d and trace %s", r.ParentSpanID, r.Kind, r.TraceID)
+	}
+	for name, parent := range map[string]string{
+		"db.GetMyConversations": "GET /conversations",
+		"sql":                   "db.GetMyConversations",
+		"db.GetUserByID":        "GET /conversations",
+	} {
+		if s := byName[name]; s.TraceID != r.TraceID || s.ParentSpanID != byName[parent].SpanID {
+			t.Errorf("%s: got the parent %s/%s, want %s", name, s.TraceID, s.ParentSpanID, parent)
+		}
+	}
+	if o := byName["GET /users"]; o.TraceID == r.TraceID || o.ParentSpanID.IsValid() {
+		t.Errorf("a span without parent joined the trace %s", o.TraceID)
+	}
+
+	g := byName["sql"]
+	if len(g.Attributes) != 1 || g.Attributes[0] != (Attribute{Key: "rows", Value: 4}) || g.Error != "interrupted" {
+		t.Errorf("got the attributes %v and the error %q", g.Attributes, g.Error)
+	}
+}
+
+func TestNilTracer(t *testing.T) {
+	var tracer *Tracer
+	ctx, span := tracer.Start(context.Background(), "GET /", SpanKindServer)
+	if span != nil || ctx != context.Background() {
+		t.Fatal("a nil tracer started a span")
+	}
+	span.SetAttribute("k", "v")
+	span.SetError(errors.New("e"))
+	span.End()
+	if span.SpanContext().IsValid() {
+		t.Error("a nil span has a valid context")
+	}
+	if err := tracer.Shutdown(context.Background()); err != nil {
+		t.Error(err)
+	}
+}